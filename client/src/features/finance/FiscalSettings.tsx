@@ -1,7 +1,7 @@
 
 import React, { useState, useEffect } from 'react';
 import { useNavigate } from 'react-router-dom';
-import { User, ConfiguracaoFiscal } from '@/shared/types';
+import { User, UserRole, ConfiguracaoFiscal } from '@/shared/types';
 import { apiService } from '@/shared/services/apiService';
 
 interface FiscalSettingsProps {
@@ -20,6 +20,7 @@ export const FiscalSettings: React.FC<FiscalSettingsProps> = ({ currentUser }) =
     const [certPassword, setCertPassword] = useState('');
     const [error, setError] = useState<string | null>(null);
     const [regimes, setRegimes] = useState<any[]>([]);
+    const [certStatus, setCertStatus] = useState<any>(null);
 
     const REGIMES_FALLBACK = [
         { id: 'MEI', nome: 'MEI - Microempreendedor Individual' },
@@ -48,6 +49,7 @@ export const FiscalSettings: React.FC<FiscalSettingsProps> = ({ currentUser }) =
             if (companyData?.cnpj) {
                 setCnpjInput(companyData.cnpj);
             }
+            apiService.getCertificateStatus().then(setCertStatus).catch(() => setCertStatus(null));
         } catch (err: any) {
             console.error('Failed to load fiscal data:', err);
             setError(err.message || 'Erro ao carregar configurações fiscais');
@@ -58,6 +60,23 @@ export const FiscalSettings: React.FC<FiscalSettingsProps> = ({ currentUser }) =
         }
     };
 
+    const handleApurarRBT12 = async () => {
+        try {
+            const apuracao = await apiService.apurarRBT12();
+            setConfig(prev => prev ? {
+                ...prev,
+                receitaBruta12Meses: apuracao.rbt12,
+                rbt12Competencia: apuracao.competencia,
+                faixaSimplesNac: apuracao.faixa,
+                aliquotaSimplesNac: apuracao.aliquota,
+                alertaTetoReceita: apuracao.alerta,
+            } : null);
+            if (apuracao.mensagem) alert(apuracao.mensagem);
+        } catch (err: any) {
+            alert('Erro ao apurar RBT12: ' + err.message);
+        }
+    };
+
     const handleLookupCNPJ = async () => {
         if (!cnpjInput) return;
         setSearching(true);
@@ -142,6 +161,7 @@ export const FiscalSettings: React.FC<FiscalSettingsProps> = ({ currentUser }) =
                 await apiService.uploadCertificate(e.target.files[0], certPassword);
                 alert('Certificado digital configurado com sucesso!');
                 setCertPassword('');
+                setCertStatus(await apiService.getCertificateStatus());
             } catch (err: any) {
                 alert('Erro no upload: ' + err.message);
             } finally {
@@ -317,6 +337,18 @@ export const FiscalSettings: React.FC<FiscalSettingsProps> = ({ currentUser }) =
                                         <option value="FAIXA_5">Faixa 5 - Até R$ 3.600.000 (21,0%)</option>
                                         <option value="FAIXA_6">Faixa 6 - Até R$ 4.800.000 (33,0%)</option>
                                     </select>
+                                    <div className="flex items-center justify-between gap-4 ml-2 text-xs font-bold text-slate-500">
+                                        <span>
+                                            {config?.rbt12Competencia
+                                                ? `RBT12 apurado em ${config.rbt12Competencia}: R$ ${(config.receitaBruta12Meses || 0).toLocaleString('pt-BR', { minimumFractionDigits: 2 })} · alíquota efetiva ${(config.aliquotaSimplesNac || 0).toFixed(2)}%`
+                                                : 'RBT12 ainda não apurado a partir das NFS-e emitidas'}
+                                        </span>
+                                        {currentUser.role === UserRole.ADMIN && (
+                                            <button type="button" onClick={handleApurarRBT12} className="text-blue-600 hover:underline">
+                                                Recalcular
+                                            </button>
+                                        )}
+                                    </div>
                                 </div>
                             )}
                         </div>
@@ -483,6 +515,14 @@ export const FiscalSettings: React.FC<FiscalSettingsProps> = ({ currentUser }) =
                         </h3>
 
                         <div className="space-y-4 relative z-10">
+                            {certStatus && (
+                                <div className={`p-4 rounded-2xl border text-xs font-bold ${certStatus.situacao === 'VALIDO' ? 'bg-emerald-500/10 border-emerald-500/30 text-emerald-300' : certStatus.situacao === 'EXPIRANDO' ? 'bg-amber-500/10 border-amber-500/30 text-amber-300' : 'bg-rose-500/10 border-rose-500/30 text-rose-300'}`}>
+                                    {certStatus.certificado && (
+                                        <p className="text-[10px] uppercase tracking-widest opacity-70 mb-1">{certStatus.certificado.titular}</p>
+                                    )}
+                                    {certStatus.mensagem}
+                                </div>
+                            )}
                             <div className="space-y-2">
                                 <label className="text-[10px] font-black text-slate-400 uppercase tracking-widest ml-2">Senha do Certificado</label>
                                 <input
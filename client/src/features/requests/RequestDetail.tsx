@@ -2,6 +2,7 @@ import React, { useState, useEffect, useRef } from 'react';
 import { useParams, useNavigate } from 'react-router-dom';
 import { ServiceRequest, RequestStatus, User, UserRole, OrcamentoItem, OrcamentoSugestao, NotaFiscal, Attachment } from '@/shared/types';
 import { apiService } from '@/shared/services/apiService';
+import { wsService } from '@/shared/services/websocketService';
 import { ArrowLeft, Printer, Info, DollarSign, PenTool, FileSpreadsheet, Paperclip, CheckCircle, XCircle, Trash2, Plus, Download, AlertTriangle, Calendar, Clock } from 'lucide-react';
 
 interface RequestDetailProps {
@@ -233,6 +234,16 @@ export const RequestDetail: React.FC<RequestDetailProps> = ({ request: propReque
     }
   }, [request, activeTab]);
 
+  // Follow the emission queue (attempts, authorization, failures)
+  useEffect(() => {
+    if (!request) return;
+    return wsService.on('nfse:updated', (data: NotaFiscal) => {
+      if (data.solicitacaoId !== request.id) return;
+      setNfse(data);
+      apiService.getNFSeEventos(request.id).then(setNfseEventos).catch(console.error);
+    });
+  }, [request?.id]);
+
   // Load attachments when tab is active
   useEffect(() => {
     if (request && activeTab === 'anexos') {
@@ -391,8 +402,8 @@ export const RequestDetail: React.FC<RequestDetailProps> = ({ request: propReque
 
     setIsIssuingNF(true);
     try {
-      const newNF = await apiService.issueNFSe(request.id);
-      setNfse(newNF);
+      const result = await apiService.issueNFSe(request.id);
+      setNfse(result.nfse ?? result);
       alert('NFS-e enviada para processamento!');
     } catch (err) {
       console.error(err);
@@ -402,6 +413,17 @@ export const RequestDetail: React.FC<RequestDetailProps> = ({ request: propReque
     }
   };
 
+  const openDANFSe = async () => {
+    if (!request) return;
+    try {
+      const pdf = await apiService.getDANFSePDF(request.id);
+      window.open(URL.createObjectURL(pdf), '_blank');
+    } catch (err) {
+      console.error(err);
+      alert('Erro ao obter o DANFS-e.');
+    }
+  };
+
   // Checklist Handlers
   const handleAddChecklist = async () => {
       if (!request || !newChecklistDesc.trim()) return;
@@ -590,20 +612,6 @@ export const RequestDetail: React.FC<RequestDetailProps> = ({ request: propReque
     }
   };
 
-  const handleCancelNFSe = async () => {
-    if (!request || !nfse) return;
-    if (!confirm('Tem certeza que deseja cancelar esta Nota Fiscal? Esta ação não pode ser desfeita.')) return;
-
-    try {
-      await apiService.cancelNFSe(request.id);
-      setNfse(prev => prev ? { ...prev, status: 'CANCELADA' } : null);
-      alert('Nota Fiscal cancelada com sucesso!');
-    } catch (err) {
-      console.error(err);
-      alert('Erro ao cancelar NFS-e');
-    }
-  };
-
   const handleDeleteRequest = async () => {
     if (!request) return;
     if (!confirm('ATENÇÃO: Deseja realmente excluir este chamado permanentemente? Esta ação não pode ser desfeita.')) return;
@@ -1658,10 +1666,26 @@ export const RequestDetail: React.FC<RequestDetailProps> = ({ request: propReque
                       </div>
                     </div>
 
+                    {nfse.mensagemErro && nfse.status !== 'EMITIDA' && (
+                      <p className={`text-xs font-bold mt-4 text-left ${nfse.status === 'ERRO' ? 'text-rose-700' : 'text-amber-700'}`}>
+                        {nfse.mensagemErro}
+                      </p>
+                    )}
+
+                    {nfse.status === 'ERRO' && canManageNFSe && (
+                      <button
+                        onClick={issueNFSe}
+                        disabled={isIssuingNF}
+                        className="w-full mt-6 py-4 bg-rose-600 text-white rounded-2xl font-black text-xs uppercase tracking-widest shadow-lg shadow-rose-600/30 hover:bg-rose-700 transition-all active:scale-95 disabled:opacity-50"
+                      >
+                        {isIssuingNF ? 'Reenviando...' : 'Reenviar NFS-e'}
+                      </button>
+                    )}
+
                     {nfse.status === 'EMITIDA' && (
                       <div className="flex gap-3 mt-6">
                         <button
-                          onClick={() => window.open(`/api/requests/${request.id}/nfse/danfse`, '_blank')}
+                          onClick={openDANFSe}
                           className="flex-1 py-4 bg-blue-600 text-white rounded-2xl font-black text-xs uppercase tracking-widest shadow-lg shadow-blue-600/30 hover:bg-blue-700 transition-all active:scale-95"
                         >
                           Visualizar DANFS-e
@@ -1703,16 +1727,47 @@ export const RequestDetail: React.FC<RequestDetailProps> = ({ request: propReque
                     </div>
                   )}
 
-                  {canManageNFSe && nfse.status !== 'CANCELADA' && (
+                  {canManageNFSe && nfse.status === 'EMITIDA' && (
+                    <div className="pt-4">
+                      <button
+                        onClick={() => {
+                          const codigoMotivo = prompt("Motivo da substituição:\n01 - Desenquadramento do Simples Nacional\n02 - Enquadramento no Simples Nacional\n03 - Inclusão de Imunidade/Isenção\n04 - Exclusão de Imunidade/Isenção\n05 - Rejeição pelo Tomador\n99 - Outros", "05");
+                          if (!codigoMotivo) return;
+                          const motivo = prompt("Descrição do motivo:") || '';
+                          const tomadorNome = prompt("Nome do tomador:", nfse.tomadorNome) || '';
+                          const tomadorDocumento = prompt("CPF/CNPJ do tomador:", nfse.tomadorDocumento) || '';
+                          const valor = prompt("Valor dos serviços:", nfse.valorServicos.toFixed(2));
+                          apiService.substituteNFSe(request.id, {
+                            codigoMotivo,
+                            motivo,
+                            tomadorNome,
+                            tomadorDocumento,
+                            valorServicos: valor ? parseFloat(valor.replace(',', '.')) : undefined,
+                          })
+                            .then(result => {
+                              setNfse(result.nfse);
+                              apiService.getNFSeEventos(request.id).then(setNfseEventos);
+                            })
+                            .catch(err => alert('Erro ao substituir: ' + err.message));
+                        }}
+                        className="w-full py-4 bg-amber-50 text-amber-700 rounded-2xl font-black text-xs uppercase tracking-widest border-2 border-amber-100 hover:bg-amber-100 transition-all"
+                      >
+                        Substituir NFS-e
+                      </button>
+                    </div>
+                  )}
+
+                  {canManageNFSe && nfse.status === 'EMITIDA' && (
                     <div className="pt-4">
                       <button
                         onClick={() => {
-                          const motivo = prompt("Motivo do cancelamento:\n1 - Erro na Emissão\n2 - Serviço não Prestado\n3 - Duplicidade\n4 - Erro de Preenchimento", "1");
-                          if (motivo) {
-                            const justificativa = prompt("Justificativa (opcional):");
-                            apiService.cancelNFSeWithMotivo(request.id, parseInt(motivo), justificativa || undefined)
+                          const codigoMotivo = prompt("Motivo do cancelamento:\n1 - Erro na Emissão\n2 - Serviço não Prestado\n3 - Duplicidade\n4 - Erro de Preenchimento", "1");
+                          if (codigoMotivo) {
+                            const justificativa = prompt("Justificativa (mínimo 15 caracteres):");
+                            if (!justificativa) return;
+                            apiService.cancelNFSeWithMotivo(request.id, codigoMotivo.trim(), justificativa)
                               .then(() => {
-                                alert('Cancelamento solicitado com sucesso!');
+                                alert('Cancelamento enviado à SEFIN. A nota será cancelada quando o evento for homologado.');
                                 // Trigger reload
                                 apiService.getNFSe(request.id).then(setNfse);
                                 apiService.getNFSeEventos(request.id).then(setNfseEventos);
@@ -104,7 +104,7 @@ export const CompanyProfile: React.FC<CompanyProfileProps> = ({ currentUser }) =
       setUploadingCert(true);
       try {
         const result = await apiService.uploadCertificate(file, certPassword);
-        alert('Certificado enviado com sucesso!');
+        alert(`Certificado de ${result.titular} enviado com sucesso! Válido até ${new Date(result.validade).toLocaleDateString('pt-BR')}.`);
         setCertPassword('');
         // Reload fiscal config to show updated status
         const fiscal = await apiService.getFiscalConfig();
@@ -26,9 +26,9 @@ export const SystemSettings: React.FC = () => {
 
   const handleUpdate = async (key: string, value: string) => {
     try {
-        const newSettings = { ...settings, [key]: value };
-        await apiService.updateSettings(newSettings);
-        setSettings(newSettings);
+        // Only the changed key is sent, so the company keeps following the shared values it did not touch
+        await apiService.updateSettings({ [key]: value });
+        setSettings({ ...settings, [key]: value });
         alert('Configuração atualizada com sucesso!');
     } catch (err) {
         console.error(err);
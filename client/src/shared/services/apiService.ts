@@ -13,7 +13,13 @@ interface AuthResponse {
     accessToken: string;
     refreshToken: string;
     expiresIn: number;
+    recoveryCodes?: string[];
+    deviceToken?: string;
   };
+  // Set when the password is right but a second factor is needed
+  error?: string;
+  message?: string;
+  challengeToken?: string;
 }
 
 class ApiService {
@@ -88,7 +94,10 @@ class ApiService {
 
       if (response.ok) {
         const data = await response.json();
+        if (!data.success) return false;
         this.setAccessToken(data.accessToken);
+        // Refresh tokens are single-use: keep the rotated one
+        this.setRefreshToken(data.refreshToken);
         return true;
       }
     } catch (e) {
@@ -131,7 +140,7 @@ class ApiService {
       const response = await fetch(`${API_BASE}/auth/login`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ email, password }),
+        body: JSON.stringify({ email, password, deviceToken: localStorage.getItem('trustedDevice') || '' }),
       });
 
       const data = await response.json();
@@ -157,6 +166,35 @@ class ApiService {
     }
   }
 
+  // Second step of a sign-in: authenticator code, recovery code or the first code of a forced enrollment
+  async verifyTwoFactor(challengeToken: string, data: { code?: string; recoveryCode?: string; rememberDevice?: boolean }): Promise<AuthResponse> {
+    const response = await fetch(`${API_BASE}/auth/2fa/verify`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ challengeToken, ...data }),
+    });
+    const result = await response.json();
+
+    if (result.success) {
+      this.setAccessToken(result.data.accessToken);
+      this.setRefreshToken(result.data.refreshToken);
+      localStorage.setItem('currentUser', JSON.stringify(result.data.user));
+      if (result.data.deviceToken) {
+        localStorage.setItem('trustedDevice', result.data.deviceToken);
+      }
+    }
+    return result;
+  }
+
+  async setupTwoFactorAtLogin(challengeToken: string): Promise<any> {
+    const response = await fetch(`${API_BASE}/auth/2fa/setup`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ challengeToken }),
+    });
+    return response.json();
+  }
+
   async logout(): Promise<void> {
     try {
       await this.request('/logout', { method: 'POST' });
@@ -165,6 +203,29 @@ class ApiService {
     }
   }
 
+  async logoutAllDevices(): Promise<void> {
+    try {
+      await this.request('/logout?all=true', { method: 'POST' });
+    } finally {
+      this.clearTokens();
+    }
+  }
+
+  // Sessions (signed-in devices) of the current user
+  async getSessions(): Promise<any[]> {
+    const response = await this.request<{ data: any[] }>('/me/sessions');
+    return response.data || [];
+  }
+
+  async revokeSession(id: string): Promise<void> {
+    await this.request(`/me/sessions/${id}`, { method: 'DELETE' });
+  }
+
+  async revokeOtherSessions(): Promise<number> {
+    const response = await this.request<{ data: { count: number } }>('/me/sessions', { method: 'DELETE' });
+    return response.data.count;
+  }
+
   async getCurrentUser(): Promise<any> {
     const response = await this.request<{ data: any }>('/me');
     // Sync to localStorage to ensure fresh state on reload (crucial for MustChangePassword)
@@ -236,6 +297,93 @@ class ApiService {
     return response.data;
   }
 
+  async resetUserTwoFactor(id: string): Promise<void> {
+    await this.request(`/users/${id}/2fa`, { method: 'DELETE' });
+  }
+
+  // Two-factor authentication of the current user
+  async getTwoFactorStatus(): Promise<any> {
+    const response = await this.request<{ data: any }>('/me/2fa');
+    return response.data;
+  }
+
+  async enrollTwoFactor(): Promise<{ secret: string; otpauthUri: string; qrCode: string }> {
+    const response = await this.request<{ data: any }>('/me/2fa/enroll', { method: 'POST' });
+    return response.data;
+  }
+
+  async confirmTwoFactor(code: string): Promise<string[]> {
+    const response = await this.request<{ data: { recoveryCodes: string[] } }>('/me/2fa/confirm', {
+      method: 'POST',
+      body: JSON.stringify({ code }),
+    });
+    return response.data.recoveryCodes;
+  }
+
+  async regenerateRecoveryCodes(code: string): Promise<string[]> {
+    const response = await this.request<{ data: { recoveryCodes: string[] } }>('/me/2fa/recovery-codes', {
+      method: 'POST',
+      body: JSON.stringify({ code }),
+    });
+    return response.data.recoveryCodes;
+  }
+
+  async disableTwoFactor(password: string, code: string): Promise<void> {
+    await this.request('/me/2fa', {
+      method: 'DELETE',
+      body: JSON.stringify({ password, code }),
+    });
+  }
+
+  async forgetTrustedDevices(): Promise<void> {
+    await this.request('/me/2fa/devices', { method: 'DELETE' });
+    localStorage.removeItem('trustedDevice');
+  }
+
+  async assignUserRole(id: string, perfilId: string | null): Promise<any> {
+    const response = await this.request<{ data: any }>(`/users/${id}/role`, {
+      method: 'PUT',
+      body: JSON.stringify({ perfilId: perfilId || '' }),
+    });
+    return response.data;
+  }
+
+  // Access roles (permission profiles)
+  async getMyPermissions(): Promise<string[]> {
+    const response = await this.request<{ data: string[] }>('/me/permissions');
+    return response.data || [];
+  }
+
+  async getPermissionCatalog(): Promise<any> {
+    const response = await this.request<{ data: any }>('/roles/permissions');
+    return response.data;
+  }
+
+  async getRoles(): Promise<any[]> {
+    const response = await this.request<{ data: any[] }>('/roles');
+    return response.data || [];
+  }
+
+  async createRole(data: any): Promise<any> {
+    const response = await this.request<{ data: any }>('/roles', {
+      method: 'POST',
+      body: JSON.stringify(data),
+    });
+    return response.data;
+  }
+
+  async updateRole(id: string, data: any): Promise<any> {
+    const response = await this.request<{ data: any }>(`/roles/${id}`, {
+      method: 'PUT',
+      body: JSON.stringify(data),
+    });
+    return response.data;
+  }
+
+  async deleteRole(id: string): Promise<void> {
+    await this.request(`/roles/${id}`, { method: 'DELETE' });
+  }
+
   // Clients
   async getClients(): Promise<any[]> {
     const response = await this.request<{ data: any[] }>('/clients');
@@ -468,11 +616,6 @@ class ApiService {
     return response.data;
   }
 
-  async cancelNFSe(requestId: string): Promise<void> {
-    await this.request(`/requests/${requestId}/nfse`, {
-      method: 'DELETE',
-    });
-  }
 
   async getNFSe(requestId: string): Promise<any> {
     const response = await this.request<{ data: any }>(`/requests/${requestId}/nfse`);
@@ -487,15 +630,47 @@ class ApiService {
     return response.text();
   }
 
+  async getDANFSePDF(requestId: string): Promise<Blob> {
+    const response = await fetch(`${API_BASE}/requests/${requestId}/nfse/danfse?format=pdf`, {
+      headers: { 'Authorization': `Bearer ${this.accessToken}` }
+    });
+    if (!response.ok) throw new Error('Falha ao obter DANFS-e');
+    return response.blob();
+  }
+
+  async getNFSeXML(requestId: string): Promise<Blob> {
+    const response = await fetch(`${API_BASE}/requests/${requestId}/nfse/xml`, {
+      headers: { 'Authorization': `Bearer ${this.accessToken}` }
+    });
+    if (!response.ok) throw new Error('Falha ao obter XML da NFS-e');
+    return response.blob();
+  }
+
   async getNFSeEventos(requestId: string): Promise<any[]> {
     const response = await this.request<{ data: any[] }>(`/requests/${requestId}/nfse/eventos`);
     return response.data;
   }
 
-  async cancelNFSeWithMotivo(requestId: string, motivo: number, justificativa?: string): Promise<any> {
+  // codigoMotivo is one of motivosCancelamento (getTaxRegimes); motivo is the justification sent to the SEFIN (15-255 chars)
+  async cancelNFSeWithMotivo(requestId: string, codigoMotivo: string, motivo: string): Promise<any> {
     const response = await this.request<{ data: any }>(`/requests/${requestId}/nfse/cancelar`, {
       method: 'POST',
-      body: JSON.stringify({ motivo, justificativa }),
+      body: JSON.stringify({ codigoMotivo, motivo }),
+    });
+    return response.data;
+  }
+
+  async substituteNFSe(requestId: string, data: {
+    codigoMotivo: string;
+    motivo?: string;
+    tomadorNome?: string;
+    tomadorDocumento?: string;
+    valorServicos?: number;
+    discriminacao?: string;
+  }): Promise<any> {
+    const response = await this.request<{ data: any }>(`/requests/${requestId}/nfse/substituir`, {
+      method: 'POST',
+      body: JSON.stringify(data),
     });
     return response.data;
   }
@@ -734,6 +909,89 @@ class ApiService {
     return data.data || {};
   }
 
+  async getCertificateStatus(): Promise<any> {
+    const response = await this.request<{ data: any }>('/fiscal/certificate/status');
+    return response.data;
+  }
+
+  async apurarRBT12(): Promise<any> {
+    const response = await this.request<{ data: any }>('/fiscal/rbt12/apurar', { method: 'POST' });
+    return response.data;
+  }
+
+  async getReceitasMensais(): Promise<any[]> {
+    const response = await this.request<{ data: any[] }>('/fiscal/receitas-mensais');
+    return response.data || [];
+  }
+
+  async saveReceitaMensal(competencia: string, valor: number): Promise<any> {
+    const response = await this.request<{ data: any }>(`/fiscal/receitas-mensais/${competencia}`, {
+      method: 'PUT',
+      body: JSON.stringify({ valor }),
+    });
+    return response.data;
+  }
+
+  async deleteReceitaMensal(competencia: string): Promise<void> {
+    await this.request(`/fiscal/receitas-mensais/${competencia}`, { method: 'DELETE' });
+  }
+
+  async previewLoteNFSe(filtro: { inicio?: string; fim?: string; clientId?: string; status?: string[] }): Promise<any> {
+    const response = await this.request<{ data: any }>('/fiscal/nfse/lote/preview', {
+      method: 'POST',
+      body: JSON.stringify(filtro),
+    });
+    return response.data;
+  }
+
+  async emitirLoteNFSe(filtro: { inicio?: string; fim?: string; clientId?: string; status?: string[]; solicitacaoIds?: string[] }): Promise<any> {
+    const response = await this.request<{ data: any }>('/fiscal/nfse/lote', {
+      method: 'POST',
+      body: JSON.stringify(filtro),
+    });
+    return response.data;
+  }
+
+  async getLotesNFSe(): Promise<any[]> {
+    const response = await this.request<{ data: any[] }>('/fiscal/nfse/lote');
+    return response.data;
+  }
+
+  async getLoteNFSe(id: string): Promise<any> {
+    const response = await this.request<{ data: any }>(`/fiscal/nfse/lote/${id}`);
+    return response.data;
+  }
+
+  async previewNFSeConsolidada(solicitacaoIds: string[]): Promise<any> {
+    const response = await this.request<{ data: any }>('/fiscal/nfse/consolidada/preview', {
+      method: 'POST',
+      body: JSON.stringify({ solicitacaoIds }),
+    });
+    return response.data;
+  }
+
+  async emitirNFSeConsolidada(solicitacaoIds: string[]): Promise<any> {
+    const response = await this.request<{ data: any }>('/fiscal/nfse/consolidada', {
+      method: 'POST',
+      body: JSON.stringify({ solicitacaoIds }),
+    });
+    return response.data;
+  }
+
+  async getLivroFiscal(competencia: string): Promise<any> {
+    const response = await this.request<{ data: any }>(`/fiscal/livro?competencia=${competencia}`);
+    return response.data;
+  }
+
+  // format: 'csv' for the register only, 'zip' for the accountant package with XMLs and DANFS-e
+  async downloadLivroFiscal(competencia: string, format: 'csv' | 'zip'): Promise<Blob> {
+    const response = await fetch(`${API_BASE}/fiscal/livro?competencia=${competencia}&format=${format}`, {
+      headers: { 'Authorization': `Bearer ${this.accessToken}` }
+    });
+    if (!response.ok) throw new Error('Falha ao exportar o livro fiscal');
+    return response.blob();
+  }
+
   async getTaxRegimes(): Promise<any> {
     const response = await this.request<{ data: any }>('/fiscal/regimes');
     // Backend returns { regimes: [...], motivosCancelamento: [...] }
@@ -13,10 +13,12 @@ class WebSocketService {
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
     const host = window.location.host;
     const wsBase = import.meta.env.VITE_WS_URL || `${protocol}//${host}`;
+    // The server scopes the events to the company of the token
     const params = new URLSearchParams({
       userId,
       role,
       ...(companyId && { companyId }),
+      token: localStorage.getItem('accessToken') || '',
     });
 
     this.socket = new WebSocket(`${wsBase}/ws?${params}`);
@@ -57,6 +57,7 @@ export interface Company {
   avatarUrl?: string;
   cnpj?: string;
   bankDetails?: string;
+  tecnicoSomenteAtribuidas?: boolean;
 }
 
 export interface Client {
@@ -150,13 +151,17 @@ export interface NotaFiscal {
   solicitacaoId: string;
   numero?: string;
   codigoVerificacao?: string;
-  status: 'PENDENTE' | 'PROCESSANDO' | 'EMITIDA' | 'CANCELADA' | 'ERRO';
+  chaveAcesso?: string;
+  status: 'PENDENTE' | 'PROCESSANDO' | 'EMITIDA' | 'CANCELADA' | 'SUBSTITUIDA' | 'ERRO';
+  mensagemErro?: string;
+  substituiId?: string;
+  substituidaPorId?: string;
+  codigoMotivoSubst?: string;
+  motivoSubst?: string;
   tomadorNome: string;
   tomadorDocumento: string;
   valorServicos: number;
   valorLiquido: number;
-  xmlPath?: string;
-  pdfPath?: string;
   dataEmissao?: string;
 }
 
@@ -167,6 +172,9 @@ export interface ConfiguracaoFiscal {
   regimeTributario: string;
   faixaSimplesNac?: string;
   aliquotaSimplesNac?: number;
+  receitaBruta12Meses?: number;
+  rbt12Competencia?: string;
+  alertaTetoReceita?: string;
   aliquotaISSPadrao?: number;
   issRetido?: boolean;
   codigoServico?: string;
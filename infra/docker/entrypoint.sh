@@ -11,7 +11,7 @@ echo "║       Iniciando Container...         ║"
 echo "╚══════════════════════════════════════╝"
 
 # Ensure data directories exist with correct permissions
-mkdir -p /app/data/db /app/data/uploads /app/data/certs
+mkdir -p /app/data/db /app/data/uploads /app/data/certs /app/data/fiscal
 chown -R inovar:inovar /app/data
 echo "✅ Data directories verified (permissions fixed)"
 
@@ -66,237 +66,6 @@ def send_email(params):
             json.dump(payload, f, indent=4)
         return {"success": True, "queued": True}
 
-def emit_nfse(params):
-    import xml.etree.ElementTree as ET
-    import gzip
-    import base64
-    import io
-    from datetime import datetime
-    import requests
-
-    dps_data = params.get('dps_data')
-    cert_path = params.get('cert_path')
-    cert_password = params.get('cert_password', '')
-    url = params.get('url')
-
-    if not dps_data or not cert_path or not url:
-        return {"success": False, "error": "Missing dps_data, cert_path or url"}
-
-    try:
-        # Build XML
-        root = ET.Element("DPS")
-        inf_dps = ET.SubElement(root, "infDPS", Id=dps_data.get('id', 'DPS1'))
-        ET.SubElement(inf_dps, "tpAmb").text = str(dps_data.get('tpAmb', 2))
-        ET.SubElement(inf_dps, "dhEmi").text = dps_data.get('dhEmi', datetime.now().isoformat())
-        ET.SubElement(inf_dps, "verAplic").text = dps_data.get('verAplic', 'INOVAR_1.0')
-        ET.SubElement(inf_dps, "serie").text = dps_data.get('serie', 'NFS')
-        ET.SubElement(inf_dps, "nDPS").text = str(dps_data.get('nDPS', 1))
-        ET.SubElement(inf_dps, "dCompet").text = dps_data.get('dCompet', datetime.now().strftime("%Y-%m-%d"))
-        ET.SubElement(inf_dps, "tpEmit").text = str(dps_data.get('tpEmit', 1))
-        ET.SubElement(inf_dps, "cLocEmi").text = str(dps_data.get('cLocEmi', 0))
-        ET.SubElement(inf_dps, "tpOp").text = str(dps_data.get('tpOp', 1))
-
-        # Prestador
-        prest = ET.SubElement(inf_dps, "prest")
-        ET.SubElement(prest, "CNPJ").text = dps_data.get('prest', {}).get('CNPJ', '')
-        if dps_data.get('prest', {}).get('IM'):
-            ET.SubElement(prest, "IM").text = dps_data.get('prest', {}).get('IM')
-
-        # Tomador
-        toma = ET.SubElement(inf_dps, "toma")
-        if dps_data.get('toma', {}).get('CNPJ'):
-            ET.SubElement(toma, "CNPJ").text = dps_data.get('toma', {}).get('CNPJ')
-        elif dps_data.get('toma', {}).get('CPF'):
-            ET.SubElement(toma, "CPF").text = dps_data.get('toma', {}).get('CPF')
-        ET.SubElement(toma, "xNome").text = dps_data.get('toma', {}).get('xNome', '')
-
-        # Endereco Tomador
-        end = dps_data.get('toma', {}).get('end', {})
-        if end:
-            t_end = ET.SubElement(toma, "end")
-            ET.SubElement(t_end, "xLgr").text = end.get('xLgr', '')
-            ET.SubElement(t_end, "nro").text = end.get('nro', '')
-            if end.get('xCpl'): ET.SubElement(t_end, "xCpl").text = end.get('xCpl')
-            ET.SubElement(t_end, "xBairro").text = end.get('xBairro', '')
-            ET.SubElement(t_end, "cMun").text = str(end.get('cMun', 0))
-            ET.SubElement(t_end, "UF").text = end.get('UF', '')
-            ET.SubElement(t_end, "CEP").text = end.get('CEP', '')
-
-        # Servico
-        serv = ET.SubElement(inf_dps, "serv")
-        ET.SubElement(serv, "cLocPrestacao").text = str(dps_data.get('serv', {}).get('cLocPrestacao', 0))
-        c_serv = ET.SubElement(serv, "cServ")
-        ET.SubElement(c_serv, "cTribNac").text = dps_data.get('serv', {}).get('cServ', {}).get('cTribNac', '')
-        if dps_data.get('serv', {}).get('cServ', {}).get('CNAE'):
-            ET.SubElement(c_serv, "CNAE").text = dps_data.get('serv', {}).get('cServ', {}).get('CNAE')
-        ET.SubElement(serv, "xDescServ").text = dps_data.get('serv', {}).get('xDescServ', '')
-
-        # Valores
-        vals = ET.SubElement(inf_dps, "valores")
-        v_serv = ET.SubElement(vals, "vServPrest")
-        ET.SubElement(v_serv, "vReceb").text = f"{dps_data.get('valores', {}).get('vServPrest', {}).get('vReceb', 0.0):.2f}"
-
-        vt_dc = ET.SubElement(vals, "vTDC")
-        v_data = dps_data.get('valores', {}).get('vTDC', {})
-        ET.SubElement(vt_dc, "vBC").text = f"{v_data.get('vBC', 0.0):.2f}"
-        ET.SubElement(vt_dc, "pAliqAplic").text = f"{v_data.get('pAliqAplic', 0.0):.2f}"
-        ET.SubElement(vt_dc, "vISSQN").text = f"{v_data.get('vISSQN', 0.0):.2f}"
-        ET.SubElement(vt_dc, "tISSQN").text = str(v_data.get('tISSQN', 1))
-
-        # Convert to XML String
-        xml_string = '<?xml version="1.0" encoding="UTF-8"?>' + ET.tostring(root, encoding="unicode")
-
-        # Compress GZip
-        gzip_buf = io.BytesIO()
-        with gzip.GzipFile(fileobj=gzip_buf, mode="wb") as f:
-            f.write(xml_string.encode('utf-8'))
-        gzip_data = gzip_buf.getvalue()
-
-        # Base64
-        xml_b64 = base64.b64encode(gzip_data).decode('utf-8')
-
-        # Send POST with mTLS
-        payload = {"dpsXmlGZipB64": xml_b64}
-
-        response = requests.post(
-            url,
-            json=payload,
-            cert=(cert_path, cert_path),
-            headers={"Content-Type": "application/json", "Accept": "application/json"},
-            timeout=30
-        )
-
-        if response.status_code != 200:
-             return {"success": False, "error": f"API Error {response.status_code}: {response.text}"}
-
-        return {"success": True, "data": response.json()}
-
-    except Exception as e:
-        return {"success": False, "error": str(e)}
-
-def cancel_nfse(params):
-    import requests
-
-    url = params.get('url')
-    cert_path = params.get('cert_path')
-    chave_acesso = params.get('chave_acesso')
-    motivo = params.get('motivo', '1')
-    x_motivo = params.get('x_motivo', 'Erro de emissao')
-
-    if not url or not cert_path or not chave_acesso:
-        return {"success": False, "error": "Missing url, cert_path or chave_acesso"}
-
-    try:
-        payload = {
-            "chaveAcesso": chave_acesso,
-            "cMotivo": motivo,
-            "xMotivo": x_motivo
-        }
-
-        response = requests.post(
-            url,
-            json=payload,
-            cert=(cert_path, cert_path),
-            headers={"Content-Type": "application/json", "Accept": "application/json"},
-            timeout=30
-        )
-
-        if response.status_code != 200:
-            return {"success": False, "error": f"API Error {response.status_code}: {response.text}"}
-
-        return {"success": True, "data": response.json()}
-    except Exception as e:
-        return {"success": False, "error": str(e)}
-
-def render_danfse(params):
-    from jinja2 import Template
-    data = params.get('data', {})
-
-    template_str = """
-    <!DOCTYPE html>
-    <html lang="pt-BR">
-    <head>
-        <meta charset="UTF-8">
-        <style>
-            body { font-family: sans-serif; font-size: 11px; padding: 20px; }
-            .header { border: 2px solid #000; padding: 15px; display: flex; justify-content: space-between; }
-            .section { border: 1px solid #ccc; margin-top: 10px; padding: 10px; }
-            .total-row { background: #0066cc; color: white; font-weight: bold; }
-        </style>
-    </head>
-    <body>
-        <div class="header">
-            <div><strong>DANFS-e</strong><br>Doc. Auxiliar NFS-e</div>
-            <div>NF-e: {{ data.NumeroNFSe }}</div>
-        </div>
-        <div class="section">
-            <strong>Prestador:</strong> {{ data.PrestadorNome }} ({{ data.PrestadorCNPJ }})
-        </div>
-        <div class="section">
-            <strong>Tomador:</strong> {{ data.TomadorNome }} ({{ data.TomadorDocumento }})
-        </div>
-        <div class="section">
-            <strong>Servico:</strong><br>{{ data.Discriminacao }}
-        </div>
-        <div class="section">
-            <strong>Valor Liquido:</strong> {{ data.ValorLiquido }}
-        </div>
-    </body>
-    </html>
-    """
-
-    try:
-        t = Template(template_str)
-        html = t.render(data=data)
-        return {"success": True, "html": html}
-    except Exception as e:
-        return {"success": False, "error": str(e)}
-
-def calculate_taxes(params):
-    valor_servicos = params.get('valor_servicos', 0.0)
-    valor_deducoes = params.get('valor_deducoes', 0.0)
-    config = params.get('config', {})
-    regime = config.get('regime_tributario', 'SIMPLES_NACIONAL')
-
-    base_calculo = valor_servicos - valor_deducoes
-    result = {
-        "valorServicos": valor_servicos,
-        "valorDeducoes": valor_deducoes,
-        "baseCalculo": base_calculo,
-        "regimeTributario": regime,
-        "totalTributos": 0.0,
-        "aliquotaIss": 0.0,
-        "valorIss": 0.0,
-    }
-
-    if regime == 'MEI':
-        result["observacoes"] = "MEI: Impostos ja inclusos no DAS mensal fixo."
-    elif regime == 'SIMPLES_NACIONAL':
-        faixa = config.get('faixa_simples_nac', 'FAIXA_1')
-        aliquota = 6.0
-        if faixa == 'FAIXA_2': aliquota = 11.2
-        elif faixa == 'FAIXA_3': aliquota = 13.5
-
-        result["aliquotaSimplesNac"] = aliquota
-        result["valorSimplesNac"] = base_calculo * (aliquota / 100)
-        result["totalTributos"] = result["valorSimplesNac"]
-        result["aliquotaIss"] = 2.0
-        result["valorIss"] = base_calculo * 0.02
-        result["observacoes"] = f"Simples Nacional - Aliquota: {aliquota}%"
-    elif regime in ['LUCRO_PRESUMIDO', 'LUCRO_REAL']:
-        result["aliquotaIss"] = config.get('aliquotaIssPadrao', 5.0)
-        result["valorIss"] = base_calculo * (result["aliquotaIss"] / 100)
-        result["aliquotaPis"] = 0.65 if regime == 'LUCRO_PRESUMIDO' else 1.65
-        result["valorPis"] = base_calculo * (result["aliquotaPis"] / 100)
-        result["aliquotaCofins"] = 3.0 if regime == 'LUCRO_PRESUMIDO' else 7.6
-        result["valorCofins"] = base_calculo * (result["aliquotaCofins"] / 100)
-
-        result["totalTributos"] = result["valorIss"] + result["valorPis"] + result["valorCofins"]
-        result["observacoes"] = f"Regime {regime}"
-
-    result["valorLiquido"] = base_calculo - result["totalTributos"]
-    return {"success": True, "data": result}
-
 def lookup_cnpj(params):
     import requests
     import re
@@ -360,14 +129,6 @@ def main():
 
         if action == "send_email":
             result = send_email(params)
-        elif action == "emit_nfse":
-            result = emit_nfse(params)
-        elif action == "cancel_nfse":
-            result = cancel_nfse(params)
-        elif action == "calculate_taxes":
-            result = calculate_taxes(params)
-        elif action == "render_danfse":
-            result = render_danfse(params)
         elif action == "lookup_cnpj":
             result = lookup_cnpj(params)
 
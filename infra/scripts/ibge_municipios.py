@@ -0,0 +1,47 @@
+"""
+Regenerates server/internal/services/data/municipios.csv from the IBGE localidades API
+with all municipalities of the Divisão Territorial Brasileira.
+
+    python3 infra/scripts/ibge_municipios.py [output.csv]
+
+Point FISCAL_DATA_DIR at the output directory to use it without rebuilding the server.
+"""
+import csv
+import os
+import sys
+
+import requests
+
+IBGE_URL = "https://servicodados.ibge.gov.br/api/v1/localidades/municipios"
+DEFAULT_OUTPUT = os.path.join(
+    os.path.dirname(__file__), "..", "..", "server", "internal", "services", "data", "municipios.csv"
+)
+
+
+def fetch_municipios():
+    r = requests.get(IBGE_URL, timeout=60)
+    r.raise_for_status()
+    municipios = []
+    for m in r.json():
+        uf = m["microrregiao"]["mesorregiao"]["UF"]["sigla"] if m.get("microrregiao") else m["regiao-imediata"]["regiao-intermediaria"]["UF"]["sigla"]
+        municipios.append((m["id"], m["nome"], uf))
+    municipios.sort()
+    return municipios
+
+
+def main():
+    output = sys.argv[1] if len(sys.argv) > 1 else DEFAULT_OUTPUT
+    municipios = fetch_municipios()
+    if len(municipios) < 5000:
+        sys.exit(f"❌ IBGE retornou apenas {len(municipios)} municípios, arquivo não atualizado")
+
+    with open(output, "w", newline="", encoding="utf-8") as f:
+        f.write("# Municípios IBGE (DTB). Regenerate the complete table with infra/scripts/ibge_municipios.py\n")
+        w = csv.writer(f, delimiter=";", lineterminator="\n")
+        w.writerow(["codigo", "nome", "uf"])
+        w.writerows(municipios)
+    print(f"✅ {len(municipios)} municípios gravados em {output}")
+
+
+if __name__ == "__main__":
+    main()
@@ -3,18 +3,22 @@ package main
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/helmet"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/websocket/v2"
 
 	"inovar/internal/api/handlers"
 	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
 	"inovar/internal/infra/config"
 	"inovar/internal/infra/database"
+	"inovar/internal/services"
 
 	"github.com/joho/godotenv"
 )
@@ -56,17 +60,61 @@ func main() {
 	if err != nil {
 		log.Fatalf("❌ Erro ao conectar ao banco de dados: %v", err)
 	}
+	database.PromoteSuperAdmins(db, cfg.SuperAdminEmails)
+
+	// IBGE municipalities and municipal ISS rules
+	catalogo, err := services.LoadMunicipioCatalog(cfg.FiscalDataDir)
+	if err != nil {
+		log.Fatalf("❌ Erro ao carregar catálogo de municípios: %v", err)
+	}
+	services.SetMunicipioCatalog(catalogo)
+	log.Printf("🗺️ Catálogo de municípios carregado: %d município(s)", catalogo.Len())
+	if !catalogo.Completo() {
+		log.Printf("⚠️ Catálogo de municípios parcial (%d de %d): NFS-e de clientes de municípios fora dele serão recusadas. Gere a tabela completa com infra/scripts/ibge_municipios.py e aponte FISCAL_DATA_DIR para ela",
+			catalogo.Len(), services.MunicipiosIBGE)
+	}
 
 	// Initialize handlers
 	h := handlers.New(db, cfg)
 
+	// Re-encrypt certificates after a master key rotation
+	if n, err := h.CertVault.Rotate(); err != nil {
+		log.Printf("⚠️ Erro ao recifrar certificados digitais: %v", err)
+	} else if n > 0 {
+		log.Printf("🔐 %d certificado(s) digital(is) recifrado(s) com a chave mestra atual", n)
+	}
+
+	// Re-seal TOTP secrets after a key rotation, and those of earlier versions sealed with the
+	// certificate keys
+	if n, err := h.TwoFactor.Rotate(); err != nil {
+		log.Printf("⚠️ Erro ao recifrar segredos TOTP: %v", err)
+	} else if n > 0 {
+		log.Printf("🔐 %d segredo(s) TOTP recifrado(s) com a chave atual", n)
+	}
+
+	// Fiscal documents of earlier versions were publicly served from the upload tree
+	if n, err := h.NFSeEmissor.MoveFiscalDocuments(); err != nil {
+		log.Printf("⚠️ Erro ao mover documentos fiscais para o armazenamento privado: %v", err)
+	} else if n > 0 {
+		log.Printf("📁 %d documento(s) fiscal(is) movido(s) para o armazenamento privado", n)
+	}
+
+	// Background jobs
+	go h.SLAService.Run()
+	go h.PreventiveService.Run()
+	go h.LockService.Run()
+	go h.JobQueue.Run()
+	go h.NFSeEmissor.Run()
+	go h.CertMonitor.Run()
+	go h.ReceitaBruta.Run()
+
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
 		return c.JSON(fiber.Map{"status": "ok", "version": "1.0.0"})
 	})
 
 	// API routes
-	api := app.Group("/api")
+	api := app.Group(handlers.APIPrefix)
 
 	// API Root Handler (to fix 404 on /api)
 	api.Get("/", func(c *fiber.Ctx) error {
@@ -84,144 +132,31 @@ func main() {
 	auth.Post("/forgot-password", h.ForgotPassword)
 	auth.Post("/reset-password", h.ResetPassword)
 	auth.Post("/register", h.PublicRegister)
+	auth.Post("/2fa/setup", h.SetupTwoFactor)
+	auth.Post("/2fa/verify", limiter.New(limiter.Config{Max: 10, Expiration: time.Minute}), h.VerifyTwoFactor)
 
 	// Protected routes
-	protected := api.Group("", middleware.AuthRequired(cfg.JWTSecret))
-
-	// User profile
-	protected.Get("/me", h.GetCurrentUser)
-	protected.Put("/me", h.UpdateCurrentUser)
-	protected.Put("/me/password", h.ChangePassword)
-	protected.Post("/logout", h.Logout)
-	protected.Post("/upload", h.UploadFile)
-
-	// Company profile
-	protected.Get("/company", h.GetCompany)
-	protected.Put("/company", h.UpdateCompany)
-
-	// Notifications
-	notifications := protected.Group("/notifications")
-	notifications.Get("/", h.ListNotifications)
-	notifications.Patch("/read-all", h.MarkAllNotificationsAsRead)
-	notifications.Patch("/:id/read", h.MarkNotificationAsRead)
-
-	// Users management (Admin)
-	users := protected.Group("/users", middleware.RolesAllowed("ADMIN_SISTEMA"))
-	users.Get("/", h.ListUsers)
-	users.Post("/", h.CreateUser)
-	users.Get("/:id", h.GetUser)
-	users.Put("/:id", h.UpdateUser)
-	users.Patch("/:id/block", h.BlockUser)
-	users.Post("/:id/reset-password", h.AdminResetPassword)
-	users.Delete("/:id", middleware.RolesAllowed("ADMIN_SISTEMA"), h.DeleteUser)
-
-	// Clients
-	clients := protected.Group("/clients")
-	clients.Get("/", h.ListClients)
-	clients.Post("/", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.CreateClient)
-	clients.Get("/:id", h.GetClient)
-	clients.Put("/:id", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.UpdateClient)
-	clients.Patch("/:id/block", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.BlockClient)
-	clients.Delete("/:id", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.DeleteClient)
-
-	// Equipment
-	equipments := protected.Group("/equipments")
-	equipments.Get("/", h.ListEquipments)
-	equipments.Post("/", h.CreateEquipment)
-	equipments.Get("/custom", h.GetCustomQRs)
-	equipments.Post("/custom", h.CreateCustomQR)
-	equipments.Delete("/custom/:id", h.DeleteCustomQR)
-	equipments.Get("/:id", h.GetEquipment)
-	equipments.Put("/:id", h.UpdateEquipment)
-	equipments.Patch("/:id/deactivate", h.DeactivateEquipment)
-	equipments.Patch("/:id/reactivate", h.ReactivateEquipment)
-	equipments.Delete("/:id", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.DeleteEquipment)
-
-	// Service Requests
-	requests := protected.Group("/requests")
-	requests.Get("/", h.ListRequests)
-	requests.Post("/", h.CreateRequest)
-	requests.Get("/:id", h.GetRequest)
-	requests.Put("/:id", h.UpdateRequest)
-	requests.Patch("/:id/status", h.UpdateRequestStatus)
-	requests.Patch("/:id/details", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.UpdateRequestDetails)
-	requests.Patch("/:id/assign", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.AssignRequest)
-	requests.Get("/:id/history", h.GetRequestHistory)
-	requests.Post("/:id/confirm", h.ConfirmRequest)
-	requests.Delete("/:id", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.DeleteRequest)
-
-	// Checklists
-	checklists := protected.Group("/requests/:requestId/checklists")
-	checklists.Get("/", h.ListChecklists)
-	checklists.Post("/", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.CreateChecklist)
-	checklists.Delete("/:id", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.DeleteChecklist)
-	checklists.Patch("/:id", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.ToggleChecklist)
-
-	// Attachments
-	attachments := protected.Group("/requests/:requestId/attachments")
-	attachments.Get("/", h.ListAttachments)
-	attachments.Post("/", h.UploadAttachment)
-	attachments.Delete("/:id", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.DeleteAttachment)
-
-	// Budget/Orcamento
-	requests.Get("/orcamento/sugestoes", h.GetOrcamentoSugestoes)
-	requests.Post("/:id/orcamento/itens", h.AddOrcamentoItem)
-	requests.Delete("/:id/orcamento/itens/:itemId", h.RemoveOrcamentoItem)
-	requests.Post("/:id/orcamento/aprovar", h.AprovarOrcamento)
-
-	// Signatures
-	requests.Post("/:id/assinatura", h.SalvarAssinatura)
-
-	// NFS-e
-	requests.Post("/:id/nfse", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.IssueNFSe)
-	requests.Delete("/:id/nfse", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.CancelNFSe)
-	requests.Get("/:id/nfse", h.GetNFSe)
-	requests.Get("/:id/nfse/danfse", h.GetDANFSe)
-	requests.Get("/:id/nfse/eventos", h.GetNFSeEventos)
-	requests.Post("/:id/nfse/cancelar", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"), h.CancelNFSeWithMotivo)
-
-	// Fiscal Management
-	fiscal := protected.Group("/fiscal", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"))
-	fiscal.Get("/config", h.GetFiscalConfig)
-	fiscal.Put("/config", h.UpdateFiscalConfig)
-	fiscal.Post("/certificate", h.UploadCertificate)
-	fiscal.Get("/regimes", h.GetTaxRegimes)
-	fiscal.Get("/lookup/:cnpj", h.LookupCNPJ)
-	fiscal.Post("/calcular", h.CalculateTaxes)
-
-	// Agenda
-	agenda := protected.Group("/agenda", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"))
-	agenda.Get("/", h.GetAgenda)
-	agenda.Post("/", h.CreateAgendaEntry)
-	agenda.Put("/:id", h.UpdateAgendaEntry)
-	agenda.Delete("/:id", h.DeleteAgendaEntry)
-
-	// Finance (Admin/Tech)
-	finance := protected.Group("/finance", middleware.RolesAllowed("ADMIN_SISTEMA", "TECNICO"))
-	finance.Get("/summary", h.GetFinanceSummary)
-	finance.Get("/transactions", h.ListTransactions)
-	finance.Get("/export", h.ExportFinance)
-
-	// Audit logs (Admin only)
-	audit := protected.Group("/audit", middleware.RolesAllowed("ADMIN_SISTEMA"))
-	audit.Get("/", h.ListAuditLogs)
-	audit.Get("/export", h.ExportAudit)
-
-	// Settings (Admin only)
-	settings := protected.Group("/settings", middleware.RolesAllowed("ADMIN_SISTEMA"))
-	settings.Get("/", h.GetSettings)
-	settings.Put("/", h.UpdateSettings)
-
-	// System Visibility (Admin only)
-	system := protected.Group("/system", middleware.RolesAllowed("ADMIN_SISTEMA"))
-	system.Get("/routes", h.ListRoutes)
-	system.Get("/tables", h.ListTables)
-	system.Get("/tables/:name", h.GetTableData)
+	protected := api.Group("", middleware.AuthRequired(cfg.JWTSecret, h.Sessions), middleware.TenantScope())
+
+	// Authenticated routes, each guarded by the requirement declared in the route table
+	for _, route := range h.Routes() {
+		protected.Add(route.Method, route.Path, middleware.Require(h.Permissoes, route.Requirement), route.Handler)
+	}
 
 	// WebSocket for real-time updates
 	app.Use("/ws", func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
+			// Events carry company data: each connection only receives those of its company, and only
+			// while its session is open, as for the API
+			claims, err := middleware.ParseSessionToken(c.Query("token"), cfg.JWTSecret, h.Sessions)
+			if err != nil {
+				return fiber.ErrUnauthorized
+			}
 			c.Locals("allowed", true)
+			c.Locals("userId", claims.UserID)
+			c.Locals("sessionId", claims.SessionID)
+			c.Locals("companyId", claims.CompanyID)
+			c.Locals("allCompanies", claims.Role == domain.RoleSuperAdmin)
 			return c.Next()
 		}
 		return fiber.ErrUpgradeRequired
@@ -0,0 +1,38 @@
+// Command sefin-fake runs a local stand-in for the SEFIN Nacional (NFS-e) API.
+//
+//	go run ./cmd/sefin-fake -addr 127.0.0.1:8443 -ca ./data/sefin-fake.pem
+//
+// Point the API to it with NFSE_BASE_URL=https://127.0.0.1:8443 and NFSE_CA_FILE=./data/sefin-fake.pem.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"inovar/internal/sefinfake"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8443", "listen address")
+	caFile := flag.String("ca", "./data/sefin-fake.pem", "where to write the server TLS certificate")
+	flag.Parse()
+
+	fake, err := sefinfake.Start(*addr)
+	if err != nil {
+		log.Fatalf("❌ Erro ao iniciar SEFIN fake: %v", err)
+	}
+	defer fake.Close()
+
+	if err := os.WriteFile(*caFile, fake.CertificatePEM(), 0644); err != nil {
+		log.Fatalf("❌ Erro ao gravar certificado: %v", err)
+	}
+
+	log.Printf("🧾 SEFIN fake em %s", fake.URL())
+	log.Printf("   NFSE_BASE_URL=%s NFSE_CA_FILE=%s", fake.URL(), *caFile)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+	<-stop
+}
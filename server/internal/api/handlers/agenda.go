@@ -14,7 +14,6 @@ import (
 func (h *Handler) GetAgenda(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 	role := middleware.GetUserRole(c)
-	companyID := middleware.GetCompanyID(c)
 
 	// Query params
 	startStr := c.Query("start")
@@ -22,11 +21,7 @@ func (h *Handler) GetAgenda(c *fiber.Ctx) error {
 	technicianID := c.Query("technicianId")
 
 	var agenda []domain.Agenda
-	query := h.DB
-
-	if role != domain.RoleAdmin {
-		query = query.Where("company_id = ?", companyID)
-	}
+	query := h.db(c)
 
 	if role == domain.RoleTecnico {
 		query = query.Where("user_id = ?", userID)
@@ -92,11 +87,11 @@ func (h *Handler) CreateAgendaEntry(c *fiber.Ctx) error {
 		}
 	}
 
-	if err := h.DB.Create(&entry).Error; err != nil {
+	if err := h.db(c).Create(&entry).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("agenda:created", entry)
+	h.broadcast(c, "agenda:created", entry)
 
 	return Created(c, entry)
 }
@@ -111,15 +106,15 @@ func (h *Handler) UpdateAgendaEntry(c *fiber.Ctx) error {
 	}
 
 	var entry domain.Agenda
-	if err := h.DB.First(&entry, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&entry, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Agendamento não encontrado")
 	}
 
-	if err := h.DB.Model(&entry).Updates(req).Error; err != nil {
+	if err := h.db(c).Model(&entry).Updates(req).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("agenda:updated", entry)
+	h.broadcast(c, "agenda:updated", entry)
 
 	return Success(c, entry)
 }
@@ -128,11 +123,11 @@ func (h *Handler) UpdateAgendaEntry(c *fiber.Ctx) error {
 func (h *Handler) DeleteAgendaEntry(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	if err := h.DB.Delete(&domain.Agenda{}, "id = ?", id).Error; err != nil {
+	if err := h.db(c).Delete(&domain.Agenda{}, "id = ?", id).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("agenda:deleted", fiber.Map{"id": id})
+	h.broadcast(c, "agenda:deleted", fiber.Map{"id": id})
 
 	return Success(c, fiber.Map{"message": "Agendamento removido"})
 }
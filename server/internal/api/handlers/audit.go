@@ -8,7 +8,7 @@ import (
 // ListAuditLogs returns filtered audit logs
 func (h *Handler) ListAuditLogs(c *fiber.Ctx) error {
 	var logs []domain.AuditLog
-	query := h.DB.Model(&domain.AuditLog{}).Order("created_at desc")
+	query := h.db(c).Model(&domain.AuditLog{}).Order("created_at desc")
 
 	// Filters
 	if entity := c.Query("entity"); entity != "" {
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"time"
 
@@ -10,12 +11,15 @@ import (
 
 	"inovar/internal/api/middleware"
 	"inovar/internal/domain"
+	"inovar/internal/services"
 )
 
 // LoginRequest represents login payload
 type LoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// DeviceToken is the token of a browser remembered after a second-factor sign-in
+	DeviceToken string `json:"deviceToken"`
 }
 
 // Login authenticates a user
@@ -53,43 +57,64 @@ func (h *Handler) Login(c *fiber.Ctx) error {
 		})
 	}
 
+	// Second factor, unless the browser was remembered
+	if user.TOTPEnabled {
+		if h.TwoFactor.TrustedDevice(user.ID, req.DeviceToken) {
+			return h.issueSession(c, &user, nil)
+		}
+		return h.twoFactorChallenge(c, &user, twoFactorPurposeVerify)
+	}
+	if h.TwoFactor.Required(&user) {
+		return h.twoFactorChallenge(c, &user, twoFactorPurposeSetup)
+	}
+
+	return h.issueSession(c, &user, nil)
+}
+
+// issueSession opens a session for a successful sign-in and returns its access and refresh tokens
+func (h *Handler) issueSession(c *fiber.Ctx, user *domain.User, extra fiber.Map) error {
+	refreshTokenStr, session, err := h.Sessions.Start(user.ID, c.Get("User-Agent"), c.IP())
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	accessToken, err := h.accessToken(user, session.FamilyID)
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	data := fiber.Map{
+		"user":         user,
+		"accessToken":  accessToken,
+		"refreshToken": refreshTokenStr,
+		"expiresIn":    h.Config.JWTExpireMinutes * 60,
+	}
+	for k, v := range extra {
+		data[k] = v
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    data,
+	})
+}
+
+// accessToken signs an access token of the user bound to the session
+func (h *Handler) accessToken(user *domain.User, sessionID string) (string, error) {
 	companyID := ""
 	if user.CompanyID != nil {
 		companyID = *user.CompanyID
 	}
 
-	// Generate access token
-	accessToken, err := middleware.GenerateToken(
+	return middleware.GenerateToken(
 		user.ID,
 		user.Email,
 		user.Role,
 		companyID,
+		sessionID,
 		h.Config.JWTSecret,
 		h.Config.JWTExpireMinutes,
 	)
-	if err != nil {
-		return ServerError(c, err)
-	}
-
-	// Generate refresh token
-	refreshTokenStr := uuid.New().String()
-	refreshToken := domain.RefreshToken{
-		ID:        uuid.New().String(),
-		UserID:    user.ID,
-		Token:     refreshTokenStr,
-		ExpiresAt: time.Now().AddDate(0, 0, h.Config.RefreshExpireDays),
-	}
-	h.DB.Create(&refreshToken)
-
-	return c.JSON(fiber.Map{
-		"success": true,
-		"data": fiber.Map{
-			"user":         user,
-			"accessToken":  accessToken,
-			"refreshToken": refreshTokenStr,
-			"expiresIn":    h.Config.JWTExpireMinutes * 60,
-		},
-	})
 }
 
 // RefreshTokenRequest represents refresh token payload
@@ -97,69 +122,69 @@ type RefreshTokenRequest struct {
 	RefreshToken string `json:"refreshToken"`
 }
 
-// RefreshToken generates a new access token
+// RefreshToken rotates the refresh token and generates a new access token
 func (h *Handler) RefreshToken(c *fiber.Ctx) error {
 	var req RefreshTokenRequest
 	if err := c.BodyParser(&req); err != nil {
 		return BadRequest(c, "Dados inválidos")
 	}
 
-	// Find refresh token via GORM
-	var tokenData domain.RefreshToken
-	if err := h.DB.Where("token = ? AND revoked = ?", req.RefreshToken, false).First(&tokenData).Error; err != nil {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"error":   "invalid_token",
-			"message": "Refresh token inválido",
-		})
-	}
-
-	// Check expiration
-	if tokenData.ExpiresAt.Before(time.Now()) {
+	refreshTokenStr, session, err := h.Sessions.Rotate(req.RefreshToken, c.Get("User-Agent"), c.IP())
+	if err != nil {
+		code := "invalid_token"
+		switch {
+		case errors.Is(err, services.ErrRefreshExpired):
+			code = "token_expired"
+		case errors.Is(err, services.ErrRefreshReused):
+			code = "token_reused"
+			log.Printf("🚨 Refresh token reutilizado: sessão encerrada")
+		case !errors.Is(err, services.ErrRefreshInvalid):
+			return ServerError(c, err)
+		}
 		return c.Status(fiber.StatusOK).JSON(fiber.Map{
 			"success": false,
-			"error":   "token_expired",
-			"message": "Refresh token expirado",
+			"error":   code,
+			"message": err.Error(),
 		})
 	}
 
 	// Find user via GORM
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", tokenData.UserID).Error; err != nil {
+	if err := h.DB.First(&user, "id = ?", session.UserID).Error; err != nil {
 		return ServerError(c, err)
 	}
-
-	companyID := ""
-	if user.CompanyID != nil {
-		companyID = *user.CompanyID
+	if !user.Active {
+		h.Sessions.RevokeAll(user.ID, "", domain.SessionRevokedBlocked)
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"success": false,
+			"error":   "user_blocked",
+			"message": "Usuário bloqueado. Contate o administrador.",
+		})
 	}
 
-	// Generate new access token
-	accessToken, err := middleware.GenerateToken(
-		user.ID,
-		user.Email,
-		user.Role,
-		companyID,
-		h.Config.JWTSecret,
-		h.Config.JWTExpireMinutes,
-	)
+	accessToken, err := h.accessToken(&user, session.FamilyID)
 	if err != nil {
 		return ServerError(c, err)
 	}
 
 	return c.JSON(fiber.Map{
-		"success":     true,
-		"accessToken": accessToken,
-		"expiresIn":   h.Config.JWTExpireMinutes * 60,
+		"success":      true,
+		"accessToken":  accessToken,
+		"refreshToken": refreshTokenStr,
+		"expiresIn":    h.Config.JWTExpireMinutes * 60,
 	})
 }
 
-// Logout revokes refresh token
+// Logout ends the current session, or every session of the user with ?all=true
 func (h *Handler) Logout(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
+	sessionID := middleware.GetSessionID(c)
 
-	// Revoke all refresh tokens for user via GORM
-	h.DB.Model(&domain.RefreshToken{}).Where("user_id = ?", userID).Update("revoked", true)
+	if c.QueryBool("all") || sessionID == "" {
+		h.Sessions.RevokeAll(userID, "", domain.SessionRevokedLogout)
+	} else {
+		h.Sessions.RevokeSession(userID, sessionID, domain.SessionRevokedLogout)
+	}
 
 	return Success(c, fiber.Map{"message": "Logout realizado com sucesso"})
 }
@@ -240,6 +265,7 @@ func (h *Handler) ResetPassword(c *fiber.Ctx) error {
 		"password_hash": string(hashedPassword),
 		"reset_token":   nil,
 	})
+	h.Sessions.RevokeAll(user.ID, "", domain.SessionRevokedPassword)
 
 	return Success(c, fiber.Map{"message": "Senha alterada com sucesso"})
 }
@@ -249,7 +275,7 @@ func (h *Handler) GetCurrentUser(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", userID).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", userID).Error; err != nil {
 		return NotFound(c, "Usuário não encontrado")
 	}
 
@@ -273,7 +299,7 @@ func (h *Handler) UpdateCurrentUser(c *fiber.Ctx) error {
 	}
 
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", userID).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", userID).Error; err != nil {
 		return NotFound(c, "Usuário não encontrado")
 	}
 
@@ -287,7 +313,7 @@ func (h *Handler) UpdateCurrentUser(c *fiber.Ctx) error {
 		user.AvatarURL = *req.AvatarURL
 	}
 
-	if err := h.DB.Save(&user).Error; err != nil {
+	if err := h.db(c).Save(&user).Error; err != nil {
 		return ServerError(c, err)
 	}
 
@@ -312,7 +338,7 @@ func (h *Handler) ChangePassword(c *fiber.Ctx) error {
 
 	// Get user via GORM
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", userID).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", userID).Error; err != nil {
 		return NotFound(c, "Usuário não encontrado")
 	}
 
@@ -329,7 +355,40 @@ func (h *Handler) ChangePassword(c *fiber.Ctx) error {
 
 	user.PasswordHash = string(hashedPassword)
 	user.MustChangePassword = false
-	h.DB.Save(&user)
+	h.db(c).Save(&user)
+
+	// Other devices must sign in again with the new password
+	h.Sessions.RevokeAll(user.ID, middleware.GetSessionID(c), domain.SessionRevokedPassword)
 
 	return Success(c, fiber.Map{"message": "Senha alterada com sucesso"})
 }
+
+// ListSessions returns the active sessions (signed-in devices) of the authenticated user
+func (h *Handler) ListSessions(c *fiber.Ctx) error {
+	sessions, err := h.Sessions.List(middleware.GetUserID(c), middleware.GetSessionID(c))
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, sessions)
+}
+
+// RevokeSession signs the authenticated user out of one of their sessions
+func (h *Handler) RevokeSession(c *fiber.Ctx) error {
+	ok, err := h.Sessions.RevokeSession(middleware.GetUserID(c), c.Params("id"), domain.SessionRevokedUser)
+	if err != nil {
+		return ServerError(c, err)
+	}
+	if !ok {
+		return NotFound(c, "Sessão não encontrada")
+	}
+	return Success(c, fiber.Map{"message": "Sessão encerrada"})
+}
+
+// RevokeOtherSessions signs the authenticated user out of every session but the current one
+func (h *Handler) RevokeOtherSessions(c *fiber.Ctx) error {
+	n, err := h.Sessions.RevokeAll(middleware.GetUserID(c), middleware.GetSessionID(c), domain.SessionRevokedUser)
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, fiber.Map{"message": "Sessões encerradas", "count": n})
+}
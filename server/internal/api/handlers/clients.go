@@ -16,18 +16,15 @@ import (
 func (h *Handler) ListClients(c *fiber.Ctx) error {
 	role := middleware.GetUserRole(c)
 	userID := middleware.GetUserID(c)
-	companyID := middleware.GetCompanyID(c)
 
 	var clients []domain.Cliente
-	query := h.DB.Preload("Endereco")
+	query := h.db(c).Preload("Endereco")
 
 	if role == domain.RoleCliente {
 		// A client can only see their own profile?
 		// Actually, Cliente domain model usually represents the legal entity/person.
 		// If the user role is CLIENTE, find the client associated with this UserID.
 		query = query.Where("user_id = ?", userID)
-	} else if role != domain.RoleAdmin {
-		query = query.Where("company_id = ?", companyID)
 	}
 
 	if err := query.Find(&clients).Error; err != nil {
@@ -61,7 +58,7 @@ func (h *Handler) CreateClient(c *fiber.Ctx) error {
 		var company struct {
 			ID string
 		}
-		if err := h.DB.Table("prestadores").Select("id").First(&company).Error; err != nil {
+		if err := h.db(c).Table("prestadores").Select("id").First(&company).Error; err != nil {
 			return BadRequest(c, "Nenhuma empresa (prestador) cadastrada no sistema")
 		}
 		companyID = company.ID
@@ -88,7 +85,7 @@ func (h *Handler) CreateClient(c *fiber.Ctx) error {
 		MustChangePassword: true,
 	}
 
-	if err := h.DB.Create(&user).Error; err != nil {
+	if err := h.db(c).Create(&user).Error; err != nil {
 		log.Printf("❌ Falha ao criar conta de usuário para o cliente: %v", err)
 		return BadRequest(c, fmt.Sprintf("Erro ao criar conta de acesso: %v", err))
 	}
@@ -107,7 +104,7 @@ func (h *Handler) CreateClient(c *fiber.Ctx) error {
 			State:      req.Endereco.State,
 			ZipCode:    req.Endereco.ZipCode,
 		}
-		if err := h.DB.Create(&endereco).Error; err == nil {
+		if err := h.db(c).Create(&endereco).Error; err == nil {
 			enderecoID = &addrID
 		}
 	}
@@ -125,12 +122,12 @@ func (h *Handler) CreateClient(c *fiber.Ctx) error {
 		Active:     true,
 	}
 
-	if err := h.DB.Create(&client).Error; err != nil {
+	if err := h.db(c).Create(&client).Error; err != nil {
 		log.Printf("❌ Falha crítica ao salvar cliente no banco: %v", err)
 		return BadRequest(c, fmt.Sprintf("Não foi possível salvar os dados do cliente: %v", err))
 	}
 
-	h.Hub.Broadcast("client:created", client)
+	h.Hub.Broadcast(client.CompanyID, "client:created", client)
 
 	// Send Notifications (Email)
 	go func() {
@@ -147,7 +144,7 @@ func (h *Handler) GetClient(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var client domain.Cliente
-	if err := h.DB.Preload("Endereco").First(&client, "id = ?", id).Error; err != nil {
+	if err := h.db(c).Preload("Endereco").First(&client, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Cliente não encontrado")
 	}
 
@@ -164,7 +161,7 @@ func (h *Handler) UpdateClient(c *fiber.Ctx) error {
 	}
 
 	var client domain.Cliente
-	if err := h.DB.First(&client, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&client, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Cliente não encontrado")
 	}
 
@@ -179,7 +176,7 @@ func (h *Handler) UpdateClient(c *fiber.Ctx) error {
 	if req.Endereco != nil {
 		var endereco domain.Endereco
 		if client.EnderecoID != nil {
-			h.DB.First(&endereco, "id = ?", *client.EnderecoID)
+			h.db(c).First(&endereco, "id = ?", *client.EnderecoID)
 		} else {
 			addrID := uuid.New().String()
 			endereco.ID = addrID
@@ -194,21 +191,21 @@ func (h *Handler) UpdateClient(c *fiber.Ctx) error {
 		endereco.State = req.Endereco.State
 		endereco.ZipCode = req.Endereco.ZipCode
 
-		h.DB.Save(&endereco)
+		h.db(c).Save(&endereco)
 	}
 
-	if err := h.DB.Save(&client).Error; err != nil {
+	if err := h.db(c).Save(&client).Error; err != nil {
 		return ServerError(c, err)
 	}
 
 	// Update associated user if email changed or needed
-	h.DB.Model(&domain.User{}).Where("id = ?", client.UserID).Updates(map[string]interface{}{
+	h.db(c).Model(&domain.User{}).Where("id = ?", client.UserID).Updates(map[string]interface{}{
 		"name":  client.Name,
 		"email": client.Email,
 		"phone": client.Phone,
 	})
 
-	h.Hub.Broadcast("client:updated", client)
+	h.Hub.Broadcast(client.CompanyID, "client:updated", client)
 
 	// Final Audit
 	h.LogAudit(c, "Client", id, "UPDATE", fmt.Sprintf("Updated client %s", req.Name), before, client)
@@ -221,23 +218,23 @@ func (h *Handler) BlockClient(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var client domain.Cliente
-	if err := h.DB.First(&client, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&client, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Cliente não encontrado")
 	}
 
 	client.Active = !client.Active
-	if err := h.DB.Save(&client).Error; err != nil {
+	if err := h.db(c).Save(&client).Error; err != nil {
 		return ServerError(c, err)
 	}
 
 	// Also block/unblock the user
-	h.DB.Model(&domain.User{}).Where("id = ?", client.UserID).Update("active", client.Active)
+	h.db(c).Model(&domain.User{}).Where("id = ?", client.UserID).Update("active", client.Active)
 
 	action := "client:blocked"
 	if client.Active {
 		action = "client:unblocked"
 	}
-	h.Hub.Broadcast(action, fiber.Map{"id": id})
+	h.Hub.Broadcast(client.CompanyID, action, fiber.Map{"id": id})
 
 	return Success(c, fiber.Map{"active": client.Active})
 }
@@ -247,12 +244,12 @@ func (h *Handler) DeleteClient(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var client domain.Cliente
-	if err := h.DB.First(&client, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&client, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Cliente não encontrado")
 	}
 
 	// Delete in transaction
-	tx := h.DB.Begin()
+	tx := h.db(c).Begin()
 
 	// Delete solicitudes/requests related to this client?
 	// Usually better to keep them or archive. For now, let's just delete the client surface.
@@ -272,7 +269,7 @@ func (h *Handler) DeleteClient(c *fiber.Ctx) error {
 
 	tx.Commit()
 
-	h.Hub.Broadcast("client:deleted", fiber.Map{"id": id})
+	h.Hub.Broadcast(client.CompanyID, "client:deleted", fiber.Map{"id": id})
 
 	return Success(c, fiber.Map{"message": "Cliente e todos os dados associados foram removidos permanentemente"})
 }
@@ -12,7 +12,7 @@ func (h *Handler) GetCustomQRs(c *fiber.Ctx) error {
 	companyID := c.Locals("companyId").(string)
 	var qrs []domain.CustomQRCode
 
-	if err := h.DB.Where("company_id = ?", companyID).Order("created_at desc").Find(&qrs).Error; err != nil {
+	if err := h.db(c).Where("company_id = ?", companyID).Order("created_at desc").Find(&qrs).Error; err != nil {
 		return ServerError(c, err)
 	}
 
@@ -30,7 +30,7 @@ func (h *Handler) CreateCustomQR(c *fiber.Ctx) error {
 	qr.ID = uuid.New().String()
 	qr.CompanyID = companyID
 
-	if err := h.DB.Create(&qr).Error; err != nil {
+	if err := h.db(c).Create(&qr).Error; err != nil {
 		return ServerError(c, err)
 	}
 
@@ -41,7 +41,7 @@ func (h *Handler) DeleteCustomQR(c *fiber.Ctx) error {
 	companyID := c.Locals("companyId").(string)
 	id := c.Params("id")
 
-	if err := h.DB.Where("id = ? AND company_id = ?", id, companyID).Delete(&domain.CustomQRCode{}).Error; err != nil {
+	if err := h.db(c).Where("id = ? AND company_id = ?", id, companyID).Delete(&domain.CustomQRCode{}).Error; err != nil {
 		return ServerError(c, err)
 	}
 
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+)
+
+// fiscalCompanyID resolves the company whose fiscal data the logged user manages
+func (h *Handler) fiscalCompanyID(c *fiber.Ctx) string {
+	var user domain.User
+	if err := h.db(c).First(&user, "id = ?", middleware.GetUserID(c)).Error; err != nil || user.CompanyID == nil {
+		return ""
+	}
+	return *user.CompanyID
+}
+
+// ListSeriesDPS returns the DPS series of the company with their counters
+func (h *Handler) ListSeriesDPS(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	series, err := h.DPSNumeracao.Series(companyID)
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, series)
+}
+
+// CreateSerieDPS opens a new DPS series
+func (h *Handler) CreateSerieDPS(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	var req struct {
+		Serie         string `json:"serie"`
+		ProximoNumero int64  `json:"proximoNumero"`
+		Padrao        bool   `json:"padrao"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	serie, err := h.DPSNumeracao.CriarSerie(companyID, req.Serie, req.ProximoNumero, req.Padrao)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	h.LogAudit(c, "SerieDPS", serie.ID, "CREATE", fmt.Sprintf("Série %s criada a partir do número %d", serie.Serie, serie.UltimoNumero+1), nil, serie)
+	return Created(c, serie)
+}
+
+// UpdateSerieDPS resets the next number of a series and/or makes it the default one
+func (h *Handler) UpdateSerieDPS(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	var req struct {
+		ProximoNumero int64  `json:"proximoNumero"`
+		Padrao        bool   `json:"padrao"`
+		Motivo        string `json:"motivo"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+	if req.ProximoNumero == 0 && !req.Padrao {
+		return BadRequest(c, "Informe o próximo número ou defina a série como padrão")
+	}
+
+	var before domain.SerieDPS
+	if err := h.db(c).Where("prestador_id = ? AND serie = ?", companyID, c.Params("serie")).First(&before).Error; err != nil {
+		return NotFound(c, "Série não encontrada")
+	}
+
+	serie := &before
+	var err error
+	if req.ProximoNumero > 0 {
+		if req.Motivo == "" {
+			return BadRequest(c, "Informe o motivo da redefinição da numeração")
+		}
+		if serie, err = h.DPSNumeracao.Redefinir(companyID, before.Serie, req.ProximoNumero); err != nil {
+			return BadRequest(c, err.Error())
+		}
+		h.LogAudit(c, "SerieDPS", serie.ID, "RESET",
+			fmt.Sprintf("Série %s: próximo número %d → %d | Motivo: %s", serie.Serie, before.UltimoNumero+1, serie.UltimoNumero+1, req.Motivo), before, serie)
+	}
+	if req.Padrao && !before.Padrao {
+		if serie, err = h.DPSNumeracao.DefinirPadrao(companyID, before.Serie); err != nil {
+			return BadRequest(c, err.Error())
+		}
+		h.LogAudit(c, "SerieDPS", serie.ID, "UPDATE", fmt.Sprintf("Série %s definida como padrão", serie.Serie), before, serie)
+	}
+
+	return Success(c, serie)
+}
+
+// GetLacunasDPS reports the unused numbers of a series for inutilização
+func (h *Handler) GetLacunasDPS(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	var count int64
+	h.db(c).Model(&domain.SerieDPS{}).Where("prestador_id = ? AND serie = ?", companyID, c.Params("serie")).Count(&count)
+	if count == 0 {
+		return NotFound(c, "Série não encontrada")
+	}
+
+	relatorio, err := h.DPSNumeracao.Lacunas(companyID, c.Params("serie"))
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, relatorio)
+}
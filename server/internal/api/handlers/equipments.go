@@ -7,23 +7,16 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 
-	"inovar/internal/api/middleware"
 	"inovar/internal/domain"
 )
 
 // ListEquipments returns equipments based on user role
 func (h *Handler) ListEquipments(c *fiber.Ctx) error {
-	role := middleware.GetUserRole(c)
-	companyID := middleware.GetCompanyID(c)
 	clientID := c.Query("clientId")
 	activeOnly := c.Query("activeOnly", "true")
 
 	var equipments []domain.Equipamento
-	query := h.DB.Preload("Client")
-
-	if role != domain.RoleAdmin {
-		query = query.Where("company_id = ?", companyID)
-	}
+	query := h.db(c).Preload("Client").Preload("Endereco")
 
 	if clientID != "" {
 		query = query.Where("client_id = ?", clientID)
@@ -42,14 +35,44 @@ func (h *Handler) ListEquipments(c *fiber.Ctx) error {
 
 // CreateEquipmentRequest represents equipment creation payload
 type CreateEquipmentRequest struct {
-	ClientID           string `json:"clientId"`
-	Brand              string `json:"brand"`
-	Model              string `json:"model"`
-	BTU                int    `json:"btu"`
-	SerialNumber       string `json:"serialNumber"`
-	Location           string `json:"location"`
-	LastPreventiveDate string `json:"lastPreventiveDate"`
-	PreventiveInterval int    `json:"preventiveInterval"`
+	ClientID           string  `json:"clientId"`
+	Brand              string  `json:"brand"`
+	Model              string  `json:"model"`
+	BTU                int     `json:"btu"`
+	SerialNumber       string  `json:"serialNumber"`
+	Location           string  `json:"location"`
+	LastPreventiveDate string  `json:"lastPreventiveDate"`
+	PreventiveInterval int     `json:"preventiveInterval"`
+	GasType            string  `json:"gasType"`
+	CargaNominalKg     float64 `json:"cargaNominalKg"`
+	// Site of the unit when it is not installed at the address of the client
+	Endereco *CreateEnderecoRequest `json:"endereco,omitempty"`
+}
+
+// saveEquipmentSite creates or updates the site address of an equipment
+func (h *Handler) saveEquipmentSite(c *fiber.Ctx, equipment *domain.Equipamento, req *CreateEnderecoRequest) error {
+	var endereco domain.Endereco
+	if equipment.EnderecoID != nil {
+		h.db(c).First(&endereco, "id = ?", *equipment.EnderecoID)
+	}
+	if endereco.ID == "" {
+		endereco.ID = uuid.New().String()
+	}
+
+	endereco.Street = req.Street
+	endereco.Number = req.Number
+	endereco.Complement = req.Complement
+	endereco.District = req.District
+	endereco.City = req.City
+	endereco.State = req.State
+	endereco.ZipCode = req.ZipCode
+
+	if err := h.db(c).Save(&endereco).Error; err != nil {
+		return err
+	}
+	equipment.EnderecoID = &endereco.ID
+	equipment.Endereco = &endereco
+	return nil
 }
 
 // CreateEquipment creates a new equipment
@@ -61,9 +84,12 @@ func (h *Handler) CreateEquipment(c *fiber.Ctx) error {
 
 	// Get companyID from client
 	var client domain.Cliente
-	if err := h.DB.First(&client, "id = ?", req.ClientID).Error; err != nil {
+	if err := h.db(c).First(&client, "id = ?", req.ClientID).Error; err != nil {
 		return BadRequest(c, "Cliente não encontrado")
 	}
+	if !h.canWriteEquipment(c, client.ID) {
+		return Forbidden(c, "Sem permissão para cadastrar equipamentos deste cliente")
+	}
 
 	equipment := domain.Equipamento{
 		ID:                 uuid.New().String(),
@@ -75,8 +101,15 @@ func (h *Handler) CreateEquipment(c *fiber.Ctx) error {
 		SerialNumber:       req.SerialNumber,
 		Location:           req.Location,
 		PreventiveInterval: req.PreventiveInterval,
+		GasType:            req.GasType,
+		CargaNominalKg:     req.CargaNominalKg,
 		Active:             true,
 	}
+	if req.Endereco != nil {
+		if err := h.saveEquipmentSite(c, &equipment, req.Endereco); err != nil {
+			return ServerError(c, err)
+		}
+	}
 
 	if req.LastPreventiveDate != "" {
 		if t, err := time.Parse(time.RFC3339, req.LastPreventiveDate); err == nil {
@@ -89,11 +122,11 @@ func (h *Handler) CreateEquipment(c *fiber.Ctx) error {
 		}
 	}
 
-	if err := h.DB.Create(&equipment).Error; err != nil {
+	if err := h.db(c).Create(&equipment).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("equipment:created", equipment)
+	h.Hub.Broadcast(equipment.CompanyID, "equipment:created", equipment)
 
 	return Created(c, equipment)
 }
@@ -103,7 +136,7 @@ func (h *Handler) GetEquipment(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var equipment domain.Equipamento
-	if err := h.DB.Preload("Client").First(&equipment, "id = ?", id).Error; err != nil {
+	if err := h.db(c).Preload("Client").Preload("Endereco").First(&equipment, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Equipamento não encontrado")
 	}
 
@@ -120,9 +153,12 @@ func (h *Handler) UpdateEquipment(c *fiber.Ctx) error {
 	}
 
 	var equipment domain.Equipamento
-	if err := h.DB.First(&equipment, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&equipment, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Equipamento não encontrado")
 	}
+	if !h.canWriteEquipment(c, equipment.ClientID) {
+		return Forbidden(c, "Sem permissão para alterar este equipamento")
+	}
 
 	before := equipment
 
@@ -132,6 +168,18 @@ func (h *Handler) UpdateEquipment(c *fiber.Ctx) error {
 	equipment.SerialNumber = req.SerialNumber
 	equipment.Location = req.Location
 	equipment.PreventiveInterval = req.PreventiveInterval
+	// Refrigerant data is kept when the form does not send it
+	if req.GasType != "" {
+		equipment.GasType = req.GasType
+	}
+	if req.CargaNominalKg > 0 {
+		equipment.CargaNominalKg = req.CargaNominalKg
+	}
+	if req.Endereco != nil {
+		if err := h.saveEquipmentSite(c, &equipment, req.Endereco); err != nil {
+			return ServerError(c, err)
+		}
+	}
 
 	if req.LastPreventiveDate != "" {
 		if t, err := time.Parse(time.RFC3339, req.LastPreventiveDate); err == nil {
@@ -143,11 +191,11 @@ func (h *Handler) UpdateEquipment(c *fiber.Ctx) error {
 		}
 	}
 
-	if err := h.DB.Save(&equipment).Error; err != nil {
+	if err := h.db(c).Save(&equipment).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("equipment:updated", equipment)
+	h.Hub.Broadcast(equipment.CompanyID, "equipment:updated", equipment)
 
 	// Final Audit
 	h.LogAudit(c, "Equipment", id, "UPDATE", fmt.Sprintf("Updated equipment %s", req.Model), before, equipment)
@@ -160,16 +208,19 @@ func (h *Handler) DeactivateEquipment(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var equipment domain.Equipamento
-	if err := h.DB.First(&equipment, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&equipment, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Equipamento não encontrado")
 	}
+	if !h.canWriteEquipment(c, equipment.ClientID) {
+		return Forbidden(c, "Sem permissão para alterar este equipamento")
+	}
 
 	equipment.Active = false
-	if err := h.DB.Save(&equipment).Error; err != nil {
+	if err := h.db(c).Save(&equipment).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("equipment:updated", equipment)
+	h.Hub.Broadcast(equipment.CompanyID, "equipment:updated", equipment)
 
 	return Success(c, equipment)
 }
@@ -179,16 +230,19 @@ func (h *Handler) ReactivateEquipment(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var equipment domain.Equipamento
-	if err := h.DB.First(&equipment, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&equipment, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Equipamento não encontrado")
 	}
+	if !h.canWriteEquipment(c, equipment.ClientID) {
+		return Forbidden(c, "Sem permissão para alterar este equipamento")
+	}
 
 	equipment.Active = true
-	if err := h.DB.Save(&equipment).Error; err != nil {
+	if err := h.db(c).Save(&equipment).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("equipment:updated", equipment)
+	h.Hub.Broadcast(equipment.CompanyID, "equipment:updated", equipment)
 
 	return Success(c, equipment)
 }
@@ -197,11 +251,11 @@ func (h *Handler) ReactivateEquipment(c *fiber.Ctx) error {
 func (h *Handler) DeleteEquipment(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	if err := h.DB.Delete(&domain.Equipamento{}, "id = ?", id).Error; err != nil {
+	if err := h.db(c).Delete(&domain.Equipamento{}, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Equipamento não encontrado")
 	}
 
-	h.Hub.Broadcast("equipment:deleted", fiber.Map{"id": id})
+	h.broadcast(c, "equipment:deleted", fiber.Map{"id": id})
 
 	return Success(c, fiber.Map{"message": "Equipamento excluído permanentemente"})
 }
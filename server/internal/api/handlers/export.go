@@ -12,12 +12,12 @@ import (
 // ExportFinance exports financial transactions to CSV
 func (h *Handler) ExportFinance(c *fiber.Ctx) error {
 	var requests []domain.Solicitacao
-	if err := h.DB.Preload("OrcamentoItens").Find(&requests).Error; err != nil {
+	if err := h.db(c).Preload("OrcamentoItens").Find(&requests).Error; err != nil {
 		return ServerError(c, err)
 	}
 
 	var expenses []domain.Expense
-	h.DB.Find(&expenses)
+	h.db(c).Find(&expenses)
 
 	c.Set("Content-Type", "text/csv")
 	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=finance_export_%s.csv", time.Now().Format("20060102_1504")))
@@ -60,7 +60,7 @@ func (h *Handler) ExportFinance(c *fiber.Ctx) error {
 // ExportAudit exports audit logs to CSV
 func (h *Handler) ExportAudit(c *fiber.Ctx) error {
 	var logs []domain.AuditLog
-	if err := h.DB.Order("created_at desc").Find(&logs).Error; err != nil {
+	if err := h.db(c).Order("created_at desc").Find(&logs).Error; err != nil {
 		return ServerError(c, err)
 	}
 
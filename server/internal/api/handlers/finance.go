@@ -12,12 +12,12 @@ func (h *Handler) GetFinanceSummary(c *fiber.Ctx) error {
 	var count int64
 
 	// Example: sum of all budget items in completed requests
-	h.DB.Model(&domain.OrcamentoItem{}).
-		Joins("Join solicitacoes on solicitacoes.id = orcamento_items.solicitacao_id").
+	h.db(c).Model(&domain.OrcamentoItem{}).
+		Joins("Join solicitacoes on solicitacoes.id = orcamento_itens.solicitacao_id").
 		Where("solicitacoes.status = ?", domain.StatusConcluida).
 		Select("sum(valor_unit * quantidade)").Row().Scan(&total)
 
-	h.DB.Model(&domain.Solicitacao{}).Where("status = ?", domain.StatusConcluida).Count(&count)
+	h.db(c).Model(&domain.Solicitacao{}).Where("status = ?", domain.StatusConcluida).Count(&count)
 
 	return Success(c, fiber.Map{
 		"totalRevenue":    total,
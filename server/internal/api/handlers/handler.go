@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 
 	"github.com/gofiber/fiber/v2"
@@ -23,6 +24,31 @@ type Handler struct {
 	EmailService        *services.EmailService
 	StorageService      *services.StorageService
 	NotificationService *services.NotificationService
+	SLAService          *services.SLAService
+	PreventiveService   *services.PreventiveService
+	PMOCService         *services.PMOCService
+	RefrigerantService  *services.RefrigerantService
+	LockService         *services.LockService
+	NFSeEmissor         *services.NFSeEmissor
+	JobQueue            *services.JobQueue
+	DPSNumeracao        *services.DPSNumeracao
+	CertVault           *services.CertVault
+	CertMonitor         *services.CertificateMonitor
+	ReceitaBruta        *services.ReceitaBrutaService
+	LivroFiscal         *services.LivroFiscalService
+	Permissoes          *services.PermissaoService
+	TwoFactor           *services.TwoFactorService
+	Sessions            *services.SessionService
+}
+
+// db returns the database scoped to the company of the request (see middleware.TenantScope)
+func (h *Handler) db(c *fiber.Ctx) *gorm.DB {
+	return h.DB.WithContext(c.UserContext())
+}
+
+// broadcast sends an event to the clients of the company of the logged user
+func (h *Handler) broadcast(c *fiber.Ctx, topic string, payload interface{}) {
+	h.Hub.Broadcast(middleware.GetCompanyID(c), topic, payload)
 }
 
 // CreateEnderecoRequest represents address creation payload
@@ -38,11 +64,21 @@ type CreateEnderecoRequest struct {
 
 // New creates a new Handler instance
 func New(db *gorm.DB, cfg *config.Config) *Handler {
+	sessions := services.NewSessionService(db, cfg)
 	hub := websocket.NewHub()
+	hub.CheckSessions(sessions.Active)
 	go hub.Run()
 
 	emailService := services.NewEmailService(cfg, db)
 	storageService := services.NewStorageService(cfg)
+	permissoes := services.NewPermissaoService(db)
+	notificationService := services.NewNotificationService(db, hub, permissoes)
+	slaService := services.NewSLAService(db, hub, notificationService, cfg)
+	lockService := services.NewLockService(db, hub, cfg)
+	hub.OnMessage(lockService.HandleMessage)
+	jobQueue := services.NewJobQueue(db, hub, cfg)
+	numeracao := services.NewDPSNumeracao(db)
+	certVault := services.NewCertVault(db, storageService, cfg)
 
 	return &Handler{
 		DB:                  db,
@@ -50,7 +86,22 @@ func New(db *gorm.DB, cfg *config.Config) *Handler {
 		Hub:                 hub,
 		EmailService:        emailService,
 		StorageService:      storageService,
-		NotificationService: services.NewNotificationService(db, hub),
+		NotificationService: notificationService,
+		SLAService:          slaService,
+		PreventiveService:   services.NewPreventiveService(db, hub, slaService, cfg),
+		PMOCService:         services.NewPMOCService(db, hub),
+		RefrigerantService:  services.NewRefrigerantService(db, hub, notificationService),
+		LockService:         lockService,
+		NFSeEmissor:         services.NewNFSeEmissor(db, hub, storageService, certVault, jobQueue, numeracao, notificationService, cfg),
+		JobQueue:            jobQueue,
+		DPSNumeracao:        numeracao,
+		CertVault:           certVault,
+		CertMonitor:         services.NewCertificateMonitor(db, notificationService, emailService, cfg),
+		ReceitaBruta:        services.NewReceitaBrutaService(db, notificationService, cfg),
+		LivroFiscal:         services.NewLivroFiscalService(db, storageService),
+		Permissoes:          permissoes,
+		TwoFactor:           services.NewTwoFactorService(db, cfg),
+		Sessions:            sessions,
 	}
 }
 
@@ -113,6 +164,42 @@ func Forbidden(c *fiber.Ctx, message string) error {
 	})
 }
 
+// TransitionConflict renders a workflow transition rejected by the domain engine
+func TransitionConflict(c *fiber.Ctx, err error) error {
+	var te *domain.TransitionError
+	if !errors.As(err, &te) {
+		return BadRequest(c, err.Error())
+	}
+
+	code := fiber.StatusConflict
+	if te.Code == domain.TransitionForbidden {
+		code = fiber.StatusForbidden
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"success": false,
+		"error":   te.Code,
+		"message": te.Message,
+		"from":    te.From,
+		"to":      te.To,
+		"allowed": te.Allowed,
+	})
+}
+
+// Locked renders a request whose edit lock is held by another user
+func Locked(c *fiber.Ctx, lock *services.LockInfo) error {
+	holder := lock.LockedName
+	if holder == "" {
+		holder = "outro usuário"
+	}
+	return c.Status(fiber.StatusLocked).JSON(fiber.Map{
+		"success": false,
+		"error":   "locked",
+		"message": "Solicitação bloqueada para edição por " + holder,
+		"lock":    lock,
+	})
+}
+
 func ServerError(c *fiber.Ctx, err error) error {
 	// Log the error internally
 	if err != nil {
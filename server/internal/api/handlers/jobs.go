@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"inovar/internal/domain"
+)
+
+// ListJobs returns background jobs, filtered by status, type and reference
+func (h *Handler) ListJobs(c *fiber.Ctx) error {
+	query := h.db(c).Order("created_at desc").Limit(c.QueryInt("limit", 100))
+
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if tipo := c.Query("tipo"); tipo != "" {
+		query = query.Where("tipo = ?", tipo)
+	}
+	if ref := c.Query("referenciaId"); ref != "" {
+		query = query.Where("referencia_id = ?", ref)
+	}
+
+	var jobs []domain.Job
+	if err := query.Find(&jobs).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	return Success(c, jobs)
+}
+
+// RetryJob puts a dead letter job back in the queue
+func (h *Handler) RetryJob(c *fiber.Ctx) error {
+	var job domain.Job
+	if err := h.db(c).First(&job, "id = ?", c.Params("id")).Error; err != nil {
+		return NotFound(c, "Job não encontrado")
+	}
+
+	retried, err := h.JobQueue.Retry(job.ID)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	h.LogAudit(c, "Job", job.ID, "RETRY", "Job "+job.Tipo+" reenviado à fila", job, retried)
+	return Success(c, retried)
+}
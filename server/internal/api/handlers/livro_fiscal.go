@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetLivroFiscal returns the register of services rendered of a competência with its totals per
+// tax. format=csv downloads the register and format=zip the accountant package with the XMLs and
+// DANFS-e of the month.
+func (h *Handler) GetLivroFiscal(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+	competencia, ok := competenciaParam(c)
+	if !ok {
+		return BadRequest(c, "Competência inválida, use AAAA-MM")
+	}
+
+	livro, err := h.LivroFiscal.Gerar(companyID, competencia)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	switch c.Query("format") {
+	case "csv":
+		c.Set("Content-Type", "text/csv; charset=utf-8")
+		c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=livro_fiscal_%s.csv", livro.Competencia))
+		return h.LivroFiscal.WriteCSV(c, livro)
+	case "zip":
+		h.LogAudit(c, "LivroFiscal", companyID, "EXPORTACAO",
+			fmt.Sprintf("Pacote do contador %s com %d NFS-e", livro.Competencia, len(livro.Notas)), nil, nil)
+		c.Set("Content-Type", "application/zip")
+		c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=contador_%s.zip", livro.Competencia))
+		return h.LivroFiscal.WriteZIP(c, livro)
+	}
+	return Success(c, livro)
+}
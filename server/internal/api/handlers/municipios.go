@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"inovar/internal/services"
+)
+
+// SearchMunicipios looks up IBGE municipalities by name prefix and UF, or by CEP. When the
+// company's service code is known, the municipal ISS rule of each municipality comes along.
+func (h *Handler) SearchMunicipios(c *fiber.Ctx) error {
+	catalogo := services.Municipios()
+
+	var municipios []services.Municipio
+	if cep := c.Query("cep"); cep != "" {
+		if m := catalogo.BuscarCEP(cep); m != nil {
+			municipios = append(municipios, *m)
+		}
+	} else {
+		if len([]rune(c.Query("q"))) < 2 && c.Query("uf") == "" {
+			return BadRequest(c, "Informe ao menos 2 letras do nome, a UF ou o CEP")
+		}
+		municipios = catalogo.Pesquisar(c.Query("q"), c.Query("uf"), c.QueryInt("limit", 20))
+	}
+
+	codigoServico := c.Query("codigoServico")
+	result := make([]fiber.Map, 0, len(municipios))
+	for _, m := range municipios {
+		item := fiber.Map{"codigo": m.Codigo, "nome": m.Nome, "uf": m.UF}
+		if codigoServico != "" {
+			item["regraISS"] = catalogo.RegraISS(m.Codigo, codigoServico)
+		}
+		result = append(result, item)
+	}
+	return Success(c, result)
+}
@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"inovar/internal/api/middleware"
@@ -10,60 +14,142 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
-// UploadCertificate uploads a digital certificate (A1)
+// UploadCertificate validates an A1 e-CNPJ certificate and stores it encrypted in the vault
 func (h *Handler) UploadCertificate(c *fiber.Ctx) error {
-	userID := middleware.GetUserID(c)
-
-	var user domain.User
-	if err := h.DB.First(&user, "id = ?", userID).Error; err != nil {
-		return NotFound(c, "Usuário não encontrado")
-	}
-
-	if user.CompanyID == nil {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
 		return BadRequest(c, "Usuário não vinculado a uma empresa")
 	}
-	companyID := *user.CompanyID
 
 	file, err := c.FormFile("certificate")
 	if err != nil {
 		return BadRequest(c, "Certificado não enviado")
 	}
+	if ext := strings.ToLower(filepath.Ext(file.Filename)); ext != ".pfx" && ext != ".p12" {
+		return BadRequest(c, "Envie o certificado A1 no formato PKCS#12 (.pfx ou .p12)")
+	}
 
 	password := c.FormValue("password")
 	if password == "" {
 		return BadRequest(c, "Senha do certificado obrigatória")
 	}
+	if len(password) > 128 {
+		return BadRequest(c, "Senha do certificado muito longa")
+	}
 
-	// Save certificate using Local Storage
-	url, err := h.StorageService.Upload(file)
+	src, err := file.Open()
 	if err != nil {
 		return ServerError(c, err)
 	}
+	defer src.Close()
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	a1, err := services.LoadA1Certificate(data, password)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	var prestador domain.Prestador
+	if err := h.db(c).First(&prestador, "id = ?", companyID).Error; err != nil {
+		return BadRequest(c, "Prestador não encontrado")
+	}
+	if prestador.CNPJ == "" {
+		return BadRequest(c, "Cadastre o CNPJ da empresa antes de enviar o certificado")
+	}
+	if err := a1.ValidateECNPJ(prestador.CNPJ, time.Now()); err != nil {
+		return BadRequest(c, err.Error())
+	}
 
 	cert := domain.CertificadoDigital{
 		ID:          uuid.New().String(),
 		PrestadorID: companyID,
 		Nome:        file.Filename,
-		CertPath:    url,
-		Validade:    time.Now().AddDate(1, 0, 0),
+		Tipo:        "A1",
+		ValidoDesde: a1.Certificate.NotBefore,
+		Validade:    a1.Certificate.NotAfter,
+		Titular:     a1.Certificate.Subject.CommonName,
+		CNPJ:        a1.CNPJ(),
+		Emissor:     a1.Certificate.Issuer.CommonName,
+		NumeroSerie: strings.ToUpper(a1.Certificate.SerialNumber.Text(16)),
+		Impressao:   a1.Fingerprint(),
 		Ativo:       true,
 	}
+	if err := h.CertVault.Store(&cert, data, password); err != nil {
+		return ServerError(c, err)
+	}
 
-	if err := h.DB.Create(&cert).Error; err != nil {
+	// A provider has a single certificate: the new one replaces the previous
+	var previous domain.CertificadoDigital
+	hasPrevious := h.db(c).Where("prestador_id = ?", companyID).First(&previous).Error == nil
+	err = h.db(c).Transaction(func(tx *gorm.DB) error {
+		if hasPrevious {
+			if err := tx.Delete(&previous).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&cert).Error
+	})
+	if err != nil {
+		h.CertVault.Remove(&cert)
 		return ServerError(c, err)
 	}
 
+	if hasPrevious {
+		if previous.ChaveID == "" {
+			h.StorageService.Delete(previous.CertPath)
+		} else {
+			h.CertVault.Remove(&previous)
+		}
+		h.LogAudit(c, "CertificadoDigital", cert.ID, "UPDATE",
+			fmt.Sprintf("Certificado %s substituído por %s (série %s, válido até %s)", previous.NumeroSerie, cert.Titular, cert.NumeroSerie, cert.Validade.Format("02/01/2006")), previous, cert)
+	} else {
+		h.LogAudit(c, "CertificadoDigital", cert.ID, "CREATE",
+			fmt.Sprintf("Certificado %s (série %s, válido até %s) enviado", cert.Titular, cert.NumeroSerie, cert.Validade.Format("02/01/2006")), nil, cert)
+	}
+
 	return Created(c, cert)
 }
 
+// GetCertificate returns the metadata of the active certificate
+func (h *Handler) GetCertificate(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	var cert domain.CertificadoDigital
+	if err := h.db(c).Where("prestador_id = ?", companyID).First(&cert).Error; err != nil {
+		return NotFound(c, "Certificado digital não configurado")
+	}
+	return Success(c, cert)
+}
+
+// GetCertificateStatus reports whether the certificate is valid, expiring or expired
+func (h *Handler) GetCertificateStatus(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	status, err := h.CertMonitor.Status(companyID)
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, status)
+}
+
 // GetFiscalConfig returns fiscal configuration
 func (h *Handler) GetFiscalConfig(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", userID).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", userID).Error; err != nil {
 		return BadRequest(c, "Usuário não encontrado")
 	}
 
@@ -73,7 +159,7 @@ func (h *Handler) GetFiscalConfig(c *fiber.Ctx) error {
 	companyID := *user.CompanyID
 
 	var config domain.ConfiguracaoFiscal
-	if err := h.DB.Where("prestador_id = ?", companyID).First(&config).Error; err != nil {
+	if err := h.db(c).Where("prestador_id = ?", companyID).First(&config).Error; err != nil {
 		// Return empty default
 		return Success(c, fiber.Map{"prestador_id": companyID})
 	}
@@ -86,7 +172,7 @@ func (h *Handler) UpdateFiscalConfig(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", userID).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", userID).Error; err != nil {
 		return BadRequest(c, "Usuário não encontrado")
 	}
 
@@ -101,7 +187,7 @@ func (h *Handler) UpdateFiscalConfig(c *fiber.Ctx) error {
 	}
 
 	var config domain.ConfiguracaoFiscal
-	err := h.DB.Where("prestador_id = ?", companyID).First(&config).Error
+	err := h.db(c).Where("prestador_id = ?", companyID).First(&config).Error
 	isNew := err != nil
 
 	if isNew {
@@ -116,11 +202,28 @@ func (h *Handler) UpdateFiscalConfig(c *fiber.Ctx) error {
 	config.CNAE = req.CNAE
 	config.InscricaoMunicipal = req.InscricaoMunicipal
 	config.OptanteSimplesNac = req.OptanteSimplesNac
+	if req.ReceitaBruta12Meses > 0 {
+		config.ReceitaBruta12Meses = req.ReceitaBruta12Meses
+		config.FaixaSimplesNac = services.NewTaxCalculationService().CalculateFaixaSimplesNacional(req.ReceitaBruta12Meses)
+	}
+	if req.CodigoMunicipio != 0 {
+		config.CodigoMunicipio = req.CodigoMunicipio
+	}
+	if req.PrazoCancelamentoDias < 0 {
+		return BadRequest(c, "Prazo de cancelamento inválido")
+	}
+	config.PrazoCancelamentoDias = req.PrazoCancelamentoDias
+	if req.Ambiente != "" {
+		if req.Ambiente != services.AmbienteProducao && req.Ambiente != services.AmbienteHomologacao {
+			return BadRequest(c, "Ambiente inválido (use PRODUCAO ou HOMOLOGACAO)")
+		}
+		config.Ambiente = req.Ambiente
+	}
 
 	if isNew {
-		h.DB.Create(&config)
+		h.db(c).Create(&config)
 	} else {
-		h.DB.Save(&config)
+		h.db(c).Save(&config)
 	}
 
 	return Success(c, config)
@@ -132,103 +235,49 @@ func (h *Handler) IssueNFSe(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 
 	var solicitacao domain.Solicitacao
-	if err := h.DB.Preload("Client").Preload("Client.Endereco").Preload("OrcamentoItens").First(&solicitacao, "id = ?", requestID).Error; err != nil {
+	if err := h.db(c).Preload("Client").Preload("Client.Endereco").Preload("OrcamentoItens").First(&solicitacao, "id = ?", requestID).Error; err != nil {
 		return NotFound(c, "Solicitação não encontrada")
 	}
-
-	// Calculate total amount from orcamento items
-	var valorTotal float64
-	for _, item := range solicitacao.OrcamentoItens {
-		valorTotal += item.ValorUnit * item.Quantidade
-	}
-
-	// Validations
-	if solicitacao.Status != domain.StatusConcluida && solicitacao.Status != domain.StatusFinalizada {
-		return BadRequest(c, "Solicitação deve estar CONCLUÍDA para emitir NFSe")
-	}
-	if valorTotal <= 0 {
-		return BadRequest(c, "Solicitação sem valor definido")
+	if !h.canAccessRequest(c, &solicitacao) {
+		return Forbidden(c, "Acesso negado a esta solicitação")
 	}
 
 	companyID := solicitacao.CompanyID
 
 	// Get fiscal config
 	var fiscalConfig domain.ConfiguracaoFiscal
-	if err := h.DB.Where("prestador_id = ?", companyID).First(&fiscalConfig).Error; err != nil {
+	if err := h.db(c).Where("prestador_id = ?", companyID).First(&fiscalConfig).Error; err != nil {
 		return BadRequest(c, "Configuração fiscal não encontrada")
 	}
 
 	// Get active certificate
 	var certificate domain.CertificadoDigital
-	h.DB.Where("prestador_id = ? AND ativo = ?", companyID, true).First(&certificate)
+	if err := h.db(c).Where("prestador_id = ? AND ativo = ?", companyID, true).First(&certificate).Error; err != nil {
+		return BadRequest(c, "Certificado digital A1 não configurado")
+	}
+	if err := services.CheckCertificateValid(&certificate, time.Now()); err != nil {
+		return BadRequest(c, err.Error())
+	}
 
-	// AUTOMATIC TAX CALCULATION
-	taxService := services.NewTaxCalculationService()
-	taxResult := taxService.Calculate(valorTotal, 0, &fiscalConfig)
-
-	// Create NFSe record
-	nfse := domain.NotaFiscal{
-		ID:               uuid.New().String(),
-		SolicitacaoID:    requestID,
-		PrestadorID:      companyID,
-		TomadorNome:      solicitacao.ClientName,
-		TomadorDocumento: solicitacao.Client.Document,
-		ValorServicos:    taxResult.ValorServicos,
-		ValorDeducoes:    taxResult.ValorDeducoes,
-		ValorLiquido:     taxResult.ValorLiquido,
-		AliquotaISS:      taxResult.AliquotaISS,
-		ValorISS:         taxResult.ValorISS,
-		ValorPIS:         taxResult.ValorPIS,
-		ValorCOFINS:      taxResult.ValorCOFINS,
-		ValorCSLL:        taxResult.ValorCSLL,
-		ValorIR:          taxResult.ValorIRPJ,
-		ValorINSS:        taxResult.ValorINSS,
-		Status:           domain.NFSeStatusProcessando,
-		Discriminacao:    "Serviços de Manutenção de Ar Condicionado - Chamado #" + solicitacao.ID[:8],
-		CodigoServico:    fiscalConfig.CodigoServico,
-		CNAE:             fiscalConfig.CNAE,
-	}
-
-	if err := h.DB.Create(&nfse).Error; err != nil {
-		return ServerError(c, err)
+	var prestador domain.Prestador
+	if err := h.db(c).Preload("Endereco").First(&prestador, "id = ?", companyID).Error; err != nil {
+		return BadRequest(c, "Prestador não encontrado")
 	}
 
-	// Create emission event
-	event := domain.NFSeEvento{
-		ID:       uuid.New().String(),
-		NFSeID:   nfse.ID,
-		Tipo:     domain.NFSeEventoEmissao,
-		Status:   domain.NFSeStatusProcessando,
-		Mensagem: fmt.Sprintf("Regime: %s | ISS: %.2f%% | Total Tributos: R$ %.2f", taxResult.RegimeTributario, taxResult.AliquotaISS, taxResult.TotalTributos),
-		UserID:   userID,
-	}
-	h.DB.Create(&event)
-
-	// Process NFSe asynchronously
-	go func() {
-		var resultado string
-
-		// Use the service to emit (Integration point)
-		// For now, we simulate success since the actual integration logic depends on external APIs
-		time.Sleep(2 * time.Second)
-
-		nfse.Status = domain.NFSeStatusEmitida
-		nfse.Numero = fmt.Sprintf("%d", time.Now().Unix())
-		nfse.CodigoVerificacao = uuid.New().String()[:8]
-		h.DB.Save(&nfse)
-
-		h.DB.Create(&domain.NFSeEvento{
-			ID:       uuid.New().String(),
-			NFSeID:   nfse.ID,
-			Tipo:     domain.NFSeEventoEmissao,
-			Status:   domain.NFSeStatusEmitida,
-			Mensagem: "NFS-e emitida com sucesso via GOV.BR Nacional",
-			UserID:   userID,
-		})
+	// Values, automatic tax calculation and DPS validation
+	nfse, taxResult, err := h.NFSeEmissor.PrepararEmissao(&solicitacao, &prestador, &fiscalConfig)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
 
-		resultado = "NFS-e emitida com sucesso"
-		h.createHistoryEntry(requestID, userID, "Nota Fiscal", resultado)
-	}()
+	err = h.db(c).Transaction(func(tx *gorm.DB) error {
+		return h.NFSeEmissor.SolicitarEmissao(tx, nfse, taxResult, userID)
+	})
+	if err != nil {
+		return emissaoError(c, err)
+	}
+	h.JobQueue.Wake()
+	h.Hub.Broadcast(nfse.PrestadorID, "nfse:updated", nfse)
 
 	return Created(c, fiber.Map{
 		"nfse":        nfse,
@@ -237,12 +286,157 @@ func (h *Handler) IssueNFSe(c *fiber.Ctx) error {
 	})
 }
 
+// emissaoError answers a failed emission; a request invoiced meanwhile by another note is a client error
+func emissaoError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrNFSeJaEmitida) || errors.Is(err, services.ErrNFSeEmProcessamento) {
+		return BadRequest(c, err.Error())
+	}
+	return ServerError(c, err)
+}
+
+// SubstituteNFSe replaces an issued NFS-e by a new one with corrected data referencing its access key
+func (h *Handler) SubstituteNFSe(c *fiber.Ctx) error {
+	requestID := c.Params("id")
+	userID := middleware.GetUserID(c)
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return err
+	}
+
+	var req struct {
+		TomadorNome      string   `json:"tomadorNome"`
+		TomadorDocumento string   `json:"tomadorDocumento"`
+		ValorServicos    *float64 `json:"valorServicos"`
+		Discriminacao    string   `json:"discriminacao"`
+		CodigoMotivo     string   `json:"codigoMotivo"`
+		Motivo           string   `json:"motivo"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+	if err := services.ValidarSubstituicao(req.CodigoMotivo, req.Motivo); err != nil {
+		return BadRequest(c, err.Error())
+	}
+	if req.ValorServicos != nil && *req.ValorServicos <= 0 {
+		return BadRequest(c, "Valor dos serviços deve ser maior que zero")
+	}
+
+	var original domain.NotaFiscal
+	if err := h.db(c).Scopes(services.NotasDaSolicitacao(requestID)).Where("status = ?", domain.NFSeStatusEmitida).Order("created_at desc").First(&original).Error; err != nil {
+		return NotFound(c, "Nenhuma NFS-e emitida para esta solicitação")
+	}
+
+	// Only one substitute may be in flight; a failed one is resent with the new data
+	var nfse domain.NotaFiscal
+	err := h.db(c).Where("substitui_id = ? AND status IN ?", original.ID, []string{domain.NFSeStatusProcessando, domain.NFSeStatusErro}).First(&nfse).Error
+	switch {
+	case err == nil && nfse.Status == domain.NFSeStatusProcessando:
+		return BadRequest(c, "Substituição já está em processamento")
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		return ServerError(c, err)
+	case err != nil:
+		nfse = original
+		nfse.ID = uuid.New().String()
+		nfse.SubstituiID = original.ID
+		nfse.SubstituidaPorID = ""
+		nfse.Numero = ""
+		nfse.ChaveAcesso = ""
+		nfse.CodigoVerificacao = ""
+		nfse.NumeroDPS = 0
+		nfse.XMLPath = ""
+		nfse.PDFPath = ""
+		nfse.DataEmissao = nil
+		nfse.CreatedAt = time.Time{}
+		nfse.UpdatedAt = time.Time{}
+	}
+
+	var fiscalConfig domain.ConfiguracaoFiscal
+	if err := h.db(c).Where("prestador_id = ?", original.PrestadorID).First(&fiscalConfig).Error; err != nil {
+		return BadRequest(c, "Configuração fiscal não encontrada")
+	}
+	var certificate domain.CertificadoDigital
+	if err := h.db(c).Where("prestador_id = ? AND ativo = ?", original.PrestadorID, true).First(&certificate).Error; err != nil {
+		return BadRequest(c, "Certificado digital A1 não configurado")
+	}
+	if err := services.CheckCertificateValid(&certificate, time.Now()); err != nil {
+		return BadRequest(c, err.Error())
+	}
+	var prestador domain.Prestador
+	if err := h.db(c).Preload("Endereco").First(&prestador, "id = ?", original.PrestadorID).Error; err != nil {
+		return BadRequest(c, "Prestador não encontrado")
+	}
+	var solicitacao domain.Solicitacao
+	h.db(c).Preload("Client").Preload("Client.Endereco").First(&solicitacao, "id = ?", requestID)
+
+	if req.TomadorNome != "" {
+		nfse.TomadorNome = req.TomadorNome
+	}
+	if req.TomadorDocumento != "" {
+		nfse.TomadorDocumento = req.TomadorDocumento
+	}
+	if req.Discriminacao != "" {
+		nfse.Discriminacao = req.Discriminacao
+	}
+	if req.ValorServicos != nil {
+		taxService := services.NewTaxCalculationService()
+		taxResult, err := taxService.CalculateAt(*req.ValorServicos, nfse.ValorDeducoes, &fiscalConfig, services.CodigoMunicipioEndereco(solicitacao.Client.Endereco))
+		if err != nil {
+			return BadRequest(c, "Cálculo de tributos: "+err.Error())
+		}
+		services.ApplyTaxResult(&nfse, taxResult)
+	}
+	nfse.CodigoMotivoSubst = req.CodigoMotivo
+	nfse.MotivoSubst = req.Motivo
+	nfse.Status = domain.NFSeStatusProcessando
+	nfse.MensagemErro = ""
+
+	if _, err := h.NFSeEmissor.BuildDPS(&nfse, &prestador, &fiscalConfig, &solicitacao.Client); err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	err = h.db(c).Transaction(func(tx *gorm.DB) error {
+		if err := h.DPSNumeracao.Reservar(tx, &nfse); err != nil {
+			return err
+		}
+		if err := tx.Save(&nfse).Error; err != nil {
+			return err
+		}
+		if err := services.CopiarSolicitacoes(tx, original.ID, nfse.ID); err != nil {
+			return err
+		}
+		mensagem := fmt.Sprintf("Substituição solicitada pela DPS %s nº %d | Motivo %s: %s", nfse.SerieDPS, nfse.NumeroDPS, req.CodigoMotivo, req.Motivo)
+		for _, event := range []domain.NFSeEvento{
+			{ID: uuid.New().String(), NFSeID: original.ID, Tipo: domain.NFSeEventoSubstituicao, Status: original.Status, Mensagem: mensagem, UserID: userID},
+			{ID: uuid.New().String(), NFSeID: nfse.ID, Tipo: domain.NFSeEventoEmissao, Status: nfse.Status, Protocolo: original.ChaveAcesso, Mensagem: mensagem, UserID: userID},
+		} {
+			if err := tx.Create(&event).Error; err != nil {
+				return err
+			}
+		}
+		_, err := h.NFSeEmissor.Enfileirar(tx, &nfse, userID)
+		return err
+	})
+	if err != nil {
+		return ServerError(c, err)
+	}
+	h.JobQueue.Wake()
+	h.Hub.Broadcast(nfse.PrestadorID, "nfse:updated", nfse)
+
+	h.createHistoryEntry(requestID, userID, "Nota Fiscal", fmt.Sprintf("Substituição da NFS-e %s solicitada: %s", original.Numero, req.Motivo))
+	h.LogAudit(c, "NotaFiscal", nfse.ID, "SUBSTITUTE", fmt.Sprintf("Substituição da NFS-e %s (motivo %s)", original.Numero, req.CodigoMotivo), original, nfse)
+
+	return Created(c, fiber.Map{
+		"nfse":     nfse,
+		"original": original,
+		"mensagem": "Substituição em processamento via GOV.BR Nacional.",
+	})
+}
+
 // CalculateTaxes calculates taxes automatically based on fiscal configuration
 func (h *Handler) CalculateTaxes(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 
 	var user domain.User
-	h.DB.First(&user, "id = ?", userID)
+	h.db(c).First(&user, "id = ?", userID)
 
 	if user.CompanyID == nil {
 		return BadRequest(c, "Empresa não encontrada")
@@ -250,18 +444,22 @@ func (h *Handler) CalculateTaxes(c *fiber.Ctx) error {
 	companyID := *user.CompanyID
 
 	var req struct {
-		ValorServicos float64 `json:"valorServicos"`
-		ValorDeducoes float64 `json:"valorDeducoes"`
+		ValorServicos            float64 `json:"valorServicos"`
+		ValorDeducoes            float64 `json:"valorDeducoes"`
+		CodigoMunicipioPrestacao int     `json:"codigoMunicipioPrestacao"`
 	}
 	if err := c.BodyParser(&req); err != nil {
 		return BadRequest(c, "Dados inválidos")
 	}
 
 	var fiscalConfig domain.ConfiguracaoFiscal
-	h.DB.Where("prestador_id = ?", companyID).First(&fiscalConfig)
+	h.db(c).Where("prestador_id = ?", companyID).First(&fiscalConfig)
 
 	taxService := services.NewTaxCalculationService()
-	result := taxService.Calculate(req.ValorServicos, req.ValorDeducoes, &fiscalConfig)
+	result, err := taxService.CalculateAt(req.ValorServicos, req.ValorDeducoes, &fiscalConfig, req.CodigoMunicipioPrestacao)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
 
 	return Success(c, fiber.Map{
 		"calculo": result,
@@ -272,107 +470,170 @@ func (h *Handler) CalculateTaxes(c *fiber.Ctx) error {
 // GetNFSeEventos returns the event history for an NFS-e
 func (h *Handler) GetNFSeEventos(c *fiber.Ctx) error {
 	requestID := c.Params("id")
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return err
+	}
 
 	var nfse domain.NotaFiscal
-	if err := h.DB.Where("solicitacao_id = ?", requestID).First(&nfse).Error; err != nil {
+	if err := h.db(c).Scopes(services.NotasDaSolicitacao(requestID)).Order("created_at desc").First(&nfse).Error; err != nil {
 		return NotFound(c, "Nota Fiscal não encontrada")
 	}
 
 	var eventos []domain.NFSeEvento
-	h.DB.Where("nfse_id = ?", nfse.ID).Order("created_at desc").Find(&eventos)
+	h.db(c).Where("nf_se_id = ?", nfse.ID).Order("created_at desc").Find(&eventos)
 
 	return Success(c, eventos)
 }
 
-// CancelNFSeWithMotivo cancels an NFS-e with a specific reason
+// CancelNFSeWithMotivo requests the cancellation of the request's issued NFS-e to the SEFIN. The note
+// is marked CANCELADA once the SEFIN registers the event; past the municipal window the answer
+// suggests a substitution instead.
 func (h *Handler) CancelNFSeWithMotivo(c *fiber.Ctx) error {
 	requestID := c.Params("id")
 	userID := middleware.GetUserID(c)
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return err
+	}
 
 	var req struct {
-		Motivo       string `json:"motivo"`
 		CodigoMotivo string `json:"codigoMotivo"`
+		Motivo       string `json:"motivo"`
 	}
 	if err := c.BodyParser(&req); err != nil {
 		return BadRequest(c, "Dados inválidos")
 	}
 
+	// The issued note of the request, or its latest one so the service tells why it cannot be cancelled
 	var nfse domain.NotaFiscal
-	if err := h.DB.Where("solicitacao_id = ?", requestID).First(&nfse).Error; err != nil {
-		return NotFound(c, "Nota Fiscal não encontrada")
-	}
-
-	nfse.Status = domain.NFSeStatusCancelada
-	nfse.MensagemErro = "Cancelada: " + req.Motivo
-	h.DB.Save(&nfse)
-
-	event := domain.NFSeEvento{
-		ID:       uuid.New().String(),
-		NFSeID:   nfse.ID,
-		Tipo:     domain.NFSeEventoCancelamento,
-		Status:   "CANCELADA",
-		Mensagem: "Motivo: " + req.Motivo + " | Código: " + req.CodigoMotivo,
-		UserID:   userID,
+	if err := h.db(c).Scopes(services.NotasDaSolicitacao(requestID)).Where("status = ?", domain.NFSeStatusEmitida).
+		Order("created_at desc").First(&nfse).Error; err != nil {
+		if err := h.db(c).Scopes(services.NotasDaSolicitacao(requestID)).Order("created_at desc").First(&nfse).Error; err != nil {
+			return NotFound(c, "Nota Fiscal não encontrada")
+		}
+	}
+
+	job, err := h.NFSeEmissor.SolicitarCancelamento(&nfse, req.CodigoMotivo, req.Motivo, userID)
+	var foraDoPrazo *services.ForaDoPrazoCancelamento
+	switch {
+	case errors.As(err, &foraDoPrazo):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"success":  false,
+			"error":    "prazo_cancelamento",
+			"message":  "Prazo de cancelamento encerrado em " + foraDoPrazo.Prazo.Format("02/01/2006") + ". Emita uma NFS-e substituta.",
+			"prazo":    foraDoPrazo.Prazo,
+			"sugestao": "SUBSTITUICAO",
+		})
+	case err != nil:
+		return BadRequest(c, err.Error())
 	}
-	h.DB.Create(&event)
 
-	h.createHistoryEntry(requestID, userID, "NFS-e cancelada", req.Motivo)
+	h.LogAudit(c, "NotaFiscal", nfse.ID, "CANCELAMENTO_SOLICITADO",
+		fmt.Sprintf("Cancelamento da NFS-e %s | Código %s: %s", nfse.Numero, req.CodigoMotivo, req.Motivo), nil, nil)
+	h.Hub.Broadcast(nfse.PrestadorID, "nfse:updated", nfse)
 
-	return Success(c, fiber.Map{"message": "Nota Fiscal cancelada"})
+	return Success(c, fiber.Map{
+		"nfse":     nfse,
+		"job":      job,
+		"mensagem": "Cancelamento enviado à SEFIN Nacional. A NFS-e será cancelada quando o evento for homologado.",
+	})
 }
 
 // GetNFSe returns the NFSe for a request
 func (h *Handler) GetNFSe(c *fiber.Ctx) error {
 	requestID := c.Params("id")
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return err
+	}
 
+	// A consolidated note is returned for each of its requests, listing all of them
 	var nfse domain.NotaFiscal
-	if err := h.DB.Where("solicitacao_id = ?", requestID).First(&nfse).Error; err != nil {
+	if err := h.db(c).Scopes(services.NotasDaSolicitacao(requestID)).Preload("Solicitacoes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("numero_solicitacao")
+	}).Order("created_at desc").First(&nfse).Error; err != nil {
 		return NotFound(c, "Nota Fiscal não encontrada")
 	}
 
 	return Success(c, nfse)
 }
 
-// CancelNFSe cancels an issued invoice
+// CancelNFSe requests the cancellation of the issued invoice (DELETE alias of CancelNFSeWithMotivo)
 func (h *Handler) CancelNFSe(c *fiber.Ctx) error {
 	return h.CancelNFSeWithMotivo(c)
 }
 
-// GetDANFSe returns the DANFS-e (Documento Auxiliar) HTML for printing
+// GetDANFSe returns the DANFS-e (Documento Auxiliar) of the request's issued note as PDF or printable HTML
 func (h *Handler) GetDANFSe(c *fiber.Ctx) error {
-	requestID := c.Params("id")
-
-	var nfse domain.NotaFiscal
-	if err := h.DB.Where("solicitacao_id = ?", requestID).First(&nfse).Error; err != nil {
-		return NotFound(c, "Nota Fiscal não encontrada")
+	nfse, err := h.issuedNFSe(c, c.Params("id"))
+	if nfse == nil {
+		return err
+	}
+
+	danfseService := services.NewDANFSeService(h.db(c), h.StorageService)
+	switch c.Query("format", "html") {
+	case "pdf":
+		data, err := danfseService.PDF(nfse)
+		if err != nil {
+			return ServerError(c, err)
+		}
+		c.Set("Content-Type", "application/pdf")
+		c.Set("Content-Disposition", fmt.Sprintf("inline; filename=DANFSe_%s.pdf", nfse.Numero))
+		return c.Send(data)
+	case "base64":
+		html, err := danfseService.HTML(nfse)
+		if err != nil {
+			return ServerError(c, err)
+		}
+		return Success(c, fiber.Map{
+			"html":   html,
+			"base64": true,
+		})
 	}
 
-	if nfse.Status != domain.NFSeStatusEmitida {
-		return BadRequest(c, "NFS-e ainda não foi emitida")
+	html, err := danfseService.HTML(nfse)
+	if err != nil {
+		return ServerError(c, err)
 	}
+	c.Set("Content-Type", "text/html; charset=utf-8")
+	return c.SendString(html)
+}
 
-	var solicitacao domain.Solicitacao
-	h.DB.Preload("Client").First(&solicitacao, "id = ?", requestID)
-
-	var prestador domain.Prestador
-	h.DB.Where("id = ?", nfse.PrestadorID).First(&prestador)
+// GetNFSeXML downloads the authorized XML of the request's issued note
+func (h *Handler) GetNFSeXML(c *fiber.Ctx) error {
+	nfse, err := h.issuedNFSe(c, c.Params("id"))
+	if nfse == nil {
+		return err
+	}
+	if nfse.XMLPath == "" {
+		return NotFound(c, "XML da NFS-e não disponível")
+	}
 
-	danfseService := services.NewDANFSeService(h.Config)
-	html, err := danfseService.Generate(&nfse, &prestador, &solicitacao.Client)
+	data, err := h.StorageService.ReadFiscal(nfse.XMLPath)
 	if err != nil {
-		return ServerError(c, err)
+		return NotFound(c, "XML da NFS-e não disponível")
 	}
+	c.Set("Content-Type", "application/xml")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=NFSe_%s.xml", nfse.Numero))
+	return c.Send(data)
+}
 
-	format := c.Query("format", "html")
-	if format == "base64" {
-		return Success(c, fiber.Map{
-			"html":   html,
-			"base64": true,
-		})
+// issuedNFSe loads the issued note of a request the user can access
+func (h *Handler) issuedNFSe(c *fiber.Ctx, requestID string) (*domain.NotaFiscal, error) {
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return nil, err
 	}
 
-	c.Set("Content-Type", "text/html; charset=utf-8")
-	return c.SendString(html)
+	var nfse domain.NotaFiscal
+	if err := h.db(c).Scopes(services.NotasDaSolicitacao(requestID)).Order("created_at DESC").First(&nfse).Error; err != nil {
+		return nil, NotFound(c, "Nota Fiscal não encontrada")
+	}
+	if nfse.Status != domain.NFSeStatusEmitida {
+		// A substituted note leaves its replacement as the request's issued one
+		if err := h.db(c).Scopes(services.NotasDaSolicitacao(requestID)).Where("status = ?", domain.NFSeStatusEmitida).
+			Order("created_at DESC").First(&nfse).Error; err != nil {
+			return nil, BadRequest(c, "NFS-e ainda não foi emitida")
+		}
+	}
+	return &nfse, nil
 }
 
 // GetTaxRegimes returns available tax regimes for configuration
@@ -420,16 +681,19 @@ func (h *Handler) GetTaxRegimes(c *fiber.Ctx) error {
 		},
 	}
 
-	motivosCancelamento := []fiber.Map{
-		{"codigo": domain.MotivoCancelErroEmissao, "descricao": "Erro na Emissão"},
-		{"codigo": domain.MotivoCancelServNaoRealizado, "descricao": "Serviço não Prestado"},
-		{"codigo": domain.MotivoCancelDuplicidade, "descricao": "Duplicidade de Nota"},
-		{"codigo": domain.MotivoCancelErroPreenchimento, "descricao": "Erro de Preenchimento"},
+	motivosSubstituicao := []fiber.Map{
+		{"codigo": domain.MotivoSubstDesenquadramentoSN, "descricao": "Desenquadramento do Simples Nacional"},
+		{"codigo": domain.MotivoSubstEnquadramentoSN, "descricao": "Enquadramento no Simples Nacional"},
+		{"codigo": domain.MotivoSubstInclusaoImunidade, "descricao": "Inclusão Retroativa de Imunidade/Isenção"},
+		{"codigo": domain.MotivoSubstExclusaoImunidade, "descricao": "Exclusão Retroativa de Imunidade/Isenção"},
+		{"codigo": domain.MotivoSubstRejeicaoTomador, "descricao": "Rejeição pelo Tomador"},
+		{"codigo": domain.MotivoSubstOutros, "descricao": "Outros"},
 	}
 
 	return Success(c, fiber.Map{
 		"regimes":             regimes,
-		"motivosCancelamento": motivosCancelamento,
+		"motivosCancelamento": services.MotivosCancelamento,
+		"motivosSubstituicao": motivosSubstituicao,
 	})
 }
 
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+	"inovar/internal/services"
+)
+
+// consolidadaRequest picks the requests invoiced together
+type consolidadaRequest struct {
+	SolicitacaoIDs []string `json:"solicitacaoIds"`
+}
+
+// prepararConsolidada loads the picked requests of the company and prepares their single note
+func (h *Handler) prepararConsolidada(c *fiber.Ctx) (*domain.NotaFiscal, *services.TaxCalculationResult, []*domain.Solicitacao, error) {
+	var req consolidadaRequest
+	if err := c.BodyParser(&req); err != nil {
+		return nil, nil, nil, errors.New("Dados inválidos")
+	}
+
+	prestador, fiscal, _, err := h.loteContexto(c)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var encontradas []domain.Solicitacao
+	if len(req.SolicitacaoIDs) > 0 {
+		if err := h.db(c).Preload("Client").Preload("Client.Endereco").Preload("OrcamentoItens").
+			Where("company_id = ? AND id IN ?", prestador.ID, req.SolicitacaoIDs).
+			Order("numero").Find(&encontradas).Error; err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	pedidas := make(map[string]int, len(req.SolicitacaoIDs))
+	for _, id := range req.SolicitacaoIDs {
+		pedidas[id]++
+	}
+	if len(encontradas) < len(pedidas) {
+		return nil, nil, nil, errors.New("Solicitação não encontrada")
+	}
+
+	// Kept in OS order; ids sent twice are repeated so PrepararConsolidada reports them
+	solicitacoes := make([]*domain.Solicitacao, 0, len(req.SolicitacaoIDs))
+	for i := range encontradas {
+		for n := 0; n < pedidas[encontradas[i].ID]; n++ {
+			solicitacoes = append(solicitacoes, &encontradas[i])
+		}
+	}
+
+	nf, taxResult, err := h.NFSeEmissor.PrepararConsolidada(solicitacoes, prestador, fiscal)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return nf, taxResult, solicitacoes, nil
+}
+
+// PreviewNFSeConsolidada shows the note that would invoice the picked requests together
+func (h *Handler) PreviewNFSeConsolidada(c *fiber.Ctx) error {
+	nf, taxResult, _, err := h.prepararConsolidada(c)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	result := fiber.Map{
+		"nfse":          nf,
+		"calculo":       taxResult,
+		"discriminacao": nf.Discriminacao,
+		"solicitacoes":  nf.Solicitacoes,
+	}
+	if err := h.loteCertificado(c, nf.PrestadorID); err != nil {
+		result["certificado"] = err.Error()
+	}
+	return Success(c, result)
+}
+
+// EmitirNFSeConsolidada issues a single NFS-e for several completed requests of the same client
+func (h *Handler) EmitirNFSeConsolidada(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+	nf, taxResult, solicitacoes, err := h.prepararConsolidada(c)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+	if err := h.loteCertificado(c, nf.PrestadorID); err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	err = h.db(c).Transaction(func(tx *gorm.DB) error {
+		return h.NFSeEmissor.SolicitarEmissao(tx, nf, taxResult, userID)
+	})
+	if err != nil {
+		return emissaoError(c, err)
+	}
+	h.JobQueue.Wake()
+	h.Hub.Broadcast(nf.PrestadorID, "nfse:updated", nf)
+
+	numeros := ""
+	for i, s := range solicitacoes {
+		if i > 0 {
+			numeros += ", "
+		}
+		numeros += fmt.Sprintf("#%d", s.Numero)
+	}
+	h.LogAudit(c, "NotaFiscal", nf.ID, "CONSOLIDADA",
+		fmt.Sprintf("NFS-e consolidada das OS %s (R$ %.2f)", numeros, nf.ValorServicos), nil, nf)
+
+	return Created(c, fiber.Map{
+		"nfse":        nf,
+		"mensagem":    fmt.Sprintf("NFS-e consolidada de %d solicitações em processamento via GOV.BR Nacional.", len(solicitacoes)),
+		"usandoGovBR": true,
+	})
+}
@@ -0,0 +1,275 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+	"inovar/internal/services"
+)
+
+// loteContexto loads what every request of a batch is issued with
+func (h *Handler) loteContexto(c *fiber.Ctx) (*domain.Prestador, *domain.ConfiguracaoFiscal, *services.LoteFiltro, error) {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return nil, nil, nil, errors.New("Empresa não encontrada")
+	}
+
+	var filtro services.LoteFiltro
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&filtro); err != nil {
+			return nil, nil, nil, errors.New("Dados inválidos")
+		}
+	}
+
+	var fiscal domain.ConfiguracaoFiscal
+	if err := h.db(c).Where("prestador_id = ?", companyID).First(&fiscal).Error; err != nil {
+		return nil, nil, nil, errors.New("Configuração fiscal não encontrada")
+	}
+	var prestador domain.Prestador
+	if err := h.db(c).Preload("Endereco").First(&prestador, "id = ?", companyID).Error; err != nil {
+		return nil, nil, nil, errors.New("Prestador não encontrado")
+	}
+	return &prestador, &fiscal, &filtro, nil
+}
+
+// loteCertificado checks the certificate the batch will be signed with
+func (h *Handler) loteCertificado(c *fiber.Ctx, prestadorID string) error {
+	var certificate domain.CertificadoDigital
+	if err := h.db(c).Where("prestador_id = ? AND ativo = ?", prestadorID, true).First(&certificate).Error; err != nil {
+		return errors.New("Certificado digital A1 não configurado")
+	}
+	return services.CheckCertificateValid(&certificate, time.Now())
+}
+
+// PreviewLoteNFSe lists the completed requests without a note matching the filter, with the
+// values and taxes each note would be issued with
+func (h *Handler) PreviewLoteNFSe(c *fiber.Ctx) error {
+	prestador, fiscal, filtro, err := h.loteContexto(c)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	solicitacoes, err := h.NFSeEmissor.CandidatosLote(prestador.ID, filtro)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	itens := make([]fiber.Map, 0, len(solicitacoes))
+	var validas int
+	var valorServicos, valorISS, totalTributos, valorLiquido float64
+	for i := range solicitacoes {
+		s := &solicitacoes[i]
+		item := fiber.Map{
+			"solicitacaoId": s.ID,
+			"numero":        s.Numero,
+			"cliente":       s.ClientName,
+			"documento":     s.Client.Document,
+			"status":        s.Status,
+			"valorServicos": services.ValorSolicitacao(s),
+			"valido":        false,
+		}
+		nf, taxResult, err := h.NFSeEmissor.PrepararEmissao(s, prestador, fiscal)
+		if err != nil {
+			item["erro"] = err.Error()
+			itens = append(itens, item)
+			continue
+		}
+		item["valido"] = true
+		item["calculo"] = taxResult
+		item["discriminacao"] = nf.Discriminacao
+		itens = append(itens, item)
+
+		validas++
+		valorServicos += taxResult.ValorServicos
+		valorISS += taxResult.ValorISS
+		totalTributos += taxResult.TotalTributos
+		valorLiquido += taxResult.ValorLiquido
+	}
+
+	result := fiber.Map{
+		"itens":         itens,
+		"quantidade":    len(itens),
+		"validas":       validas,
+		"valorServicos": valorServicos,
+		"valorIss":      valorISS,
+		"totalTributos": totalTributos,
+		"valorLiquido":  valorLiquido,
+	}
+	if err := h.loteCertificado(c, prestador.ID); err != nil {
+		result["certificado"] = err.Error()
+	}
+	return Success(c, result)
+}
+
+// EmitirLoteNFSe queues the emission of the notes of every matching request (or of the requests
+// picked on the preview). Progress is broadcast as nfse:lote; requests that cannot be issued are
+// left out and listed in the summary.
+func (h *Handler) EmitirLoteNFSe(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+	prestador, fiscal, filtro, err := h.loteContexto(c)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+	if err := h.loteCertificado(c, prestador.ID); err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	solicitacoes, err := h.NFSeEmissor.CandidatosLote(prestador.ID, filtro)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	lote := domain.LoteNFSe{
+		ID:          uuid.New().String(),
+		PrestadorID: prestador.ID,
+		UserID:      userID,
+		Status:      domain.LoteNFSeProcessando,
+	}
+
+	// Every note is prepared before the transaction; the ones that fail validation stay out
+	type emissao struct {
+		nf        *domain.NotaFiscal
+		taxResult *services.TaxCalculationResult
+	}
+	var emissoes []emissao
+	ignoradas := []services.LoteIgnorada{}
+	for i := range solicitacoes {
+		s := &solicitacoes[i]
+		nf, taxResult, err := h.NFSeEmissor.PrepararEmissao(s, prestador, fiscal)
+		if err != nil {
+			ignoradas = append(ignoradas, services.LoteIgnorada{SolicitacaoID: s.ID, Numero: s.Numero, Cliente: s.ClientName, Motivo: err.Error()})
+			continue
+		}
+		nf.LoteID = lote.ID
+		emissoes = append(emissoes, emissao{nf: nf, taxResult: taxResult})
+		lote.ValorTotal += nf.ValorServicos
+	}
+	if len(emissoes) == 0 {
+		if len(ignoradas) > 0 {
+			return BadRequest(c, fmt.Sprintf("Nenhuma das %d solicitação(ões) pode ser emitida; confira a pré-visualização", len(ignoradas)))
+		}
+		return BadRequest(c, "Nenhuma solicitação concluída sem NFS-e encontrada para o filtro")
+	}
+	lote.Total = len(emissoes)
+	filtroJSON, _ := json.Marshal(filtro)
+	lote.Filtro = string(filtroJSON)
+	ignoradasJSON, _ := json.Marshal(ignoradas)
+	lote.Ignoradas = string(ignoradasJSON)
+
+	// The batch and all its notes are queued together so no note runs before the batch is complete
+	err = h.db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&lote).Error; err != nil {
+			return err
+		}
+		for _, e := range emissoes {
+			if err := h.NFSeEmissor.SolicitarEmissao(tx, e.nf, e.taxResult, userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return emissaoError(c, err)
+	}
+	h.JobQueue.Wake()
+
+	for _, e := range emissoes {
+		h.Hub.Broadcast(e.nf.PrestadorID, "nfse:updated", e.nf)
+	}
+	h.Hub.Broadcast(lote.PrestadorID, "nfse:lote", lote)
+	h.LogAudit(c, "LoteNFSe", lote.ID, "EMISSAO_LOTE",
+		fmt.Sprintf("Lote com %d NFS-e (R$ %.2f), %d solicitação(ões) ignorada(s)", lote.Total, lote.ValorTotal, len(ignoradas)), nil, lote)
+
+	resumo, err := h.resumoLote(c, &lote)
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Created(c, resumo)
+}
+
+// ListLotesNFSe lists the latest batch emissions of the company
+func (h *Handler) ListLotesNFSe(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	var lotes []domain.LoteNFSe
+	if err := h.db(c).Where("prestador_id = ?", companyID).Order("created_at DESC").Limit(50).Find(&lotes).Error; err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, lotes)
+}
+
+// GetLoteNFSe returns the summary of a batch emission: its notes with their outcome and the
+// requests left out
+func (h *Handler) GetLoteNFSe(c *fiber.Ctx) error {
+	var lote domain.LoteNFSe
+	if err := h.db(c).First(&lote, "id = ? AND prestador_id = ?", c.Params("id"), h.fiscalCompanyID(c)).Error; err != nil {
+		return NotFound(c, "Lote de NFS-e não encontrado")
+	}
+
+	resumo, err := h.resumoLote(c, &lote)
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, resumo)
+}
+
+// resumoLote lists the notes of a batch with the request each one was issued for
+func (h *Handler) resumoLote(c *fiber.Ctx, lote *domain.LoteNFSe) (fiber.Map, error) {
+	var notas []domain.NotaFiscal
+	if err := h.db(c).Where("lote_id = ?", lote.ID).Order("numero_dps").Find(&notas).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(notas))
+	for i, nf := range notas {
+		ids[i] = nf.SolicitacaoID
+	}
+	var solicitacoes []domain.Solicitacao
+	h.db(c).Select("id", "numero").Where("id IN ?", ids).Find(&solicitacoes)
+	numeros := make(map[string]int, len(solicitacoes))
+	for _, s := range solicitacoes {
+		numeros[s.ID] = s.Numero
+	}
+
+	itens := make([]fiber.Map, len(notas))
+	pendentes := 0
+	for i, nf := range notas {
+		if nf.Status == domain.NFSeStatusProcessando || nf.Status == domain.NFSeStatusPendente {
+			pendentes++
+		}
+		itens[i] = fiber.Map{
+			"notaId":        nf.ID,
+			"solicitacaoId": nf.SolicitacaoID,
+			"solicitacao":   numeros[nf.SolicitacaoID],
+			"tomador":       nf.TomadorNome,
+			"valorServicos": nf.ValorServicos,
+			"serieDps":      nf.SerieDPS,
+			"numeroDps":     nf.NumeroDPS,
+			"status":        nf.Status,
+			"numero":        nf.Numero,
+			"mensagemErro":  nf.MensagemErro,
+		}
+	}
+
+	ignoradas := []services.LoteIgnorada{}
+	if lote.Ignoradas != "" {
+		json.Unmarshal([]byte(lote.Ignoradas), &ignoradas)
+	}
+	return fiber.Map{
+		"lote":      lote,
+		"notas":     itens,
+		"pendentes": pendentes,
+		"ignoradas": ignoradas,
+	}, nil
+}
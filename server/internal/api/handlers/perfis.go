@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+)
+
+// PerfilRequest represents the payload of a configurable role
+type PerfilRequest struct {
+	Nome       string   `json:"nome"`
+	Descricao  string   `json:"descricao"`
+	Base       string   `json:"base"`
+	Permissoes []string `json:"permissoes"`
+}
+
+// GetPermissionCatalog returns the permissions, the defaults of the built-in roles and the suggested roles
+func (h *Handler) GetPermissionCatalog(c *fiber.Ctx) error {
+	return Success(c, fiber.Map{
+		"permissoes": domain.Permissoes,
+		"padrao":     domain.PermissoesPadrao,
+		"modelos":    domain.ModelosPerfil,
+	})
+}
+
+// GetMyPermissions returns the permissions of the authenticated user
+func (h *Handler) GetMyPermissions(c *fiber.Ctx) error {
+	perms := h.Permissoes.Permissions(middleware.GetUserID(c), middleware.GetUserRole(c))
+	list := make([]string, 0, len(perms))
+	for _, p := range domain.Permissoes {
+		if perms[p.Codigo] {
+			list = append(list, p.Codigo)
+		}
+	}
+	return Success(c, list)
+}
+
+// ListPerfis returns the configurable roles of the company with the number of users in each
+func (h *Handler) ListPerfis(c *fiber.Ctx) error {
+	var perfis []domain.Perfil
+	if err := h.db(c).Order("nome").Find(&perfis).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	var counts []struct {
+		PerfilID string
+		Total    int
+	}
+	h.db(c).Model(&domain.User{}).Select("perfil_id, COUNT(*) AS total").
+		Where("perfil_id IS NOT NULL").Group("perfil_id").Scan(&counts)
+	usuarios := map[string]int{}
+	for _, row := range counts {
+		usuarios[row.PerfilID] = row.Total
+	}
+
+	result := make([]fiber.Map, 0, len(perfis))
+	for _, p := range perfis {
+		result = append(result, fiber.Map{
+			"id":         p.ID,
+			"nome":       p.Nome,
+			"descricao":  p.Descricao,
+			"base":       p.Base,
+			"permissoes": p.Permissoes,
+			"usuarios":   usuarios[p.ID],
+			"createdAt":  p.CreatedAt,
+			"updatedAt":  p.UpdatedAt,
+		})
+	}
+	return Success(c, result)
+}
+
+// validatePerfil normalizes the payload and checks that the user may grant every permission in it
+func (h *Handler) validatePerfil(c *fiber.Ctx, req *PerfilRequest) string {
+	req.Nome = strings.TrimSpace(req.Nome)
+	if req.Nome == "" {
+		return "Informe o nome do perfil"
+	}
+	if req.Base != domain.RoleAdmin && req.Base != domain.RoleTecnico {
+		return "Perfil base deve ser ADMIN_SISTEMA ou TECNICO"
+	}
+
+	seen := map[string]bool{}
+	perms := make([]string, 0, len(req.Permissoes))
+	for _, p := range req.Permissoes {
+		if seen[p] {
+			continue
+		}
+		if !domain.PermissaoValida(p) {
+			return fmt.Sprintf("Permissão desconhecida: %s", p)
+		}
+		seen[p] = true
+		perms = append(perms, p)
+	}
+	req.Permissoes = perms
+	return h.checkGrant(c, perms)
+}
+
+// checkGrant checks that the user holds every permission they hand out, returning the error message
+func (h *Handler) checkGrant(c *fiber.Ctx, perms []string) string {
+	granted := h.Permissoes.Permissions(middleware.GetUserID(c), middleware.GetUserRole(c))
+	for _, p := range perms {
+		// Nobody hands out more than they hold
+		if !granted[p] {
+			return fmt.Sprintf("Você não possui a permissão %s para concedê-la", p)
+		}
+	}
+	return ""
+}
+
+// CreatePerfil creates a configurable role for the company
+func (h *Handler) CreatePerfil(c *fiber.Ctx) error {
+	companyID := middleware.GetCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	var req PerfilRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+	if msg := h.validatePerfil(c, &req); msg != "" {
+		return BadRequest(c, msg)
+	}
+
+	var exists int64
+	h.db(c).Model(&domain.Perfil{}).Where("nome = ?", req.Nome).Count(&exists)
+	if exists > 0 {
+		return BadRequest(c, "Já existe um perfil com este nome")
+	}
+
+	perfil := domain.Perfil{
+		ID:         uuid.New().String(),
+		CompanyID:  companyID,
+		Nome:       req.Nome,
+		Descricao:  req.Descricao,
+		Base:       req.Base,
+		Permissoes: req.Permissoes,
+	}
+	if err := h.db(c).Create(&perfil).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	h.LogAudit(c, "Perfil", perfil.ID, "CREATE", fmt.Sprintf("Perfil %s criado", perfil.Nome), nil, perfil)
+	return Created(c, perfil)
+}
+
+// UpdatePerfil changes the name, base role or permissions of a configurable role
+func (h *Handler) UpdatePerfil(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var req PerfilRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+	if msg := h.validatePerfil(c, &req); msg != "" {
+		return BadRequest(c, msg)
+	}
+
+	var perfil domain.Perfil
+	if err := h.db(c).First(&perfil, "id = ?", id).Error; err != nil {
+		return NotFound(c, "Perfil não encontrado")
+	}
+	before := perfil
+
+	var exists int64
+	h.db(c).Model(&domain.Perfil{}).Where("nome = ? AND id <> ?", req.Nome, id).Count(&exists)
+	if exists > 0 {
+		return BadRequest(c, "Já existe um perfil com este nome")
+	}
+
+	perfil.Nome = req.Nome
+	perfil.Descricao = req.Descricao
+	perfil.Base = req.Base
+	perfil.Permissoes = req.Permissoes
+	if err := h.db(c).Save(&perfil).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	// Users of the role follow its base role
+	if before.Base != perfil.Base {
+		var users []domain.User
+		h.db(c).Where("perfil_id = ?", perfil.ID).Find(&users)
+		for _, user := range users {
+			h.db(c).Model(&user).Update("role", perfil.Base)
+			if perfil.Base == domain.RoleTecnico {
+				h.ensureTecnico(c, user)
+			}
+		}
+	}
+	h.Permissoes.Invalidate()
+
+	h.LogAudit(c, "Perfil", perfil.ID, "UPDATE", fmt.Sprintf("Perfil %s alterado", perfil.Nome), before, perfil)
+	return Success(c, perfil)
+}
+
+// DeletePerfil removes a configurable role no user is assigned to
+func (h *Handler) DeletePerfil(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var perfil domain.Perfil
+	if err := h.db(c).First(&perfil, "id = ?", id).Error; err != nil {
+		return NotFound(c, "Perfil não encontrado")
+	}
+
+	var users int64
+	h.db(c).Model(&domain.User{}).Where("perfil_id = ?", id).Count(&users)
+	if users > 0 {
+		return BadRequest(c, fmt.Sprintf("Perfil atribuído a %d usuário(s); altere o perfil deles antes de excluir", users))
+	}
+
+	// Hard delete, so the name can be reused
+	if err := h.db(c).Unscoped().Delete(&perfil).Error; err != nil {
+		return ServerError(c, err)
+	}
+	h.Permissoes.Invalidate()
+
+	h.LogAudit(c, "Perfil", perfil.ID, "DELETE", fmt.Sprintf("Perfil %s excluído", perfil.Nome), perfil, nil)
+	return Success(c, fiber.Map{"message": "Perfil excluído"})
+}
+
+// AssignPerfil assigns a configurable role to a user, or returns them to the defaults of their
+// built-in role when no role is given
+func (h *Handler) AssignPerfil(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var req struct {
+		PerfilID string `json:"perfilId"`
+	}
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+	if id == middleware.GetUserID(c) {
+		return BadRequest(c, "Não é possível alterar o próprio perfil")
+	}
+
+	var user domain.User
+	if err := h.db(c).First(&user, "id = ?", id).Error; err != nil {
+		return NotFound(c, "Usuário não encontrado")
+	}
+	if user.Role != domain.RoleAdmin && user.Role != domain.RoleTecnico {
+		return BadRequest(c, "Perfis configuráveis se aplicam apenas a administradores e técnicos")
+	}
+	before := user
+
+	// The permissions the user ends up with
+	var perms []string
+	if req.PerfilID == "" {
+		user.PerfilID = nil
+		perms = domain.PermissoesPadrao[user.Role]
+	} else {
+		var perfil domain.Perfil
+		if err := h.db(c).First(&perfil, "id = ?", req.PerfilID).Error; err != nil {
+			return NotFound(c, "Perfil não encontrado")
+		}
+		user.PerfilID = &perfil.ID
+		user.Role = perfil.Base
+		perms = perfil.Permissoes
+	}
+	if msg := h.checkGrant(c, perms); msg != "" {
+		return Forbidden(c, msg)
+	}
+
+	if err := h.db(c).Save(&user).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	if user.Role == domain.RoleTecnico && before.Role != domain.RoleTecnico {
+		h.ensureTecnico(c, user)
+	}
+	h.Permissoes.Invalidate(user.ID)
+
+	h.broadcast(c, "user:updated", user)
+	h.LogAudit(c, "User", user.ID, "ASSIGN_ROLE", fmt.Sprintf("Perfil de acesso de %s alterado", user.Email), before, user)
+	return Success(c, user)
+}
+
+// ensureTecnico creates the technician entry of a user moved to a technician role
+func (h *Handler) ensureTecnico(c *fiber.Ctx, user domain.User) {
+	var tecnicos int64
+	h.db(c).Model(&domain.Tecnico{}).Where("user_id = ?", user.ID).Count(&tecnicos)
+	if tecnicos == 0 && user.CompanyID != nil {
+		h.db(c).Create(&domain.Tecnico{ID: uuid.New().String(), UserID: user.ID, CompanyID: *user.CompanyID})
+	}
+}
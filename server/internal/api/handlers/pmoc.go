@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+	"inovar/internal/services"
+)
+
+// SavePMOCRequest represents the PMOC plan payload
+type SavePMOCRequest struct {
+	ResponsavelID       string  `json:"responsavelId"`
+	ResponsavelNome     string  `json:"responsavelNome"`
+	ResponsavelRegistro string  `json:"responsavelRegistro"`
+	ResponsavelART      string  `json:"responsavelArt"`
+	Ambiente            string  `json:"ambiente"`
+	AreaM2              float64 `json:"areaM2"`
+	Ocupantes           int     `json:"ocupantes"`
+	VigenciaInicio      string  `json:"vigenciaInicio"`
+	VigenciaFim         string  `json:"vigenciaFim"`
+	Observacoes         string  `json:"observacoes"`
+	Active              *bool   `json:"active"`
+}
+
+// PMOCAtividadeRequest represents an activity payload
+type PMOCAtividadeRequest struct {
+	EquipamentoID string `json:"equipamentoId"`
+	Descricao     string `json:"descricao"`
+	Frequencia    string `json:"frequencia"`
+}
+
+// PMOCExecucaoRequest represents a manual execution record
+type PMOCExecucaoRequest struct {
+	AtividadeID   string `json:"atividadeId"`
+	EquipamentoID string `json:"equipamentoId"`
+	SolicitacaoID string `json:"solicitacaoId"`
+	ExecutadoEm   string `json:"executadoEm"`
+	Observacao    string `json:"observacao"`
+}
+
+// canAccessClient reports whether the current user may see data of a client: a client only its own,
+// the staff of a company its clients and the super admin every one. What the staff may change is up
+// to the route permissions.
+func (h *Handler) canAccessClient(c *fiber.Ctx, client *domain.Cliente) bool {
+	switch middleware.GetUserRole(c) {
+	case domain.RoleSuperAdmin:
+		return true
+	case domain.RoleCliente:
+		return client.UserID == middleware.GetUserID(c)
+	default:
+		return client.CompanyID == middleware.GetCompanyID(c)
+	}
+}
+
+var (
+	errPMOCNotFound  = errors.New("PMOC não encontrado")
+	errPMOCForbidden = errors.New("Acesso negado a este PMOC")
+)
+
+// loadPMOC fetches a plan checking the user's access to its client
+func (h *Handler) loadPMOC(c *fiber.Ctx, id string) (*domain.PMOCPlano, error) {
+	var plano domain.PMOCPlano
+	err := h.db(c).Preload("Client").Preload("Atividades").First(&plano, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errPMOCNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !h.canAccessClient(c, &plano.Client) {
+		return nil, errPMOCForbidden
+	}
+	return &plano, nil
+}
+
+// pmocError writes the response for an error of loadPMOC
+func pmocError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, errPMOCNotFound):
+		return NotFound(c, err.Error())
+	case errors.Is(err, errPMOCForbidden):
+		return Forbidden(c, err.Error())
+	default:
+		return ServerError(c, err)
+	}
+}
+
+// GetClientPMOC returns the PMOC plan of a client
+func (h *Handler) GetClientPMOC(c *fiber.Ctx) error {
+	var client domain.Cliente
+	if err := h.db(c).First(&client, "id = ?", c.Params("id")).Error; err != nil {
+		return NotFound(c, "Cliente não encontrado")
+	}
+	if !h.canAccessClient(c, &client) {
+		return Forbidden(c, "Acesso negado a este cliente")
+	}
+
+	var plano domain.PMOCPlano
+	err := h.db(c).Preload("Atividades").Where("client_id = ?", client.ID).First(&plano).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return NotFound(c, "Cliente não possui PMOC")
+	}
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	return Success(c, plano)
+}
+
+// SaveClientPMOC creates or updates the PMOC plan of a client.
+// New plans start with the standard activities.
+func (h *Handler) SaveClientPMOC(c *fiber.Ctx) error {
+	var client domain.Cliente
+	if err := h.db(c).First(&client, "id = ?", c.Params("id")).Error; err != nil {
+		return NotFound(c, "Cliente não encontrado")
+	}
+	if !h.canAccessClient(c, &client) {
+		return Forbidden(c, "Acesso negado a este cliente")
+	}
+
+	var req SavePMOCRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	// Default the responsible to the given technician's name
+	if req.ResponsavelID != "" && req.ResponsavelNome == "" {
+		var tecnico domain.User
+		if err := h.db(c).First(&tecnico, "id = ?", req.ResponsavelID).Error; err == nil {
+			req.ResponsavelNome = tecnico.Name
+		}
+	}
+	if req.ResponsavelNome == "" || req.ResponsavelRegistro == "" {
+		return BadRequest(c, "Responsável técnico e registro (CREA/CFT) são obrigatórios")
+	}
+
+	inicio, err := ParseDateTime(req.VigenciaInicio)
+	if err != nil {
+		return BadRequest(c, "Data de início de vigência inválida")
+	}
+	fim, err := ParseDateTime(req.VigenciaFim)
+	if err != nil {
+		return BadRequest(c, "Data de fim de vigência inválida")
+	}
+
+	var plano domain.PMOCPlano
+	isNew := errors.Is(h.db(c).Where("client_id = ?", client.ID).First(&plano).Error, gorm.ErrRecordNotFound)
+	before := plano
+
+	if isNew {
+		plano = domain.PMOCPlano{
+			ID:             uuid.New().String(),
+			ClientID:       client.ID,
+			CompanyID:      client.CompanyID,
+			VigenciaInicio: time.Now(),
+			Active:         true,
+		}
+	}
+	plano.ResponsavelID = req.ResponsavelID
+	plano.ResponsavelNome = req.ResponsavelNome
+	plano.ResponsavelRegistro = req.ResponsavelRegistro
+	plano.ResponsavelART = req.ResponsavelART
+	plano.Ambiente = req.Ambiente
+	plano.AreaM2 = req.AreaM2
+	plano.Ocupantes = req.Ocupantes
+	plano.Observacoes = req.Observacoes
+	plano.VigenciaFim = fim
+	if inicio != nil {
+		plano.VigenciaInicio = *inicio
+	}
+	if req.Active != nil {
+		plano.Active = *req.Active
+	}
+
+	err = h.db(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&plano).Error; err != nil {
+			return err
+		}
+		if isNew {
+			atividades := h.PMOCService.DefaultActivities(plano.ID)
+			if err := tx.Create(&atividades).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	h.db(c).Preload("Atividades").First(&plano, "id = ?", plano.ID)
+
+	action := "UPDATE"
+	if isNew {
+		action = "CREATE"
+	}
+	h.LogAudit(c, "PMOC", plano.ID, action, fmt.Sprintf("PMOC do cliente %s", client.Name), before, plano)
+	h.Hub.Broadcast(plano.CompanyID, "pmoc:updated", plano)
+
+	return Success(c, plano)
+}
+
+// AddPMOCAtividade adds an activity to a plan
+func (h *Handler) AddPMOCAtividade(c *fiber.Ctx) error {
+	plano, err := h.loadPMOC(c, c.Params("id"))
+	if err != nil {
+		return pmocError(c, err)
+	}
+
+	var req PMOCAtividadeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+	if req.Descricao == "" {
+		return BadRequest(c, "Descrição é obrigatória")
+	}
+	if !services.ValidFrequency(req.Frequencia) {
+		return BadRequest(c, "Periodicidade inválida (MENSAL, TRIMESTRAL, SEMESTRAL ou ANUAL)")
+	}
+
+	atividade := domain.PMOCAtividade{
+		ID:         uuid.New().String(),
+		PlanoID:    plano.ID,
+		Descricao:  req.Descricao,
+		Frequencia: req.Frequencia,
+	}
+	if req.EquipamentoID != "" {
+		var eq domain.Equipamento
+		if err := h.db(c).First(&eq, "id = ? AND client_id = ?", req.EquipamentoID, plano.ClientID).Error; err != nil {
+			return BadRequest(c, "Equipamento não pertence ao cliente do PMOC")
+		}
+		atividade.EquipamentoID = &eq.ID
+	}
+
+	if err := h.db(c).Create(&atividade).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	h.LogAudit(c, "PMOC", plano.ID, "ADD_ACTIVITY", atividade.Descricao, nil, atividade)
+
+	return Created(c, atividade)
+}
+
+// DeletePMOCAtividade removes an activity from a plan. Its execution records are kept.
+func (h *Handler) DeletePMOCAtividade(c *fiber.Ctx) error {
+	plano, err := h.loadPMOC(c, c.Params("id"))
+	if err != nil {
+		return pmocError(c, err)
+	}
+
+	var atividade domain.PMOCAtividade
+	if err := h.db(c).First(&atividade, "id = ? AND plano_id = ?", c.Params("atividadeId"), plano.ID).Error; err != nil {
+		return NotFound(c, "Atividade não encontrada")
+	}
+
+	if err := h.db(c).Delete(&atividade).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	h.LogAudit(c, "PMOC", plano.ID, "REMOVE_ACTIVITY", atividade.Descricao, atividade, nil)
+
+	return Success(c, fiber.Map{"message": "Atividade removida"})
+}
+
+// pmocPeriod reads the from/to query parameters, defaulting to the last 12 months
+func pmocPeriod(c *fiber.Ctx) (time.Time, time.Time, error) {
+	from, to := services.PMOCPeriodoPadrao(time.Now())
+	if v := c.Query("from"); v != "" {
+		t, err := ParseDateTime(v)
+		if err != nil {
+			return from, to, err
+		}
+		from = *t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := ParseDateTime(v)
+		if err != nil {
+			return from, to, err
+		}
+		to = *t
+		if len(v) == len("2006-01-02") {
+			to = to.Add(24*time.Hour - time.Second) // date-only includes the whole day
+		}
+	}
+	if to.Before(from) {
+		return from, to, fmt.Errorf("período inválido")
+	}
+	return from, to, nil
+}
+
+// ListPMOCExecucoes returns the execution log of a plan for a period
+func (h *Handler) ListPMOCExecucoes(c *fiber.Ctx) error {
+	plano, err := h.loadPMOC(c, c.Params("id"))
+	if err != nil {
+		return pmocError(c, err)
+	}
+
+	from, to, err := pmocPeriod(c)
+	if err != nil {
+		return BadRequest(c, "Período inválido")
+	}
+
+	var execucoes []domain.PMOCExecucao
+	if err := h.db(c).Preload("Atividade").Preload("Equipamento").
+		Where("plano_id = ? AND executado_em BETWEEN ? AND ?", plano.ID, from, to).
+		Order("executado_em DESC").Find(&execucoes).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	return Success(c, execucoes)
+}
+
+// CreatePMOCExecucao records an activity performed outside a request flow
+func (h *Handler) CreatePMOCExecucao(c *fiber.Ctx) error {
+	plano, err := h.loadPMOC(c, c.Params("id"))
+	if err != nil {
+		return pmocError(c, err)
+	}
+
+	var req PMOCExecucaoRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	var atividade domain.PMOCAtividade
+	if err := h.db(c).First(&atividade, "id = ? AND plano_id = ?", req.AtividadeID, plano.ID).Error; err != nil {
+		return BadRequest(c, "Atividade não pertence a este PMOC")
+	}
+	var eq domain.Equipamento
+	if err := h.db(c).First(&eq, "id = ? AND client_id = ?", req.EquipamentoID, plano.ClientID).Error; err != nil {
+		return BadRequest(c, "Equipamento não pertence ao cliente do PMOC")
+	}
+	if atividade.EquipamentoID != nil && *atividade.EquipamentoID != eq.ID {
+		return BadRequest(c, "Atividade não se aplica a este equipamento")
+	}
+
+	executadoEm := time.Now()
+	if t, err := ParseDateTime(req.ExecutadoEm); err != nil {
+		return BadRequest(c, "Data de execução inválida")
+	} else if t != nil {
+		executadoEm = *t
+	}
+
+	userID := middleware.GetUserID(c)
+	var user domain.User
+	h.db(c).First(&user, "id = ?", userID)
+
+	execucao := domain.PMOCExecucao{
+		ID:            uuid.New().String(),
+		PlanoID:       plano.ID,
+		AtividadeID:   atividade.ID,
+		EquipamentoID: eq.ID,
+		ExecutadoEm:   executadoEm,
+		TecnicoID:     userID,
+		TecnicoNome:   user.Name,
+		Observacao:    req.Observacao,
+	}
+	if req.SolicitacaoID != "" {
+		execucao.SolicitacaoID = &req.SolicitacaoID
+	}
+
+	if err := h.db(c).Create(&execucao).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	h.Hub.Broadcast(plano.CompanyID, "pmoc:executed", fiber.Map{"planoId": plano.ID, "count": 1})
+
+	return Created(c, execucao)
+}
+
+// GetPMOCDocumento renders the PMOC document and execution log of a period.
+// format=pdf returns a PDF, json the raw report; HTML is the default.
+func (h *Handler) GetPMOCDocumento(c *fiber.Ctx) error {
+	plano, err := h.loadPMOC(c, c.Params("id"))
+	if err != nil {
+		return pmocError(c, err)
+	}
+
+	from, to, err := pmocPeriod(c)
+	if err != nil {
+		return BadRequest(c, "Período inválido")
+	}
+
+	report, err := h.PMOCService.Report(plano.ID, from, to)
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	filename := fmt.Sprintf("pmoc_%s_%s", from.Format("20060102"), to.Format("20060102"))
+
+	switch c.Query("format", "html") {
+	case "json":
+		return Success(c, report)
+	case "pdf":
+		data, err := services.RenderPMOCPDF(report)
+		if err != nil {
+			return ServerError(c, err)
+		}
+		c.Set("Content-Type", "application/pdf")
+		c.Set("Content-Disposition", fmt.Sprintf("inline; filename=%s.pdf", filename))
+		return c.Send(data)
+	default:
+		html, err := services.RenderPMOCHTML(report)
+		if err != nil {
+			return ServerError(c, err)
+		}
+		c.Set("Content-Type", "text/html; charset=utf-8")
+		return c.SendString(html)
+	}
+}
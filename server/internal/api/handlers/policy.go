@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+)
+
+// clienteDoUsuario returns the client profile of the logged CLIENTE, loaded once per request
+func (h *Handler) clienteDoUsuario(c *fiber.Ctx) *domain.Cliente {
+	if cliente, ok := c.Locals("cliente").(*domain.Cliente); ok {
+		return cliente
+	}
+	var cliente domain.Cliente
+	if err := h.db(c).Where("user_id = ?", middleware.GetUserID(c)).First(&cliente).Error; err != nil {
+		return nil
+	}
+	c.Locals("cliente", &cliente)
+	return &cliente
+}
+
+// tecnicoSomenteAtribuidas reports whether the technicians of the company only reach the requests
+// assigned to them, loaded once per request
+func (h *Handler) tecnicoSomenteAtribuidas(c *fiber.Ctx) bool {
+	if somente, ok := c.Locals("tecnicoSomenteAtribuidas").(bool); ok {
+		return somente
+	}
+	var somente []bool
+	h.db(c).Model(&domain.Prestador{}).Where("id = ?", middleware.GetCompanyID(c)).
+		Limit(1).Pluck("tecnico_somente_atribuidas", &somente)
+	c.Locals("tecnicoSomenteAtribuidas", len(somente) == 1 && somente[0])
+	return len(somente) == 1 && somente[0]
+}
+
+// workflowRole is the role whose status transitions the logged user follows
+func (h *Handler) workflowRole(c *fiber.Ctx) string {
+	role := middleware.GetUserRole(c)
+	return domain.WorkflowRole(role, h.Permissoes.Permissions(middleware.GetUserID(c), role))
+}
+
+// canWriteEquipment reports whether the user may register or change units of the client: a client
+// only its own units, anyone else with the equipment permission
+func (h *Handler) canWriteEquipment(c *fiber.Ctx, clientID string) bool {
+	role := middleware.GetUserRole(c)
+	if role == domain.RoleCliente {
+		cliente := h.clienteDoUsuario(c)
+		return cliente != nil && cliente.ID == clientID
+	}
+	return h.Permissoes.Has(middleware.GetUserID(c), role, domain.PermEquipmentsManage)
+}
+
+// canAccessRequest applies the request policy on top of the tenant scope: a client only reaches
+// its own requests and, when its company says so, a technician only those assigned to them
+func (h *Handler) canAccessRequest(c *fiber.Ctx, solicitacao *domain.Solicitacao) bool {
+	switch middleware.GetUserRole(c) {
+	case domain.RoleCliente:
+		cliente := h.clienteDoUsuario(c)
+		return cliente != nil && solicitacao.ClientID == cliente.ID
+	case domain.RoleTecnico:
+		if !h.tecnicoSomenteAtribuidas(c) {
+			return true
+		}
+		return solicitacao.ResponsibleID != nil && *solicitacao.ResponsibleID == middleware.GetUserID(c)
+	default:
+		return true
+	}
+}
+
+// requestPolicy narrows a request listing with the same rules as canAccessRequest
+func (h *Handler) requestPolicy(c *fiber.Ctx) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		switch middleware.GetUserRole(c) {
+		case domain.RoleCliente:
+			clientID := ""
+			if cliente := h.clienteDoUsuario(c); cliente != nil {
+				clientID = cliente.ID
+			}
+			return db.Where("client_id = ?", clientID)
+		case domain.RoleTecnico:
+			if h.tecnicoSomenteAtribuidas(c) {
+				return db.Where("responsible_id = ?", middleware.GetUserID(c))
+			}
+		}
+		return db
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+
+	"inovar/internal/api/middleware"
+)
+
+// preventiveScope returns the company to plan for: super admins see every company
+func preventiveScope(c *fiber.Ctx) string {
+	if middleware.IsSuperAdmin(c) {
+		return c.Query("companyId")
+	}
+	return middleware.GetCompanyID(c)
+}
+
+// PreviewPreventive lists the preventive work orders that would be generated (dry run)
+func (h *Handler) PreviewPreventive(c *fiber.Ctx) error {
+	horizon := c.QueryInt("horizonDays", h.PreventiveService.HorizonDays(preventiveScope(c)))
+
+	plans, err := h.PreventiveService.Plan(preventiveScope(c), horizon)
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	return Success(c, fiber.Map{
+		"horizonDays": horizon,
+		"plans":       plans,
+	})
+}
+
+// GeneratePreventive opens the preventive work orders that are due
+func (h *Handler) GeneratePreventive(c *fiber.Ctx) error {
+	horizon := c.QueryInt("horizonDays", h.PreventiveService.HorizonDays(preventiveScope(c)))
+
+	created, err := h.PreventiveService.Generate(preventiveScope(c), horizon)
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	h.LogAudit(c, "Solicitacao", "", "GENERATE_PREVENTIVE", fmt.Sprintf("%d chamado(s) de preventiva gerado(s)", len(created)), nil, nil)
+
+	return Created(c, created)
+}
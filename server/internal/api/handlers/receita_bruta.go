@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"inovar/internal/domain"
+)
+
+// competenciaParam parses the optional ?competencia=YYYY-MM, defaulting to the current month
+func competenciaParam(c *fiber.Ctx) (time.Time, bool) {
+	value := c.Query("competencia")
+	if value == "" {
+		return time.Now(), true
+	}
+	t, err := time.ParseInLocation("2006-01", value, time.Local)
+	return t, err == nil
+}
+
+// GetRBT12 previews the RBT12 of the company from its issued NFS-e without storing it
+func (h *Handler) GetRBT12(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+	competencia, ok := competenciaParam(c)
+	if !ok {
+		return BadRequest(c, "Competência inválida, use AAAA-MM")
+	}
+
+	var fiscal domain.ConfiguracaoFiscal
+	if err := h.db(c).Where("prestador_id = ?", companyID).First(&fiscal).Error; err != nil {
+		return NotFound(c, "Configuração fiscal não encontrada")
+	}
+
+	apuracao, err := h.ReceitaBruta.Calcular(&fiscal, competencia)
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, apuracao)
+}
+
+// ApurarRBT12 recalculates and stores the RBT12, bracket and effective rate of the company now
+func (h *Handler) ApurarRBT12(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+	competencia, ok := competenciaParam(c)
+	if !ok {
+		return BadRequest(c, "Competência inválida, use AAAA-MM")
+	}
+
+	apuracao, err := h.ReceitaBruta.Apurar(companyID, competencia)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	before, after := apuracao.Valores()
+	h.LogAudit(c, "ConfiguracaoFiscal", apuracao.ConfiguracaoID, "RBT12", apuracao.Resumo(), before, after)
+	return Success(c, apuracao)
+}
+
+// ListReceitasMensais lists the monthly revenue the company informed for the months before the system
+func (h *Handler) ListReceitasMensais(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	var receitas []domain.ReceitaMensal
+	if err := h.db(c).Where("prestador_id = ?", companyID).Order("competencia DESC").Find(&receitas).Error; err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, receitas)
+}
+
+// SaveReceitaMensalRequest is the revenue of a month the system has no notes for
+type SaveReceitaMensalRequest struct {
+	Valor float64 `json:"valor"`
+}
+
+// SaveReceitaMensal informs the gross revenue of a month before the system history, counted in the RBT12
+func (h *Handler) SaveReceitaMensal(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+	competencia, err := time.ParseInLocation("2006-01", c.Params("competencia"), time.Local)
+	if err != nil {
+		return BadRequest(c, "Competência inválida, use AAAA-MM")
+	}
+	var req SaveReceitaMensalRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+	if req.Valor < 0 {
+		return BadRequest(c, "Valor não pode ser negativo")
+	}
+
+	ref := competencia.Format("2006-01")
+	var receita domain.ReceitaMensal
+	found := h.db(c).Where("prestador_id = ? AND competencia = ?", companyID, ref).First(&receita).Error == nil
+	before := receita.Valor
+	if !found {
+		receita = domain.ReceitaMensal{ID: uuid.New().String(), PrestadorID: companyID, Competencia: ref}
+	}
+	receita.Valor = req.Valor
+	if err := h.db(c).Save(&receita).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	h.LogAudit(c, "ReceitaMensal", receita.ID, "UPDATE", "Receita de "+ref+" informada",
+		map[string]interface{}{"valor": before}, map[string]interface{}{"valor": receita.Valor})
+	return Success(c, receita)
+}
+
+// DeleteReceitaMensal removes an informed monthly revenue; the month falls back to the stored RBT12
+func (h *Handler) DeleteReceitaMensal(c *fiber.Ctx) error {
+	companyID := h.fiscalCompanyID(c)
+	if companyID == "" {
+		return BadRequest(c, "Empresa não encontrada")
+	}
+
+	var receita domain.ReceitaMensal
+	if err := h.db(c).Where("prestador_id = ? AND competencia = ?", companyID, c.Params("competencia")).First(&receita).Error; err != nil {
+		return NotFound(c, "Receita não informada para a competência")
+	}
+	if err := h.db(c).Delete(&receita).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	h.LogAudit(c, "ReceitaMensal", receita.ID, "DELETE", "Receita de "+receita.Competencia+" removida",
+		map[string]interface{}{"valor": receita.Valor}, nil)
+	return Success(c, fiber.Map{"message": "Receita removida"})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+)
+
+// RecordGasRequest represents a refrigerant ledger entry payload
+type RecordGasRequest struct {
+	Tipo           string  `json:"tipo"`
+	GasType        string  `json:"gasType"`
+	QuantidadeKg   float64 `json:"quantidadeKg"`
+	LoteCilindro   string  `json:"loteCilindro"`
+	TesteVazamento string  `json:"testeVazamento"`
+	MetodoTeste    string  `json:"metodoTeste"`
+	Observacao     string  `json:"observacao"`
+	SolicitacaoID  string  `json:"solicitacaoId"`
+	RealizadoEm    string  `json:"realizadoEm"`
+}
+
+// GetEquipmentGas returns the refrigerant ledger and leak-rate history of a unit
+func (h *Handler) GetEquipmentGas(c *fiber.Ctx) error {
+	var eq domain.Equipamento
+	if err := h.db(c).First(&eq, "id = ?", c.Params("id")).Error; err != nil {
+		return NotFound(c, "Equipamento não encontrado")
+	}
+
+	var movs []domain.MovimentacaoGas
+	if err := h.db(c).Where("equipamento_id = ?", eq.ID).Order("realizado_em DESC").Find(&movs).Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	history, err := h.RefrigerantService.LeakHistory(&eq)
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	var carga, recolhido float64
+	for _, m := range movs {
+		switch m.Tipo {
+		case domain.GasCarga:
+			carga += m.QuantidadeKg
+		case domain.GasRecolhimento:
+			recolhido += m.QuantidadeKg
+		}
+	}
+
+	return Success(c, fiber.Map{
+		"gasType":        eq.GasType,
+		"cargaNominalKg": eq.CargaNominalKg,
+		"totalCargaKg":   carga,
+		"totalRecolhido": recolhido,
+		"movimentacoes":  movs,
+		"taxaVazamento":  history,
+	})
+}
+
+// RecordEquipmentGas adds a refrigerant movement or leak test to a unit
+func (h *Handler) RecordEquipmentGas(c *fiber.Ctx) error {
+	var eq domain.Equipamento
+	if err := h.db(c).First(&eq, "id = ?", c.Params("id")).Error; err != nil {
+		return NotFound(c, "Equipamento não encontrado")
+	}
+
+	var req RecordGasRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	switch req.Tipo {
+	case domain.GasCarga, domain.GasRecolhimento:
+		if req.QuantidadeKg <= 0 {
+			return BadRequest(c, "Quantidade (kg) deve ser maior que zero")
+		}
+	case domain.GasTesteVazamento:
+		if req.TesteVazamento == "" || req.TesteVazamento == domain.TesteNaoRealizado {
+			return BadRequest(c, "Informe o resultado do teste de vazamento")
+		}
+		req.QuantidadeKg = 0
+	default:
+		return BadRequest(c, "Tipo inválido (CARGA, RECOLHIMENTO ou TESTE_VAZAMENTO)")
+	}
+
+	if req.TesteVazamento == "" {
+		req.TesteVazamento = domain.TesteNaoRealizado
+	}
+	switch req.TesteVazamento {
+	case domain.TesteNaoRealizado, domain.TesteAprovado, domain.TesteReprovado:
+	default:
+		return BadRequest(c, "Resultado de teste inválido (NAO_REALIZADO, APROVADO ou REPROVADO)")
+	}
+
+	gasType := domain.NormalizeGasType(req.GasType)
+	if gasType == "" {
+		gasType = domain.NormalizeGasType(eq.GasType)
+	}
+	if gasType == "" {
+		return BadRequest(c, "Informe o tipo de gás refrigerante")
+	}
+
+	realizadoEm := time.Now()
+	if t, err := ParseDateTime(req.RealizadoEm); err != nil {
+		return BadRequest(c, "Data inválida")
+	} else if t != nil {
+		realizadoEm = *t
+	}
+
+	userID := middleware.GetUserID(c)
+	var user domain.User
+	h.db(c).First(&user, "id = ?", userID)
+
+	mov := domain.MovimentacaoGas{
+		ID:             uuid.New().String(),
+		EquipamentoID:  eq.ID,
+		ClientID:       eq.ClientID,
+		CompanyID:      eq.CompanyID,
+		Tipo:           req.Tipo,
+		GasType:        gasType,
+		QuantidadeKg:   req.QuantidadeKg,
+		LoteCilindro:   req.LoteCilindro,
+		TesteVazamento: req.TesteVazamento,
+		MetodoTeste:    req.MetodoTeste,
+		Observacao:     req.Observacao,
+		TecnicoID:      userID,
+		TecnicoNome:    user.Name,
+		RealizadoEm:    realizadoEm,
+	}
+
+	if req.SolicitacaoID != "" {
+		var count int64
+		h.db(c).Model(&domain.SolicitacaoEquipamento{}).
+			Where("solicitacao_id = ? AND equipamento_id = ?", req.SolicitacaoID, eq.ID).Count(&count)
+		if count == 0 {
+			return BadRequest(c, "Equipamento não pertence a este chamado")
+		}
+		mov.SolicitacaoID = &req.SolicitacaoID
+		h.createHistoryEntry(req.SolicitacaoID, userID, "Movimentação de gás",
+			fmt.Sprintf("%s de %.2f kg de %s em %s %s", req.Tipo, req.QuantidadeKg, gasType, eq.Brand, eq.Model))
+	}
+
+	if err := h.RefrigerantService.Record(&mov); err != nil {
+		return ServerError(c, err)
+	}
+
+	return Created(c, mov)
+}
+
+// GetRefrigerantReport returns the yearly refrigerant consumption per gas (IBAMA CTF declaration).
+// format=csv downloads the report.
+func (h *Handler) GetRefrigerantReport(c *fiber.Ctx) error {
+	year := c.QueryInt("year", time.Now().Year())
+
+	companyID := middleware.GetCompanyID(c)
+	if middleware.IsSuperAdmin(c) {
+		companyID = c.Query("companyId")
+	}
+
+	report, err := h.RefrigerantService.AnnualReport(companyID, year)
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	if c.Query("format") != "csv" {
+		return Success(c, fiber.Map{"year": year, "gases": report})
+	}
+
+	c.Set("Content-Type", "text/csv")
+	c.Set("Content-Disposition", fmt.Sprintf("attachment; filename=relatorio_gases_%d.csv", year))
+
+	writer := csv.NewWriter(c)
+	defer writer.Flush()
+
+	writer.Write([]string{"Ano", "Gas", "Carga (kg)", "Recolhido (kg)", "Movimentacoes", "Equipamentos", "GWP", "Emissoes (tCO2e)"})
+	for _, line := range report {
+		writer.Write([]string{
+			strconv.Itoa(year),
+			line.GasType,
+			fmt.Sprintf("%.2f", line.CargaKg),
+			fmt.Sprintf("%.2f", line.RecolhidoKg),
+			strconv.Itoa(line.Movimentacoes),
+			strconv.Itoa(line.Equipamentos),
+			fmt.Sprintf("%.0f", line.GWP),
+			fmt.Sprintf("%.2f", line.EmissoesTCO2e),
+		})
+	}
+
+	return nil
+}
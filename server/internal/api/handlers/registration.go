@@ -137,7 +137,7 @@ func (h *Handler) PublicRegister(c *fiber.Ctx) error {
 	}
 
 	// 10. Broadcast events (optional but good for UI updates if admin is watching)
-	h.Hub.Broadcast("client:created", cliente)
+	h.Hub.Broadcast(cliente.CompanyID, "client:created", cliente)
 
 	// 11. Send Welcome Email
 	go func() {
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -8,24 +9,17 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 
 	"inovar/internal/api/middleware"
 	"inovar/internal/domain"
+	"inovar/internal/services"
 )
 
-// SLA hours by priority
-var slaHours = map[string]int{
-	domain.PriorityBaixa:       72,
-	domain.PriorityMedia:       48,
-	domain.PriorityAlta:        24,
-	domain.PriorityEmergencial: 6,
-}
-
 // ListRequests returns requests based on user role
 func (h *Handler) ListRequests(c *fiber.Ctx) error {
 	role := middleware.GetUserRole(c)
 	userID := middleware.GetUserID(c)
-	companyID := middleware.GetCompanyID(c)
 
 	// Query params
 	status := c.Query("status")
@@ -34,27 +28,12 @@ func (h *Handler) ListRequests(c *fiber.Ctx) error {
 	onlyMine := c.Query("onlyMine") == "true"
 
 	var requests []domain.Solicitacao
-	query := h.DB.Preload("Client").Preload("Equipments")
-
-	// Global filtering by CompanyID for non-global admins (if multi-tenant)
-	// But per user request: Admin & Tech see all, Client sees only their own.
-	if role == domain.RoleCliente {
-		// Find the Client record linked to this User
-		var client domain.Cliente
-		if err := h.DB.Where("user_id = ?", userID).First(&client).Error; err != nil {
-			return Success(c, []domain.Solicitacao{}) // No client profile yet
-		}
-		query = query.Where("client_id = ?", client.ID)
-	} else if role == domain.RoleTecnico || role == domain.RoleAdmin {
-		// Admin and Tech see everything related to their company
-		if role != domain.RoleAdmin {
-			query = query.Where("company_id = ?", companyID)
-		}
+	// The company filter comes from the tenant scope, the client one from the request policy
+	query := h.db(c).Scopes(h.requestPolicy(c)).Preload("Client").Preload("Equipments")
 
+	if onlyMine && role != domain.RoleCliente {
 		// Apply 'onlyMine' filter for Tech and Admin if requested
-		if onlyMine {
-			query = query.Where("(responsible_id = ? OR responsible_id IS NULL OR responsible_id = '')", userID)
-		}
+		query = query.Where("(responsible_id = ? OR responsible_id IS NULL OR responsible_id = '')", userID)
 	}
 
 	if status != "" {
@@ -98,20 +77,14 @@ func (h *Handler) CreateRequest(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 	companyID := middleware.GetCompanyID(c)
 
-	// Get next sequential number (Company scoped)
-	var count int64
-	h.DB.Model(&domain.Solicitacao{}).Where("company_id = ?", companyID).Count(&count)
-	number := int(count) + 1001
-
 	// Get client name
 	var client domain.Cliente
-	if err := h.DB.First(&client, "id = ?", req.ClientID).Error; err != nil {
+	if err := h.db(c).First(&client, "id = ?", req.ClientID).Error; err != nil {
 		return NotFound(c, "Cliente não encontrado")
 	}
 
 	solicitacao := domain.Solicitacao{
 		ID:          uuid.New().String(),
-		Numero:      number,
 		ClientID:    req.ClientID,
 		ClientName:  client.Name,
 		CompanyID:   companyID,
@@ -119,6 +92,7 @@ func (h *Handler) CreateRequest(c *fiber.Ctx) error {
 		ServiceType: req.ServiceType,
 		Description: req.Description,
 		Status:      domain.StatusAberta,
+		CreatedAt:   time.Now(),
 	}
 
 	if req.ScheduledAt != "" {
@@ -127,25 +101,26 @@ func (h *Handler) CreateRequest(c *fiber.Ctx) error {
 		}
 	}
 
-	// Calculate SLA
-	if hours, ok := slaHours[req.Priority]; ok {
-		limit := time.Now().Add(time.Duration(hours) * time.Hour)
-		solicitacao.SLALimit = limit
-	}
+	// Calculate SLA (settings, client contract and business calendar)
+	h.SLAService.Apply(&solicitacao)
 
-	if err := h.DB.Create(&solicitacao).Error; err != nil {
+	// Sequential number of the company, allocated along with the insert
+	if err := services.CreateNumberedRequest(h.db(c), &solicitacao); err != nil {
 		return ServerError(c, err)
 	}
 
-	// Attach equipments
+	// Attach equipments; units of another company are refused by the tenant scope
 	for _, eqID := range req.EquipmentIDs {
-		h.DB.Exec("INSERT INTO solicitacao_equipamentos (solicitacao_id, equipamento_id) VALUES (?, ?)", solicitacao.ID, eqID)
+		link := domain.SolicitacaoEquipamento{ID: uuid.New().String(), SolicitacaoID: solicitacao.ID, EquipamentoID: eqID}
+		if err := h.db(c).Create(&link).Error; err != nil {
+			log.Printf("⚠️ Equipamento %s não vinculado ao chamado %s: %v", eqID, solicitacao.ID, err)
+		}
 	}
 
 	// Create initial history
 	h.createHistoryEntry(solicitacao.ID, userID, "Chamado criado", "Solicitação inicial enviada")
 
-	h.Hub.Broadcast("request:created", solicitacao)
+	h.Hub.Broadcast(solicitacao.CompanyID, "request:created", solicitacao)
 
 	return Created(c, solicitacao)
 }
@@ -156,11 +131,14 @@ func (h *Handler) GetRequest(c *fiber.Ctx) error {
 
 	var solicitacao domain.Solicitacao
 	// Try finding by UUID or Number
-	query := h.DB.Preload("Client").Preload("Equipments").Preload("History").Preload("Checklists").Preload("Attachments").Preload("OrcamentoItens")
+	query := h.db(c).Preload("Client").Preload("Equipments").Preload("History").Preload("Checklists").Preload("Attachments").Preload("OrcamentoItens")
 
 	if err := query.Where("id = ? OR numero = ?", id, id).First(&solicitacao).Error; err != nil {
 		return NotFound(c, "Solicitação não encontrada")
 	}
+	if !h.canAccessRequest(c, &solicitacao) {
+		return Forbidden(c, "Acesso negado a esta solicitação")
+	}
 
 	return Success(c, solicitacao)
 }
@@ -175,14 +153,21 @@ func (h *Handler) UpdateRequest(c *fiber.Ctx) error {
 		return BadRequest(c, "Dados inválidos")
 	}
 
-	var solicitacao domain.Solicitacao
-	if err := h.DB.First(&solicitacao, "id = ?", id).Error; err != nil {
-		return NotFound(c, "Solicitação não encontrada")
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
 	}
 
+	priorityChanged := solicitacao.Priority != req.Priority
 	solicitacao.Priority = req.Priority
 	solicitacao.ServiceType = req.ServiceType
 	solicitacao.Description = req.Description
+	if priorityChanged {
+		h.SLAService.Apply(solicitacao)
+	}
 
 	if req.ScheduledAt != "" {
 		if t, err := time.Parse(time.RFC3339, req.ScheduledAt); err == nil {
@@ -190,12 +175,12 @@ func (h *Handler) UpdateRequest(c *fiber.Ctx) error {
 		}
 	}
 
-	if err := h.DB.Save(&solicitacao).Error; err != nil {
+	if err := h.db(c).Save(solicitacao).Error; err != nil {
 		return ServerError(c, err)
 	}
 
 	h.createHistoryEntry(solicitacao.ID, userID, "Chamado atualizado", "Dados principais alterados")
-	h.Hub.Broadcast("request:updated", solicitacao)
+	h.Hub.Broadcast(solicitacao.CompanyID, "request:updated", solicitacao)
 
 	return Success(c, solicitacao)
 }
@@ -217,23 +202,38 @@ func (h *Handler) UpdateRequestDetails(c *fiber.Ctx) error {
 		return BadRequest(c, "Dados inválidos")
 	}
 
-	var solicitacao domain.Solicitacao
-	if err := h.DB.First(&solicitacao, "id = ?", id).Error; err != nil {
-		return NotFound(c, "Solicitação não encontrada")
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
 	}
 
-	solicitacao.Priority = req.Priority
+	oldStatus := solicitacao.Status
+	if solicitacao.Priority != req.Priority {
+		solicitacao.Priority = req.Priority
+		h.SLAService.Apply(solicitacao)
+	}
 	if req.ResponsibleID != "" {
 		solicitacao.ResponsibleID = &req.ResponsibleID
 		solicitacao.ResponsibleName = req.ResponsibleName
+
+		// Assigning a technician schedules an open request, which the assign permission covers
+		if solicitacao.Status == domain.StatusAberta {
+			if err := solicitacao.Transition(domain.StatusAgendada, domain.TransitionInput{Role: domain.RoleAdmin}); err != nil {
+				return TransitionConflict(c, err)
+			}
+		}
 	}
 
-	if err := h.DB.Save(&solicitacao).Error; err != nil {
+	if err := h.db(c).Save(solicitacao).Error; err != nil {
 		return ServerError(c, err)
 	}
 
 	h.createHistoryEntry(solicitacao.ID, userID, "Detalhes atualizados", fmt.Sprintf("Prioridade: %s, Responsável: %s", req.Priority, req.ResponsibleName))
-	h.Hub.Broadcast("request:updated", solicitacao)
+	h.recordStatusChange(solicitacao, oldStatus, solicitacao.Status, userID, "")
+	h.Hub.Broadcast(solicitacao.CompanyID, "request:updated", solicitacao)
 
 	return Success(c, solicitacao)
 }
@@ -258,13 +258,22 @@ func (h *Handler) UpdateRequestStatus(c *fiber.Ctx) error {
 		return BadRequest(c, "Dados inválidos")
 	}
 
-	var solicitacao domain.Solicitacao
-	if err := h.DB.First(&solicitacao, "id = ?", id).Error; err != nil {
-		return NotFound(c, "Solicitação não encontrada")
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
 	}
 
 	oldStatus := solicitacao.Status
-	solicitacao.Status = req.Status
+	if err := solicitacao.Transition(req.Status, domain.TransitionInput{
+		Role:        h.workflowRole(c),
+		Observation: req.Observation,
+	}); err != nil {
+		return TransitionConflict(c, err)
+	}
+	h.SLAService.OnStatusChange(solicitacao, oldStatus)
 	solicitacao.MaterialsUsed = req.MaterialsUsed
 
 	if req.ScheduledAt != "" {
@@ -272,21 +281,70 @@ func (h *Handler) UpdateRequestStatus(c *fiber.Ctx) error {
 			solicitacao.ScheduledAt = &t
 		}
 	}
+	if req.NextMaintenanceAt != "" {
+		if t, err := ParseDateTime(req.NextMaintenanceAt); err == nil {
+			solicitacao.NextMaintenanceAt = t
+		}
+	}
 
-	if err := h.DB.Save(&solicitacao).Error; err != nil {
+	if err := h.db(c).Save(solicitacao).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.createHistoryEntry(solicitacao.ID, userID, "Status alterado", fmt.Sprintf("De %s para %s. Obs: %s", oldStatus, req.Status, req.Observation))
+	h.recordStatusChange(solicitacao, oldStatus, solicitacao.Status, userID, req.Observation)
+
+	// Roll the preventive schedule of the units forward once the service is done
+	finished := solicitacao.Status == domain.StatusFinalizada && oldStatus != domain.StatusFinalizada
+	if finished && (req.PreventiveDone || solicitacao.ServiceType == domain.ServiceTypePreventiva) {
+		if err := h.PreventiveService.Complete(solicitacao.ID, time.Now(), solicitacao.NextMaintenanceAt); err != nil {
+			log.Printf("⚠️ Falha ao atualizar agenda de preventivas do chamado %s: %v", solicitacao.ID, err)
+		}
+	}
+	if finished {
+		var user domain.User
+		h.db(c).First(&user, "id = ?", userID)
+		if _, err := h.PMOCService.RecordFromRequest(solicitacao, userID, user.Name); err != nil {
+			log.Printf("⚠️ Falha ao registrar execução do PMOC do chamado %s: %v", solicitacao.ID, err)
+		}
+	}
+
+	return Success(c, solicitacao)
+}
+
+// GetRequestTransitions returns the statuses the current user may move a request to
+func (h *Handler) GetRequestTransitions(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
+	}
+
+	return Success(c, fiber.Map{
+		"status":  solicitacao.Status,
+		"allowed": domain.AllowedTransitions(solicitacao.Status, h.workflowRole(c)),
+	})
+}
+
+// recordStatusChange writes the history entry and websocket event for a status change.
+// It is a no-op when the status did not change.
+func (h *Handler) recordStatusChange(solicitacao *domain.Solicitacao, oldStatus, newStatus, userID, observation string) {
+	if oldStatus == newStatus {
+		return
+	}
+
+	details := fmt.Sprintf("De %s para %s", oldStatus, newStatus)
+	if observation != "" {
+		details += ". Obs: " + observation
+	}
+	h.createHistoryEntry(solicitacao.ID, userID, "Status alterado", details)
 
-	h.Hub.Broadcast("request:status_changed", fiber.Map{
-		"id":        id,
+	h.Hub.Broadcast(solicitacao.CompanyID, "request:status_changed", fiber.Map{
+		"id":        solicitacao.ID,
 		"oldStatus": oldStatus,
-		"newStatus": req.Status,
+		"newStatus": newStatus,
 		"userId":    userID,
 	})
-
-	return Success(c, solicitacao)
 }
 
 // AssignRequestRequest represents assignment payload
@@ -305,23 +363,28 @@ func (h *Handler) AssignRequest(c *fiber.Ctx) error {
 		return BadRequest(c, "Dados inválidos")
 	}
 
-	var solicitacao domain.Solicitacao
-	if err := h.DB.First(&solicitacao, "id = ?", id).Error; err != nil {
-		return NotFound(c, "Solicitação não encontrada")
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
 	}
 
+	oldStatus := solicitacao.Status
 	solicitacao.ResponsibleID = &req.ResponsibleID
 	solicitacao.ResponsibleName = req.ResponsibleName
+	// The assign permission covers scheduling the open request
 	if solicitacao.Status == domain.StatusAberta {
-		solicitacao.Status = domain.StatusAgendada
+		if err := solicitacao.Transition(domain.StatusAgendada, domain.TransitionInput{Role: domain.RoleAdmin}); err != nil {
+			return TransitionConflict(c, err)
+		}
 	}
 
-	if err := h.DB.Save(&solicitacao).Error; err != nil {
+	if err := h.db(c).Save(solicitacao).Error; err != nil {
 		return ServerError(c, err)
 	}
 
 	h.createHistoryEntry(solicitacao.ID, userID, "Técnico atribuído", fmt.Sprintf("Atribuído a %s", req.ResponsibleName))
-	h.Hub.Broadcast("request:assigned", solicitacao)
+	h.recordStatusChange(solicitacao, oldStatus, solicitacao.Status, userID, "")
+	h.Hub.Broadcast(solicitacao.CompanyID, "request:assigned", solicitacao)
 
 	return Success(c, solicitacao)
 }
@@ -329,9 +392,12 @@ func (h *Handler) AssignRequest(c *fiber.Ctx) error {
 // GetRequestHistory returns request history
 func (h *Handler) GetRequestHistory(c *fiber.Ctx) error {
 	id := c.Params("id")
+	if solicitacao, err := h.findRequest(c, id); solicitacao == nil {
+		return err
+	}
 
 	var history []domain.SolicitacaoHistorico
-	if err := h.DB.Where("solicitacao_id = ?", id).Order("created_at desc").Find(&history).Error; err != nil {
+	if err := h.db(c).Where("solicitacao_id = ?", id).Order("created_at desc").Find(&history).Error; err != nil {
 		return Success(c, []interface{}{})
 	}
 
@@ -343,21 +409,26 @@ func (h *Handler) ConfirmRequest(c *fiber.Ctx) error {
 	id := c.Params("id")
 	userID := middleware.GetUserID(c)
 
-	var solicitacao domain.Solicitacao
-	if err := h.DB.First(&solicitacao, "id = ?", id).Error; err != nil {
-		return NotFound(c, "Solicitação não encontrada")
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
 	}
 
-	solicitacao.Status = domain.StatusConcluida
+	oldStatus := solicitacao.Status
+	if err := solicitacao.Transition(domain.StatusConcluida, domain.TransitionInput{Role: h.workflowRole(c)}); err != nil {
+		return TransitionConflict(c, err)
+	}
 	now := time.Now()
 	solicitacao.ConfirmedAt = &now
+	solicitacao.ConfirmedBy = &userID
 
-	if err := h.DB.Save(&solicitacao).Error; err != nil {
+	if err := h.db(c).Save(solicitacao).Error; err != nil {
 		return ServerError(c, err)
 	}
 
 	h.createHistoryEntry(solicitacao.ID, userID, "Chamado confirmado", "Finalizado pelo cliente")
-	h.Hub.Broadcast("request:confirmed", fiber.Map{"id": id})
+	h.recordStatusChange(solicitacao, oldStatus, solicitacao.Status, userID, "")
+	h.Hub.Broadcast(solicitacao.CompanyID, "request:confirmed", fiber.Map{"id": id})
 
 	return Success(c, solicitacao)
 }
@@ -366,65 +437,98 @@ func (h *Handler) ConfirmRequest(c *fiber.Ctx) error {
 func (h *Handler) AcquireLock(c *fiber.Ctx) error {
 	id := c.Params("id")
 	userID := middleware.GetUserID(c)
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
+	}
 
-	var solicitacao domain.Solicitacao
-	if err := h.DB.First(&solicitacao, "id = ?", id).Error; err != nil {
+	lock, ok, err := h.LockService.Acquire(id, solicitacao.CompanyID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return NotFound(c, "Solicitação não encontrada")
 	}
-
-	now := time.Now()
-	if solicitacao.LockedBy != nil && *solicitacao.LockedBy != userID && solicitacao.LockedAt != nil && solicitacao.LockedAt.After(now.Add(-time.Duration(h.Config.LockTimeoutSecs)*time.Second)) {
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"success": false,
-			"message": "Solicitação bloqueada para edição por outro usuário",
-		})
+	if err != nil {
+		return ServerError(c, err)
+	}
+	if !ok {
+		return Locked(c, lock)
 	}
 
-	solicitacao.LockedBy = &userID
-	solicitacao.LockedAt = &now
-
-	h.DB.Save(&solicitacao)
+	return Success(c, fiber.Map{"locked": true, "lock": lock})
+}
 
-	// Get user name for broadcast
-	var user domain.User
-	h.DB.First(&user, "id = ?", userID)
+// ReleaseLock releases edit lock on a request
+func (h *Handler) ReleaseLock(c *fiber.Ctx) error {
+	id := c.Params("id")
+	userID := middleware.GetUserID(c)
+	if solicitacao, err := h.findRequest(c, id); solicitacao == nil {
+		return err
+	}
 
-	h.Hub.Broadcast("request:locked", fiber.Map{
-		"id":         id,
-		"lockedBy":   userID,
-		"lockedName": user.Name,
-	})
+	if _, err := h.LockService.Release(id, userID); err != nil {
+		return ServerError(c, err)
+	}
 
-	return Success(c, fiber.Map{"locked": true})
+	return Success(c, fiber.Map{"locked": false})
 }
 
-// ReleaseLock releases edit lock on a request
-func (h *Handler) ReleaseLock(c *fiber.Ctx) error {
+// ForceUnlock removes the edit lock of a request regardless of its holder (admin only)
+func (h *Handler) ForceUnlock(c *fiber.Ctx) error {
 	id := c.Params("id")
 	userID := middleware.GetUserID(c)
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
+	}
 
-	var solicitacao domain.Solicitacao
-	if err := h.DB.First(&solicitacao, "id = ?", id).Error; err != nil {
+	previous, err := h.LockService.ForceRelease(id, solicitacao.CompanyID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return NotFound(c, "Solicitação não encontrada")
 	}
+	if err != nil {
+		return ServerError(c, err)
+	}
 
-	if solicitacao.LockedBy != nil && *solicitacao.LockedBy == userID {
-		solicitacao.LockedBy = nil
-		solicitacao.LockedAt = nil
-		h.DB.Save(&solicitacao)
+	details := "Solicitação não estava bloqueada"
+	if previous != nil {
+		details = fmt.Sprintf("Bloqueio de %s removido", previous.LockedName)
 	}
+	h.LogAudit(c, "requests", id, "FORCE_UNLOCK", details, previous, nil)
+	h.createHistoryEntry(id, userID, "Bloqueio removido", details)
 
-	h.Hub.Broadcast("request:unlocked", fiber.Map{"id": id})
+	return Success(c, fiber.Map{"locked": false, "previous": previous})
+}
 
-	return Success(c, fiber.Map{"locked": false})
+// findRequest loads a request the user may reach: 404 outside the company, 403 outside the request policy
+func (h *Handler) findRequest(c *fiber.Ctx, id string) (*domain.Solicitacao, error) {
+	var solicitacao domain.Solicitacao
+	if err := h.db(c).First(&solicitacao, "id = ?", id).Error; err != nil {
+		return nil, NotFound(c, "Solicitação não encontrada")
+	}
+	if !h.canAccessRequest(c, &solicitacao) {
+		return nil, Forbidden(c, "Acesso negado a esta solicitação")
+	}
+	return &solicitacao, nil
+}
+
+// lockConflict returns the edit lock of a request loaded by findRequest when another user holds it.
+// The caller's own lock is renewed, since editing counts as activity.
+func (h *Handler) lockConflict(c *fiber.Ctx, solicitacao *domain.Solicitacao) *services.LockInfo {
+	lock, err := h.LockService.HeldByOther(solicitacao.ID, solicitacao.CompanyID, middleware.GetUserID(c))
+	if err != nil {
+		return nil // missing requests are reported by the handler itself
+	}
+	return lock
 }
 
 // ListChecklists returns checklists for a request
 func (h *Handler) ListChecklists(c *fiber.Ctx) error {
 	requestID := c.Params("requestId")
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return err
+	}
 
 	var checklists []domain.Checklist
-	if err := h.DB.Where("solicitacao_id = ?", requestID).Find(&checklists).Error; err != nil {
+	if err := h.db(c).Where("solicitacao_id = ?", requestID).Find(&checklists).Error; err != nil {
 		return Success(c, []interface{}{})
 	}
 
@@ -434,6 +538,13 @@ func (h *Handler) ListChecklists(c *fiber.Ctx) error {
 // CreateChecklist creates a new checklist item
 func (h *Handler) CreateChecklist(c *fiber.Ctx) error {
 	requestID := c.Params("requestId")
+	solicitacao, err := h.findRequest(c, requestID)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
+	}
 
 	var req struct {
 		EquipamentoID string `json:"equipamentoId,omitempty"`
@@ -453,11 +564,11 @@ func (h *Handler) CreateChecklist(c *fiber.Ctx) error {
 		item.EquipamentoID = &req.EquipamentoID
 	}
 
-	if err := h.DB.Create(&item).Error; err != nil {
+	if err := h.db(c).Create(&item).Error; err != nil {
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("checklist:created", item)
+	h.broadcast(c, "checklist:created", item)
 
 	return Created(c, item)
 }
@@ -474,14 +585,21 @@ func (h *Handler) ToggleChecklist(c *fiber.Ctx) error {
 	}
 
 	var item domain.Checklist
-	if err := h.DB.First(&item, "id = ?", itemID).Error; err != nil {
+	if err := h.db(c).First(&item, "id = ?", itemID).Error; err != nil {
 		return NotFound(c, "Item não encontrado")
 	}
+	solicitacao, err := h.findRequest(c, item.SolicitacaoID)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
+	}
 
 	item.Checked = req.Checked
-	h.DB.Save(&item)
+	h.db(c).Save(&item)
 
-	h.Hub.Broadcast("checklist:updated", item)
+	h.broadcast(c, "checklist:updated", item)
 
 	return Success(c, item)
 }
@@ -490,11 +608,23 @@ func (h *Handler) ToggleChecklist(c *fiber.Ctx) error {
 func (h *Handler) DeleteChecklist(c *fiber.Ctx) error {
 	itemID := c.Params("id")
 
-	if err := h.DB.Delete(&domain.Checklist{}, "id = ?", itemID).Error; err != nil {
+	var item domain.Checklist
+	if err := h.db(c).First(&item, "id = ?", itemID).Error; err != nil {
 		return NotFound(c, "Item não encontrado")
 	}
+	solicitacao, err := h.findRequest(c, item.SolicitacaoID)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
+	}
 
-	h.Hub.Broadcast("checklist:deleted", fiber.Map{"id": itemID})
+	if err := h.db(c).Delete(&domain.Checklist{}, "id = ?", itemID).Error; err != nil {
+		return NotFound(c, "Item não encontrado")
+	}
+
+	h.broadcast(c, "checklist:deleted", fiber.Map{"id": itemID})
 
 	return Success(c, fiber.Map{"message": "Item removido"})
 }
@@ -502,9 +632,12 @@ func (h *Handler) DeleteChecklist(c *fiber.Ctx) error {
 // ListAttachments returns attachments for a request
 func (h *Handler) ListAttachments(c *fiber.Ctx) error {
 	requestID := c.Params("requestId")
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return err
+	}
 
 	var attachments []domain.Anexo
-	if err := h.DB.Where("solicitacao_id = ?", requestID).Find(&attachments).Error; err != nil {
+	if err := h.db(c).Where("solicitacao_id = ?", requestID).Find(&attachments).Error; err != nil {
 		return Success(c, []interface{}{})
 	}
 
@@ -515,6 +648,9 @@ func (h *Handler) ListAttachments(c *fiber.Ctx) error {
 func (h *Handler) UploadAttachment(c *fiber.Ctx) error {
 	requestID := c.Params("requestId")
 	userID := middleware.GetUserID(c)
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return err
+	}
 
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -533,7 +669,7 @@ func (h *Handler) UploadAttachment(c *fiber.Ctx) error {
 	}
 
 	var user domain.User
-	h.DB.First(&user, "id = ?", userID)
+	h.db(c).First(&user, "id = ?", userID)
 
 	attachment := domain.Anexo{
 		ID:             uuid.New().String(),
@@ -546,13 +682,13 @@ func (h *Handler) UploadAttachment(c *fiber.Ctx) error {
 		UploadedByName: user.Name,
 	}
 
-	if err := h.DB.Create(&attachment).Error; err != nil {
+	if err := h.db(c).Create(&attachment).Error; err != nil {
 		// Physical Rollback: remove file if DB registration fails
 		h.StorageService.Delete(url)
 		return ServerError(c, err)
 	}
 
-	h.Hub.Broadcast("attachment:created", attachment)
+	h.broadcast(c, "attachment:created", attachment)
 
 	return Created(c, attachment)
 }
@@ -562,9 +698,12 @@ func (h *Handler) DeleteAttachment(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var attachment domain.Anexo
-	if err := h.DB.First(&attachment, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&attachment, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Anexo não encontrado")
 	}
+	if solicitacao, err := h.findRequest(c, attachment.SolicitacaoID); solicitacao == nil {
+		return err
+	}
 
 	// Delete from storage
 	go func(path string) {
@@ -574,9 +713,9 @@ func (h *Handler) DeleteAttachment(c *fiber.Ctx) error {
 	}(attachment.FilePath)
 
 	// Delete from DB
-	h.DB.Delete(&attachment)
+	h.db(c).Delete(&attachment)
 
-	h.Hub.Broadcast("attachment:deleted", fiber.Map{"id": id})
+	h.broadcast(c, "attachment:deleted", fiber.Map{"id": id})
 
 	return Success(c, fiber.Map{"message": "Anexo removido"})
 }
@@ -590,6 +729,13 @@ func (h *Handler) GetOrcamentoSugestoes(c *fiber.Ctx) error {
 func (h *Handler) AddOrcamentoItem(c *fiber.Ctx) error {
 	requestID := c.Params("id")
 	userID := middleware.GetUserID(c)
+	solicitacao, err := h.findRequest(c, requestID)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
+	}
 
 	var req struct {
 		Descricao  string  `json:"descricao"`
@@ -611,7 +757,7 @@ func (h *Handler) AddOrcamentoItem(c *fiber.Ctx) error {
 		Tipo:          req.Tipo,
 	}
 
-	if err := h.DB.Create(&item).Error; err != nil {
+	if err := h.db(c).Create(&item).Error; err != nil {
 		return ServerError(c, err)
 	}
 
@@ -625,8 +771,15 @@ func (h *Handler) RemoveOrcamentoItem(c *fiber.Ctx) error {
 	itemID := c.Params("itemId")
 	requestID := c.Params("id")
 	userID := middleware.GetUserID(c)
+	solicitacao, err := h.findRequest(c, requestID)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
+	}
 
-	if err := h.DB.Delete(&domain.OrcamentoItem{}, "id = ?", itemID).Error; err != nil {
+	if err := h.db(c).Delete(&domain.OrcamentoItem{}, "id = ? AND solicitacao_id = ?", itemID, requestID).Error; err != nil {
 		return NotFound(c, "Item não encontrado")
 	}
 
@@ -639,8 +792,17 @@ func (h *Handler) RemoveOrcamentoItem(c *fiber.Ctx) error {
 func (h *Handler) AprovarOrcamento(c *fiber.Ctx) error {
 	requestID := c.Params("id")
 	userID := middleware.GetUserID(c)
+	solicitacao, err := h.findRequest(c, requestID)
+	if solicitacao == nil {
+		return err
+	}
+	if lock := h.lockConflict(c, solicitacao); lock != nil {
+		return Locked(c, lock)
+	}
 
-	h.DB.Model(&domain.Solicitacao{}).Where("id = ?", requestID).Update("orcamento_aprovado", true)
+	if err := h.db(c).Model(&domain.Solicitacao{}).Where("id = ?", requestID).Update("orcamento_aprovado", true).Error; err != nil {
+		return ServerError(c, err)
+	}
 
 	h.createHistoryEntry(requestID, userID, "Orçamento aprovado", "Aprovado pelo cliente")
 
@@ -651,6 +813,9 @@ func (h *Handler) AprovarOrcamento(c *fiber.Ctx) error {
 func (h *Handler) SalvarAssinatura(c *fiber.Ctx) error {
 	requestID := c.Params("id")
 	userID := middleware.GetUserID(c)
+	if solicitacao, err := h.findRequest(c, requestID); solicitacao == nil {
+		return err
+	}
 
 	var req struct {
 		Assinatura string `json:"assinatura"`
@@ -667,7 +832,9 @@ func (h *Handler) SalvarAssinatura(c *fiber.Ctx) error {
 		update["assinatura_tecnico"] = req.Assinatura
 	}
 
-	h.DB.Model(&domain.Solicitacao{}).Where("id = ?", requestID).Updates(update)
+	if err := h.db(c).Model(&domain.Solicitacao{}).Where("id = ?", requestID).Updates(update).Error; err != nil {
+		return ServerError(c, err)
+	}
 
 	h.createHistoryEntry(requestID, userID, "Assinatura salva", "Assinatura do "+req.Tipo)
 
@@ -690,13 +857,13 @@ func (h *Handler) createHistoryEntry(requestID, userID, action, details string)
 func (h *Handler) DeleteRequest(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	var solicitacao domain.Solicitacao
-	if err := h.DB.First(&solicitacao, "id = ?", id).Error; err != nil {
-		return NotFound(c, "Solicitação não encontrada")
+	solicitacao, err := h.findRequest(c, id)
+	if solicitacao == nil {
+		return err
 	}
 
 	// Delete in transaction
-	tx := h.DB.Begin()
+	tx := h.db(c).Begin()
 
 	// Delete attachments and cleanup storage
 	var attachments []domain.Anexo
@@ -713,14 +880,14 @@ func (h *Handler) DeleteRequest(c *fiber.Ctx) error {
 	tx.Exec("DELETE FROM solicitacao_equipamentos WHERE solicitacao_id = ?", id)
 
 	// Delete request
-	if err := tx.Delete(&solicitacao).Error; err != nil {
+	if err := tx.Delete(solicitacao).Error; err != nil {
 		tx.Rollback()
 		return ServerError(c, err)
 	}
 
 	tx.Commit()
 
-	h.Hub.Broadcast("request:deleted", fiber.Map{"id": id})
+	h.Hub.Broadcast(solicitacao.CompanyID, "request:deleted", fiber.Map{"id": id})
 
 	return Success(c, fiber.Map{"message": "Chamado e dados relacionados excluídos com sucesso"})
 }
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+)
+
+// APIPrefix is the path the API routes are mounted under
+const APIPrefix = "/api"
+
+// Route is an authenticated API route and what it requires from the user. The table is both what the
+// router registers and the permission matrix served by ListRoutes.
+type Route struct {
+	Method      string
+	Path        string // relative to APIPrefix
+	Requirement middleware.Requirement
+	Handler     fiber.Handler
+}
+
+// can requires every permission from the role of the user
+func can(permissoes ...string) middleware.Requirement {
+	return middleware.Requirement{Permissions: permissoes}
+}
+
+var (
+	// anyone signed in may call the route; the handler decides what the user sees
+	anyone     = middleware.Requirement{}
+	superAdmin = middleware.Requirement{Roles: []string{domain.RoleSuperAdmin}}
+)
+
+// Routes returns the authenticated API routes
+func (h *Handler) Routes() []Route {
+	return []Route{
+		// User profile
+		{fiber.MethodGet, "/me", anyone, h.GetCurrentUser},
+		{fiber.MethodPut, "/me", anyone, h.UpdateCurrentUser},
+		{fiber.MethodPut, "/me/password", anyone, h.ChangePassword},
+		{fiber.MethodPost, "/logout", anyone, h.Logout},
+		{fiber.MethodGet, "/me/sessions", anyone, h.ListSessions},
+		{fiber.MethodDelete, "/me/sessions", anyone, h.RevokeOtherSessions},
+		{fiber.MethodDelete, "/me/sessions/:id", anyone, h.RevokeSession},
+		{fiber.MethodGet, "/me/permissions", anyone, h.GetMyPermissions},
+		{fiber.MethodGet, "/me/2fa", anyone, h.GetTwoFactorStatus},
+		{fiber.MethodPost, "/me/2fa/enroll", anyone, h.BeginTwoFactor},
+		{fiber.MethodPost, "/me/2fa/confirm", anyone, h.ConfirmTwoFactor},
+		{fiber.MethodPost, "/me/2fa/recovery-codes", anyone, h.RegenerateRecoveryCodes},
+		{fiber.MethodDelete, "/me/2fa", anyone, h.DisableTwoFactor},
+		{fiber.MethodDelete, "/me/2fa/devices", anyone, h.ForgetTrustedDevices},
+		{fiber.MethodPost, "/upload", anyone, h.UploadFile},
+
+		// Company profile
+		{fiber.MethodGet, "/company", anyone, h.GetCompany},
+		{fiber.MethodPut, "/company", anyone, h.UpdateCompany},
+
+		// Notifications
+		{fiber.MethodGet, "/notifications", anyone, h.ListNotifications},
+		{fiber.MethodPatch, "/notifications/read-all", anyone, h.MarkAllNotificationsAsRead},
+		{fiber.MethodPatch, "/notifications/:id/read", anyone, h.MarkNotificationAsRead},
+
+		// Users management
+		{fiber.MethodGet, "/users", can(domain.PermUsersManage), h.ListUsers},
+		{fiber.MethodPost, "/users", can(domain.PermUsersManage), h.CreateUser},
+		{fiber.MethodGet, "/users/:id", can(domain.PermUsersManage), h.GetUser},
+		{fiber.MethodPut, "/users/:id", can(domain.PermUsersManage), h.UpdateUser},
+		{fiber.MethodPatch, "/users/:id/block", can(domain.PermUsersManage), h.BlockUser},
+		{fiber.MethodPost, "/users/:id/reset-password", can(domain.PermUsersManage), h.AdminResetPassword},
+		{fiber.MethodPut, "/users/:id/role", can(domain.PermUsersManage, domain.PermRolesManage), h.AssignPerfil},
+		{fiber.MethodDelete, "/users/:id/2fa", can(domain.PermUsersManage), h.AdminResetTwoFactor},
+		{fiber.MethodDelete, "/users/:id", can(domain.PermUsersManage), h.DeleteUser},
+
+		// Access roles (permission profiles)
+		{fiber.MethodGet, "/roles/permissions", can(domain.PermRolesManage), h.GetPermissionCatalog},
+		{fiber.MethodGet, "/roles", can(domain.PermRolesManage), h.ListPerfis},
+		{fiber.MethodPost, "/roles", can(domain.PermRolesManage), h.CreatePerfil},
+		{fiber.MethodPut, "/roles/:id", can(domain.PermRolesManage), h.UpdatePerfil},
+		{fiber.MethodDelete, "/roles/:id", can(domain.PermRolesManage), h.DeletePerfil},
+
+		// Clients
+		{fiber.MethodGet, "/clients", anyone, h.ListClients},
+		{fiber.MethodPost, "/clients", can(domain.PermClientsCreate), h.CreateClient},
+		{fiber.MethodGet, "/clients/:id", anyone, h.GetClient},
+		{fiber.MethodPut, "/clients/:id", can(domain.PermClientsUpdate), h.UpdateClient},
+		{fiber.MethodPatch, "/clients/:id/block", can(domain.PermClientsUpdate), h.BlockClient},
+		{fiber.MethodDelete, "/clients/:id", can(domain.PermClientsDelete), h.DeleteClient},
+		{fiber.MethodGet, "/clients/:id/sla", can(domain.PermSLAView), h.GetClientSLA},
+		{fiber.MethodPut, "/clients/:id/sla", can(domain.PermSLAManage), h.UpdateClientSLA},
+		{fiber.MethodGet, "/clients/:id/pmoc", anyone, h.GetClientPMOC},
+		{fiber.MethodPut, "/clients/:id/pmoc", can(domain.PermPMOCManage), h.SaveClientPMOC},
+
+		// PMOC (Lei 13.589)
+		{fiber.MethodGet, "/pmoc/:id/documento", anyone, h.GetPMOCDocumento},
+		{fiber.MethodGet, "/pmoc/:id/execucoes", anyone, h.ListPMOCExecucoes},
+		{fiber.MethodPost, "/pmoc/:id/execucoes", can(domain.PermPMOCManage), h.CreatePMOCExecucao},
+		{fiber.MethodPost, "/pmoc/:id/atividades", can(domain.PermPMOCManage), h.AddPMOCAtividade},
+		{fiber.MethodDelete, "/pmoc/:id/atividades/:atividadeId", can(domain.PermPMOCManage), h.DeletePMOCAtividade},
+
+		// Equipment (clients write their own units, everyone else needs PermEquipmentsManage)
+		{fiber.MethodGet, "/equipments", anyone, h.ListEquipments},
+		{fiber.MethodPost, "/equipments", anyone, h.CreateEquipment},
+		{fiber.MethodGet, "/equipments/custom", anyone, h.GetCustomQRs},
+		{fiber.MethodPost, "/equipments/custom", anyone, h.CreateCustomQR},
+		{fiber.MethodDelete, "/equipments/custom/:id", anyone, h.DeleteCustomQR},
+		{fiber.MethodGet, "/equipments/:id", anyone, h.GetEquipment},
+		{fiber.MethodPut, "/equipments/:id", anyone, h.UpdateEquipment},
+		{fiber.MethodPatch, "/equipments/:id/deactivate", anyone, h.DeactivateEquipment},
+		{fiber.MethodPatch, "/equipments/:id/reactivate", anyone, h.ReactivateEquipment},
+		{fiber.MethodDelete, "/equipments/:id", can(domain.PermEquipmentsDelete), h.DeleteEquipment},
+		{fiber.MethodGet, "/equipments/:id/gas", can(domain.PermRefrigerantManage), h.GetEquipmentGas},
+		{fiber.MethodPost, "/equipments/:id/gas", can(domain.PermRefrigerantManage), h.RecordEquipmentGas},
+
+		// Refrigerant ledger
+		{fiber.MethodGet, "/refrigerant/report", can(domain.PermRefrigerantManage), h.GetRefrigerantReport},
+
+		// Service Requests
+		{fiber.MethodGet, "/requests", anyone, h.ListRequests},
+		{fiber.MethodPost, "/requests", anyone, h.CreateRequest},
+		{fiber.MethodGet, "/requests/:id", anyone, h.GetRequest},
+		{fiber.MethodPut, "/requests/:id", can(domain.PermRequestsUpdate), h.UpdateRequest},
+		{fiber.MethodPatch, "/requests/:id/status", anyone, h.UpdateRequestStatus}, // steps checked against domain.WorkflowRole
+		{fiber.MethodPatch, "/requests/:id/details", can(domain.PermRequestsAssign), h.UpdateRequestDetails},
+		{fiber.MethodPatch, "/requests/:id/assign", can(domain.PermRequestsAssign), h.AssignRequest},
+		{fiber.MethodGet, "/requests/:id/history", anyone, h.GetRequestHistory},
+		{fiber.MethodGet, "/requests/:id/transitions", anyone, h.GetRequestTransitions},
+		{fiber.MethodPost, "/requests/:id/confirm", anyone, h.ConfirmRequest},
+		{fiber.MethodPost, "/requests/:id/lock", anyone, h.AcquireLock},
+		{fiber.MethodDelete, "/requests/:id/lock", anyone, h.ReleaseLock},
+		{fiber.MethodDelete, "/requests/:id/lock/force", can(domain.PermRequestsUnlock), h.ForceUnlock},
+		{fiber.MethodDelete, "/requests/:id", can(domain.PermRequestsDelete), h.DeleteRequest},
+
+		// Preventive maintenance
+		{fiber.MethodGet, "/preventive/preview", can(domain.PermPreventiveManage), h.PreviewPreventive},
+		{fiber.MethodPost, "/preventive/generate", can(domain.PermPreventiveManage), h.GeneratePreventive},
+
+		// Checklists
+		{fiber.MethodGet, "/requests/:requestId/checklists", anyone, h.ListChecklists},
+		{fiber.MethodPost, "/requests/:requestId/checklists", can(domain.PermChecklistsManage), h.CreateChecklist},
+		{fiber.MethodDelete, "/requests/:requestId/checklists/:id", can(domain.PermChecklistsManage), h.DeleteChecklist},
+		{fiber.MethodPatch, "/requests/:requestId/checklists/:id", can(domain.PermChecklistsManage), h.ToggleChecklist},
+
+		// Attachments
+		{fiber.MethodGet, "/requests/:requestId/attachments", anyone, h.ListAttachments},
+		{fiber.MethodPost, "/requests/:requestId/attachments", anyone, h.UploadAttachment},
+		{fiber.MethodDelete, "/requests/:requestId/attachments/:id", can(domain.PermAttachmentsDel), h.DeleteAttachment},
+
+		// Budget/Orcamento
+		{fiber.MethodGet, "/requests/orcamento/sugestoes", anyone, h.GetOrcamentoSugestoes},
+		{fiber.MethodPost, "/requests/:id/orcamento/itens", anyone, h.AddOrcamentoItem},
+		{fiber.MethodDelete, "/requests/:id/orcamento/itens/:itemId", anyone, h.RemoveOrcamentoItem},
+		{fiber.MethodPost, "/requests/:id/orcamento/aprovar", anyone, h.AprovarOrcamento},
+
+		// Signatures
+		{fiber.MethodPost, "/requests/:id/assinatura", anyone, h.SalvarAssinatura},
+
+		// NFS-e
+		{fiber.MethodPost, "/requests/:id/nfse", can(domain.PermNFSeIssue), h.IssueNFSe},
+		{fiber.MethodDelete, "/requests/:id/nfse", can(domain.PermNFSeCancel), h.CancelNFSe},
+		{fiber.MethodGet, "/requests/:id/nfse", anyone, h.GetNFSe},
+		{fiber.MethodGet, "/requests/:id/nfse/danfse", anyone, h.GetDANFSe},
+		{fiber.MethodGet, "/requests/:id/nfse/xml", anyone, h.GetNFSeXML},
+		{fiber.MethodGet, "/requests/:id/nfse/eventos", anyone, h.GetNFSeEventos},
+		{fiber.MethodPost, "/requests/:id/nfse/cancelar", can(domain.PermNFSeCancel), h.CancelNFSeWithMotivo},
+		{fiber.MethodPost, "/requests/:id/nfse/substituir", can(domain.PermNFSeCancel), h.SubstituteNFSe},
+
+		// Fiscal Management
+		{fiber.MethodGet, "/fiscal/config", can(domain.PermFiscalView), h.GetFiscalConfig},
+		{fiber.MethodPut, "/fiscal/config", can(domain.PermFiscalView, domain.PermFiscalConfig), h.UpdateFiscalConfig},
+		{fiber.MethodGet, "/fiscal/certificate", can(domain.PermFiscalView), h.GetCertificate},
+		{fiber.MethodGet, "/fiscal/certificate/status", can(domain.PermFiscalView), h.GetCertificateStatus},
+		{fiber.MethodPost, "/fiscal/certificate", can(domain.PermFiscalView, domain.PermFiscalConfig), h.UploadCertificate},
+		{fiber.MethodGet, "/fiscal/regimes", can(domain.PermFiscalView), h.GetTaxRegimes},
+		{fiber.MethodGet, "/fiscal/lookup/:cnpj", can(domain.PermFiscalView), h.LookupCNPJ},
+		{fiber.MethodPost, "/fiscal/calcular", can(domain.PermFiscalView), h.CalculateTaxes},
+		{fiber.MethodGet, "/fiscal/municipios", can(domain.PermFiscalView), h.SearchMunicipios},
+		{fiber.MethodGet, "/fiscal/rbt12", can(domain.PermFiscalView), h.GetRBT12},
+		{fiber.MethodPost, "/fiscal/rbt12/apurar", can(domain.PermFiscalView, domain.PermFiscalRBT12), h.ApurarRBT12},
+		{fiber.MethodGet, "/fiscal/receitas-mensais", can(domain.PermFiscalView), h.ListReceitasMensais},
+		{fiber.MethodPut, "/fiscal/receitas-mensais/:competencia", can(domain.PermFiscalView, domain.PermFiscalRBT12), h.SaveReceitaMensal},
+		{fiber.MethodDelete, "/fiscal/receitas-mensais/:competencia", can(domain.PermFiscalView, domain.PermFiscalRBT12), h.DeleteReceitaMensal},
+		{fiber.MethodGet, "/fiscal/livro", can(domain.PermFiscalView), h.GetLivroFiscal},
+		{fiber.MethodGet, "/fiscal/nfse/lote", can(domain.PermFiscalView), h.ListLotesNFSe},
+		{fiber.MethodPost, "/fiscal/nfse/lote/preview", can(domain.PermFiscalView), h.PreviewLoteNFSe},
+		{fiber.MethodPost, "/fiscal/nfse/lote", can(domain.PermFiscalView, domain.PermNFSeBatch), h.EmitirLoteNFSe},
+		{fiber.MethodGet, "/fiscal/nfse/lote/:id", can(domain.PermFiscalView), h.GetLoteNFSe},
+		{fiber.MethodPost, "/fiscal/nfse/consolidada/preview", can(domain.PermFiscalView), h.PreviewNFSeConsolidada},
+		{fiber.MethodPost, "/fiscal/nfse/consolidada", can(domain.PermFiscalView, domain.PermNFSeBatch), h.EmitirNFSeConsolidada},
+		{fiber.MethodGet, "/fiscal/jobs", can(domain.PermFiscalView), h.ListJobs},
+		{fiber.MethodPost, "/fiscal/jobs/:id/retry", can(domain.PermFiscalView), h.RetryJob},
+		{fiber.MethodGet, "/fiscal/series", can(domain.PermFiscalView), h.ListSeriesDPS},
+		{fiber.MethodPost, "/fiscal/series", can(domain.PermFiscalView, domain.PermFiscalSeries), h.CreateSerieDPS},
+		{fiber.MethodPut, "/fiscal/series/:serie", can(domain.PermFiscalView, domain.PermFiscalSeries), h.UpdateSerieDPS},
+		{fiber.MethodGet, "/fiscal/series/:serie/lacunas", can(domain.PermFiscalView), h.GetLacunasDPS},
+
+		// Agenda
+		{fiber.MethodGet, "/agenda", can(domain.PermAgendaManage), h.GetAgenda},
+		{fiber.MethodPost, "/agenda", can(domain.PermAgendaManage), h.CreateAgendaEntry},
+		{fiber.MethodPut, "/agenda/:id", can(domain.PermAgendaManage), h.UpdateAgendaEntry},
+		{fiber.MethodDelete, "/agenda/:id", can(domain.PermAgendaManage), h.DeleteAgendaEntry},
+
+		// Finance
+		{fiber.MethodGet, "/finance/summary", can(domain.PermFinanceView), h.GetFinanceSummary},
+		{fiber.MethodGet, "/finance/transactions", can(domain.PermFinanceView), h.ListTransactions},
+		{fiber.MethodGet, "/finance/export", can(domain.PermFinanceView), h.ExportFinance},
+
+		// Audit logs
+		{fiber.MethodGet, "/audit", can(domain.PermAuditView), h.ListAuditLogs},
+		{fiber.MethodGet, "/audit/export", can(domain.PermAuditView), h.ExportAudit},
+
+		// Settings of the company; the super admin edits the values shared by every company
+		{fiber.MethodGet, "/settings", can(domain.PermSettingsManage), h.GetSettings},
+		{fiber.MethodPut, "/settings", can(domain.PermSettingsManage), h.UpdateSettings},
+
+		// System Visibility (raw tables span every company, so only the super admin reads them)
+		{fiber.MethodGet, "/system/routes", can(domain.PermSystemView), h.ListRoutes},
+		{fiber.MethodGet, "/system/tables", superAdmin, h.ListTables},
+		{fiber.MethodGet, "/system/tables/:name", superAdmin, h.GetTableData},
+	}
+}
@@ -1,42 +1,36 @@
 package handlers
 
 import (
+	"fmt"
+
+	"inovar/internal/api/middleware"
 	"inovar/internal/domain"
+	"inovar/internal/services"
 
 	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
-// GetSettings returns all system settings
-func (h *Handler) GetSettings(c *fiber.Ctx) error {
-	var settings []domain.Setting
-	if err := h.DB.Find(&settings).Error; err != nil {
-		return ServerError(c, err)
-	}
-
-	// Convert to map for frontend
-	settingsMap := make(map[string]string)
-	for _, s := range settings {
-		settingsMap[s.Key] = s.Value
+// settingsCompany returns the company whose settings the user manages; the super admin manages
+// the values shared by every company
+func settingsCompany(c *fiber.Ctx) string {
+	if middleware.IsSuperAdmin(c) {
+		return ""
 	}
+	return middleware.GetCompanyID(c)
+}
 
-	// Default values if database is empty
-	if len(settingsMap) == 0 {
-		defaults := map[string]string{
-			"sla_baixa":           "72", // hours
-			"sla_media":           "48",
-			"sla_alta":            "24",
-			"sla_emergencial":     "6",
-			"lock_timeout":        "5", // minutes
-			"confirm_days":        "7",
-			"preventive_interval": "90", // days, default 3 months
-		}
-		return Success(c, defaults)
+// GetSettings returns the settings in effect for the company of the user
+func (h *Handler) GetSettings(c *fiber.Ctx) error {
+	settings, err := services.Settings(h.db(c), settingsCompany(c))
+	if err != nil {
+		return ServerError(c, err)
 	}
-
-	return Success(c, settingsMap)
+	return Success(c, settings)
 }
 
-// UpdateSettings updates system settings
+// UpdateSettings updates the settings of the company of the user. System settings and the values
+// shared by every company are changed by the super admin.
 func (h *Handler) UpdateSettings(c *fiber.Ctx) error {
 	type SettingsRequest struct {
 		Settings map[string]string `json:"settings"`
@@ -47,33 +41,71 @@ func (h *Handler) UpdateSettings(c *fiber.Ctx) error {
 		return BadRequest(c, "Invalid JSON")
 	}
 
-	tx := h.DB.Begin()
+	companyID := settingsCompany(c)
+	current, err := services.Settings(h.db(c), companyID)
+	if err != nil {
+		return ServerError(c, err)
+	}
 
+	// Only what changes is stored, so a company keeps following the shared values it did not touch
+	changed := map[string]string{}
 	for key, value := range req.Settings {
-		var setting domain.Setting
-		if err := tx.FirstOrInit(&setting, domain.Setting{Key: key}).Error; err != nil {
-			tx.Rollback()
-			return ServerError(c, err)
+		if current[key] == value {
+			continue
 		}
-		setting.Value = value
-		if err := tx.Save(&setting).Error; err != nil {
-			tx.Rollback()
-			return ServerError(c, err)
+		if services.SystemSettings[key] && !middleware.IsSuperAdmin(c) {
+			return Forbidden(c, fmt.Sprintf("A configuração %s vale para todas as empresas e só pode ser alterada pelo super admin", key))
 		}
+		changed[key] = value
+		current[key] = value
 	}
 
-	tx.Commit()
+	// Validate the SLA business calendar before persisting
+	if _, err := services.ParseBusinessCalendar(
+		current["sla_business_hours"], current["sla_business_days"], current["sla_holidays"], current["sla_timezone"],
+	); err != nil {
+		return BadRequest(c, err.Error())
+	}
 
-	// Update preventive dates on equipment when interval changes
-	if intervalStr, ok := req.Settings["preventive_interval"]; ok {
-		// SQLite-compatible date arithmetic using datetime() function
-		query := `
-            UPDATE equipamentos
-            SET next_preventive_date = datetime(COALESCE(last_preventive_date, created_at), '+' || ? || ' days')
-            WHERE preventive_interval = 0 AND active = 1
-        `
-		h.DB.Exec(query, intervalStr)
+	err = h.db(c).Transaction(func(tx *gorm.DB) error {
+		for key, value := range changed {
+			if companyID == "" || services.SystemSettings[key] {
+				var setting domain.Setting
+				if err := tx.FirstOrInit(&setting, domain.Setting{Key: key}).Error; err != nil {
+					return err
+				}
+				setting.Value = value
+				if err := tx.Save(&setting).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Save(&domain.CompanySetting{CompanyID: companyID, Key: key, Value: value}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	// Reschedule the units following the default preventive interval
+	if interval, ok := changed["preventive_interval"]; ok {
+		query := h.db(c).Model(&domain.Equipamento{}).Where("preventive_interval = 0 AND active = ?", true)
+		if companyID != "" {
+			query = query.Where("company_id = ?", companyID)
+		} else {
+			// The shared default only moves the companies that have no interval of their own
+			query = query.Where("company_id NOT IN (SELECT company_id FROM company_settings WHERE key = ?)", "preventive_interval")
+		}
+		// SQLite date arithmetic
+		if err := query.Update("next_preventive_date",
+			gorm.Expr("datetime(COALESCE(last_preventive_date, created_at), '+' || ? || ' days')", interval)).Error; err != nil {
+			return ServerError(c, err)
+		}
 	}
 
-	return Success(c, req.Settings)
+	h.LogAudit(c, "Setting", companyID, "UPDATE", fmt.Sprintf("%d configuração(ões) alterada(s)", len(changed)), nil, changed)
+	return Success(c, current)
 }
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+
+	"inovar/internal/domain"
+)
+
+// GetClientSLA returns the effective SLA hours per priority for a client
+func (h *Handler) GetClientSLA(c *fiber.Ctx) error {
+	clientID := c.Params("id")
+
+	var client domain.Cliente
+	if err := h.db(c).First(&client, "id = ?", clientID).Error; err != nil {
+		return NotFound(c, "Cliente não encontrado")
+	}
+
+	var contratos []domain.ContratoSLA
+	h.db(c).Where("client_id = ?", clientID).Find(&contratos)
+
+	effective := fiber.Map{}
+	for _, p := range []string{domain.PriorityBaixa, domain.PriorityMedia, domain.PriorityAlta, domain.PriorityEmergencial} {
+		effective[p] = h.SLAService.HoursFor(client.CompanyID, clientID, p)
+	}
+
+	return Success(c, fiber.Map{
+		"contrato": contratos,
+		"efetivo":  effective,
+	})
+}
+
+// UpdateClientSLA sets the contract SLA overrides of a client. A value of 0 removes the override.
+func (h *Handler) UpdateClientSLA(c *fiber.Ctx) error {
+	clientID := c.Params("id")
+
+	var req map[string]int
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	var client domain.Cliente
+	if err := h.db(c).First(&client, "id = ?", clientID).Error; err != nil {
+		return NotFound(c, "Cliente não encontrado")
+	}
+
+	var before []domain.ContratoSLA
+	h.db(c).Where("client_id = ?", clientID).Find(&before)
+
+	tx := h.db(c).Begin()
+	for priority, hours := range req {
+		switch priority {
+		case domain.PriorityBaixa, domain.PriorityMedia, domain.PriorityAlta, domain.PriorityEmergencial:
+		default:
+			tx.Rollback()
+			return BadRequest(c, "Prioridade inválida: "+priority)
+		}
+		if hours < 0 {
+			tx.Rollback()
+			return BadRequest(c, "Horas de SLA inválidas")
+		}
+
+		if hours == 0 {
+			tx.Delete(&domain.ContratoSLA{}, "client_id = ? AND priority = ?", clientID, priority)
+			continue
+		}
+
+		var contrato domain.ContratoSLA
+		if err := tx.Where("client_id = ? AND priority = ?", clientID, priority).First(&contrato).Error; err != nil {
+			contrato = domain.ContratoSLA{
+				ID:        uuid.New().String(),
+				ClientID:  clientID,
+				CompanyID: client.CompanyID,
+				Priority:  priority,
+			}
+		}
+		contrato.Hours = hours
+		if err := tx.Save(&contrato).Error; err != nil {
+			tx.Rollback()
+			return ServerError(c, err)
+		}
+	}
+	if err := tx.Commit().Error; err != nil {
+		return ServerError(c, err)
+	}
+
+	var after []domain.ContratoSLA
+	h.db(c).Where("client_id = ?", clientID).Find(&after)
+
+	h.LogAudit(c, "Client", clientID, "UPDATE_SLA", "Updated SLA contract of "+client.Name, before, after)
+
+	return Success(c, after)
+}
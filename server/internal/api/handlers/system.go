@@ -4,35 +4,81 @@ import (
 	"sort"
 
 	"github.com/gofiber/fiber/v2"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
 )
 
-// ListRoutes returns all registered routes
+// ListRoutes returns the registered routes with the permission matrix: what each route requires (see
+// Routes) and which built-in and configurable roles of the company pass it
 func (h *Handler) ListRoutes(c *fiber.Ctx) error {
+	// Roles to evaluate: the built-in ones and the configurable roles of the company
+	type perfilMatriz struct {
+		nome  string
+		base  string
+		perms map[string]bool
+	}
+	var matriz []perfilMatriz
+	for _, role := range []string{domain.RoleAdmin, domain.RoleTecnico, domain.RoleCliente} {
+		matriz = append(matriz, perfilMatriz{nome: role, base: role, perms: permissaoSet(domain.PermissoesPadrao[role])})
+	}
+	var perfis []domain.Perfil
+	h.db(c).Order("nome").Find(&perfis)
+	for _, p := range perfis {
+		matriz = append(matriz, perfilMatriz{nome: p.Nome, base: p.Base, perms: permissaoSet(p.Permissoes)})
+	}
+
+	requirements := map[string]middleware.Requirement{}
+	for _, route := range h.Routes() {
+		requirements[route.Method+" "+APIPrefix+route.Path] = route.Requirement
+	}
+
 	var routes []fiber.Map
+	for _, route := range c.App().GetRoutes(true) {
+		// HEAD is served by the GET route
+		method := route.Method
+		if method == fiber.MethodHead {
+			method = fiber.MethodGet
+		}
+		req := requirements[method+" "+route.Path]
 
-	for _, stack := range c.App().Stack() {
-		for _, route := range stack {
-			routes = append(routes, fiber.Map{
-				"method": route.Method,
-				"path":   route.Path,
-				"name":   route.Name,
-			})
+		allowed := []string{}
+		for _, p := range matriz {
+			if req.Satisfies(p.base, p.perms) {
+				allowed = append(allowed, p.nome)
+			}
 		}
+		routes = append(routes, fiber.Map{
+			"method":     route.Method,
+			"path":       route.Path,
+			"name":       route.Name,
+			"roles":      append([]string{}, req.Roles...),
+			"permissoes": append([]string{}, req.Permissions...),
+			"perfis":     allowed,
+		})
 	}
 
-	sort.Slice(routes, func(i, j int) bool {
+	sort.SliceStable(routes, func(i, j int) bool {
 		return routes[i]["path"].(string) < routes[j]["path"].(string)
 	})
 
 	return Success(c, routes)
 }
 
+func permissaoSet(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, p := range list {
+		set[p] = true
+	}
+	return set
+}
+
 // ListTables returns all database tables
 func (h *Handler) ListTables(c *fiber.Ctx) error {
 	var tables []string
 
 	// GORM Migrator is database-agnostic (works with SQLite)
-	tableList, err := h.DB.Migrator().GetTables()
+	tableList, err := h.db(c).Migrator().GetTables()
 	if err != nil {
 		return ServerError(c, err)
 	}
@@ -46,15 +92,15 @@ func (h *Handler) GetTableData(c *fiber.Ctx) error {
 	tableName := c.Params("name")
 
 	// Security check: Ensure table exists to prevent SQL injection via table name
-	if !h.DB.Migrator().HasTable(tableName) {
+	if !h.db(c).Migrator().HasTable(tableName) {
 		return NotFound(c, "Tabela não encontrada")
 	}
 
 	// Fetch data (limit 100)
 	var results []map[string]interface{}
-	if err := h.DB.Table(tableName).Limit(100).Order("created_at desc").Find(&results).Error; err != nil {
+	if err := h.db(c).Table(tableName).Limit(100).Order("created_at desc").Find(&results).Error; err != nil {
 		// Try without order if created_at doesn't exist
-		if err := h.DB.Table(tableName).Limit(100).Find(&results).Error; err != nil {
+		if err := h.db(c).Table(tableName).Limit(100).Find(&results).Error; err != nil {
 			return ServerError(c, err)
 		}
 	}
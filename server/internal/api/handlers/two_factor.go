@@ -0,0 +1,271 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/crypto/bcrypt"
+
+	"inovar/internal/api/middleware"
+	"inovar/internal/domain"
+)
+
+// Purposes of the challenge tokens handed out between the password and the second factor
+const (
+	twoFactorPurposeVerify = "2fa"
+	twoFactorPurposeSetup  = "2fa-setup"
+	twoFactorChallengeMins = 5
+)
+
+// twoFactorChallenge answers a correct password with the token for the second-factor step
+func (h *Handler) twoFactorChallenge(c *fiber.Ctx, user *domain.User, purpose string) error {
+	token, err := middleware.GenerateChallengeToken(user.ID, purpose, h.Config.JWTSecret, twoFactorChallengeMins)
+	if err != nil {
+		return ServerError(c, err)
+	}
+
+	code, message := "two_factor_required", "Informe o código do aplicativo autenticador"
+	if purpose == twoFactorPurposeSetup {
+		code, message = "two_factor_setup_required", "Seu perfil exige autenticação em dois fatores. Configure o aplicativo autenticador."
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"success":        false,
+		"error":          code,
+		"message":        message,
+		"challengeToken": token,
+		"expiresIn":      twoFactorChallengeMins * 60,
+	})
+}
+
+// challengeUser resolves the active user of a challenge token
+func (h *Handler) challengeUser(token, purpose string) (*domain.User, bool) {
+	claims, err := middleware.ParseChallengeToken(token, purpose, h.Config.JWTSecret)
+	if err != nil {
+		return nil, false
+	}
+	var user domain.User
+	if err := h.DB.First(&user, "id = ? AND active = ?", claims.UserID, true).Error; err != nil {
+		return nil, false
+	}
+	return &user, true
+}
+
+// TwoFactorSetupRequest represents the payload of an enrollment forced at sign-in
+type TwoFactorSetupRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+}
+
+// SetupTwoFactor starts the enrollment of a user whose role requires a second factor before
+// their first sign-in with it
+func (h *Handler) SetupTwoFactor(c *fiber.Ctx) error {
+	var req TwoFactorSetupRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+	user, ok := h.challengeUser(req.ChallengeToken, twoFactorPurposeSetup)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Sessão de autenticação expirada. Faça login novamente.",
+		})
+	}
+
+	enrollment, err := h.TwoFactor.Begin(user)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+	return Success(c, enrollment)
+}
+
+// TwoFactorVerifyRequest represents the second step of a sign-in
+type TwoFactorVerifyRequest struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+	RememberDevice bool   `json:"rememberDevice"`
+}
+
+// VerifyTwoFactor checks the authenticator or recovery code of a challenge and signs the user in.
+// A setup challenge confirms the enrollment and returns the recovery codes along with the tokens.
+func (h *Handler) VerifyTwoFactor(c *fiber.Ctx) error {
+	var req TwoFactorVerifyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	purpose := twoFactorPurposeVerify
+	user, ok := h.challengeUser(req.ChallengeToken, purpose)
+	if !ok {
+		purpose = twoFactorPurposeSetup
+		if user, ok = h.challengeUser(req.ChallengeToken, purpose); !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Sessão de autenticação expirada. Faça login novamente.",
+			})
+		}
+	}
+
+	extra := fiber.Map{}
+	switch {
+	case purpose == twoFactorPurposeSetup:
+		codes, err := h.TwoFactor.Confirm(user, req.Code)
+		if err != nil {
+			return BadRequest(c, err.Error())
+		}
+		extra["recoveryCodes"] = codes
+	case req.RecoveryCode != "":
+		if err := h.TwoFactor.UseRecoveryCode(user.ID, req.RecoveryCode); err != nil {
+			return BadRequest(c, err.Error())
+		}
+		extra["recoveryCodesLeft"] = h.TwoFactor.RecoveryCodesLeft(user.ID)
+	default:
+		if err := h.TwoFactor.Verify(user.ID, req.Code); err != nil {
+			return BadRequest(c, err.Error())
+		}
+	}
+
+	if req.RememberDevice {
+		token, expires, err := h.TwoFactor.TrustDevice(user, c.Get("User-Agent"), c.IP())
+		if err != nil {
+			return ServerError(c, err)
+		}
+		extra["deviceToken"] = token
+		extra["deviceExpiresAt"] = expires
+	}
+
+	return h.issueSession(c, user, extra)
+}
+
+// GetTwoFactorStatus returns the second-factor state of the authenticated user
+func (h *Handler) GetTwoFactorStatus(c *fiber.Ctx) error {
+	var user domain.User
+	if err := h.db(c).First(&user, "id = ?", middleware.GetUserID(c)).Error; err != nil {
+		return NotFound(c, "Usuário não encontrado")
+	}
+
+	var devices []domain.TrustedDevice
+	h.db(c).Where("user_id = ?", user.ID).Order("last_used_at DESC").Find(&devices)
+
+	return Success(c, fiber.Map{
+		"enabled":           user.TOTPEnabled,
+		"required":          h.TwoFactor.Required(&user),
+		"recoveryCodesLeft": h.TwoFactor.RecoveryCodesLeft(user.ID),
+		"trustedDevices":    devices,
+	})
+}
+
+// BeginTwoFactor starts the enrollment of the authenticated user
+func (h *Handler) BeginTwoFactor(c *fiber.Ctx) error {
+	var user domain.User
+	if err := h.db(c).First(&user, "id = ?", middleware.GetUserID(c)).Error; err != nil {
+		return NotFound(c, "Usuário não encontrado")
+	}
+
+	enrollment, err := h.TwoFactor.Begin(&user)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+	return Success(c, enrollment)
+}
+
+// TwoFactorCodeRequest carries an authenticator code
+type TwoFactorCodeRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// ConfirmTwoFactor enables the second factor after the first valid code
+func (h *Handler) ConfirmTwoFactor(c *fiber.Ctx) error {
+	var req TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	var user domain.User
+	if err := h.db(c).First(&user, "id = ?", middleware.GetUserID(c)).Error; err != nil {
+		return NotFound(c, "Usuário não encontrado")
+	}
+
+	codes, err := h.TwoFactor.Confirm(&user, req.Code)
+	if err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	h.LogAudit(c, "User", user.ID, "2FA_ENABLE", fmt.Sprintf("Autenticação em dois fatores ativada por %s", user.Email), nil, nil)
+	return Success(c, fiber.Map{"recoveryCodes": codes})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after a valid authenticator code
+func (h *Handler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var req TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	userID := middleware.GetUserID(c)
+	if err := h.TwoFactor.Verify(userID, req.Code); err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	codes, err := h.TwoFactor.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, fiber.Map{"recoveryCodes": codes})
+}
+
+// DisableTwoFactor turns the second factor off for the authenticated user, unless their role requires it
+func (h *Handler) DisableTwoFactor(c *fiber.Ctx) error {
+	var req TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return BadRequest(c, "Dados inválidos")
+	}
+
+	var user domain.User
+	if err := h.db(c).First(&user, "id = ?", middleware.GetUserID(c)).Error; err != nil {
+		return NotFound(c, "Usuário não encontrado")
+	}
+	if h.TwoFactor.Required(&user) {
+		return Forbidden(c, "Seu perfil exige autenticação em dois fatores")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		return BadRequest(c, "Senha atual incorreta")
+	}
+	if err := h.TwoFactor.Verify(user.ID, req.Code); err != nil {
+		return BadRequest(c, err.Error())
+	}
+
+	if err := h.TwoFactor.Disable(user.ID); err != nil {
+		return ServerError(c, err)
+	}
+
+	h.LogAudit(c, "User", user.ID, "2FA_DISABLE", fmt.Sprintf("Autenticação em dois fatores desativada por %s", user.Email), nil, nil)
+	return Success(c, fiber.Map{"message": "Autenticação em dois fatores desativada"})
+}
+
+// ForgetTrustedDevices makes every remembered browser of the user ask for the second factor again
+func (h *Handler) ForgetTrustedDevices(c *fiber.Ctx) error {
+	if err := h.TwoFactor.ForgetDevices(middleware.GetUserID(c)); err != nil {
+		return ServerError(c, err)
+	}
+	return Success(c, fiber.Map{"message": "Dispositivos esquecidos"})
+}
+
+// AdminResetTwoFactor removes the second factor of a user who lost the authenticator; on the next
+// sign-in they enroll again if their role requires it
+func (h *Handler) AdminResetTwoFactor(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	var user domain.User
+	if err := h.db(c).First(&user, "id = ?", id).Error; err != nil {
+		return NotFound(c, "Usuário não encontrado")
+	}
+	if user.Role == domain.RoleSuperAdmin && !middleware.IsSuperAdmin(c) {
+		return Forbidden(c, "Apenas o super administrador pode alterar este usuário")
+	}
+
+	if err := h.TwoFactor.Disable(user.ID); err != nil {
+		return ServerError(c, err)
+	}
+
+	h.LogAudit(c, "User", user.ID, "2FA_RESET", fmt.Sprintf("Autenticação em dois fatores de %s redefinida", user.Email), nil, nil)
+	return Success(c, fiber.Map{"message": "Autenticação em dois fatores redefinida"})
+}
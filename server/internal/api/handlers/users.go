@@ -13,17 +13,8 @@ import (
 
 // ListUsers returns all users based on role
 func (h *Handler) ListUsers(c *fiber.Ctx) error {
-	role := middleware.GetUserRole(c)
-	companyID := middleware.GetCompanyID(c)
-
 	var users []domain.User
-	query := h.DB
-
-	if role != domain.RoleAdmin {
-		query = query.Where("company_id = ?", companyID)
-	}
-
-	if err := query.Find(&users).Error; err != nil {
+	if err := h.db(c).Find(&users).Error; err != nil {
 		return ServerError(c, err)
 	}
 
@@ -48,6 +39,9 @@ func (h *Handler) CreateUser(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return BadRequest(c, "Dados inválidos")
 	}
+	if req.Role == domain.RoleSuperAdmin && !middleware.IsSuperAdmin(c) {
+		return Forbidden(c, "Apenas o super administrador pode conceder este perfil")
+	}
 
 	// Hash password
 	password := req.Password
@@ -56,15 +50,15 @@ func (h *Handler) CreateUser(c *fiber.Ctx) error {
 	}
 	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 
-	// Set company ID for non-admin creators
+	// Only the super admin may place a user in another company
 	companyID := req.CompanyID
 	creatorRole := middleware.GetUserRole(c)
-	if creatorRole != domain.RoleAdmin || companyID == "" {
-		// If Admin didn't provide one, or if it's not an Admin, get the companyID from token or default
+	if creatorRole != domain.RoleSuperAdmin || companyID == "" {
+		// Otherwise the company comes from the token or the default one
 		companyID = middleware.GetCompanyID(c)
 		if companyID == "" {
 			// Get default company if still empty
-			h.DB.Table("prestadores").Select("id").Limit(1).Scan(&companyID)
+			h.db(c).Table("prestadores").Select("id").Limit(1).Scan(&companyID)
 		}
 	}
 
@@ -85,7 +79,7 @@ func (h *Handler) CreateUser(c *fiber.Ctx) error {
 		user.CompanyID = &companyID
 	}
 
-	if err := h.DB.Create(&user).Error; err != nil {
+	if err := h.db(c).Create(&user).Error; err != nil {
 		return ServerError(c, err)
 	}
 
@@ -97,10 +91,10 @@ func (h *Handler) CreateUser(c *fiber.Ctx) error {
 			CompanyID:   companyID,
 			Specialties: req.Specialties,
 		}
-		h.DB.Create(&tecnico)
+		h.db(c).Create(&tecnico)
 	}
 
-	h.Hub.Broadcast("user:created", user)
+	h.broadcast(c, "user:created", user)
 
 	// Send Notifications (Email)
 	go func() {
@@ -117,7 +111,7 @@ func (h *Handler) GetUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Usuário não encontrado")
 	}
 
@@ -132,9 +126,12 @@ func (h *Handler) UpdateUser(c *fiber.Ctx) error {
 	if err := c.BodyParser(&req); err != nil {
 		return BadRequest(c, "Dados inválidos")
 	}
+	if req.Role == domain.RoleSuperAdmin && !middleware.IsSuperAdmin(c) {
+		return Forbidden(c, "Apenas o super administrador pode conceder este perfil")
+	}
 
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Usuário não encontrado")
 	}
 
@@ -144,6 +141,10 @@ func (h *Handler) UpdateUser(c *fiber.Ctx) error {
 	user.Email = req.Email
 	user.Phone = req.Phone
 	user.AvatarURL = req.AvatarURL
+	if user.Role != req.Role {
+		// A new built-in role drops the configurable one
+		user.PerfilID = nil
+	}
 	user.Role = req.Role
 
 	if req.Password != "" {
@@ -151,20 +152,24 @@ func (h *Handler) UpdateUser(c *fiber.Ctx) error {
 		user.PasswordHash = string(hashedPassword)
 	}
 
-	if err := h.DB.Save(&user).Error; err != nil {
+	if err := h.db(c).Save(&user).Error; err != nil {
 		return ServerError(c, err)
 	}
+	if req.Password != "" {
+		h.Sessions.RevokeAll(user.ID, "", domain.SessionRevokedPassword)
+	}
 
 	// Update technician if exists
 	if user.Role == domain.RoleTecnico {
 		var tecnico domain.Tecnico
-		if err := h.DB.Where("user_id = ?", user.ID).First(&tecnico).Error; err == nil {
+		if err := h.db(c).Where("user_id = ?", user.ID).First(&tecnico).Error; err == nil {
 			tecnico.Specialties = req.Specialties
-			h.DB.Save(&tecnico)
+			h.db(c).Save(&tecnico)
 		}
 	}
 
-	h.Hub.Broadcast("user:updated", user)
+	h.Permissoes.Invalidate(user.ID)
+	h.broadcast(c, "user:updated", user)
 
 	// Final Audit
 	h.LogAudit(c, "User", id, "UPDATE", fmt.Sprintf("Updated user %s", req.Email), before, user)
@@ -177,16 +182,19 @@ func (h *Handler) BlockUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Usuário não encontrado")
 	}
 
 	user.Active = !user.Active
-	if err := h.DB.Save(&user).Error; err != nil {
+	if err := h.db(c).Save(&user).Error; err != nil {
 		return ServerError(c, err)
 	}
+	if !user.Active {
+		h.Sessions.RevokeAll(user.ID, "", domain.SessionRevokedBlocked)
+	}
 
-	h.Hub.Broadcast("user:updated", user)
+	h.broadcast(c, "user:updated", user)
 
 	return Success(c, user)
 }
@@ -196,7 +204,7 @@ func (h *Handler) AdminResetPassword(c *fiber.Ctx) error {
 	id := c.Params("id")
 
 	var user domain.User
-	if err := h.DB.First(&user, "id = ?", id).Error; err != nil {
+	if err := h.db(c).First(&user, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Usuário não encontrado")
 	}
 
@@ -206,9 +214,10 @@ func (h *Handler) AdminResetPassword(c *fiber.Ctx) error {
 
 	user.PasswordHash = string(hashedPassword)
 	user.MustChangePassword = true
-	if err := h.DB.Save(&user).Error; err != nil {
+	if err := h.db(c).Save(&user).Error; err != nil {
 		return ServerError(c, err)
 	}
+	h.Sessions.RevokeAll(user.ID, "", domain.SessionRevokedPassword)
 
 	// Send email with new temporary password
 	go func() {
@@ -227,16 +236,18 @@ func (h *Handler) AdminResetPassword(c *fiber.Ctx) error {
 func (h *Handler) DeleteUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 
-	if err := h.DB.Delete(&domain.User{}, "id = ?", id).Error; err != nil {
+	if err := h.db(c).Delete(&domain.User{}, "id = ?", id).Error; err != nil {
 		return NotFound(c, "Usuário não encontrado")
 	}
 
 	// Also delete dependencies like technico
-	h.DB.Delete(&domain.Tecnico{}, "user_id = ?", id)
-	h.DB.Delete(&domain.RefreshToken{}, "user_id = ?", id)
+	h.db(c).Delete(&domain.Tecnico{}, "user_id = ?", id)
+	h.Sessions.RevokeAll(id, "", domain.SessionRevokedBlocked)
+	h.db(c).Delete(&domain.RefreshToken{}, "user_id = ?", id)
+	h.Permissoes.Invalidate(id)
 
 	// Broadcast event
-	h.Hub.Broadcast("user:deleted", fiber.Map{"id": id})
+	h.broadcast(c, "user:deleted", fiber.Map{"id": id})
 
 	return Success(c, fiber.Map{"message": "Usuário excluído permanentemente"})
 }
@@ -245,8 +256,7 @@ func (h *Handler) DeleteUser(c *fiber.Ctx) error {
 func (h *Handler) GetCompany(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
 
-	// In the new structure, we assume the Admin manages the Company profile.
-	// We'll use a generic search or the first company found if none linked specifically to userId.
+	// The admin who registered the company owns it; other users get the company of their tenant
 	var company struct {
 		ID           string `json:"id"`
 		RazaoSocial  string `json:"razaoSocial"`
@@ -256,12 +266,13 @@ func (h *Handler) GetCompany(c *fiber.Ctx) error {
 		Phone        string `json:"phone"`
 		Address      string `json:"address"`
 		LogoURL      string `json:"logoUrl"`
+
+		TecnicoSomenteAtribuidas bool `json:"tecnicoSomenteAtribuidas"`
 	}
 
-	result := h.DB.Table("prestadores").Where("user_id = ?", userID).First(&company)
+	result := h.db(c).Table("prestadores").Where("user_id = ?", userID).First(&company)
 	if result.Error != nil {
-		// Fallback to first company if this is a single-tenant setup
-		h.DB.Table("prestadores").First(&company)
+		h.db(c).Table("prestadores").Where("id = ?", middleware.GetCompanyID(c)).First(&company)
 	}
 
 	return Success(c, company)
@@ -280,6 +291,9 @@ type UpdateCompanyRequest struct {
 	PixKeyType   string                 `json:"pixKeyType"`
 	LogoURL      string                 `json:"logoUrl"`
 	Endereco     *CreateEnderecoRequest `json:"endereco,omitempty"`
+
+	// Left as is when absent; changing it takes the settings permission
+	TecnicoSomenteAtribuidas *bool `json:"tecnicoSomenteAtribuidas,omitempty"`
 }
 
 // UpdateCompany updates the company profile
@@ -292,7 +306,7 @@ func (h *Handler) UpdateCompany(c *fiber.Ctx) error {
 	}
 
 	var prestador domain.Prestador
-	err := h.DB.Where("user_id = ?", userID).First(&prestador).Error
+	err := h.db(c).Where("user_id = ? OR id = ?", userID, middleware.GetCompanyID(c)).First(&prestador).Error
 	isNew := err != nil
 
 	if isNew {
@@ -309,15 +323,21 @@ func (h *Handler) UpdateCompany(c *fiber.Ctx) error {
 	prestador.PixKey = req.PixKey
 	prestador.PixKeyType = req.PixKeyType
 	prestador.LogoURL = req.LogoURL
+	if req.TecnicoSomenteAtribuidas != nil && *req.TecnicoSomenteAtribuidas != prestador.TecnicoSomenteAtribuidas {
+		if !h.Permissoes.Has(userID, middleware.GetUserRole(c), domain.PermSettingsManage) {
+			return Forbidden(c, "Sem permissão para alterar o acesso dos técnicos")
+		}
+		prestador.TecnicoSomenteAtribuidas = *req.TecnicoSomenteAtribuidas
+	}
 
 	if isNew {
-		if err := h.DB.Create(&prestador).Error; err != nil {
+		if err := h.db(c).Create(&prestador).Error; err != nil {
 			return ServerError(c, err)
 		}
 		// Link user to company
-		h.DB.Model(&domain.User{}).Where("id = ?", userID).Update("company_id", prestador.ID)
+		h.db(c).Model(&domain.User{}).Where("id = ?", userID).Update("company_id", prestador.ID)
 	} else {
-		if err := h.DB.Save(&prestador).Error; err != nil {
+		if err := h.db(c).Save(&prestador).Error; err != nil {
 			return ServerError(c, err)
 		}
 	}
@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"errors"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+
+	"inovar/internal/domain"
 )
 
 // Claims represents JWT claims
@@ -14,11 +17,22 @@ type Claims struct {
 	Email     string `json:"email"`
 	Role      string `json:"role"`
 	CompanyID string `json:"companyId,omitempty"`
+	SessionID string `json:"sid,omitempty"`
+	// Purpose marks short-lived tokens of a sign-in step (e.g. the second factor); they never authorize the API
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// AuthRequired validates JWT tokens
-func AuthRequired(jwtSecret string) fiber.Handler {
+// ErrSessionEnded is returned for access tokens of a revoked or expired session
+var ErrSessionEnded = errors.New("sessão encerrada")
+
+// SessionChecker tells whether the session an access token belongs to is still open
+type SessionChecker interface {
+	Active(sessionID string) bool
+}
+
+// AuthRequired validates JWT tokens and rejects those of ended sessions
+func AuthRequired(jwtSecret string, sessions SessionChecker) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
@@ -27,37 +41,90 @@ func AuthRequired(jwtSecret string) fiber.Handler {
 			})
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			// Don't forget to validate the alg is what you expect:
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fiber.ErrUnauthorized
-			}
-			return []byte(jwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
+		claims, err := ParseSessionToken(strings.TrimPrefix(authHeader, "Bearer "), jwtSecret, sessions)
+		if errors.Is(err, ErrSessionEnded) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Sessão encerrada",
+			})
+		}
+		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Mismatched Token",
 			})
 		}
 
-		if claims, ok := token.Claims.(*Claims); ok {
-			c.Locals("userId", claims.UserID)
-			c.Locals("userEmail", claims.Email)
-			c.Locals("userRole", claims.Role)
-			c.Locals("companyId", claims.CompanyID)
-		}
+		c.Locals("userId", claims.UserID)
+		c.Locals("userEmail", claims.Email)
+		c.Locals("userRole", claims.Role)
+		c.Locals("companyId", claims.CompanyID)
+		c.Locals("sessionId", claims.SessionID)
 
 		return c.Next()
 	}
 }
 
-// RolesAllowed checks if user has required role
-// RolesAllowed checks if user has required role
+// ParseToken validates an access token and returns its claims
+func ParseToken(tokenString, jwtSecret string) (*Claims, error) {
+	claims, err := parseClaims(tokenString, jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// ParseSessionToken validates an access token and checks that its session is still open
+func ParseSessionToken(tokenString, jwtSecret string, sessions SessionChecker) (*Claims, error) {
+	claims, err := ParseToken(tokenString, jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID != "" && !sessions.Active(claims.SessionID) {
+		return nil, ErrSessionEnded
+	}
+	return claims, nil
+}
+
+// ParseChallengeToken validates a sign-in step token issued for the purpose
+func ParseChallengeToken(tokenString, purpose, jwtSecret string) (*Claims, error) {
+	claims, err := parseClaims(tokenString, jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+func parseClaims(tokenString, jwtSecret string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		// Don't forget to validate the alg is what you expect:
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fiber.ErrUnauthorized
+		}
+		return []byte(jwtSecret), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// RolesAllowed checks if user has required role; the super admin is always allowed
 func RolesAllowed(allowedRoles ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userRole := GetUserRole(c)
+		if userRole == domain.RoleSuperAdmin {
+			return c.Next()
+		}
 		for _, role := range allowedRoles {
 			if role == userRole {
 				return c.Next()
@@ -93,6 +160,14 @@ func GetCompanyID(c *fiber.Ctx) string {
 	return ""
 }
 
+// GetSessionID gets the session of the access token from context
+func GetSessionID(c *fiber.Ctx) string {
+	if sid := c.Locals("sessionId"); sid != nil {
+		return sid.(string)
+	}
+	return ""
+}
+
 // GetUserName gets user email/name from context
 func GetUserName(c *fiber.Ctx) string {
 	if email := c.Locals("userEmail"); email != nil {
@@ -102,12 +177,28 @@ func GetUserName(c *fiber.Ctx) string {
 }
 
 // GenerateToken creates a new JWT token
-func GenerateToken(userID, email, role, companyID, jwtSecret string, expireMinutes int) (string, error) {
+func GenerateToken(userID, email, role, companyID, sessionID, jwtSecret string, expireMinutes int) (string, error) {
 	claims := Claims{
 		UserID:    userID,
 		Email:     email,
 		Role:      role,
 		CompanyID: companyID,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expireMinutes) * time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(jwtSecret))
+}
+
+// GenerateChallengeToken creates a short-lived token for a sign-in step of the user
+func GenerateChallengeToken(userID, purpose, jwtSecret string, expireMinutes int) (string, error) {
+	claims := Claims{
+		UserID:  userID,
+		Purpose: purpose,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expireMinutes) * time.Minute)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"inovar/internal/domain"
+)
+
+// PermissionSource resolves the permissions a user holds
+type PermissionSource interface {
+	Has(userID, role, permissao string) bool
+}
+
+// Requirement is what a route demands from the user: one of the roles, when any is given, and every
+// permission
+type Requirement struct {
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissoes,omitempty"`
+}
+
+// Require checks the user against the requirement; the super admin is always allowed
+func Require(src PermissionSource, req Requirement) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if IsSuperAdmin(c) {
+			return c.Next()
+		}
+		userID, role := GetUserID(c), GetUserRole(c)
+		if len(req.Roles) > 0 && !hasRole(req.Roles, role) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Acesso negado: privilégios insuficientes",
+			})
+		}
+		for _, p := range req.Permissions {
+			if !src.Has(userID, role, p) {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"error":     "Acesso negado: privilégios insuficientes",
+					"permissao": p,
+				})
+			}
+		}
+		return c.Next()
+	}
+}
+
+// Satisfies reports whether a role holding the permissions passes the requirement
+func (r Requirement) Satisfies(role string, perms map[string]bool) bool {
+	if role == domain.RoleSuperAdmin {
+		return true
+	}
+	if len(r.Roles) > 0 && !hasRole(r.Roles, role) {
+		return false
+	}
+	for _, p := range r.Permissions {
+		if !perms[p] {
+			return false
+		}
+	}
+	return true
+}
+
+func hasRole(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
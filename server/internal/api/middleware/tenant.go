@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/database"
+)
+
+// TenantScope binds the request context to the company of the token, so every query made with
+// it only reaches that company's data. Super admins are the only ones left unscoped.
+func TenantScope() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if GetUserRole(c) == domain.RoleSuperAdmin {
+			return c.Next()
+		}
+
+		companyID := GetCompanyID(c)
+		if companyID == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Usuário sem empresa vinculada",
+			})
+		}
+		c.SetUserContext(database.WithTenant(c.UserContext(), companyID))
+		return c.Next()
+	}
+}
+
+// IsSuperAdmin reports whether the user operates across every company
+func IsSuperAdmin(c *fiber.Ctx) bool {
+	return GetUserRole(c) == domain.RoleSuperAdmin
+}
@@ -0,0 +1,16 @@
+package domain
+
+import "time"
+
+// ContratoSLA overrides the default SLA hours of a priority for a client contract
+type ContratoSLA struct {
+	ID        string    `gorm:"primaryKey;size:36" json:"id"`
+	ClientID  string    `gorm:"size:36;not null;uniqueIndex:idx_sla_client_priority" json:"clientId"`
+	CompanyID string    `gorm:"size:36;not null;index" json:"companyId"`
+	Priority  string    `gorm:"size:20;not null;uniqueIndex:idx_sla_client_priority" json:"priority"`
+	Hours     int       `gorm:"not null" json:"hours"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (ContratoSLA) TableName() string { return "contratos_sla" }
@@ -16,6 +16,12 @@ type Equipamento struct {
 	BTU          int    `gorm:"not null" json:"btu"`
 	SerialNumber string `gorm:"size:100" json:"serialNumber,omitempty"`
 	Location     string `gorm:"size:255;not null" json:"location"`
+	// Site where the unit is installed; empty means the address of the client
+	EnderecoID *string `gorm:"size:36" json:"enderecoId,omitempty"`
+
+	// Refrigerant
+	GasType        string  `gorm:"size:20" json:"gasType,omitempty"` // e.g. R410A
+	CargaNominalKg float64 `gorm:"default:0" json:"cargaNominalKg"`  // factory charge
 
 	// Maintenance Automation Fields
 	LastPreventiveDate *time.Time `json:"lastPreventiveDate"`
@@ -27,7 +33,8 @@ type Equipamento struct {
 	UpdatedAt time.Time      `json:"updatedAt"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
-	Client Cliente `gorm:"foreignKey:ClientID" json:"-"`
+	Client   Cliente   `gorm:"foreignKey:ClientID" json:"-"`
+	Endereco *Endereco `gorm:"foreignKey:EnderecoID" json:"endereco,omitempty"`
 }
 
 func (Equipamento) TableName() string { return "equipamentos" }
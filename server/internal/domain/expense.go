@@ -7,6 +7,7 @@ import (
 // Expense tracks operational costs related to services or general company expenses
 type Expense struct {
 	ID            string    `gorm:"primaryKey;size:36" json:"id"`
+	CompanyID     string    `gorm:"size:36;index" json:"companyId"`
 	SolicitacaoID *string   `gorm:"size:36;index" json:"solicitacaoId,omitempty"` // Optional: linked to a specific OS
 	Category      string    `gorm:"size:50;not null" json:"category"`             // e.g., "PART", "TRAVEL", "TOOL", "TAX"
 	Description   string    `gorm:"size:255;not null" json:"description"`
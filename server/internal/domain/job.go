@@ -0,0 +1,41 @@
+package domain
+
+import (
+	"time"
+)
+
+// Job is a unit of background work persisted so it survives restarts
+type Job struct {
+	ID               string     `gorm:"primaryKey;size:36" json:"id"`
+	Tipo             string     `gorm:"size:30;not null;index" json:"tipo"`
+	Status           string     `gorm:"size:20;not null;index" json:"status"`
+	ReferenciaID     string     `gorm:"size:36;index" json:"referenciaId"` // e.g. NotaFiscal ID
+	CompanyID        string     `gorm:"size:36;index" json:"companyId,omitempty"`
+	UserID           string     `gorm:"size:36" json:"userId,omitempty"`
+	Payload          string     `gorm:"type:text" json:"payload,omitempty"` // JSON parameters of the job
+	Tentativas       int        `json:"tentativas"`
+	MaxTentativas    int        `json:"maxTentativas"`
+	ProximaTentativa time.Time  `gorm:"index" json:"proximaTentativa"`
+	UltimoErro       string     `gorm:"type:text" json:"ultimoErro,omitempty"`
+	IniciadoEm       *time.Time `json:"iniciadoEm,omitempty"`
+	ConcluidoEm      *time.Time `json:"concluidoEm,omitempty"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+}
+
+func (Job) TableName() string { return "jobs" }
+
+// Job status constants
+const (
+	JobStatusPendente   = "PENDENTE"
+	JobStatusExecutando = "EXECUTANDO"
+	JobStatusConcluido  = "CONCLUIDO"
+	JobStatusFalhou     = "FALHOU" // dead letter: attempts exhausted or permanent error
+)
+
+// Job types
+const (
+	JobNFSeEmitir    = "NFSE_EMITIR"
+	JobNFSeCancelar  = "NFSE_CANCELAR"
+	JobNFSeConsultar = "NFSE_CONSULTAR"
+)
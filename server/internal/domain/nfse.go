@@ -13,6 +13,21 @@ type NotaFiscal struct {
 	// NFS-e Identification
 	Numero            string `gorm:"size:50" json:"numero,omitempty"`
 	CodigoVerificacao string `gorm:"size:50" json:"codigoVerificacao,omitempty"`
+	ChaveAcesso       string `gorm:"size:50;index" json:"chaveAcesso,omitempty"`
+
+	// DPS (Declaração Prévia de Serviço) that originated the note
+	SerieDPS  string `gorm:"size:5" json:"serieDps,omitempty"`
+	NumeroDPS int64  `json:"numeroDps,omitempty"`
+	Ambiente  string `gorm:"size:20" json:"ambiente,omitempty"` // PRODUCAO, HOMOLOGACAO
+
+	// Substituição: the note this one replaces and the one that replaced it
+	SubstituiID       string `gorm:"size:36;index" json:"substituiId,omitempty"`
+	SubstituidaPorID  string `gorm:"size:36;index" json:"substituidaPorId,omitempty"`
+	CodigoMotivoSubst string `gorm:"size:2" json:"codigoMotivoSubst,omitempty"`
+	MotivoSubst       string `gorm:"type:text" json:"motivoSubst,omitempty"`
+
+	// Batch emission the note was requested in
+	LoteID string `gorm:"size:36;index" json:"loteId,omitempty"`
 
 	// Client/Tomador Info
 	TomadorNome      string `gorm:"size:255;not null" json:"tomadorNome"`
@@ -32,6 +47,7 @@ type NotaFiscal struct {
 	// Taxes
 	AliquotaISS float64 `json:"aliquotaIss"`
 	ValorISS    float64 `json:"valorIss"`
+	ISSRetido   bool    `json:"issRetido"` // ISS withheld by the tomador
 	ValorPIS    float64 `json:"valorPis,omitempty"`
 	ValorCOFINS float64 `json:"valorCofins,omitempty"`
 	ValorCSLL   float64 `json:"valorCsll,omitempty"`
@@ -39,28 +55,45 @@ type NotaFiscal struct {
 	ValorINSS   float64 `json:"valorInss,omitempty"`
 
 	// Status
-	Status       string `gorm:"size:30;not null;index" json:"status"` // PENDENTE, PROCESSANDO, EMITIDA, CANCELADA, ERRO
+	Status       string `gorm:"size:30;not null;index" json:"status"` // PENDENTE, PROCESSANDO, EMITIDA, CANCELADA, SUBSTITUIDA, ERRO
 	MensagemErro string `gorm:"type:text" json:"mensagemErro,omitempty"`
 
-	// Files
-	XMLPath string `gorm:"size:500" json:"xmlPath,omitempty"`
-	PDFPath string `gorm:"size:500" json:"pdfPath,omitempty"`
+	// Files, kept in the private fiscal storage and downloaded through the API
+	XMLPath string `gorm:"size:500" json:"-"`
+	PDFPath string `gorm:"size:500" json:"-"`
 
 	// Dates
 	DataEmissao     *time.Time `json:"dataEmissao,omitempty"`
 	DataCompetencia time.Time  `json:"dataCompetencia"`
 	CreatedAt       time.Time  `json:"createdAt"`
 	UpdatedAt       time.Time  `json:"updatedAt"`
+
+	// Requests invoiced by the note; SolicitacaoID is the first of them
+	Solicitacoes []NotaFiscalSolicitacao `gorm:"foreignKey:NotaFiscalID" json:"solicitacoes,omitempty"`
 }
 
 func (NotaFiscal) TableName() string { return "notas_fiscais" }
 
+// NotaFiscalSolicitacao links a note to one of the requests it invoices; a consolidated note
+// covers several requests of the same client
+type NotaFiscalSolicitacao struct {
+	ID                string    `gorm:"primaryKey;size:36" json:"id"`
+	NotaFiscalID      string    `gorm:"size:36;not null;uniqueIndex:idx_nota_solicitacao" json:"notaFiscalId"`
+	SolicitacaoID     string    `gorm:"size:36;not null;uniqueIndex:idx_nota_solicitacao;index" json:"solicitacaoId"`
+	NumeroSolicitacao int       `json:"numeroSolicitacao"`
+	Valor             float64   `json:"valor"` // share of the request in the services value
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+func (NotaFiscalSolicitacao) TableName() string { return "notas_fiscais_solicitacoes" }
+
 // NFS-e Status constants
 const (
 	NFSeStatusPendente    = "PENDENTE"
 	NFSeStatusProcessando = "PROCESSANDO"
 	NFSeStatusEmitida     = "EMITIDA"
 	NFSeStatusCancelada   = "CANCELADA"
+	NFSeStatusSubstituida = "SUBSTITUIDA"
 	NFSeStatusErro        = "ERRO"
 )
 
@@ -70,11 +103,26 @@ type CertificadoDigital struct {
 	PrestadorID string    `gorm:"size:36;uniqueIndex;not null" json:"prestadorId"`
 	Nome        string    `gorm:"size:255;not null" json:"nome"`
 	Tipo        string    `gorm:"size:20;not null" json:"tipo"` // A1, A3
-	Validade    time.Time `json:"validade"`
-	CertPath    string    `gorm:"size:500" json:"certPath,omitempty"` // Encrypted path
-	Ativo       bool      `json:"ativo"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ValidoDesde time.Time `json:"validoDesde"`
+	Validade    time.Time `json:"validade"` // NotAfter of the certificate
+
+	// Identification read from the certificate itself
+	Titular     string `gorm:"size:255" json:"titular"`
+	CNPJ        string `gorm:"size:14" json:"cnpj"`
+	Emissor     string `gorm:"size:255" json:"emissor"`
+	NumeroSerie string `gorm:"size:64" json:"numeroSerie"`
+	Impressao   string `gorm:"size:64" json:"impressao"` // SHA-256 fingerprint
+
+	// The PKCS#12 bundle and its password are encrypted with the master key ChaveID
+	CertPath string `gorm:"size:500" json:"-"`
+	Senha    string `gorm:"size:255" json:"-"`
+	ChaveID  string `gorm:"size:50" json:"-"`
+
+	AvisoExpiracaoDias *int `json:"-"` // last expiry warning stage sent (days before expiry, 0 = expired)
+
+	Ativo     bool      `json:"ativo"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 func (CertificadoDigital) TableName() string { return "certificados_digitais" }
@@ -96,9 +144,12 @@ type ConfiguracaoFiscal struct {
 	TipoCNPJ         string `gorm:"size:30" json:"tipoCNPJ"`         // MEI, SIMPLES, LUCRO_PRESUMIDO, LUCRO_REAL
 
 	// Simples Nacional
-	OptanteSimplesNac  bool    `json:"optanteSimplesNac"`
-	FaixaSimplesNac    string  `gorm:"size:20" json:"faixaSimplesNac,omitempty"` // FAIXA_1, FAIXA_2, etc
-	AliquotaSimplesNac float64 `json:"aliquotaSimplesNac,omitempty"`             // % total Simples
+	OptanteSimplesNac   bool    `json:"optanteSimplesNac"`
+	FaixaSimplesNac     string  `gorm:"size:20" json:"faixaSimplesNac,omitempty"`   // FAIXA_1, FAIXA_2, etc
+	AliquotaSimplesNac  float64 `json:"aliquotaSimplesNac,omitempty"`               // % total Simples
+	ReceitaBruta12Meses float64 `json:"receitaBruta12Meses,omitempty"`              // RBT12 used for the effective rate
+	RBT12Competencia    string  `gorm:"size:7" json:"rbt12Competencia,omitempty"`   // YYYY-MM of the last automatic RBT12
+	AlertaTetoReceita   string  `gorm:"size:30" json:"alertaTetoReceita,omitempty"` // last revenue ceiling alert sent
 
 	// MEI
 	IsMEI bool `json:"isMei"`
@@ -112,6 +163,10 @@ type ConfiguracaoFiscal struct {
 	LocalPrestacao    string  `gorm:"size:20" json:"localPrestacao"`   // LOCAL, FORA_MUNICIPIO
 	NaturezaOperacao  string  `gorm:"size:50" json:"naturezaOperacao"` // See NaturezaOperacao constants
 
+	// Days after emission the municipality accepts a cancellation event; 0 uses the default.
+	// Later the note can only be replaced by a substitute.
+	PrazoCancelamentoDias int `json:"prazoCancelamentoDias,omitempty"`
+
 	// Other taxes (for non-Simples)
 	AliquotaPIS    float64 `json:"aliquotaPIS,omitempty"`    // 0.65% or 1.65%
 	AliquotaCOFINS float64 `json:"aliquotaCOFINS,omitempty"` // 3% or 7.6%
@@ -138,6 +193,19 @@ type ConfiguracaoFiscal struct {
 
 func (ConfiguracaoFiscal) TableName() string { return "configuracoes_fiscais" }
 
+// ReceitaMensal is the gross revenue of a month the system holds no notes for (e.g. before the
+// company started issuing here), informed by the company so the RBT12 covers the whole window
+type ReceitaMensal struct {
+	ID          string    `gorm:"primaryKey;size:36" json:"id"`
+	PrestadorID string    `gorm:"size:36;not null;uniqueIndex:idx_receita_mensal_comp" json:"prestadorId"`
+	Competencia string    `gorm:"size:7;not null;uniqueIndex:idx_receita_mensal_comp" json:"competencia"` // YYYY-MM
+	Valor       float64   `gorm:"not null" json:"valor"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func (ReceitaMensal) TableName() string { return "receitas_mensais" }
+
 // Regime Tributário constants
 const (
 	RegimeMEI            = "MEI"
@@ -200,3 +268,73 @@ const (
 	MotivoCancelDuplicidade       = "3" // Duplicidade
 	MotivoCancelErroPreenchimento = "4" // Erro de preenchimento
 )
+
+// Motivos de Substituição (leiaute NFS-e Nacional)
+const (
+	MotivoSubstDesenquadramentoSN = "01" // Desenquadramento do Simples Nacional
+	MotivoSubstEnquadramentoSN    = "02" // Enquadramento no Simples Nacional
+	MotivoSubstInclusaoImunidade  = "03" // Inclusão retroativa de imunidade/isenção
+	MotivoSubstExclusaoImunidade  = "04" // Exclusão retroativa de imunidade/isenção
+	MotivoSubstRejeicaoTomador    = "05" // Rejeição pelo tomador ou intermediário
+	MotivoSubstOutros             = "99" // Outros
+)
+
+// SerieDPS is the numbering counter of a DPS series of a provider
+type SerieDPS struct {
+	ID           string    `gorm:"primaryKey;size:36" json:"id"`
+	PrestadorID  string    `gorm:"size:36;not null;uniqueIndex:idx_serie_dps" json:"prestadorId"`
+	Serie        string    `gorm:"size:5;not null;uniqueIndex:idx_serie_dps" json:"serie"`
+	UltimoNumero int64     `gorm:"not null;default:0" json:"ultimoNumero"`
+	Padrao       bool      `json:"padrao"` // series used for new notes
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func (SerieDPS) TableName() string { return "series_dps" }
+
+// ReservaDPS records which note holds a DPS number of a series
+type ReservaDPS struct {
+	ID           string    `gorm:"primaryKey;size:36" json:"id"`
+	PrestadorID  string    `gorm:"size:36;not null;uniqueIndex:idx_reserva_dps" json:"prestadorId"`
+	Serie        string    `gorm:"size:5;not null;uniqueIndex:idx_reserva_dps" json:"serie"`
+	Numero       int64     `gorm:"not null;uniqueIndex:idx_reserva_dps" json:"numero"`
+	NotaFiscalID string    `gorm:"size:36;index" json:"notaFiscalId,omitempty"`
+	Status       string    `gorm:"size:20;not null;index" json:"status"` // RESERVADO, UTILIZADO, LIBERADO
+	Motivo       string    `gorm:"type:text" json:"motivo,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func (ReservaDPS) TableName() string { return "reservas_dps" }
+
+// DPS number reservation status
+const (
+	ReservaDPSReservado = "RESERVADO" // held by a note not yet authorized
+	ReservaDPSUtilizado = "UTILIZADO" // authorized by the SEFIN
+	ReservaDPSLiberado  = "LIBERADO"  // rejected; reused by the next note
+)
+
+// LoteNFSe is the emission of the notes of several completed requests at once
+type LoteNFSe struct {
+	ID          string     `gorm:"primaryKey;size:36" json:"id"`
+	PrestadorID string     `gorm:"size:36;index;not null" json:"prestadorId"`
+	UserID      string     `gorm:"size:36" json:"userId"`
+	Filtro      string     `gorm:"type:text" json:"filtro,omitempty"`    // JSON filter the requests were selected with
+	Ignoradas   string     `gorm:"type:text" json:"ignoradas,omitempty"` // JSON list of requests left out and why
+	Status      string     `gorm:"size:20;not null;index" json:"status"` // PROCESSANDO, CONCLUIDO
+	Total       int        `json:"total"`                                // notes queued
+	Emitidas    int        `json:"emitidas"`
+	Rejeitadas  int        `json:"rejeitadas"`
+	ValorTotal  float64    `json:"valorTotal"`
+	ConcluidoEm *time.Time `json:"concluidoEm,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+func (LoteNFSe) TableName() string { return "lotes_nfse" }
+
+// LoteNFSe status constants
+const (
+	LoteNFSeProcessando = "PROCESSANDO"
+	LoteNFSeConcluido   = "CONCLUIDO"
+)
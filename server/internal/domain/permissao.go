@@ -0,0 +1,165 @@
+package domain
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Permissions checked by the API routes
+const (
+	PermUsersManage        = "users.manage"
+	PermRolesManage        = "roles.manage"
+	PermClientsCreate      = "clients.create"
+	PermClientsUpdate      = "clients.update"
+	PermClientsDelete      = "clients.delete"
+	PermSLAView            = "sla.view"
+	PermSLAManage          = "sla.manage"
+	PermPMOCManage         = "pmoc.manage"
+	PermEquipmentsManage   = "equipments.manage"
+	PermEquipmentsDelete   = "equipments.delete"
+	PermRefrigerantManage  = "refrigerant.manage"
+	PermRequestsUpdate     = "requests.update"
+	PermRequestsTransition = "requests.transition"
+	PermRequestsAssign     = "requests.assign"
+	PermRequestsDelete     = "requests.delete"
+	PermRequestsUnlock     = "requests.unlock"
+	PermChecklistsManage   = "checklists.manage"
+	PermAttachmentsDel     = "attachments.delete"
+	PermPreventiveManage   = "preventive.manage"
+	PermNFSeIssue          = "nfse.issue"
+	PermNFSeCancel         = "nfse.cancel"
+	PermNFSeBatch          = "nfse.batch"
+	PermFiscalView         = "fiscal.view"
+	PermFiscalConfig       = "fiscal.config"
+	PermFiscalSeries       = "fiscal.series"
+	PermFiscalRBT12        = "fiscal.rbt12"
+	PermAgendaManage       = "agenda.manage"
+	PermFinanceView        = "finance.view"
+	PermAuditView          = "audit.view"
+	PermSettingsManage     = "settings.manage"
+	PermNotificationsAdmin = "notifications.admin"
+	PermSystemView         = "system.view"
+)
+
+// Permissao describes a permission in the role editor
+type Permissao struct {
+	Codigo    string `json:"codigo"`
+	Grupo     string `json:"grupo"`
+	Descricao string `json:"descricao"`
+}
+
+// Permissoes is the catalog of permissions, in the order the role editor shows them
+var Permissoes = []Permissao{
+	{PermUsersManage, "Usuários", "Cadastrar, editar e bloquear usuários"},
+	{PermRolesManage, "Usuários", "Gerenciar perfis de acesso e atribuí-los"},
+	{PermClientsCreate, "Clientes", "Cadastrar clientes"},
+	{PermClientsUpdate, "Clientes", "Editar e bloquear clientes"},
+	{PermClientsDelete, "Clientes", "Excluir clientes"},
+	{PermSLAView, "Clientes", "Consultar contratos de SLA"},
+	{PermSLAManage, "Clientes", "Alterar contratos de SLA"},
+	{PermPMOCManage, "Clientes", "Manter planos PMOC e registrar execuções"},
+	{PermEquipmentsManage, "Equipamentos", "Cadastrar, editar e desativar equipamentos"},
+	{PermEquipmentsDelete, "Equipamentos", "Excluir equipamentos"},
+	{PermRefrigerantManage, "Equipamentos", "Controle de gás refrigerante e relatório anual"},
+	{PermRequestsUpdate, "Chamados", "Editar os dados dos chamados"},
+	{PermRequestsTransition, "Chamados", "Mover chamados por todas as etapas do fluxo, inclusive cancelar em andamento"},
+	{PermRequestsAssign, "Chamados", "Atribuir técnicos e alterar prioridade"},
+	{PermRequestsDelete, "Chamados", "Excluir chamados"},
+	{PermRequestsUnlock, "Chamados", "Remover bloqueio de edição de outro usuário"},
+	{PermChecklistsManage, "Chamados", "Manter checklists dos chamados"},
+	{PermAttachmentsDel, "Chamados", "Excluir anexos"},
+	{PermPreventiveManage, "Chamados", "Gerar chamados de preventiva"},
+	{PermAgendaManage, "Chamados", "Manter a agenda dos técnicos"},
+	{PermNFSeIssue, "Fiscal", "Emitir NFS-e"},
+	{PermNFSeCancel, "Fiscal", "Cancelar e substituir NFS-e"},
+	{PermNFSeBatch, "Fiscal", "Emitir NFS-e em lote e consolidadas"},
+	{PermFiscalView, "Fiscal", "Consultar configuração fiscal, notas, livro e filas"},
+	{PermFiscalConfig, "Fiscal", "Alterar configuração fiscal e certificado digital"},
+	{PermFiscalSeries, "Fiscal", "Manter séries da DPS"},
+	{PermFiscalRBT12, "Fiscal", "Apurar a receita bruta (RBT12)"},
+	{PermFinanceView, "Financeiro", "Resumo financeiro e exportações"},
+	{PermAuditView, "Sistema", "Consultar a auditoria"},
+	{PermSettingsManage, "Sistema", "Alterar configurações do sistema"},
+	{PermNotificationsAdmin, "Sistema", "Receber avisos da empresa: SLA, certificado digital, faturamento e gás"},
+	{PermSystemView, "Sistema", "Visão de rotas e permissões"},
+}
+
+// PermissoesPadrao are the permissions of the built-in roles
+var PermissoesPadrao = map[string][]string{
+	RoleAdmin: todasPermissoes(),
+	RoleTecnico: {
+		PermClientsCreate, PermClientsUpdate, PermClientsDelete, PermSLAView, PermPMOCManage,
+		PermEquipmentsManage, PermEquipmentsDelete, PermRefrigerantManage, PermRequestsUpdate,
+		PermRequestsAssign, PermRequestsDelete,
+		PermChecklistsManage, PermAttachmentsDel, PermPreventiveManage, PermAgendaManage,
+		PermNFSeIssue, PermNFSeCancel, PermFiscalView, PermFiscalConfig, PermFinanceView,
+	},
+	RoleCliente: {},
+}
+
+func todasPermissoes() []string {
+	all := make([]string, len(Permissoes))
+	for i, p := range Permissoes {
+		all[i] = p.Codigo
+	}
+	return all
+}
+
+// PermissaoValida reports whether the code is in the catalog
+func PermissaoValida(codigo string) bool {
+	for _, p := range Permissoes {
+		if p.Codigo == codigo {
+			return true
+		}
+	}
+	return false
+}
+
+// Perfil is a configurable role of a company: a named set of permissions over a base role.
+// The base role still decides what data the user sees; what they may do comes from the permissions.
+type Perfil struct {
+	ID         string         `gorm:"primaryKey;size:36" json:"id"`
+	CompanyID  string         `gorm:"size:36;not null;uniqueIndex:idx_perfil_nome" json:"companyId"`
+	Nome       string         `gorm:"size:100;not null;uniqueIndex:idx_perfil_nome" json:"nome"`
+	Descricao  string         `gorm:"size:255" json:"descricao,omitempty"`
+	Base       string         `gorm:"size:50;not null" json:"base"`
+	Permissoes []string       `gorm:"serializer:json;type:text" json:"permissoes"`
+	CreatedAt  time.Time      `json:"createdAt"`
+	UpdatedAt  time.Time      `json:"updatedAt"`
+	DeletedAt  gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (Perfil) TableName() string { return "perfis" }
+
+// ModeloPerfil is a suggested starting point for a configurable role
+type ModeloPerfil struct {
+	Nome       string   `json:"nome"`
+	Descricao  string   `json:"descricao"`
+	Base       string   `json:"base"`
+	Permissoes []string `json:"permissoes"`
+}
+
+// ModelosPerfil are the roles most companies ask for
+var ModelosPerfil = []ModeloPerfil{
+	{
+		Nome:      "Financeiro",
+		Descricao: "Faturamento, notas fiscais e relatórios financeiros",
+		Base:      RoleAdmin,
+		Permissoes: []string{
+			PermSLAView, PermNFSeIssue, PermNFSeCancel, PermNFSeBatch, PermFiscalView,
+			PermFiscalRBT12, PermFinanceView, PermAuditView, PermNotificationsAdmin,
+		},
+	},
+	{
+		Nome:      "Supervisor de técnicos",
+		Descricao: "Distribui e acompanha os chamados da equipe técnica",
+		Base:      RoleAdmin,
+		Permissoes: []string{
+			PermClientsCreate, PermClientsUpdate, PermSLAView, PermPMOCManage, PermEquipmentsManage,
+			PermRefrigerantManage, PermRequestsUpdate, PermRequestsTransition, PermRequestsAssign,
+			PermRequestsUnlock, PermChecklistsManage, PermAttachmentsDel, PermPreventiveManage,
+			PermAgendaManage, PermNotificationsAdmin,
+		},
+	},
+}
@@ -0,0 +1,107 @@
+package domain
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PMOC activity frequencies
+const (
+	PMOCMensal     = "MENSAL"
+	PMOCTrimestral = "TRIMESTRAL"
+	PMOCSemestral  = "SEMESTRAL"
+	PMOCAnual      = "ANUAL"
+)
+
+// PMOCFrequencyMonths is the period of each frequency in months
+var PMOCFrequencyMonths = map[string]int{
+	PMOCMensal:     1,
+	PMOCTrimestral: 3,
+	PMOCSemestral:  6,
+	PMOCAnual:      12,
+}
+
+// PMOCPlano is the Plano de Manutenção, Operação e Controle of a client (Lei 13.589/2018)
+type PMOCPlano struct {
+	ID        string `gorm:"primaryKey;size:36" json:"id"`
+	ClientID  string `gorm:"size:36;not null;uniqueIndex:idx_pmoc_planos_client_ativo,where:deleted_at IS NULL" json:"clientId"` // a deleted plan does not block a new one
+	CompanyID string `gorm:"size:36;not null;index" json:"companyId"`
+
+	// Responsável técnico
+	ResponsavelID       string `gorm:"size:36" json:"responsavelId,omitempty"`
+	ResponsavelNome     string `gorm:"size:255;not null" json:"responsavelNome"`
+	ResponsavelRegistro string `gorm:"size:50;not null" json:"responsavelRegistro"` // CREA/CFT
+	ResponsavelART      string `gorm:"size:50" json:"responsavelArt,omitempty"`
+
+	// Building data required in the plan
+	Ambiente  string  `gorm:"size:255" json:"ambiente,omitempty"` // e.g. "Escritório - 2º andar"
+	AreaM2    float64 `json:"areaM2,omitempty"`
+	Ocupantes int     `json:"ocupantes,omitempty"`
+
+	VigenciaInicio time.Time  `gorm:"not null" json:"vigenciaInicio"`
+	VigenciaFim    *time.Time `json:"vigenciaFim,omitempty"`
+	Observacoes    string     `gorm:"type:text" json:"observacoes,omitempty"`
+	Active         bool       `gorm:"default:true" json:"active"`
+
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+
+	Client     Cliente         `gorm:"foreignKey:ClientID" json:"-"`
+	Atividades []PMOCAtividade `gorm:"foreignKey:PlanoID" json:"atividades,omitempty"`
+}
+
+func (PMOCPlano) TableName() string { return "pmoc_planos" }
+
+// PMOCAtividade is a periodic activity of the plan. A nil EquipamentoID applies to every unit.
+type PMOCAtividade struct {
+	ID            string    `gorm:"primaryKey;size:36" json:"id"`
+	PlanoID       string    `gorm:"size:36;not null;index" json:"planoId"`
+	EquipamentoID *string   `gorm:"size:36;index" json:"equipamentoId,omitempty"`
+	Descricao     string    `gorm:"size:255;not null" json:"descricao"`
+	Frequencia    string    `gorm:"size:20;not null" json:"frequencia"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func (PMOCAtividade) TableName() string { return "pmoc_atividades" }
+
+// PMOCExecucao records an activity performed on a unit
+type PMOCExecucao struct {
+	ID            string    `gorm:"primaryKey;size:36" json:"id"`
+	PlanoID       string    `gorm:"size:36;not null;index" json:"planoId"`
+	AtividadeID   string    `gorm:"size:36;not null;index" json:"atividadeId"`
+	EquipamentoID string    `gorm:"size:36;not null;index" json:"equipamentoId"`
+	SolicitacaoID *string   `gorm:"size:36;index" json:"solicitacaoId,omitempty"`
+	ExecutadoEm   time.Time `gorm:"not null;index" json:"executadoEm"`
+	TecnicoID     string    `gorm:"size:36" json:"tecnicoId,omitempty"`
+	TecnicoNome   string    `gorm:"size:255" json:"tecnicoNome"`
+	Observacao    string    `gorm:"type:text" json:"observacao,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+
+	Atividade   PMOCAtividade `gorm:"foreignKey:AtividadeID" json:"atividade,omitempty"`
+	Equipamento Equipamento   `gorm:"foreignKey:EquipamentoID" json:"equipamento,omitempty"`
+}
+
+func (PMOCExecucao) TableName() string { return "pmoc_execucoes" }
+
+// PMOCAtividadePadrao is a template activity used when a plan is created without activities
+type PMOCAtividadePadrao struct {
+	Descricao  string
+	Frequencia string
+}
+
+// PMOCAtividadesPadrao follows the minimum routine of ANVISA RE 09/2003 for split systems
+var PMOCAtividadesPadrao = []PMOCAtividadePadrao{
+	{"Limpeza dos filtros de ar", PMOCMensal},
+	{"Verificação e desobstrução do dreno e bandeja", PMOCMensal},
+	{"Verificação de ruídos e vibrações anormais", PMOCMensal},
+	{"Medição de temperatura de insuflamento e retorno", PMOCMensal},
+	{"Limpeza da serpentina e gabinete da evaporadora", PMOCTrimestral},
+	{"Verificação e reaperto de conexões elétricas", PMOCTrimestral},
+	{"Medição de corrente e tensão do compressor", PMOCTrimestral},
+	{"Limpeza da condensadora", PMOCSemestral},
+	{"Verificação de pressões e carga de fluido refrigerante", PMOCSemestral},
+	{"Verificação do isolamento térmico das tubulações", PMOCSemestral},
+	{"Higienização completa com bactericida", PMOCAnual},
+}
@@ -27,6 +27,9 @@ type Prestador struct {
 	PixKey      string `gorm:"size:255" json:"pixKey,omitempty"`
 	PixKeyType  string `gorm:"size:50" json:"pixKeyType,omitempty"`
 
+	// Technicians only reach the requests assigned to them
+	TecnicoSomenteAtribuidas bool `gorm:"not null;default:false" json:"tecnicoSomenteAtribuidas"`
+
 	CreatedAt time.Time      `json:"createdAt"`
 	UpdatedAt time.Time      `json:"updatedAt"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
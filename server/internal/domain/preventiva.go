@@ -0,0 +1,17 @@
+package domain
+
+// ServiceTypePreventiva is the service type of preventive maintenance requests, as offered by the client app
+const ServiceTypePreventiva = "Manutenção Preventiva"
+
+// ChecklistPreventivaPadrao is the standard checklist attached to each unit of a preventive request
+var ChecklistPreventivaPadrao = []string{
+	"Limpeza dos filtros de ar",
+	"Limpeza da serpentina da evaporadora",
+	"Limpeza da condensadora",
+	"Verificação e desobstrução do dreno",
+	"Verificação e reaperto de conexões elétricas",
+	"Medição de pressão do gás refrigerante",
+	"Medição de corrente do compressor",
+	"Medição de temperatura de insuflamento e retorno",
+	"Verificação de ruídos e vibrações",
+}
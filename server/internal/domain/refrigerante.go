@@ -0,0 +1,70 @@
+package domain
+
+import "time"
+
+// Refrigerant movement types
+const (
+	GasCarga          = "CARGA"           // gas added to the unit
+	GasRecolhimento   = "RECOLHIMENTO"    // gas recovered from the unit
+	GasTesteVazamento = "TESTE_VAZAMENTO" // leak test without gas movement
+)
+
+// Leak test results
+const (
+	TesteNaoRealizado = "NAO_REALIZADO"
+	TesteAprovado     = "APROVADO"
+	TesteReprovado    = "REPROVADO"
+)
+
+// GasGWP is the global warming potential (AR4, 100 years) of the refrigerants we handle,
+// used to report emissions in tCO2e
+var GasGWP = map[string]float64{
+	"R22":   1810,
+	"R32":   675,
+	"R134A": 1430,
+	"R404A": 3922,
+	"R407C": 1774,
+	"R410A": 2088,
+	"R600A": 3,
+	"R290":  3,
+}
+
+// MovimentacaoGas is an entry of the refrigerant ledger of a unit
+type MovimentacaoGas struct {
+	ID            string  `gorm:"primaryKey;size:36" json:"id"`
+	EquipamentoID string  `gorm:"size:36;not null;index" json:"equipamentoId"`
+	SolicitacaoID *string `gorm:"size:36;index" json:"solicitacaoId,omitempty"`
+	ClientID      string  `gorm:"size:36;not null;index" json:"clientId"`
+	CompanyID     string  `gorm:"size:36;not null;index" json:"companyId"`
+
+	Tipo           string  `gorm:"size:20;not null" json:"tipo"`
+	GasType        string  `gorm:"size:20;not null;index" json:"gasType"`
+	QuantidadeKg   float64 `json:"quantidadeKg"`
+	LoteCilindro   string  `gorm:"size:100" json:"loteCilindro,omitempty"`
+	TesteVazamento string  `gorm:"size:20;default:'NAO_REALIZADO'" json:"testeVazamento"`
+	MetodoTeste    string  `gorm:"size:100" json:"metodoTeste,omitempty"` // e.g. detector eletrônico, nitrogênio
+	Observacao     string  `gorm:"type:text" json:"observacao,omitempty"`
+
+	TecnicoID   string    `gorm:"size:36" json:"tecnicoId"`
+	TecnicoNome string    `gorm:"size:255" json:"tecnicoNome"`
+	RealizadoEm time.Time `gorm:"not null;index" json:"realizadoEm"`
+	CreatedAt   time.Time `json:"createdAt"`
+
+	Equipamento Equipamento `gorm:"foreignKey:EquipamentoID" json:"-"`
+}
+
+func (MovimentacaoGas) TableName() string { return "movimentacoes_gas" }
+
+// NormalizeGasType upper-cases a refrigerant name and drops separators ("r-410a" -> "R410A")
+func NormalizeGasType(s string) string {
+	out := make([]rune, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			out = append(out, r-'a'+'A')
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			out = append(out, r)
+		}
+	}
+	return string(out)
+}
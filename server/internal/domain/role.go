@@ -2,7 +2,10 @@ package domain
 
 // Role constants
 const (
-	RoleAdmin     = "ADMIN_SISTEMA"
-	RoleTecnico   = "TECNICO"
-	RoleCliente   = "CLIENTE"
+	RoleAdmin   = "ADMIN_SISTEMA"
+	RoleTecnico = "TECNICO"
+	RoleCliente = "CLIENTE"
+
+	// RoleSuperAdmin operates across every company; its queries are not scoped to a tenant
+	RoleSuperAdmin = "SUPER_ADMIN"
 )
@@ -41,6 +41,7 @@ type Solicitacao struct {
 	ResponsibleName string     `gorm:"size:255" json:"responsibleName,omitempty"`
 	ScheduledAt     *time.Time `json:"scheduledAt,omitempty"`
 	SLALimit        time.Time  `gorm:"not null" json:"slaLimit"`
+	SLAHours        int        `gorm:"default:0" json:"slaHours,omitempty"`
 	ConfirmedAt     *time.Time `json:"confirmedAt,omitempty"`
 	ConfirmedBy     *string    `gorm:"size:36" json:"confirmedBy,omitempty"`
 	Observation     string     `gorm:"type:text" json:"observation,omitempty"`
@@ -56,6 +57,12 @@ type Solicitacao struct {
 	MaterialsUsed     string     `gorm:"type:text" json:"materialsUsed,omitempty"`
 	NextMaintenanceAt *time.Time `json:"nextMaintenanceAt,omitempty"`
 
+	// SLA clock (paused time is measured in business minutes)
+	SLAPausedAt      *time.Time `json:"slaPausedAt,omitempty"`
+	SLAPausedMinutes int        `gorm:"default:0" json:"slaPausedMinutes,omitempty"`
+	SLAWarnedAt      *time.Time `json:"slaWarnedAt,omitempty"`
+	SLABreachedAt    *time.Time `json:"slaBreachedAt,omitempty"`
+
 	CreatedAt time.Time      `json:"createdAt"`
 	UpdatedAt time.Time      `json:"updatedAt"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
@@ -0,0 +1,159 @@
+package domain
+
+import "fmt"
+
+// Transition error codes
+const (
+	TransitionInvalid      = "invalid_transition"
+	TransitionForbidden    = "transition_forbidden"
+	TransitionMissingField = "transition_missing_field"
+)
+
+// TransitionRule declares a legal status change and who may trigger it
+type TransitionRule struct {
+	From               string
+	To                 string
+	Roles              []string
+	RequireObservation bool // e.g. reason for pausing
+	RequireSignature   bool // service must be signed before finishing
+}
+
+// StatusTransitions is the workflow of a Solicitacao
+var StatusTransitions = []TransitionRule{
+	{From: StatusAberta, To: StatusAtribuida, Roles: []string{RoleAdmin, RoleTecnico}},
+	{From: StatusAberta, To: StatusAgendada, Roles: []string{RoleAdmin, RoleTecnico}},
+	{From: StatusAberta, To: StatusCancelada, Roles: []string{RoleAdmin, RoleTecnico, RoleCliente}},
+
+	{From: StatusAtribuida, To: StatusAgendada, Roles: []string{RoleAdmin, RoleTecnico}},
+	{From: StatusAtribuida, To: StatusEmAndamento, Roles: []string{RoleAdmin, RoleTecnico}},
+	{From: StatusAtribuida, To: StatusAberta, Roles: []string{RoleAdmin, RoleTecnico}},
+	{From: StatusAtribuida, To: StatusCancelada, Roles: []string{RoleAdmin, RoleTecnico}},
+
+	{From: StatusAgendada, To: StatusEmAndamento, Roles: []string{RoleAdmin, RoleTecnico}},
+	{From: StatusAgendada, To: StatusAberta, Roles: []string{RoleAdmin, RoleTecnico}},
+	{From: StatusAgendada, To: StatusCancelada, Roles: []string{RoleAdmin, RoleTecnico, RoleCliente}},
+
+	{From: StatusEmAndamento, To: StatusPausada, Roles: []string{RoleAdmin, RoleTecnico}, RequireObservation: true},
+	{From: StatusEmAndamento, To: StatusFinalizada, Roles: []string{RoleAdmin, RoleTecnico}, RequireSignature: true},
+	{From: StatusEmAndamento, To: StatusCancelada, Roles: []string{RoleAdmin}, RequireObservation: true},
+
+	{From: StatusPausada, To: StatusEmAndamento, Roles: []string{RoleAdmin, RoleTecnico}},
+	{From: StatusPausada, To: StatusCancelada, Roles: []string{RoleAdmin}, RequireObservation: true},
+
+	{From: StatusFinalizada, To: StatusConcluida, Roles: []string{RoleAdmin, RoleCliente}},
+	{From: StatusFinalizada, To: StatusEmAndamento, Roles: []string{RoleAdmin, RoleTecnico, RoleCliente}, RequireObservation: true},
+}
+
+// TransitionInput carries the data a transition may require
+type TransitionInput struct {
+	Role        string // as given by WorkflowRole
+	Observation string
+}
+
+// TransitionError describes a rejected status change
+type TransitionError struct {
+	Code    string   `json:"error"`
+	Message string   `json:"message"`
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	Allowed []string `json:"allowed"`
+}
+
+func (e *TransitionError) Error() string { return e.Message }
+
+// IsValidStatus reports whether s is a known Solicitacao status
+func IsValidStatus(s string) bool {
+	switch s {
+	case StatusAberta, StatusAtribuida, StatusAgendada, StatusEmAndamento,
+		StatusPausada, StatusFinalizada, StatusConcluida, StatusCancelada:
+		return true
+	}
+	return false
+}
+
+// WorkflowRole is the role whose transitions a user follows: the whole workflow for whoever holds
+// PermRequestsTransition, their own steps for technicians and clients, none for anyone else
+func WorkflowRole(role string, perms map[string]bool) string {
+	switch {
+	case perms[PermRequestsTransition]:
+		return RoleAdmin
+	case role == RoleTecnico, role == RoleCliente:
+		return role
+	}
+	return ""
+}
+
+// AllowedTransitions lists the statuses the given workflow role may move a request to from the current one
+func AllowedTransitions(from, role string) []string {
+	allowed := []string{}
+	for _, rule := range StatusTransitions {
+		if rule.From == from && rule.allows(role) {
+			allowed = append(allowed, rule.To)
+		}
+	}
+	return allowed
+}
+
+func findTransition(from, to string) *TransitionRule {
+	for i := range StatusTransitions {
+		if StatusTransitions[i].From == from && StatusTransitions[i].To == to {
+			return &StatusTransitions[i]
+		}
+	}
+	return nil
+}
+
+func (r TransitionRule) allows(role string) bool {
+	if role == RoleSuperAdmin {
+		role = RoleAdmin
+	}
+	for _, allowed := range r.Roles {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTransition validates a status change without applying it.
+// Moving to the current status is a no-op and always accepted.
+func (s *Solicitacao) CheckTransition(to string, in TransitionInput) error {
+	from := s.Status
+	if from == to {
+		return nil
+	}
+
+	newErr := func(code, msg string) *TransitionError {
+		return &TransitionError{Code: code, Message: msg, From: from, To: to, Allowed: AllowedTransitions(from, in.Role)}
+	}
+
+	if !IsValidStatus(to) {
+		return newErr(TransitionInvalid, fmt.Sprintf("Status desconhecido: %s", to))
+	}
+
+	rule := findTransition(from, to)
+	if rule == nil {
+		return newErr(TransitionInvalid, fmt.Sprintf("Transição de %s para %s não permitida", from, to))
+	}
+	if !rule.allows(in.Role) {
+		return newErr(TransitionForbidden, fmt.Sprintf("Sem permissão para mover o chamado de %s para %s", from, to))
+	}
+	if rule.RequireObservation && in.Observation == "" {
+		return newErr(TransitionMissingField, fmt.Sprintf("Observação obrigatória para mover o chamado para %s", to))
+	}
+	if rule.RequireSignature && s.AssinaturaCliente == "" && s.AssinaturaTecnico == "" {
+		return newErr(TransitionMissingField, fmt.Sprintf("Assinatura obrigatória antes de mover o chamado para %s", to))
+	}
+
+	return nil
+}
+
+// Transition validates and applies a status change; the observation belongs to the history entry
+// recorded by the caller and leaves the request observation untouched
+func (s *Solicitacao) Transition(to string, in TransitionInput) error {
+	if err := s.CheckTransition(to, in); err != nil {
+		return err
+	}
+	s.Status = to
+	return nil
+}
@@ -0,0 +1,226 @@
+package domain
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+var statuses = []string{StatusAberta, StatusAtribuida, StatusAgendada, StatusEmAndamento,
+	StatusPausada, StatusFinalizada, StatusConcluida, StatusCancelada}
+
+// workflow is the expected workflow: who may move a request from one status to another
+var workflow = map[string]map[string][]string{
+	StatusAberta: {
+		StatusAtribuida: {RoleAdmin, RoleTecnico},
+		StatusAgendada:  {RoleAdmin, RoleTecnico},
+		StatusCancelada: {RoleAdmin, RoleTecnico, RoleCliente},
+	},
+	StatusAtribuida: {
+		StatusAgendada:    {RoleAdmin, RoleTecnico},
+		StatusEmAndamento: {RoleAdmin, RoleTecnico},
+		StatusAberta:      {RoleAdmin, RoleTecnico},
+		StatusCancelada:   {RoleAdmin, RoleTecnico},
+	},
+	StatusAgendada: {
+		StatusEmAndamento: {RoleAdmin, RoleTecnico},
+		StatusAberta:      {RoleAdmin, RoleTecnico},
+		StatusCancelada:   {RoleAdmin, RoleTecnico, RoleCliente},
+	},
+	StatusEmAndamento: {
+		StatusPausada:    {RoleAdmin, RoleTecnico},
+		StatusFinalizada: {RoleAdmin, RoleTecnico},
+		StatusCancelada:  {RoleAdmin},
+	},
+	StatusPausada: {
+		StatusEmAndamento: {RoleAdmin, RoleTecnico},
+		StatusCancelada:   {RoleAdmin},
+	},
+	StatusFinalizada: {
+		StatusConcluida:   {RoleAdmin, RoleCliente},
+		StatusEmAndamento: {RoleAdmin, RoleTecnico, RoleCliente},
+	},
+}
+
+// ready returns a request in the status with everything a transition may ask for
+func ready(status string) *Solicitacao {
+	return &Solicitacao{Status: status, AssinaturaCliente: "data:image/png;base64,AAAA"}
+}
+
+func TestTransitionRoles(t *testing.T) {
+	roles := []string{RoleAdmin, RoleSuperAdmin, RoleTecnico, RoleCliente, ""}
+	for _, from := range statuses {
+		for _, to := range statuses {
+			if from == to {
+				continue
+			}
+			allowed, legal := workflow[from][to]
+			for _, role := range roles {
+				want := legal && (contains(allowed, role) || role == RoleSuperAdmin && contains(allowed, RoleAdmin))
+
+				s := ready(from)
+				err := s.Transition(to, TransitionInput{Role: role, Observation: "motivo"})
+				if (err == nil) != want {
+					t.Errorf("%s -> %s as %q: err = %v, want allowed = %v", from, to, role, err, want)
+					continue
+				}
+				if err == nil {
+					if s.Status != to {
+						t.Errorf("%s -> %s as %q left status %s", from, to, role, s.Status)
+					}
+					continue
+				}
+
+				var te *TransitionError
+				if !errors.As(err, &te) {
+					t.Fatalf("%s -> %s: error %T, want *TransitionError", from, to, err)
+				}
+				wantCode := TransitionForbidden
+				if !legal {
+					wantCode = TransitionInvalid
+				}
+				if te.Code != wantCode {
+					t.Errorf("%s -> %s as %q: code %s, want %s", from, to, role, te.Code, wantCode)
+				}
+				if s.Status != from {
+					t.Errorf("%s -> %s as %q: rejected transition changed the status to %s", from, to, role, s.Status)
+				}
+			}
+		}
+	}
+}
+
+func TestTransitionRequiredFields(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  string
+		req       *Solicitacao
+		in        TransitionInput
+		wantError bool
+	}{
+		{"pause without reason", StatusEmAndamento, StatusPausada, nil, TransitionInput{Role: RoleTecnico}, true},
+		{"pause with reason", StatusEmAndamento, StatusPausada, nil, TransitionInput{Role: RoleTecnico, Observation: "Aguardando peça"}, false},
+		{"cancel in progress without reason", StatusEmAndamento, StatusCancelada, nil, TransitionInput{Role: RoleAdmin}, true},
+		{"cancel paused without reason", StatusPausada, StatusCancelada, nil, TransitionInput{Role: RoleAdmin}, true},
+		{"reopen without reason", StatusFinalizada, StatusEmAndamento, nil, TransitionInput{Role: RoleCliente}, true},
+		{"reopen with reason", StatusFinalizada, StatusEmAndamento, nil, TransitionInput{Role: RoleCliente, Observation: "Voltou a pingar"}, false},
+		{"finish unsigned", StatusEmAndamento, StatusFinalizada, &Solicitacao{Status: StatusEmAndamento}, TransitionInput{Role: RoleTecnico}, true},
+		{"finish signed by the technician", StatusEmAndamento, StatusFinalizada, &Solicitacao{Status: StatusEmAndamento, AssinaturaTecnico: "x"}, TransitionInput{Role: RoleTecnico}, false},
+		{"finish signed by the client", StatusEmAndamento, StatusFinalizada, &Solicitacao{Status: StatusEmAndamento, AssinaturaCliente: "x"}, TransitionInput{Role: RoleTecnico}, false},
+		{"open cancel needs no reason", StatusAberta, StatusCancelada, nil, TransitionInput{Role: RoleCliente}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.req
+			if s == nil {
+				s = ready(tt.from)
+			}
+			err := s.Transition(tt.to, tt.in)
+			if !tt.wantError {
+				if err != nil {
+					t.Fatalf("Transition: %v", err)
+				}
+				return
+			}
+			var te *TransitionError
+			if !errors.As(err, &te) || te.Code != TransitionMissingField {
+				t.Fatalf("Transition = %v, want %s", err, TransitionMissingField)
+			}
+			if s.Status != tt.from {
+				t.Errorf("status changed to %s", s.Status)
+			}
+		})
+	}
+}
+
+func TestTransitionEdgeCases(t *testing.T) {
+	// Moving to the current status is a no-op, whoever asks
+	s := &Solicitacao{Status: StatusConcluida}
+	if err := s.Transition(StatusConcluida, TransitionInput{}); err != nil {
+		t.Errorf("same status: %v", err)
+	}
+
+	var te *TransitionError
+	err := ready(StatusAberta).Transition("INEXISTENTE", TransitionInput{Role: RoleAdmin})
+	if !errors.As(err, &te) || te.Code != TransitionInvalid {
+		t.Errorf("unknown status = %v, want %s", err, TransitionInvalid)
+	}
+
+	// Final statuses lead nowhere
+	for _, from := range []string{StatusConcluida, StatusCancelada} {
+		if got := AllowedTransitions(from, RoleAdmin); len(got) != 0 {
+			t.Errorf("AllowedTransitions(%s) = %v, want none", from, got)
+		}
+	}
+
+	// A rejected transition lists what the role could do instead
+	err = ready(StatusEmAndamento).Transition(StatusCancelada, TransitionInput{Role: RoleTecnico, Observation: "x"})
+	if !errors.As(err, &te) {
+		t.Fatalf("error %T, want *TransitionError", err)
+	}
+	got := append([]string(nil), te.Allowed...)
+	sort.Strings(got)
+	if want := []string{StatusFinalizada, StatusPausada}; !equal(got, want) {
+		t.Errorf("allowed = %v, want %v", got, want)
+	}
+}
+
+func TestAllowedTransitions(t *testing.T) {
+	for _, from := range statuses {
+		for _, role := range []string{RoleAdmin, RoleTecnico, RoleCliente} {
+			var want []string
+			for to, roles := range workflow[from] {
+				if contains(roles, role) {
+					want = append(want, to)
+				}
+			}
+			got := AllowedTransitions(from, role)
+			sort.Strings(want)
+			sort.Strings(got)
+			if !equal(got, want) {
+				t.Errorf("AllowedTransitions(%s, %s) = %v, want %v", from, role, got, want)
+			}
+		}
+	}
+}
+
+func TestWorkflowRole(t *testing.T) {
+	full := map[string]bool{PermRequestsTransition: true}
+	tests := []struct {
+		role  string
+		perms map[string]bool
+		want  string
+	}{
+		{RoleAdmin, full, RoleAdmin},
+		{RoleAdmin, map[string]bool{PermFinanceView: true}, ""},
+		{RoleTecnico, nil, RoleTecnico},
+		{RoleTecnico, full, RoleAdmin},
+		{RoleCliente, nil, RoleCliente},
+	}
+	for _, tt := range tests {
+		if got := WorkflowRole(tt.role, tt.perms); got != tt.want {
+			t.Errorf("WorkflowRole(%s, %v) = %q, want %q", tt.role, tt.perms, got, tt.want)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
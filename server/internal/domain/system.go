@@ -21,25 +21,54 @@ type AuditLog struct {
 	CreatedAt   time.Time `gorm:"index" json:"timestamp"`
 }
 
-// Setting stores system configuration
+// Setting stores system configuration, shared by every company unless it has its own value
 type Setting struct {
 	Key         string `gorm:"primaryKey;size:100" json:"key"`
 	Value       string `gorm:"type:text;not null" json:"value"`
 	Description string `gorm:"size:255" json:"description,omitempty"`
 }
 
-// RefreshToken stores refresh tokens for JWT
+// CompanySetting overrides a Setting for one company
+type CompanySetting struct {
+	CompanyID string `gorm:"primaryKey;size:36" json:"companyId"`
+	Key       string `gorm:"primaryKey;size:100" json:"key"`
+	Value     string `gorm:"type:text;not null" json:"value"`
+}
+
+func (CompanySetting) TableName() string { return "company_settings" }
+
+// RefreshToken stores refresh tokens for JWT. Every refresh rotates the token; the tokens of one
+// sign-in share a family, which is the session listed to the user. Only the SHA-256 of the token
+// is stored.
 type RefreshToken struct {
-	ID        string    `gorm:"primaryKey;size:36" json:"id"`
-	UserID    string    `gorm:"size:36;not null;index" json:"userId"`
-	Token     string    `gorm:"size:255;uniqueIndex;not null" json:"-"`
-	ExpiresAt time.Time `gorm:"not null" json:"expiresAt"`
-	CreatedAt time.Time `json:"createdAt"`
-	Revoked   bool      `gorm:"default:false" json:"revoked"`
+	ID            string     `gorm:"primaryKey;size:36" json:"id"`
+	UserID        string     `gorm:"size:36;not null;index" json:"userId"`
+	FamilyID      string     `gorm:"size:36;index" json:"familyId"`
+	Token         string     `gorm:"size:255;uniqueIndex;not null" json:"-"`
+	ReplacedByID  *string    `gorm:"size:36" json:"-"`
+	UserAgent     string     `gorm:"size:500" json:"userAgent,omitempty"`
+	IPAddress     string     `gorm:"size:45" json:"ipAddress,omitempty"`
+	StartedAt     time.Time  `json:"startedAt"`
+	LastUsedAt    time.Time  `json:"lastUsedAt"`
+	ExpiresAt     time.Time  `gorm:"not null" json:"expiresAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	Revoked       bool       `gorm:"default:false" json:"revoked"`
+	RevokedAt     *time.Time `json:"revokedAt,omitempty"`
+	RevokedReason string     `gorm:"size:50" json:"revokedReason,omitempty"`
 
 	User User `gorm:"foreignKey:UserID" json:"-"`
 }
 
+// Reasons a session ends
+const (
+	SessionRevokedLogout   = "LOGOUT"
+	SessionRevokedUser     = "ENCERRADA_PELO_USUARIO"
+	SessionRevokedReuse    = "REUSO_DETECTADO"
+	SessionRevokedPassword = "SENHA_ALTERADA"
+	SessionRevokedBlocked  = "USUARIO_BLOQUEADO"
+	SessionRevokedRotated  = "ROTACIONADO"
+)
+
 // TableName overrides
 func (AuditLog) TableName() string     { return "audit_logs" }
 func (Setting) TableName() string      { return "settings" }
@@ -0,0 +1,43 @@
+package domain
+
+import (
+	"time"
+)
+
+// TOTPCredential holds the authenticator secret of a user. The secret is sealed with the server
+// master key; it stays pending until the first code is confirmed.
+type TOTPCredential struct {
+	UserID      string     `gorm:"primaryKey;size:36" json:"userId"`
+	Secret      string     `gorm:"size:255;not null" json:"-"`
+	ConfirmedAt *time.Time `json:"confirmedAt,omitempty"`
+	LastStep    int64      `json:"-"`
+	Failures    int        `json:"-"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// RecoveryCode is a single-use code to sign in without the authenticator; only its hash is kept
+type RecoveryCode struct {
+	ID        string     `gorm:"primaryKey;size:36" json:"id"`
+	UserID    string     `gorm:"size:36;not null;index" json:"userId"`
+	CodeHash  string     `gorm:"size:64;not null;index" json:"-"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// TrustedDevice is a browser remembered after a second-factor sign-in; only the token hash is kept
+type TrustedDevice struct {
+	ID         string    `gorm:"primaryKey;size:36" json:"id"`
+	UserID     string    `gorm:"size:36;not null;index" json:"userId"`
+	TokenHash  string    `gorm:"size:64;uniqueIndex;not null" json:"-"`
+	UserAgent  string    `gorm:"size:500" json:"userAgent,omitempty"`
+	IPAddress  string    `gorm:"size:45" json:"ipAddress,omitempty"`
+	ExpiresAt  time.Time `gorm:"not null" json:"expiresAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (TOTPCredential) TableName() string { return "totp_credentials" }
+func (RecoveryCode) TableName() string   { return "recovery_codes" }
+func (TrustedDevice) TableName() string  { return "trusted_devices" }
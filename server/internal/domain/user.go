@@ -13,9 +13,11 @@ type User struct {
 	Email               string         `gorm:"size:255;uniqueIndex;not null" json:"email"`
 	PasswordHash        string         `gorm:"size:255;not null" json:"-"`
 	Role                string         `gorm:"size:50;not null;index" json:"role"`
+	PerfilID            *string        `gorm:"size:36;index" json:"perfilId,omitempty"`
 	Phone               string         `gorm:"size:20" json:"phone,omitempty"`
 	Active              bool           `gorm:"default:true;index" json:"active"`
 	MustChangePassword  bool           `gorm:"default:true" json:"mustChangePassword"`
+	TOTPEnabled         bool           `gorm:"default:false" json:"totpEnabled"`
 	CompanyID           *string        `gorm:"size:36;index" json:"companyId,omitempty"`
 	AvatarURL           string         `gorm:"size:500" json:"avatarUrl,omitempty"`
 	ResetToken          *string        `gorm:"size:255;index" json:"-"`
@@ -2,10 +2,14 @@ package config
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
@@ -20,13 +24,49 @@ type Config struct {
 	SMTPPassword      string
 	SMTPFrom          string
 
-	MaxUploadSize   int64
-	LockTimeoutSecs int
-	ConfirmDays     int
-	Environment     string // development, staging, production
-	DefaultPassword string
-	FrontendURL     string
-	UploadDir       string
+	MaxUploadSize                int64
+	LockTimeoutSecs              int
+	ConfirmDays                  int
+	SLACheckIntervalSecs         int
+	PreventiveCheckIntervalHours int
+	Environment                  string // development, staging, production
+	DefaultPassword              string
+	FrontendURL                  string
+	UploadDir                    string
+
+	// NFS-e Nacional
+	NFSeBaseURL string // overrides the SEFIN endpoint (e.g. local fake server)
+	NFSeCAFile  string // extra CA bundle trusted for the SEFIN TLS certificate
+
+	// Directory whose municipios.csv, cep_faixas.csv and iss_municipios.csv replace the embedded datasets
+	FiscalDataDir string
+
+	// Background job queue
+	JobPollIntervalSecs       int
+	JobMaxAttempts            int
+	JobBackoffBaseSecs        int
+	NFSeReconcileIntervalMins int
+	RBT12CheckIntervalHours   int // how often the monthly RBT12 apuração checks for a new competência
+
+	// Authorized NFS-e XMLs and DANFS-e PDFs, kept outside UploadDir and served only through the API
+	FiscalDocsDir string
+
+	// Digital certificates vault, kept outside UploadDir
+	CertDir                string
+	CertMasterKeys         []MasterKey // the first key encrypts, the others only decrypt during rotation
+	CertCheckIntervalHours int
+
+	// Keys sealing the TOTP secrets; the first key seals, the others only open until rotated
+	TOTPMasterKeys []MasterKey
+
+	// E-mails promoted at startup to the super admin role, which is not bound to a company
+	SuperAdminEmails []string
+}
+
+// MasterKey is an AES-256 key used to encrypt secrets at rest
+type MasterKey struct {
+	ID  string
+	Key []byte
 }
 
 func Load() *Config {
@@ -39,6 +79,18 @@ func Load() *Config {
 		log.Fatal("❌ ERRO FATAL: DATABASE_URL não definido na configuração.")
 	}
 
+	certDir := getEnv("CERT_DIR", "./data/certs")
+	certKeys := loadCertMasterKeys(env, jwtSecret)
+	totpKeys := loadTOTPMasterKeys(env, certDir)
+	for _, k := range totpKeys {
+		for _, c := range certKeys {
+			// TOTP secrets sealed before TOTP had keys of its own carry the id of a certificate key
+			if k.ID == c.ID {
+				log.Fatalf("❌ ERRO FATAL: chave %q usada em CERT_MASTER_KEYS e TOTP_MASTER_KEYS; use ids distintos", k.ID)
+			}
+		}
+	}
+
 	return &Config{
 		Environment:       env,
 		DatabaseURL:       dbURL,
@@ -52,13 +104,114 @@ func Load() *Config {
 		SMTPPassword:      getEnv("SMTP_PASSWORD", ""),
 		SMTPFrom:          getEnv("SMTP_FROM", "noreply@inovar.com"),
 
-		MaxUploadSize:   int64(getEnvInt("MAX_UPLOAD_SIZE", 10*1024*1024)), // 10MB
-		LockTimeoutSecs: getEnvInt("LOCK_TIMEOUT_SECS", 300),               // 5 minutes
-		ConfirmDays:     getEnvInt("CONFIRM_DAYS", 7),
-		DefaultPassword: getEnv("DEFAULT_PASSWORD", "123456"),
-		FrontendURL:     frontendURL,
-		UploadDir:       getEnv("UPLOAD_DIR", "./data/uploads"),
+		MaxUploadSize:                int64(getEnvInt("MAX_UPLOAD_SIZE", 10*1024*1024)), // 10MB
+		LockTimeoutSecs:              getEnvInt("LOCK_TIMEOUT_SECS", 300),               // 5 minutes
+		ConfirmDays:                  getEnvInt("CONFIRM_DAYS", 7),
+		SLACheckIntervalSecs:         getEnvInt("SLA_CHECK_INTERVAL_SECS", 60),
+		PreventiveCheckIntervalHours: getEnvInt("PREVENTIVE_CHECK_INTERVAL_HOURS", 24),
+		DefaultPassword:              getEnv("DEFAULT_PASSWORD", "123456"),
+		FrontendURL:                  frontendURL,
+		UploadDir:                    getEnv("UPLOAD_DIR", "./data/uploads"),
+
+		NFSeBaseURL: getEnv("NFSE_BASE_URL", ""),
+		NFSeCAFile:  getEnv("NFSE_CA_FILE", ""),
+
+		FiscalDataDir: getEnv("FISCAL_DATA_DIR", ""),
+
+		JobPollIntervalSecs:       getEnvInt("JOB_POLL_INTERVAL_SECS", 5),
+		JobMaxAttempts:            getEnvInt("JOB_MAX_ATTEMPTS", 8),
+		JobBackoffBaseSecs:        getEnvInt("JOB_BACKOFF_BASE_SECS", 30),
+		NFSeReconcileIntervalMins: getEnvInt("NFSE_RECONCILE_INTERVAL_MINS", 10),
+		RBT12CheckIntervalHours:   getEnvInt("RBT12_CHECK_INTERVAL_HOURS", 6),
+
+		FiscalDocsDir: getEnv("FISCAL_DOCS_DIR", "./data/fiscal"),
+
+		CertDir:                certDir,
+		CertMasterKeys:         certKeys,
+		CertCheckIntervalHours: getEnvInt("CERT_CHECK_INTERVAL_HOURS", 24),
+
+		TOTPMasterKeys: totpKeys,
+
+		SuperAdminEmails: getEnvList("SUPER_ADMIN_EMAILS"),
+	}
+}
+
+// getEnvList reads a comma-separated list, skipping empty entries
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// loadCertMasterKeys parses CERT_MASTER_KEYS ("id:base64key,oldId:base64key", newest first).
+// Development falls back to a key derived from the JWT secret.
+func loadCertMasterKeys(env, jwtSecret string) []MasterKey {
+	raw := os.Getenv("CERT_MASTER_KEYS")
+	if raw == "" {
+		if env == "production" || env == "staging" {
+			log.Fatal("❌ ERRO FATAL: CERT_MASTER_KEYS não definido. Esta variável é obrigatória em produção!")
+		}
+		log.Println("⚠️ AVISO: CERT_MASTER_KEYS não definido. Certificados serão cifrados com chave derivada do JWT_SECRET.")
+		sum := sha256.Sum256([]byte("inovar-cert-vault:" + jwtSecret))
+		return []MasterKey{{ID: "dev", Key: sum[:]}}
+	}
+
+	return parseMasterKeys("CERT_MASTER_KEYS", raw)
+}
+
+// loadTOTPMasterKeys parses TOTP_MASTER_KEYS, in the format of CERT_MASTER_KEYS. Development falls
+// back to a random key kept in the certificate directory, so authenticators survive restarts.
+func loadTOTPMasterKeys(env, certDir string) []MasterKey {
+	raw := os.Getenv("TOTP_MASTER_KEYS")
+	if raw != "" {
+		return parseMasterKeys("TOTP_MASTER_KEYS", raw)
+	}
+	if env == "production" || env == "staging" {
+		log.Fatal("❌ ERRO FATAL: TOTP_MASTER_KEYS não definido. Esta variável é obrigatória em produção!")
+	}
+
+	path := filepath.Join(certDir, "totp-dev.key")
+	key, err := os.ReadFile(path)
+	if err != nil || len(key) != 32 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			log.Fatalf("❌ ERRO FATAL: não foi possível gerar a chave TOTP de desenvolvimento: %v", err)
+		}
+		if err := os.MkdirAll(certDir, 0700); err != nil {
+			log.Fatalf("❌ ERRO FATAL: não foi possível criar %s: %v", certDir, err)
+		}
+		if err := os.WriteFile(path, key, 0600); err != nil {
+			log.Fatalf("❌ ERRO FATAL: não foi possível gravar a chave TOTP de desenvolvimento: %v", err)
+		}
+	}
+	log.Printf("⚠️ AVISO: TOTP_MASTER_KEYS não definido. Segredos TOTP serão cifrados com a chave de desenvolvimento em %s.", path)
+	return []MasterKey{{ID: "dev-totp", Key: key}}
+}
+
+// parseMasterKeys parses a list of "id:base64key" entries, newest first
+func parseMasterKeys(name, raw string) []MasterKey {
+	var keys []MasterKey
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(raw, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			log.Fatalf("❌ ERRO FATAL: %s inválido, use o formato id:chaveBase64", name)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			log.Fatalf("❌ ERRO FATAL: chave %q de %s deve ter 32 bytes em base64", id, name)
+		}
+		if seen[id] {
+			log.Fatalf("❌ ERRO FATAL: chave %q repetida em %s", id, name)
+		}
+		seen[id] = true
+		keys = append(keys, MasterKey{ID: id, Key: key})
 	}
+	return keys
 }
 
 // loadJWTSecret loads JWT secret from environment or generates one for development
@@ -1,6 +1,8 @@
 package database
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"log"
 	"time"
 
@@ -32,11 +34,18 @@ func Connect(dbURL string) (*gorm.DB, error) {
 
 	db, err := gorm.Open(sqlite.Open(dbURL), &gorm.Config{
 		Logger: newLogger,
+		// Unique violations surface as gorm.ErrDuplicatedKey
+		TranslateError: true,
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	// Company isolation for queries made with a tenant context
+	if err := RegisterTenantScope(db); err != nil {
+		return nil, err
+	}
+
 	// Optimize SQLite for concurrent access
 	sqlDB, err := db.DB()
 	if err == nil {
@@ -75,7 +84,23 @@ func Connect(dbURL string) (*gorm.DB, error) {
 		&domain.Tecnico{},
 		&domain.AuditLog{},
 		&domain.Setting{},
+		&domain.CompanySetting{},
 		&domain.RefreshToken{},
+		&domain.ContratoSLA{},
+		&domain.PMOCPlano{},
+		&domain.PMOCAtividade{},
+		&domain.PMOCExecucao{},
+		&domain.MovimentacaoGas{},
+		&domain.Job{},
+		&domain.SerieDPS{},
+		&domain.ReservaDPS{},
+		&domain.LoteNFSe{},
+		&domain.NotaFiscalSolicitacao{},
+		&domain.Perfil{},
+		&domain.TOTPCredential{},
+		&domain.RecoveryCode{},
+		&domain.TrustedDevice{},
+		&domain.ReceitaMensal{},
 	)
 	if err != nil {
 		log.Printf("⚠️ AutoMigrate warning: %v", err)
@@ -83,8 +108,14 @@ func Connect(dbURL string) (*gorm.DB, error) {
 		log.Println("✅ Database schema migrated")
 	}
 
+	dropLegacyIndexes(db)
+
 	// Initialize default data
 	initializeDefaultData(db)
+	linkNotasSolicitacoes(db)
+	backfillExpenseCompany(db)
+	hashRefreshTokens(db)
+	moveTecnicoSomenteAtribuidas(db)
 
 	return db, nil
 }
@@ -158,6 +189,17 @@ func initializeDefaultData(db *gorm.DB) {
 			{Key: "lock_timeout", Value: "300", Description: "Timeout de bloqueio de edição (segundos)"},
 			{Key: "confirm_days", Value: "7", Description: "Dias para confirmação do cliente"},
 			{Key: "preventive_interval", Value: "90", Description: "Intervalo padrão para preventivas (dias)"},
+			{Key: "sla_warning_percent", Value: "80", Description: "Percentual do SLA consumido para emitir alerta"},
+			{Key: "sla_business_hours", Value: "", Description: "Expediente para contagem do SLA (HH:MM-HH:MM, vazio = 24x7)"},
+			{Key: "sla_business_days", Value: "1,2,3,4,5", Description: "Dias úteis do SLA (0=domingo)"},
+			{Key: "sla_holidays", Value: "", Description: "Feriados excluídos do SLA (AAAA-MM-DD separados por vírgula)"},
+			{Key: "sla_timezone", Value: "America/Sao_Paulo", Description: "Fuso horário do expediente e dos feriados do SLA"},
+			{Key: "preventive_horizon_days", Value: "15", Description: "Antecedência para gerar chamados de preventiva (dias)"},
+			{Key: "gas_recharge_window_days", Value: "180", Description: "Janela para alerta de recargas de gás repetidas (dias)"},
+			{Key: "gas_recharge_alert_count", Value: "2", Description: "Recargas na janela que disparam o alerta de vazamento"},
+			{Key: "preventive_auto_generate", Value: "true", Description: "Gerar chamados de preventiva automaticamente"},
+			{Key: "totp_obrigatorio", Value: "", Description: "Perfis obrigados a usar autenticação em dois fatores (separados por vírgula)"},
+			{Key: "totp_dispositivo_dias", Value: "30", Description: "Dias em que um dispositivo lembrado dispensa o segundo fator"},
 		}
 
 		for _, setting := range defaultSettings {
@@ -166,3 +208,98 @@ func initializeDefaultData(db *gorm.DB) {
 		log.Println("✅ Default settings created")
 	}
 }
+
+// linkNotasSolicitacoes links the notes issued before consolidated invoicing to their request
+func linkNotasSolicitacoes(db *gorm.DB) {
+	var notas []domain.NotaFiscal
+	db.Where("id NOT IN (SELECT nota_fiscal_id FROM notas_fiscais_solicitacoes)").Find(&notas)
+	if len(notas) == 0 {
+		return
+	}
+
+	for _, nf := range notas {
+		var solicitacao domain.Solicitacao
+		db.Unscoped().Select("id", "numero").First(&solicitacao, "id = ?", nf.SolicitacaoID)
+		db.Create(&domain.NotaFiscalSolicitacao{
+			ID:                uuid.New().String(),
+			NotaFiscalID:      nf.ID,
+			SolicitacaoID:     nf.SolicitacaoID,
+			NumeroSolicitacao: solicitacao.Numero,
+			Valor:             nf.ValorServicos,
+		})
+	}
+	log.Printf("🧾 %d NFS-e vinculada(s) às suas solicitações", len(notas))
+}
+
+// backfillExpenseCompany ties the expenses recorded before multi-tenancy to a company: the one of
+// their request or, with a single company in the system, that one
+func backfillExpenseCompany(db *gorm.DB) {
+	res := db.Exec(`UPDATE expenses SET company_id = (SELECT company_id FROM solicitacoes WHERE solicitacoes.id = expenses.solicitacao_id)
+		WHERE (company_id IS NULL OR company_id = '') AND solicitacao_id IS NOT NULL`)
+	n := res.RowsAffected
+
+	var prestadores []string
+	db.Model(&domain.Prestador{}).Limit(2).Pluck("id", &prestadores)
+	if len(prestadores) == 1 {
+		n += db.Model(&domain.Expense{}).Where("company_id IS NULL OR company_id = ''").
+			Update("company_id", prestadores[0]).RowsAffected
+	}
+	if n > 0 {
+		log.Printf("💸 %d despesa(s) vinculada(s) à sua empresa", n)
+	}
+}
+
+// dropLegacyIndexes removes indexes replaced by narrower ones, which AutoMigrate leaves in place
+func dropLegacyIndexes(db *gorm.DB) {
+	// The plan of a client was unique even after deletion
+	if db.Migrator().HasIndex(&domain.PMOCPlano{}, "idx_pmoc_planos_client_id") {
+		if err := db.Migrator().DropIndex(&domain.PMOCPlano{}, "idx_pmoc_planos_client_id"); err != nil {
+			log.Printf("⚠️ Falha ao remover índice antigo de PMOC: %v", err)
+		}
+	}
+}
+
+// hashRefreshTokens replaces the refresh tokens stored in plain text before rotation by their
+// SHA-256, each one becoming a session of its own
+func hashRefreshTokens(db *gorm.DB) {
+	var tokens []domain.RefreshToken
+	db.Where("family_id IS NULL OR family_id = ''").Find(&tokens)
+	for _, t := range tokens {
+		sum := sha256.Sum256([]byte(t.Token))
+		db.Model(&t).Updates(map[string]interface{}{
+			"token":        hex.EncodeToString(sum[:]),
+			"family_id":    t.ID,
+			"started_at":   t.CreatedAt,
+			"last_used_at": t.CreatedAt,
+		})
+	}
+	if len(tokens) > 0 {
+		log.Printf("🔑 %d refresh token(s) convertido(s) para hash", len(tokens))
+	}
+}
+
+// PromoteSuperAdmins grants the super admin role, which sees every company, to the configured e-mails
+func PromoteSuperAdmins(db *gorm.DB, emails []string) {
+	if len(emails) == 0 {
+		return
+	}
+	res := db.Model(&domain.User{}).Where("email IN ? AND role <> ?", emails, domain.RoleSuperAdmin).
+		Update("role", domain.RoleSuperAdmin)
+	if res.RowsAffected > 0 {
+		log.Printf("🛡️ %d usuário(s) promovido(s) a super administrador", res.RowsAffected)
+	}
+}
+
+// moveTecnicoSomenteAtribuidas carries the assigned-requests-only setting, once shared by every
+// company, over to each company
+func moveTecnicoSomenteAtribuidas(db *gorm.DB) {
+	var setting domain.Setting
+	if db.Limit(1).Find(&setting, "key = ?", "tecnico_so_atribuidas").RowsAffected == 0 {
+		return
+	}
+	if setting.Value == "true" {
+		n := db.Model(&domain.Prestador{}).Where("tecnico_somente_atribuidas = ?", false).Update("tecnico_somente_atribuidas", true).RowsAffected
+		log.Printf("🔧 Técnicos restritos aos chamados atribuídos em %d empresa(s)", n)
+	}
+	db.Delete(&setting)
+}
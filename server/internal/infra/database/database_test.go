@@ -0,0 +1,43 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+func TestMoveTecnicoSomenteAtribuidas(t *testing.T) {
+	for _, valor := range []string{"true", "false"} {
+		t.Run(valor, func(t *testing.T) {
+			db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sqlDB, _ := db.DB()
+			sqlDB.SetMaxOpenConns(1)
+			if err := db.AutoMigrate(&domain.Setting{}, &domain.Prestador{}); err != nil {
+				t.Fatal(err)
+			}
+			db.Create(&domain.Setting{Key: "tecnico_so_atribuidas", Value: valor})
+			for _, id := range []string{"company-a", "company-b"} {
+				db.Create(&domain.Prestador{ID: id, UserID: "user-" + id, RazaoSocial: id, CNPJ: id})
+			}
+
+			moveTecnicoSomenteAtribuidas(db)
+
+			var restritas int64
+			db.Model(&domain.Prestador{}).Where("tecnico_somente_atribuidas = ?", true).Count(&restritas)
+			if want := map[string]int64{"true": 2, "false": 0}[valor]; restritas != want {
+				t.Errorf("%d company(ies) restricted, want %d", restritas, want)
+			}
+			var settings int64
+			db.Model(&domain.Setting{}).Where("key = ?", "tecnico_so_atribuidas").Count(&settings)
+			if settings != 0 {
+				t.Error("global setting left in place")
+			}
+		})
+	}
+}
@@ -0,0 +1,288 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrTenantMismatch is returned when a write targets data of another company
+var ErrTenantMismatch = errors.New("registro pertence a outra empresa")
+
+type tenantKey struct{}
+
+// WithTenant scopes every query made with the returned context to the company
+func WithTenant(ctx context.Context, companyID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, companyID)
+}
+
+// TenantFrom returns the company the context is scoped to
+func TenantFrom(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	companyID, ok := ctx.Value(tenantKey{}).(string)
+	return companyID, ok
+}
+
+// tenantParent links a table to the table that owns it
+type tenantParent struct {
+	fk    string
+	table string
+}
+
+// tenantRule tells how a table is tied to a company: by a column of its own or through its parents
+type tenantRule struct {
+	column  string
+	parents []tenantParent
+}
+
+// tenantRules covers every table holding company data. Tables left out (settings, enderecos)
+// are shared or only reached through a scoped parent.
+var tenantRules = map[string]tenantRule{
+	"prestadores":           {column: "id"},
+	"users":                 {column: "company_id"},
+	"clientes":              {column: "company_id"},
+	"tecnicos":              {column: "company_id"},
+	"equipamentos":          {column: "company_id"},
+	"solicitacoes":          {column: "company_id"},
+	"contratos_sla":         {column: "company_id"},
+	"custom_qr_codes":       {column: "company_id"},
+	"pmoc_planos":           {column: "company_id"},
+	"movimentacoes_gas":     {column: "company_id"},
+	"expenses":              {column: "company_id"},
+	"jobs":                  {column: "company_id"},
+	"perfis":                {column: "company_id"},
+	"company_settings":      {column: "company_id"},
+	"notas_fiscais":         {column: "prestador_id"},
+	"certificados_digitais": {column: "prestador_id"},
+	"configuracoes_fiscais": {column: "prestador_id"},
+	"series_dps":            {column: "prestador_id"},
+	"reservas_dps":          {column: "prestador_id"},
+	"lotes_nfse":            {column: "prestador_id"},
+	"receitas_mensais":      {column: "prestador_id"},
+
+	"anexos":                {parents: []tenantParent{{"solicitacao_id", "solicitacoes"}}},
+	"checklists":            {parents: []tenantParent{{"solicitacao_id", "solicitacoes"}}},
+	"orcamento_itens":       {parents: []tenantParent{{"solicitacao_id", "solicitacoes"}}},
+	"solicitacao_historico": {parents: []tenantParent{{"solicitacao_id", "solicitacoes"}}},
+	"agenda":                {parents: []tenantParent{{"solicitacao_id", "solicitacoes"}}},
+	"solicitacao_equipamentos": {parents: []tenantParent{
+		{"solicitacao_id", "solicitacoes"}, {"equipamento_id", "equipamentos"},
+	}},
+	"notas_fiscais_solicitacoes": {parents: []tenantParent{
+		{"nota_fiscal_id", "notas_fiscais"}, {"solicitacao_id", "solicitacoes"},
+	}},
+	"nfse_eventos":     {parents: []tenantParent{{"nf_se_id", "notas_fiscais"}}},
+	"pmoc_atividades":  {parents: []tenantParent{{"plano_id", "pmoc_planos"}}},
+	"pmoc_execucoes":   {parents: []tenantParent{{"plano_id", "pmoc_planos"}}},
+	"notifications":    {parents: []tenantParent{{"user_id", "users"}}},
+	"audit_logs":       {parents: []tenantParent{{"user_id", "users"}}},
+	"refresh_tokens":   {parents: []tenantParent{{"user_id", "users"}}},
+	"totp_credentials": {parents: []tenantParent{{"user_id", "users"}}},
+	"recovery_codes":   {parents: []tenantParent{{"user_id", "users"}}},
+	"trusted_devices":  {parents: []tenantParent{{"user_id", "users"}}},
+}
+
+// RegisterTenantScope installs the callbacks that restrict queries, updates and deletes to the
+// company of the context and check the company of every created row. Raw SQL and contexts
+// without a tenant (background jobs, super admins) are left untouched.
+func RegisterTenantScope(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Query().Before("gorm:query").Register("tenant:query", tenantWhere); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("tenant:row", tenantWhere); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenant:update", tenantUpdate); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("tenant:delete", tenantWhere); err != nil {
+		return err
+	}
+	return cb.Create().Before("gorm:create").Register("tenant:create", tenantCreate)
+}
+
+// tenantStatement returns the company and the rule that apply to the statement
+func tenantStatement(db *gorm.DB) (string, tenantRule, bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.SQL.Len() > 0 {
+		return "", tenantRule{}, false
+	}
+	companyID, ok := TenantFrom(stmt.Context)
+	if !ok {
+		return "", tenantRule{}, false
+	}
+	rule, ok := tenantRules[tenantTable(stmt)]
+	return companyID, rule, ok
+}
+
+// tenantTable resolves the real table name, even when the query aliases it
+func tenantTable(stmt *gorm.Statement) string {
+	if stmt.TableExpr != nil {
+		name, _, _ := strings.Cut(strings.TrimSpace(stmt.TableExpr.SQL), " ")
+		return strings.Trim(name, "`\"")
+	}
+	if stmt.Table != "" {
+		return stmt.Table
+	}
+	if stmt.Schema != nil {
+		return stmt.Schema.Table
+	}
+	return ""
+}
+
+func tenantWhere(db *gorm.DB) {
+	companyID, rule, ok := tenantStatement(db)
+	if !ok {
+		return
+	}
+
+	if rule.column != "" {
+		db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+			clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: rule.column}, Value: companyID},
+		}})
+		return
+	}
+
+	// Every parent must belong to the company, e.g. both the request and the unit of a link
+	exprs := make([]clause.Expression, len(rule.parents))
+	for i, parent := range rule.parents {
+		exprs[i] = clause.Expr{
+			SQL: "? IN (SELECT id FROM ? WHERE ? = ?)",
+			Vars: []interface{}{
+				clause.Column{Table: clause.CurrentTable, Name: parent.fk},
+				clause.Table{Name: parent.table},
+				clause.Column{Name: tenantRules[parent.table].column},
+				companyID,
+			},
+		}
+	}
+	db.Statement.AddClause(clause.Where{Exprs: exprs})
+}
+
+// tenantUpdate scopes the update and refuses to move a row to another company
+func tenantUpdate(db *gorm.DB) {
+	companyID, rule, ok := tenantStatement(db)
+	if !ok {
+		return
+	}
+
+	if rule.column != "" {
+		var value interface{}
+		switch dest := db.Statement.Dest.(type) {
+		case map[string]interface{}:
+			value = dest[rule.column]
+		default:
+			if db.Statement.Schema != nil {
+				if field := db.Statement.Schema.LookUpField(rule.column); field != nil {
+					rv := reflect.Indirect(reflect.ValueOf(db.Statement.Dest))
+					if rv.Kind() == reflect.Struct && rv.Type() == db.Statement.Schema.ModelType {
+						value, _ = field.ValueOf(db.Statement.Context, rv)
+					}
+				}
+			}
+		}
+		if v := tenantString(value); v != "" && v != companyID {
+			db.AddError(ErrTenantMismatch)
+			return
+		}
+	}
+
+	tenantWhere(db)
+}
+
+// tenantCreate stamps the company on new rows and checks that child rows belong to parents of the company
+func tenantCreate(db *gorm.DB) {
+	companyID, rule, ok := tenantStatement(db)
+	if !ok || db.Statement.Schema == nil {
+		return
+	}
+	stmt := db.Statement
+
+	var rows []reflect.Value
+	switch rv := reflect.Indirect(stmt.ReflectValue); rv.Kind() {
+	case reflect.Struct:
+		rows = append(rows, rv)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			rows = append(rows, reflect.Indirect(rv.Index(i)))
+		}
+	default:
+		return
+	}
+
+	if rule.column != "" {
+		field := stmt.Schema.LookUpField(rule.column)
+		if field == nil {
+			return
+		}
+		for _, row := range rows {
+			value, zero := field.ValueOf(stmt.Context, row)
+			if zero || tenantString(value) == "" {
+				if err := field.Set(stmt.Context, row, companyID); err != nil {
+					db.AddError(err)
+					return
+				}
+				continue
+			}
+			if tenantString(value) != companyID {
+				db.AddError(ErrTenantMismatch)
+				return
+			}
+		}
+		return
+	}
+
+	for _, parent := range rule.parents {
+		field := stmt.Schema.LookUpField(parent.fk)
+		if field == nil {
+			continue
+		}
+		ids := map[string]bool{}
+		for _, row := range rows {
+			if value, _ := field.ValueOf(stmt.Context, row); tenantString(value) != "" {
+				ids[tenantString(value)] = true
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		list := make([]string, 0, len(ids))
+		for id := range ids {
+			list = append(list, id)
+		}
+
+		var count int64
+		err := db.Session(&gorm.Session{NewDB: true}).Table(parent.table).
+			Where(fmt.Sprintf("id IN ? AND %s = ?", tenantRules[parent.table].column), list, companyID).
+			Count(&count).Error
+		if err != nil {
+			db.AddError(err)
+			return
+		}
+		if count != int64(len(list)) {
+			db.AddError(ErrTenantMismatch)
+			return
+		}
+	}
+}
+
+// tenantString reads a company id from a string or *string field value
+func tenantString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case *string:
+		if v != nil {
+			return *v
+		}
+	}
+	return ""
+}
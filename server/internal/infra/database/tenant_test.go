@@ -0,0 +1,254 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+// tenantData holds the rows of one company
+type tenantData struct {
+	company     string
+	cliente     domain.Cliente
+	equipamento domain.Equipamento
+	solicitacao domain.Solicitacao
+	checklist   domain.Checklist
+	link        domain.SolicitacaoEquipamento
+	nota        domain.NotaFiscal
+	notaLink    domain.NotaFiscalSolicitacao
+}
+
+// newTenantTestDB opens an in-memory database with the tenant scope and two companies, a and b
+func newTenantTestDB(t *testing.T) (*gorm.DB, *tenantData, *tenantData) {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{TranslateError: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a database of its own
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := RegisterTenantScope(db); err != nil {
+		t.Fatal(err)
+	}
+	err = db.AutoMigrate(&domain.Cliente{}, &domain.Equipamento{}, &domain.Solicitacao{}, &domain.Checklist{},
+		&domain.SolicitacaoEquipamento{}, &domain.NotaFiscal{}, &domain.NotaFiscalSolicitacao{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, seedTenant(t, db, "a", 1001), seedTenant(t, db, "b", 2001)
+}
+
+// seedTenant creates the rows of a company without tenant context, as a background job would
+func seedTenant(t *testing.T, db *gorm.DB, company string, numero int) *tenantData {
+	t.Helper()
+	d := &tenantData{company: "company-" + company}
+	d.cliente = domain.Cliente{ID: "cliente-" + company, UserID: "user-" + company, Name: "Cliente " + company, CompanyID: d.company}
+	d.equipamento = domain.Equipamento{ID: "equip-" + company, ClientID: d.cliente.ID, CompanyID: d.company,
+		Brand: "LG", Model: "X", BTU: 9000, Location: "Sala"}
+	d.solicitacao = domain.Solicitacao{ID: "req-" + company, Numero: numero, ClientID: d.cliente.ID, ClientName: d.cliente.Name,
+		CompanyID: d.company, Status: domain.StatusAberta, Priority: "MEDIA"}
+	d.checklist = domain.Checklist{ID: "check-" + company, SolicitacaoID: d.solicitacao.ID, Description: "Limpar filtros"}
+	d.link = domain.SolicitacaoEquipamento{ID: "link-" + company, SolicitacaoID: d.solicitacao.ID, EquipamentoID: d.equipamento.ID}
+	d.nota = domain.NotaFiscal{ID: "nota-" + company, SolicitacaoID: d.solicitacao.ID, PrestadorID: d.company,
+		TomadorNome: d.cliente.Name, TomadorDocumento: "12345678909", Discriminacao: "Manutenção", Status: domain.NFSeStatusEmitida}
+	d.notaLink = domain.NotaFiscalSolicitacao{ID: "nota-link-" + company, NotaFiscalID: d.nota.ID, SolicitacaoID: d.solicitacao.ID}
+
+	for _, row := range []interface{}{&d.cliente, &d.equipamento, &d.solicitacao, &d.checklist, &d.link, &d.nota, &d.notaLink} {
+		if err := db.Omit("Equipamento").Create(row).Error; err != nil {
+			t.Fatalf("seeding company %s: %v", company, err)
+		}
+	}
+	return d
+}
+
+// as returns the database scoped to the company
+func as(db *gorm.DB, d *tenantData) *gorm.DB {
+	return db.WithContext(WithTenant(context.Background(), d.company))
+}
+
+func TestTenantQuery(t *testing.T) {
+	db, a, b := newTenantTestDB(t)
+
+	tests := []struct {
+		name  string
+		model interface{}
+		own   string
+		other string
+	}{
+		{"own column", &domain.Cliente{}, a.cliente.ID, b.cliente.ID},
+		{"prestador column", &domain.NotaFiscal{}, a.nota.ID, b.nota.ID},
+		{"parent", &domain.Checklist{}, a.checklist.ID, b.checklist.ID},
+		{"two parents", &domain.SolicitacaoEquipamento{}, a.link.ID, b.link.ID},
+		{"note links", &domain.NotaFiscalSolicitacao{}, a.notaLink.ID, b.notaLink.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids []string
+			if err := as(db, a).Model(tt.model).Pluck("id", &ids).Error; err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) != 1 || ids[0] != tt.own {
+				t.Errorf("company a lists %v, want only %s", ids, tt.own)
+			}
+
+			var count int64
+			as(db, a).Model(tt.model).Where("id = ?", tt.other).Count(&count)
+			if count != 0 {
+				t.Errorf("company a reaches %s of company b", tt.other)
+			}
+		})
+	}
+
+	var cliente domain.Cliente
+	if err := as(db, a).First(&cliente, "id = ?", b.cliente.ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("First of a client of company b = %v, want ErrRecordNotFound", err)
+	}
+}
+
+func TestTenantQueryChecksEveryParent(t *testing.T) {
+	db, a, b := newTenantTestDB(t)
+
+	// Rows tying a request of company a to data of company b, written without tenant context
+	mixed := domain.SolicitacaoEquipamento{ID: "link-mixed", SolicitacaoID: a.solicitacao.ID, EquipamentoID: b.equipamento.ID}
+	mixedNota := domain.NotaFiscalSolicitacao{ID: "nota-link-mixed", NotaFiscalID: b.nota.ID, SolicitacaoID: a.solicitacao.ID}
+	if err := db.Omit("Equipamento").Create(&mixed).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&mixedNota).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []*tenantData{a, b} {
+		var count int64
+		as(db, d).Model(&domain.SolicitacaoEquipamento{}).Where("id = ?", mixed.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("company %s reaches a unit link with a parent of another company", d.company)
+		}
+		as(db, d).Model(&domain.NotaFiscalSolicitacao{}).Where("id = ?", mixedNota.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("company %s reaches a note link with a parent of another company", d.company)
+		}
+	}
+}
+
+func TestTenantUpdate(t *testing.T) {
+	db, a, b := newTenantTestDB(t)
+
+	tests := []struct {
+		name   string
+		model  interface{}
+		id     string
+		column string
+	}{
+		{"own column", &domain.Cliente{}, b.cliente.ID, "name"},
+		{"parent", &domain.Checklist{}, b.checklist.ID, "description"},
+		{"two parents", &domain.SolicitacaoEquipamento{}, b.link.ID, "equipamento_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := as(db, a).Model(tt.model).Where("id = ?", tt.id).Update(tt.column, "alterado")
+			if result.Error != nil || result.RowsAffected != 0 {
+				t.Errorf("update of company b = %d row(s), %v; want none", result.RowsAffected, result.Error)
+			}
+		})
+	}
+
+	// Saving a row of company b, or moving a row of company a to b, is refused
+	outro := b.cliente
+	outro.Name = "Alterado"
+	if err := as(db, a).Save(&outro).Error; !errors.Is(err, ErrTenantMismatch) {
+		t.Errorf("Save of a client of company b = %v, want ErrTenantMismatch", err)
+	}
+	err := as(db, a).Model(&domain.Cliente{}).Where("id = ?", a.cliente.ID).
+		Updates(map[string]interface{}{"company_id": b.company}).Error
+	if !errors.Is(err, ErrTenantMismatch) {
+		t.Errorf("moving a client to company b = %v, want ErrTenantMismatch", err)
+	}
+
+	var cliente domain.Cliente
+	db.First(&cliente, "id = ?", b.cliente.ID)
+	if cliente.Name != b.cliente.Name {
+		t.Errorf("client of company b renamed to %q", cliente.Name)
+	}
+
+	// The own rows stay writable
+	result := as(db, a).Model(&domain.Checklist{}).Where("id = ?", a.checklist.ID).Update("description", "Trocar filtros")
+	if result.Error != nil || result.RowsAffected != 1 {
+		t.Errorf("update of own checklist = %d row(s), %v; want 1", result.RowsAffected, result.Error)
+	}
+}
+
+func TestTenantDelete(t *testing.T) {
+	db, a, b := newTenantTestDB(t)
+
+	tests := []struct {
+		name  string
+		model interface{}
+		id    string
+	}{
+		{"own column", &domain.Cliente{}, b.cliente.ID},
+		{"prestador column", &domain.NotaFiscal{}, b.nota.ID},
+		{"parent", &domain.Checklist{}, b.checklist.ID},
+		{"two parents", &domain.SolicitacaoEquipamento{}, b.link.ID},
+		{"note links", &domain.NotaFiscalSolicitacao{}, b.notaLink.ID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := as(db, a).Where("id = ?", tt.id).Delete(tt.model)
+			if result.Error != nil || result.RowsAffected != 0 {
+				t.Errorf("delete of company b = %d row(s), %v; want none", result.RowsAffected, result.Error)
+			}
+			var count int64
+			db.Model(tt.model).Where("id = ?", tt.id).Count(&count)
+			if count != 1 {
+				t.Errorf("row %s of company b was deleted", tt.id)
+			}
+		})
+	}
+}
+
+func TestTenantCreate(t *testing.T) {
+	db, a, b := newTenantTestDB(t)
+
+	// The company is stamped on rows created without one
+	novo := domain.Cliente{ID: "cliente-novo", UserID: "user-novo", Name: "Novo"}
+	if err := as(db, a).Create(&novo).Error; err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if novo.CompanyID != a.company {
+		t.Errorf("new client company = %q, want %q", novo.CompanyID, a.company)
+	}
+
+	tests := []struct {
+		name string
+		row  interface{}
+	}{
+		{"own column of another company", &domain.Cliente{ID: "cliente-x", UserID: "user-x", Name: "X", CompanyID: b.company}},
+		{"parent of another company", &domain.Checklist{ID: "check-x", SolicitacaoID: b.solicitacao.ID, Description: "X"}},
+		{"second parent of another company", &domain.SolicitacaoEquipamento{ID: "link-x", SolicitacaoID: a.solicitacao.ID, EquipamentoID: b.equipamento.ID}},
+		{"note of another company", &domain.NotaFiscalSolicitacao{ID: "nota-link-x", NotaFiscalID: b.nota.ID, SolicitacaoID: a.solicitacao.ID}},
+		{"batch with a row of another company", &[]domain.Checklist{
+			{ID: "check-y", SolicitacaoID: a.solicitacao.ID, Description: "Y"},
+			{ID: "check-z", SolicitacaoID: b.solicitacao.ID, Description: "Z"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := as(db, a).Omit("Equipamento").Create(tt.row).Error; !errors.Is(err, ErrTenantMismatch) {
+				t.Errorf("Create = %v, want ErrTenantMismatch", err)
+			}
+		})
+	}
+
+	if err := as(db, a).Omit("Equipamento").Create(&domain.SolicitacaoEquipamento{
+		ID: "link-own", SolicitacaoID: a.solicitacao.ID, EquipamentoID: a.equipamento.ID,
+	}).Error; err != nil {
+		t.Errorf("Create of a link between rows of the company: %v", err)
+	}
+}
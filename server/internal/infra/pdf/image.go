@@ -0,0 +1,103 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	// Registered decoders for AddImage
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// picture is an embedded image XObject
+type picture struct {
+	width, height int
+	colorSpace    string
+	filter        string
+	data          []byte
+	smask         []byte // alpha channel, deflated
+}
+
+// AddImage embeds a JPEG, PNG or GIF and returns its handle for DrawImage.
+// JPEGs are embedded as is; other formats are converted to deflated RGB.
+func (d *Document) AddImage(data []byte) (int, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("imagem inválida: %w", err)
+	}
+
+	if format == "jpeg" && cfg.ColorModel != color.CMYKModel {
+		space := "DeviceRGB"
+		if cfg.ColorModel == color.GrayModel {
+			space = "DeviceGray"
+		}
+		d.images = append(d.images, &picture{width: cfg.Width, height: cfg.Height, colorSpace: space, filter: "DCTDecode", data: data})
+		return len(d.images), nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("imagem inválida: %w", err)
+	}
+	return d.AddRGBA(img)
+}
+
+// AddRGBA embeds a decoded image and returns its handle for DrawImage
+func (d *Document) AddRGBA(img image.Image) (int, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	rgb := make([]byte, 0, w*h*3)
+	alpha := make([]byte, 0, w*h)
+	opaque := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			rgb = append(rgb, byte(r>>8), byte(g>>8), byte(b>>8))
+			alpha = append(alpha, byte(a>>8))
+			if a != 0xffff {
+				opaque = false
+			}
+		}
+	}
+
+	data, err := deflate(rgb)
+	if err != nil {
+		return 0, err
+	}
+	entry := &picture{width: w, height: h, colorSpace: "DeviceRGB", filter: "FlateDecode", data: data}
+	if !opaque {
+		if entry.smask, err = deflate(alpha); err != nil {
+			return 0, err
+		}
+	}
+
+	d.images = append(d.images, entry)
+	return len(d.images), nil
+}
+
+// ImageSize returns the pixel size of an embedded image
+func (d *Document) ImageSize(handle int) (int, int) {
+	if handle < 1 || handle > len(d.images) {
+		return 0, 0
+	}
+	img := d.images[handle-1]
+	return img.width, img.height
+}
+
+// DrawImage paints an embedded image with its top-left corner at (x, y)
+func (d *Document) DrawImage(handle int, x, y, w, h float64) {
+	if handle < 1 || handle > len(d.images) {
+		return
+	}
+	fmt.Fprintf(d.page(), "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, PageHeight-y-h, handle)
+}
+
+func cosSin(degrees float64) (float64, float64) {
+	rad := degrees * math.Pi / 180
+	return math.Cos(rad), math.Sin(rad)
+}
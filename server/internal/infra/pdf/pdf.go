@@ -0,0 +1,400 @@
+// Package pdf is a minimal PDF writer for server-side documents (reports, DANFS-e).
+// It supports A4 pages, the standard Helvetica fonts with WinAnsi encoding, text,
+// lines, rectangles and images. Coordinates are in points from the top-left corner.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+)
+
+// A4 page size in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Font styles
+const (
+	Regular = iota
+	Bold
+)
+
+var fontNames = []string{"Helvetica", "Helvetica-Bold"}
+
+// Document is a PDF being built page by page
+type Document struct {
+	pages   []*bytes.Buffer
+	current *bytes.Buffer
+	font    int
+	size    float64
+	images  []*picture
+	title   string
+}
+
+// New creates an empty document
+func New() *Document {
+	return &Document{size: 10}
+}
+
+// SetTitle sets the document title shown by viewers
+func (d *Document) SetTitle(title string) {
+	d.title = title
+}
+
+// AddPage starts a new page and makes it current
+func (d *Document) AddPage() {
+	d.current = &bytes.Buffer{}
+	d.pages = append(d.pages, d.current)
+}
+
+// PageCount returns the number of pages added so far
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+// SetFont selects the font style and size used by Text
+func (d *Document) SetFont(style int, size float64) {
+	d.font = style
+	d.size = size
+}
+
+// SetColor sets the fill color used for text and filled shapes (0-255 components)
+func (d *Document) SetColor(r, g, b int) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f rg\n", float64(r)/255, float64(g)/255, float64(b)/255)
+}
+
+// SetStrokeColor sets the color of lines and rectangle borders
+func (d *Document) SetStrokeColor(r, g, b int) {
+	fmt.Fprintf(d.page(), "%.3f %.3f %.3f RG\n", float64(r)/255, float64(g)/255, float64(b)/255)
+}
+
+// SetLineWidth sets the width of lines and borders
+func (d *Document) SetLineWidth(w float64) {
+	fmt.Fprintf(d.page(), "%.2f w\n", w)
+}
+
+// Text draws s with its baseline at (x, y)
+func (d *Document) Text(x, y float64, s string) {
+	fmt.Fprintf(d.page(), "BT /F%d %.2f Tf %.2f %.2f Td (%s) Tj ET\n", d.font+1, d.size, x, PageHeight-y, encode(s))
+}
+
+// TextRight draws s right-aligned to x
+func (d *Document) TextRight(x, y float64, s string) {
+	d.Text(x-d.TextWidth(s), y, s)
+}
+
+// TextCenter draws s centered on x
+func (d *Document) TextCenter(x, y float64, s string) {
+	d.Text(x-d.TextWidth(s)/2, y, s)
+}
+
+// TextRotated draws s rotated counter-clockwise by the given angle in degrees, centered on (x, y)
+func (d *Document) TextRotated(x, y, degrees float64, s string) {
+	c, sn := cosSin(degrees)
+	w := d.TextWidth(s)
+	// Start so that the middle of the text lands on (x, y)
+	sx := x - c*w/2
+	sy := PageHeight - y - sn*w/2
+	fmt.Fprintf(d.page(), "BT /F%d %.2f Tf %.4f %.4f %.4f %.4f %.2f %.2f Tm (%s) Tj ET\n",
+		d.font+1, d.size, c, sn, -sn, c, sx, sy, encode(s))
+}
+
+// Line draws a line between two points
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(d.page(), "%.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Rect draws a rectangle with its top-left corner at (x, y). fill paints it with the fill color.
+func (d *Document) Rect(x, y, w, h float64, fill bool) {
+	op := "S"
+	if fill {
+		op = "f"
+	}
+	fmt.Fprintf(d.page(), "%.2f %.2f %.2f %.2f re %s\n", x, PageHeight-y-h, w, h, op)
+}
+
+// TextWidth returns the width of s in the current font and size
+func (d *Document) TextWidth(s string) float64 {
+	widths := helveticaWidths
+	if d.font == Bold {
+		widths = helveticaBoldWidths
+	}
+	total := 0
+	for _, r := range s {
+		r = foldAccent(r)
+		if r >= 32 && r <= 126 {
+			total += widths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * d.size / 1000
+}
+
+// WrapText splits s into lines that fit width in the current font. Explicit newlines are kept.
+func (d *Document) WrapText(s string, width float64) []string {
+	lines := []string{}
+	for _, paragraph := range strings.Split(s, "\n") {
+		words := strings.Fields(paragraph)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := words[0]
+		for _, w := range words[1:] {
+			if d.TextWidth(line+" "+w) > width {
+				lines = append(lines, line)
+				line = w
+				continue
+			}
+			line += " " + w
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (d *Document) page() *bytes.Buffer {
+	if d.current == nil {
+		d.AddPage()
+	}
+	return d.current
+}
+
+// Bytes serializes the document
+func (d *Document) Bytes() ([]byte, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+
+	w := &writer{}
+	w.buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Fixed objects: 1 catalog, 2 pages, 3 info, 4..5 fonts, then images, then pages
+	catalog, pagesObj, info := 1, 2, 3
+	fontObj := 4
+	next := fontObj + len(fontNames)
+
+	imageObjs := make([]int, len(d.images))
+	for i := range d.images {
+		imageObjs[i] = next
+		next++
+		if d.images[i].smask != nil {
+			next++ // soft mask follows its image
+		}
+	}
+
+	pageObjs := make([]int, len(d.pages))
+	for i := range d.pages {
+		pageObjs[i] = next
+		next += 2 // page + content stream
+	}
+
+	w.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pagesObj))
+
+	kids := make([]string, len(pageObjs))
+	for i, n := range pageObjs {
+		kids[i] = fmt.Sprintf("%d 0 R", n)
+	}
+	w.object(pagesObj, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pageObjs)))
+
+	w.object(info, fmt.Sprintf("<< /Title (%s) /Producer (inovar) >>", encode(d.title)))
+
+	for i, name := range fontNames {
+		w.object(fontObj+i, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+	}
+
+	for i, img := range d.images {
+		n := imageObjs[i]
+		extra := ""
+		if img.smask != nil {
+			extra = fmt.Sprintf(" /SMask %d 0 R", n+1)
+		}
+		w.stream(n, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /%s /BitsPerComponent 8 /Filter /%s%s",
+			img.width, img.height, img.colorSpace, img.filter, extra), img.data)
+		if img.smask != nil {
+			w.stream(n+1, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceGray /BitsPerComponent 8 /Filter /FlateDecode",
+				img.width, img.height), img.smask)
+		}
+	}
+
+	var fonts strings.Builder
+	for i := range fontNames {
+		fmt.Fprintf(&fonts, "/F%d %d 0 R ", i+1, fontObj+i)
+	}
+	var xobjects strings.Builder
+	for i := range d.images {
+		fmt.Fprintf(&xobjects, "/Im%d %d 0 R ", i+1, imageObjs[i])
+	}
+	resources := fmt.Sprintf("<< /Font << %s>> /XObject << %s>> >>", fonts.String(), xobjects.String())
+
+	for i, content := range d.pages {
+		n := pageObjs[i]
+		w.object(n, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources %s /Contents %d 0 R >>",
+			pagesObj, PageWidth, PageHeight, resources, n+1))
+
+		compressed, err := deflate(content.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		w.stream(n+1, "/Filter /FlateDecode", compressed)
+	}
+
+	w.trailer(catalog, info, next)
+	return w.buf.Bytes(), nil
+}
+
+// writer tracks object offsets for the cross-reference table
+type writer struct {
+	buf     bytes.Buffer
+	offsets map[int]int
+}
+
+func (w *writer) object(n int, body string) {
+	w.begin(n)
+	w.buf.WriteString(body)
+	w.buf.WriteString("\nendobj\n")
+}
+
+func (w *writer) stream(n int, dict string, data []byte) {
+	w.begin(n)
+	fmt.Fprintf(&w.buf, "<< %s /Length %d >>\nstream\n", dict, len(data))
+	w.buf.Write(data)
+	w.buf.WriteString("\nendstream\nendobj\n")
+}
+
+func (w *writer) begin(n int) {
+	if w.offsets == nil {
+		w.offsets = map[int]int{}
+	}
+	w.offsets[n] = w.buf.Len()
+	fmt.Fprintf(&w.buf, "%d 0 obj\n", n)
+}
+
+func (w *writer) trailer(root, info, size int) {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", size)
+	for n := 1; n < size; n++ {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", w.offsets[n])
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root %d 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", size, root, info, xref)
+}
+
+func deflate(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	zw := zlib.NewWriter(&out)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// encode converts s to a WinAnsi PDF string literal body. Letters outside WinAnsi lose their
+// diacritics (e.g. ł, ş); anything else without a WinAnsi glyph becomes '?'.
+func encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteByte(' ')
+		case r >= 32 && r <= 126:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			if c, ok := winAnsiExtra[r]; ok {
+				fmt.Fprintf(&b, "\\%03o", c)
+			} else if f, ok := latinFold[r]; ok {
+				b.WriteRune(f)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
+
+// winAnsiExtra maps the non Latin-1 characters of WinAnsiEncoding
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+	// Spaces and dashes pasted from word processors
+	'\u2002': ' ', '\u2003': ' ', '\u2009': ' ', '\u202F': ' ', '\u2010': '-', '\u2011': '-', '\u2212': '-',
+}
+
+// latinFold maps the Latin Extended-A letters, which WinAnsi lacks, to their base letter
+var latinFold = func() map[rune]rune {
+	m := map[rune]rune{}
+	groups := map[rune]string{
+		'A': "ĀĂĄ", 'C': "ĆĈĊČ", 'D': "ĎĐ", 'E': "ĒĔĖĘĚ", 'G': "ĜĞĠĢ", 'H': "ĤĦ", 'I': "ĨĪĬĮİ", 'J': "Ĵ",
+		'K': "Ķ", 'L': "ĹĻĽĿŁ", 'N': "ŃŅŇ", 'O': "ŌŎŐ", 'R': "ŔŖŘ", 'S': "ŚŜŞ", 'T': "ŢŤŦ",
+		'U': "ŨŪŬŮŰŲ", 'W': "Ŵ", 'Y': "Ŷ", 'Z': "ŹŻ",
+		'a': "āăą", 'c': "ćĉċč", 'd': "ďđ", 'e': "ēĕėęě", 'g': "ĝğġģ", 'h': "ĥħ", 'i': "ĩīĭįı", 'j': "ĵ",
+		'k': "ķ", 'l': "ĺļľŀł", 'n': "ńņňŉ", 'o': "ōŏő", 'r': "ŕŗř", 's': "śŝş", 't': "ţťŧ",
+		'u': "ũūŭůűų", 'w': "ŵ", 'y': "ŷ", 'z': "źż",
+	}
+	for base, chars := range groups {
+		for _, c := range chars {
+			m[c] = base
+		}
+	}
+	return m
+}()
+
+// foldAccent maps accented letters to their base letter for width calculation
+func foldAccent(r rune) rune {
+	if r < 0xC0 {
+		return r
+	}
+	if f, ok := accentFold[r]; ok {
+		return f
+	}
+	if f, ok := latinFold[r]; ok {
+		return f
+	}
+	return r
+}
+
+var accentFold = func() map[rune]rune {
+	m := map[rune]rune{}
+	groups := map[rune]string{
+		'A': "ÀÁÂÃÄÅ", 'C': "Ç", 'E': "ÈÉÊË", 'I': "ÌÍÎÏ", 'N': "Ñ", 'O': "ÒÓÔÕÖ", 'U': "ÙÚÛÜ", 'Y': "Ý",
+		'a': "àáâãäå", 'c': "ç", 'e': "èéêë", 'i': "ìíîï", 'n': "ñ", 'o': "òóôõö", 'u': "ùúûü", 'y': "ýÿ",
+	}
+	for base, chars := range groups {
+		for _, c := range chars {
+			m[c] = base
+		}
+	}
+	return m
+}()
+
+// Glyph widths of the standard fonts for ASCII 32..126, in 1/1000 em
+var helveticaWidths = []int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = []int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
@@ -0,0 +1,427 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// parsed is a PDF read back through its cross-reference table
+type parsed struct {
+	objects map[int]parsedObject
+}
+
+type parsedObject struct {
+	dict   string
+	stream []byte // raw, still encoded
+}
+
+var (
+	startxrefRe = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+	trailerRe   = regexp.MustCompile(`trailer\n<< /Size (\d+) /Root (\d+) 0 R /Info (\d+) 0 R >>`)
+	lengthRe    = regexp.MustCompile(`/Length (\d+)`)
+)
+
+// parse reads the document as a viewer would: from startxref to the xref table, then every
+// object at its offset
+func parse(t *testing.T, data []byte) *parsed {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4\n")) {
+		t.Fatalf("missing header: %q", data[:min(len(data), 16)])
+	}
+	m := startxrefRe.FindSubmatch(data)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if xref >= len(data) || !bytes.HasPrefix(data[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+
+	lines := strings.Split(string(data[xref:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscan(lines[1], &first, &count); err != nil || first != 0 {
+		t.Fatalf("bad xref subsection %q", lines[1])
+	}
+	tm := trailerRe.FindStringSubmatch(string(data[xref:]))
+	if tm == nil {
+		t.Fatal("missing trailer")
+	}
+	if size, _ := strconv.Atoi(tm[1]); size != count {
+		t.Errorf("trailer /Size %d, xref has %d entries", size, count)
+	}
+	if lines[2] != "0000000000 65535 f " {
+		t.Errorf("bad free entry %q", lines[2])
+	}
+
+	p := &parsed{objects: map[int]parsedObject{}}
+	for n := 1; n < count; n++ {
+		entry := lines[2+n]
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("bad xref entry %d: %q", n, entry)
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		header := strconv.Itoa(n) + " 0 obj\n"
+		if !bytes.HasPrefix(data[offset:], []byte(header)) {
+			t.Fatalf("xref offset %d of object %d points at %q", offset, n, data[offset:min(len(data), offset+16)])
+		}
+		body := data[offset+len(header):]
+		end := bytes.Index(body, []byte("\nendobj\n"))
+		if i := bytes.Index(body, []byte(">>\nstream\n")); i >= 0 && (end < 0 || i < end) {
+			lm := lengthRe.FindSubmatch(body[:i])
+			if lm == nil {
+				t.Fatalf("stream of object %d without /Length", n)
+			}
+			length, _ := strconv.Atoi(string(lm[1]))
+			start := i + len(">>\nstream\n")
+			if !bytes.HasPrefix(body[start+length:], []byte("\nendstream\nendobj\n")) {
+				t.Fatalf("/Length %d of object %d does not end at endstream", length, n)
+			}
+			p.objects[n] = parsedObject{dict: string(body[:i+2]), stream: body[start : start+length]}
+			continue
+		}
+		if end < 0 {
+			t.Fatalf("object %d is not closed", n)
+		}
+		p.objects[n] = parsedObject{dict: string(body[:end])}
+	}
+	return p
+}
+
+func inflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("inflate: %v", err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("inflate: %v", err)
+	}
+	return out
+}
+
+// pageContents returns the decoded content streams of the pages, in order
+func (p *parsed) pageContents(t *testing.T) []string {
+	t.Helper()
+	var contents []string
+	for n := 1; n <= len(p.objects); n++ {
+		obj := p.objects[n]
+		if !strings.Contains(obj.dict, "/Type /Page ") {
+			continue
+		}
+		m := regexp.MustCompile(`/Contents (\d+) 0 R`).FindStringSubmatch(obj.dict)
+		c, _ := strconv.Atoi(m[1])
+		contents = append(contents, string(inflate(t, p.objects[c].stream)))
+	}
+	return contents
+}
+
+// images returns the image XObjects, leaving out the soft masks they refer to
+func (p *parsed) images() []parsedObject {
+	masks := map[string]bool{}
+	for _, obj := range p.objects {
+		if m := regexp.MustCompile(`/SMask (\d+) 0 R`).FindStringSubmatch(obj.dict); m != nil {
+			masks[m[1]] = true
+		}
+	}
+	var images []parsedObject
+	for n := 1; n <= len(p.objects); n++ {
+		if strings.Contains(p.objects[n].dict, "/Subtype /Image") && !masks[strconv.Itoa(n)] {
+			images = append(images, p.objects[n])
+		}
+	}
+	return images
+}
+
+var winAnsiRunes = func() map[byte]rune {
+	m := map[byte]rune{}
+	for r, c := range winAnsiExtra {
+		if c >= 0x80 {
+			m[c] = r
+		}
+	}
+	return m
+}()
+
+// shownText decodes the string operands of the Tj operators of a content stream
+func shownText(content string) []string {
+	var texts []string
+	for _, line := range strings.Split(content, "\n") {
+		i := strings.Index(line, "(")
+		if i < 0 || !strings.HasSuffix(line, ") Tj ET") {
+			continue
+		}
+		lit := line[i+1 : len(line)-len(") Tj ET")]
+		var b strings.Builder
+		for j := 0; j < len(lit); j++ {
+			c := lit[j]
+			if c == '\\' {
+				j++
+				if lit[j] >= '0' && lit[j] <= '7' {
+					n, _ := strconv.ParseUint(lit[j:j+3], 8, 8)
+					c = byte(n)
+					j += 2
+				} else {
+					c = lit[j]
+				}
+			}
+			switch {
+			case c >= 0x80 && c < 0xA0:
+				b.WriteRune(winAnsiRunes[c])
+			default:
+				b.WriteRune(rune(c)) // WinAnsi is Latin-1 elsewhere
+			}
+		}
+		texts = append(texts, b.String())
+	}
+	return texts
+}
+
+func TestDocumentStructure(t *testing.T) {
+	doc := New()
+	doc.SetTitle("Relatório (PMOC) \\ 2026")
+	doc.Text(40, 40, "primeira")
+	doc.AddPage()
+	doc.Rect(10, 10, 100, 20, true)
+	doc.Line(0, 0, 100, 100)
+	doc.AddPage()
+	doc.Text(40, 40, "terceira")
+
+	data, err := doc.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parse(t, data)
+
+	if !strings.Contains(p.objects[1].dict, "/Type /Catalog /Pages 2 0 R") {
+		t.Errorf("catalog = %q", p.objects[1].dict)
+	}
+	if !strings.Contains(p.objects[2].dict, "/Count 3") {
+		t.Errorf("pages = %q", p.objects[2].dict)
+	}
+	if want := `/Title (Relat\363rio \(PMOC\) \\ 2026)`; !strings.Contains(p.objects[3].dict, want) {
+		t.Errorf("info = %q, want %s", p.objects[3].dict, want)
+	}
+	contents := p.pageContents(t)
+	if len(contents) != 3 {
+		t.Fatalf("%d page contents, want 3", len(contents))
+	}
+	if !strings.Contains(contents[1], "re f") || !strings.Contains(contents[1], " l S") {
+		t.Errorf("second page = %q", contents[1])
+	}
+	if got := shownText(contents[2]); len(got) != 1 || got[0] != "terceira" {
+		t.Errorf("third page text = %q", got)
+	}
+
+	// An empty document still has a page
+	empty, err := New().Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(parse(t, empty).pageContents(t)); got != 1 {
+		t.Errorf("empty document has %d pages", got)
+	}
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Manutenção preventiva do ar-condicionado", "Manutenção preventiva do ar-condicionado"},
+		{"ÁÉÍÓÚ ÂÊÔ ÃÕ À Ç áéíóú âêô ãõ à ç ü", "ÁÉÍÓÚ ÂÊÔ ÃÕ À Ç áéíóú âêô ãõ à ç ü"},
+		{"Razão social: São João & Filhos (Ltda.)", "Razão social: São João & Filhos (Ltda.)"},
+		{"Valor: € 1.234,56 — “isento” • nº 12 · 5°C ½", "Valor: € 1.234,56 — “isento” • nº 12 · 5°C ½"},
+		{"caminho C:\\temp\\nota", "caminho C:\\temp\\nota"},
+		{"tab\tseparado", "tab separado"},
+		{"Łódź, Şişli", "Lódz, Sisli"},
+		{"ok ✓ 漢字", "ok ? ??"},
+	}
+	for _, tt := range tests {
+		doc := New()
+		doc.SetFont(Bold, 12)
+		doc.Text(40, 40, tt.in)
+		data, err := doc.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := shownText(parse(t, data).pageContents(t)[0])
+		if len(got) != 1 || got[0] != tt.want {
+			t.Errorf("text %q round-trips as %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	doc := New()
+	doc.SetFont(Regular, 10)
+	// Accented letters take the width of their base letter
+	if a, b := doc.TextWidth("Manutenção"), doc.TextWidth("Manutencao"); a != b {
+		t.Errorf("width of accented text %v, want %v", a, b)
+	}
+	if got := doc.TextWidth("MM"); got != 2*8.33 {
+		t.Errorf("width of MM = %v, want 16.66", got)
+	}
+	doc.SetFont(Bold, 10)
+	lines := doc.WrapText("Limpeza dos filtros e verificação da carga de gás\nsegunda linha", 100)
+	for _, line := range lines {
+		if doc.TextWidth(line) > 100 && strings.Contains(line, " ") {
+			t.Errorf("line %q is wider than 100", line)
+		}
+	}
+	if lines[len(lines)-1] != "segunda linha" {
+		t.Errorf("explicit newline lost: %q", lines)
+	}
+}
+
+func testImage(alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 100), G: uint8(y * 200), B: 50, A: alpha})
+		}
+	}
+	return img
+}
+
+func TestJPEGEmbedding(t *testing.T) {
+	for _, gray := range []bool{false, true} {
+		var img image.Image = testImage(255)
+		space := "/DeviceRGB"
+		if gray {
+			g := image.NewGray(image.Rect(0, 0, 3, 2))
+			g.SetGray(1, 1, color.Gray{Y: 200})
+			img, space = g, "/DeviceGray"
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		doc := New()
+		handle, err := doc.AddImage(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w, h := doc.ImageSize(handle); w != 3 || h != 2 {
+			t.Errorf("ImageSize = %dx%d", w, h)
+		}
+		doc.DrawImage(handle, 10, 20, 30, 20)
+		data, err := doc.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := parse(t, data)
+
+		images := p.images()
+		if len(images) != 1 {
+			t.Fatalf("%d images, want 1", len(images))
+		}
+		dict := images[0].dict
+		for _, want := range []string{"/Width 3", "/Height 2", "/ColorSpace " + space, "/Filter /DCTDecode"} {
+			if !strings.Contains(dict, want) {
+				t.Errorf("image dict %q lacks %s", dict, want)
+			}
+		}
+		// JPEGs are embedded byte for byte
+		if !bytes.Equal(images[0].stream, buf.Bytes()) {
+			t.Error("JPEG stream differs from the original file")
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(images[0].stream)); err != nil {
+			t.Errorf("embedded JPEG does not decode: %v", err)
+		}
+		if content := p.pageContents(t)[0]; !strings.Contains(content, "30.00 0 0 20.00 10.00 801.89 cm /Im1 Do") {
+			t.Errorf("page does not paint the image: %q", content)
+		}
+	}
+}
+
+func TestPNGEmbedding(t *testing.T) {
+	tests := []struct {
+		name  string
+		alpha uint8
+	}{
+		{"opaque", 255},
+		{"translucent", 128},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := testImage(tt.alpha)
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, src); err != nil {
+				t.Fatal(err)
+			}
+
+			doc := New()
+			handle, err := doc.AddImage(buf.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc.DrawImage(handle, 0, 0, 3, 2)
+			data, err := doc.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := parse(t, data)
+
+			images := p.images()
+			if len(images) != 1 {
+				t.Fatalf("%d images, want 1", len(images))
+			}
+			img := images[0]
+			if !strings.Contains(img.dict, "/ColorSpace /DeviceRGB") || !strings.Contains(img.dict, "/Filter /FlateDecode") {
+				t.Errorf("image dict = %q", img.dict)
+			}
+			rgb := inflate(t, img.stream)
+			if len(rgb) != 3*2*3 {
+				t.Fatalf("%d RGB bytes, want 18", len(rgb))
+			}
+			// Straight (non premultiplied) color channels survive only for opaque pixels; check those exactly
+			if tt.alpha == 255 {
+				for y := 0; y < 2; y++ {
+					for x := 0; x < 3; x++ {
+						c := src.NRGBAAt(x, y)
+						i := (y*3 + x) * 3
+						if rgb[i] != c.R || rgb[i+1] != c.G || rgb[i+2] != c.B {
+							t.Errorf("pixel %d,%d = %v, want %v", x, y, rgb[i:i+3], c)
+						}
+					}
+				}
+			}
+
+			m := regexp.MustCompile(`/SMask (\d+) 0 R`).FindStringSubmatch(img.dict)
+			if tt.alpha == 255 {
+				if m != nil {
+					t.Error("opaque image has a soft mask")
+				}
+				return
+			}
+			if m == nil {
+				t.Fatal("translucent image lacks a soft mask")
+			}
+			n, _ := strconv.Atoi(m[1])
+			alpha := inflate(t, p.objects[n].stream)
+			if !strings.Contains(p.objects[n].dict, "/ColorSpace /DeviceGray") || len(alpha) != 6 {
+				t.Fatalf("soft mask %q with %d bytes", p.objects[n].dict, len(alpha))
+			}
+			for i, a := range alpha {
+				if a != tt.alpha {
+					t.Errorf("alpha[%d] = %d, want %d", i, a, tt.alpha)
+				}
+			}
+		})
+	}
+
+	if _, err := New().AddImage([]byte("não é imagem")); err == nil {
+		t.Error("invalid image accepted")
+	}
+}
@@ -0,0 +1,416 @@
+// Package sefinfake is a local stand-in for the SEFIN Nacional (NFS-e) API, used in development
+// and tests. It is never linked into the API server.
+package sefinfake
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"inovar/internal/services"
+)
+
+// Same layout as the dates of the SEFIN Nacional responses
+const dateTimeLayout = "2006-01-02T15:04:05-07:00"
+
+// Server is a fake SEFIN Nacional API.
+// Like the real service it requires a client certificate and checks the XMLDSig of every document
+// against it. It keeps the issued notes in memory.
+type Server struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	notas    map[string]*Nota  // by chave de acesso
+	porDPS   map[string]string // DPS Id -> chave de acesso
+	numero   int64
+	rejeitar *services.APIError // next submission is rejected with this error
+	falhar   int                // next submission fails with this HTTP status
+}
+
+// Nota is an NFS-e issued by the fake SEFIN
+type Nota struct {
+	ChaveAcesso string
+	IDDPS       string
+	Numero      int64
+	CNPJ        string
+	XML         []byte
+	Cancelada   bool
+	Substituida string // chave de acesso of the substitute note
+	Processada  time.Time
+}
+
+// Start starts the fake server over TLS on addr ("" picks a free local port)
+func Start(addr string) (*Server, error) {
+	f := &Server{notas: map[string]*Nota{}, porDPS: map[string]string{}}
+
+	f.server = httptest.NewUnstartedServer(f.Handler())
+	if addr != "" {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		f.server.Listener.Close()
+		f.server.Listener = l
+	}
+	f.server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	f.server.StartTLS()
+
+	return f, nil
+}
+
+// URL returns the base URL to use as NFSE_BASE_URL
+func (f *Server) URL() string { return f.server.URL }
+
+// CertPool returns a pool trusting the server's TLS certificate
+func (f *Server) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(f.server.Certificate())
+	return pool
+}
+
+// CertificatePEM returns the server's TLS certificate, to be used as NFSE_CA_FILE
+func (f *Server) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.server.Certificate().Raw})
+}
+
+// Close stops the server
+func (f *Server) Close() { f.server.Close() }
+
+// RejectNext makes the next submission fail with a business error
+func (f *Server) RejectNext(codigo, mensagem string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rejeitar = &services.APIError{Codigo: codigo, Descricao: mensagem}
+}
+
+// FailNext makes the next submission fail with an HTTP status and no business error (e.g. 503)
+func (f *Server) FailNext(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.falhar = status
+}
+
+// Nota returns an issued note by its access key
+func (f *Server) Nota(chave string) (Nota, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, ok := f.notas[chave]
+	if !ok {
+		return Nota{}, false
+	}
+	return *n, true
+}
+
+// Handler returns the HTTP routes of the fake API
+func (f *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /nfse", f.emitir)
+	mux.HandleFunc("GET /nfse/{chave}", f.consultar)
+	mux.HandleFunc("POST /nfse/{chave}/eventos", f.registrarEvento)
+	mux.HandleFunc("GET /dps/{id}", f.consultarDPS)
+	return mux
+}
+
+func (f *Server) emitir(w http.ResponseWriter, r *http.Request) {
+	if f.forcedFailure(w) {
+		return
+	}
+
+	var body struct {
+		DPS string `json:"dpsXmlGZipB64"`
+	}
+	doc, ok := f.readSigned(w, r, &body, func() string { return body.DPS })
+	if !ok {
+		return
+	}
+
+	var dps services.DPS
+	if err := xml.Unmarshal(doc, &dps); err != nil {
+		reject(w, http.StatusBadRequest, "E0001", "XML da DPS mal formado: "+err.Error())
+		return
+	}
+	inf := dps.InfDPS
+	expected := services.DPSID(inf.CLocEmi, inf.Prest.CNPJ, inf.SeriesDPS, inf.NumDPS)
+	if inf.ID != expected {
+		reject(w, http.StatusBadRequest, "E0004", "Id da DPS inconsistente, esperado "+expected)
+		return
+	}
+	if inf.Serv.CServ.CodTribNac == "" || inf.Valores.VServPrest.VServ <= 0 {
+		reject(w, http.StatusBadRequest, "E0300", "Serviço sem código de tributação ou valor")
+		return
+	}
+
+	f.mu.Lock()
+	if chave, dup := f.porDPS[inf.ID]; dup {
+		f.mu.Unlock()
+		reject(w, http.StatusBadRequest, "E0014", "DPS já vinculada à NFS-e "+chave)
+		return
+	}
+	var original *Nota
+	if inf.Subst != nil {
+		var msg, codigo string
+		original = f.notas[inf.Subst.ChNFSeSubst]
+		switch {
+		case original == nil:
+			codigo, msg = "E0402", "NFS-e a ser substituída não encontrada"
+		case original.CNPJ != inf.Prest.CNPJ:
+			codigo, msg = "E0403", "NFS-e a ser substituída pertence a outro emitente"
+		case original.Cancelada:
+			codigo, msg = "E0404", "NFS-e a ser substituída está cancelada"
+		case original.Substituida != "":
+			codigo, msg = "E0405", "NFS-e a ser substituída já foi substituída por "+original.Substituida
+		case inf.Subst.CMotivo == "":
+			codigo, msg = "E0406", "Motivo da substituição não informado"
+		}
+		if codigo != "" {
+			f.mu.Unlock()
+			reject(w, http.StatusBadRequest, codigo, msg)
+			return
+		}
+	}
+	f.numero++
+	now := time.Now()
+	nota := &Nota{
+		IDDPS:      inf.ID,
+		Numero:     f.numero,
+		CNPJ:       inf.Prest.CNPJ,
+		Processada: now,
+	}
+	nota.ChaveAcesso = gerarChave(inf.CLocEmi, inf.TpAmb, inf.Prest.CNPJ, nota.Numero, now)
+	nota.XML = nfseXML(nota, &dps, doc)
+	f.notas[nota.ChaveAcesso] = nota
+	f.porDPS[inf.ID] = nota.ChaveAcesso
+	if original != nil {
+		original.Substituida = nota.ChaveAcesso
+	}
+	f.mu.Unlock()
+
+	payload, _ := services.EncodeNFSeXML(nota.XML)
+	writeJSON(w, http.StatusCreated, services.APIResponse{Sucesso: true, Data: services.NFSeResponse{
+		ChaveAcesso:           nota.ChaveAcesso,
+		IDDPS:                 nota.IDDPS,
+		DataHoraProcessamento: now.Format(dateTimeLayout),
+		XMLBase64:             payload,
+	}})
+}
+
+func (f *Server) registrarEvento(w http.ResponseWriter, r *http.Request) {
+	if f.forcedFailure(w) {
+		return
+	}
+
+	var body struct {
+		Pedido string `json:"pedidoRegistroEventoXmlGZipB64"`
+	}
+	doc, ok := f.readSigned(w, r, &body, func() string { return body.Pedido })
+	if !ok {
+		return
+	}
+
+	var ped services.PedidoRegistroEvento
+	if err := xml.Unmarshal(doc, &ped); err != nil {
+		reject(w, http.StatusBadRequest, "E0001", "XML do evento mal formado: "+err.Error())
+		return
+	}
+	chave := r.PathValue("chave")
+	if ped.InfPedReg.ChNFSe != chave {
+		reject(w, http.StatusBadRequest, "E1801", "Chave do evento difere da chave informada na URL")
+		return
+	}
+	if ped.InfPedReg.Cancelamento == nil {
+		reject(w, http.StatusBadRequest, "E1802", "Tipo de evento não suportado")
+		return
+	}
+	if n := len([]rune(ped.InfPedReg.Cancelamento.XMotivo)); n < 15 || n > 255 {
+		reject(w, http.StatusBadRequest, "E1807", "Justificativa do cancelamento deve ter entre 15 e 255 caracteres")
+		return
+	}
+
+	f.mu.Lock()
+	nota, exists := f.notas[chave]
+	switch {
+	case !exists:
+		f.mu.Unlock()
+		reject(w, http.StatusNotFound, "E1803", "NFS-e não encontrada")
+		return
+	case nota.Cancelada:
+		f.mu.Unlock()
+		reject(w, http.StatusBadRequest, "E1804", "NFS-e já cancelada")
+		return
+	case nota.Substituida != "":
+		f.mu.Unlock()
+		reject(w, http.StatusBadRequest, "E1806", "NFS-e substituída não pode ser cancelada")
+		return
+	case nota.CNPJ != ped.InfPedReg.CNPJAutor:
+		f.mu.Unlock()
+		reject(w, http.StatusForbidden, "E1805", "Autor do evento não é o emitente da NFS-e")
+		return
+	}
+	nota.Cancelada = true
+	f.mu.Unlock()
+
+	now := time.Now()
+	protocolo := fmt.Sprintf("%d", now.UnixNano())
+	evento := fmt.Sprintf(`<evento xmlns="%s" versao="%s"><infEvento Id="EVT%s%s001"><verAplic>SEFIN-FAKE</verAplic><ambGer>2</ambGer><nSeqEvento>1</nSeqEvento><dhProc>%s</dhProc><nDFe>%s</nDFe>%s</infEvento></evento>`,
+		services.NFSeNamespace, services.NFSeVersaoLeiaute, chave, services.EventoCancelamento, now.Format(dateTimeLayout), protocolo, stripXMLDecl(doc))
+	payload, _ := services.EncodeNFSeXML([]byte(evento))
+
+	writeJSON(w, http.StatusCreated, services.APIResponse{Sucesso: true, Data: services.EventoResponse{
+		ChaveAcesso:           chave,
+		TipoEvento:            services.EventoCancelamento,
+		Protocolo:             protocolo,
+		DataHoraProcessamento: now.Format(dateTimeLayout),
+		XMLBase64:             payload,
+	}})
+}
+
+func (f *Server) consultar(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	nota, ok := f.notas[r.PathValue("chave")]
+	var copia Nota
+	if ok {
+		copia = *nota
+	}
+	f.mu.Unlock()
+	if !ok {
+		reject(w, http.StatusNotFound, "E1803", "NFS-e não encontrada")
+		return
+	}
+
+	payload, _ := services.EncodeNFSeXML(copia.XML)
+	writeJSON(w, http.StatusOK, services.APIResponse{Sucesso: true, Data: services.NFSeResponse{
+		ChaveAcesso: copia.ChaveAcesso,
+		IDDPS:       copia.IDDPS,
+		XMLBase64:   payload,
+	}})
+}
+
+func (f *Server) consultarDPS(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	chave, ok := f.porDPS[r.PathValue("id")]
+	f.mu.Unlock()
+	if !ok {
+		reject(w, http.StatusNotFound, "E0404", "DPS não encontrada")
+		return
+	}
+	writeJSON(w, http.StatusOK, services.APIResponse{Sucesso: true, Data: services.NFSeResponse{ChaveAcesso: chave, IDDPS: r.PathValue("id")}})
+}
+
+// readSigned decodes the GZip+Base64 document of a request and checks its signature against the TLS client
+func (f *Server) readSigned(w http.ResponseWriter, r *http.Request, body interface{}, field func() string) ([]byte, bool) {
+	if err := json.NewDecoder(r.Body).Decode(body); err != nil || field() == "" {
+		reject(w, http.StatusBadRequest, "E0000", "Corpo da requisição inválido")
+		return nil, false
+	}
+	doc, err := services.DecodeNFSeXML(field())
+	if err != nil {
+		reject(w, http.StatusBadRequest, "E0001", err.Error())
+		return nil, false
+	}
+
+	signer, err := services.VerifyXMLSignature(doc)
+	if err != nil {
+		reject(w, http.StatusBadRequest, "E0714", "Assinatura digital inválida: "+err.Error())
+		return nil, false
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 || !bytes.Equal(r.TLS.PeerCertificates[0].Raw, signer.Raw) {
+		reject(w, http.StatusForbidden, "E0715", "Certificado da assinatura difere do certificado da conexão")
+		return nil, false
+	}
+	return doc, true
+}
+
+func (f *Server) forcedFailure(w http.ResponseWriter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.falhar != 0 {
+		status := f.falhar
+		f.falhar = 0
+		w.WriteHeader(status)
+		return true
+	}
+	if f.rejeitar != nil {
+		e := *f.rejeitar
+		f.rejeitar = nil
+		writeJSON(w, http.StatusBadRequest, services.APIResponse{Erros: []services.APIError{e}})
+		return true
+	}
+	return false
+}
+
+func reject(w http.ResponseWriter, status int, codigo, descricao string) {
+	writeJSON(w, status, services.APIResponse{Erros: []services.APIError{{Codigo: codigo, Descricao: descricao}}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// gerarChave builds a 50-digit access key: município(7) + ambiente(1) + tipo inscrição(1) + CNPJ(14) +
+// número(13) + AAMM(4) + código numérico(9) + DV(1)
+func gerarChave(cMun, tpAmb int, cnpj string, numero int64, t time.Time) string {
+	base := fmt.Sprintf("%07d%d2%014s%013d%s%09d", cMun, tpAmb, onlyDigits(cnpj), numero, t.Format("0601"), t.UnixNano()%1000000000)
+	return base + fmt.Sprint(mod11(base))
+}
+
+func mod11(digits string) int {
+	sum, weight := 0, 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+		weight++
+		if weight > 9 {
+			weight = 2
+		}
+	}
+	dv := 11 - sum%11
+	if dv >= 10 {
+		return 0
+	}
+	return dv
+}
+
+func nfseXML(nota *Nota, dps *services.DPS, signedDPS []byte) []byte {
+	inf := dps.InfDPS
+	var b strings.Builder
+	fmt.Fprintf(&b, `<NFSe xmlns="%s" versao="%s"><infNFSe Id="NFS%s">`, services.NFSeNamespace, services.NFSeVersaoLeiaute, nota.ChaveAcesso)
+	fmt.Fprintf(&b, `<nNFSe>%d</nNFSe><cLocIncid>%d</cLocIncid><verAplic>SEFIN-FAKE</verAplic><ambGer>%d</ambGer>`,
+		nota.Numero, inf.Serv.LocPrest.CLocPrestacao, inf.TpAmb)
+	fmt.Fprintf(&b, `<tpEmis>1</tpEmis><cStat>100</cStat><dhProc>%s</dhProc><nDFSe>%d</nDFSe>`,
+		nota.Processada.Format(dateTimeLayout), nota.Numero)
+	fmt.Fprintf(&b, `<emit><CNPJ>%s</CNPJ></emit><valores><vLiq>%.2f</vLiq></valores>`, inf.Prest.CNPJ, float64(inf.Valores.VServPrest.VServ))
+	b.Write(stripXMLDecl(signedDPS))
+	b.WriteString(`</infNFSe></NFSe>`)
+	return []byte(b.String())
+}
+
+func stripXMLDecl(doc []byte) []byte {
+	doc = bytes.TrimSpace(doc)
+	if bytes.HasPrefix(doc, []byte("<?xml")) {
+		if end := bytes.Index(doc, []byte("?>")); end >= 0 {
+			return bytes.TrimSpace(doc[end+2:])
+		}
+	}
+	return doc
+}
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,228 @@
+package sefinfake_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+	"inovar/internal/sefinfake"
+	"inovar/internal/services"
+)
+
+const testCNPJ = "11222333000181"
+
+// newTestCertificate creates a self-signed A1 certificate, used both to sign and as TLS client credential
+func newTestCertificate(t *testing.T) *services.A1Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "PRESTADOR TESTE:" + testCNPJ},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &services.A1Certificate{Certificate: cert, PrivateKey: key}
+}
+
+// setup starts a fake SEFIN and a client pointed to it
+func setup(t *testing.T) (*sefinfake.Server, *services.NFSeNacionalService, *services.A1Certificate) {
+	t.Helper()
+	fake, err := sefinfake.Start("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(fake.Close)
+
+	client := services.NewNFSeNacionalService(&config.Config{}, services.AmbienteHomologacao).
+		WithEndpoint(fake.URL(), fake.CertPool())
+	return fake, client, newTestCertificate(t)
+}
+
+func newDPS(numero int64, valor float64) *services.DPS {
+	dps := services.BuildDPSFromRequest(testCNPJ, "12345", "12345678909", "Cliente Teste", nil,
+		"Manutenção preventiva de ar condicionado", "140101", valor, 3205002, 3205002, domain.NaturezaTributacao, "4322302")
+	dps.SetNumero(services.SerieDPSPadrao, numero)
+	return dps
+}
+
+func TestEmitAndConsult(t *testing.T) {
+	fake, client, cert := setup(t)
+
+	dps := newDPS(1, 150)
+	resp, err := client.EmitirNFSe(dps, cert)
+	if err != nil {
+		t.Fatalf("EmitirNFSe: %v", err)
+	}
+	if len(resp.ChaveAcesso) != 50 {
+		t.Errorf("chave de acesso %q should have 50 digits", resp.ChaveAcesso)
+	}
+	if resp.NumeroNFSe != "1" || resp.IDDPS != dps.InfDPS.ID {
+		t.Errorf("got número %q, DPS %q; want 1, %s", resp.NumeroNFSe, resp.IDDPS, dps.InfDPS.ID)
+	}
+
+	nota, ok := fake.Nota(resp.ChaveAcesso)
+	if !ok {
+		t.Fatal("note not recorded by the fake")
+	}
+	if nota.CNPJ != testCNPJ {
+		t.Errorf("note CNPJ = %s, want %s", nota.CNPJ, testCNPJ)
+	}
+
+	consulta, err := client.ConsultarNFSe(resp.ChaveAcesso, cert)
+	if err != nil {
+		t.Fatalf("ConsultarNFSe: %v", err)
+	}
+	if consulta.NumeroNFSe != resp.NumeroNFSe || consulta.IDDPS != dps.InfDPS.ID {
+		t.Errorf("consulta = %+v, want the emitted note", consulta)
+	}
+
+	chave, err := client.ConsultarDPS(dps.InfDPS.ID, cert)
+	if err != nil {
+		t.Fatalf("ConsultarDPS: %v", err)
+	}
+	if chave != resp.ChaveAcesso {
+		t.Errorf("ConsultarDPS = %s, want %s", chave, resp.ChaveAcesso)
+	}
+}
+
+func TestConsultUnknown(t *testing.T) {
+	_, client, cert := setup(t)
+
+	if _, err := client.ConsultarNFSe("32050022112223330001810000000000009260100000000001", cert); !errors.Is(err, services.ErrNFSeNaoEncontrada) {
+		t.Errorf("ConsultarNFSe error = %v, want ErrNFSeNaoEncontrada", err)
+	}
+	if _, err := client.ConsultarDPS(newDPS(99, 10).InfDPS.ID, cert); !errors.Is(err, services.ErrNFSeNaoEncontrada) {
+		t.Errorf("ConsultarDPS error = %v, want ErrNFSeNaoEncontrada", err)
+	}
+}
+
+func TestEmitRejections(t *testing.T) {
+	_, client, cert := setup(t)
+
+	if _, err := client.EmitirNFSe(newDPS(1, 100), cert); err != nil {
+		t.Fatalf("EmitirNFSe: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		dps    *services.DPS
+		codigo string
+	}{
+		{"duplicate DPS", newDPS(1, 100), services.ErroDPSDuplicada},
+		{"zero value", newDPS(2, 0), "E0300"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.EmitirNFSe(tt.dps, cert)
+			var rejeicao *services.NFSeRejeicao
+			if !errors.As(err, &rejeicao) || !rejeicao.HasCodigo(tt.codigo) {
+				t.Errorf("error = %v, want rejection %s", err, tt.codigo)
+			}
+		})
+	}
+}
+
+func TestEmitRequiresSignerAsTLSClient(t *testing.T) {
+	fake, _, _ := setup(t)
+	signer := newTestCertificate(t)
+	other := newTestCertificate(t)
+
+	// Sign with one certificate and connect with another
+	signed, err := services.SignDPS(newDPS(1, 100), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := services.EncodeNFSeXML(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]string{"dpsXmlGZipB64": payload})
+
+	httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		Certificates: []tls.Certificate{other.TLSCertificate()},
+		RootCAs:      fake.CertPool(),
+	}}}
+	resp, err := httpClient.Post(fake.URL()+"/nfse", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var out services.APIResponse
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != http.StatusForbidden || len(out.Erros) == 0 || out.Erros[0].Codigo != "E0715" {
+		t.Errorf("got HTTP %d %+v, want 403 E0715", resp.StatusCode, out.Erros)
+	}
+}
+
+func TestForcedFailures(t *testing.T) {
+	fake, client, cert := setup(t)
+
+	fake.RejectNext("E9999", "Rejeição de teste")
+	_, err := client.EmitirNFSe(newDPS(1, 100), cert)
+	var rejeicao *services.NFSeRejeicao
+	if !errors.As(err, &rejeicao) || !rejeicao.HasCodigo("E9999") {
+		t.Errorf("error = %v, want rejection E9999", err)
+	}
+
+	fake.FailNext(http.StatusServiceUnavailable)
+	if _, err := client.EmitirNFSe(newDPS(1, 100), cert); err == nil {
+		t.Error("EmitirNFSe succeeded while the fake answered 503")
+	}
+
+	// Both failures left the DPS unused
+	if _, err := client.EmitirNFSe(newDPS(1, 100), cert); err != nil {
+		t.Errorf("EmitirNFSe after failures: %v", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	_, client, cert := setup(t)
+
+	resp, err := client.EmitirNFSe(newDPS(1, 100), cert)
+	if err != nil {
+		t.Fatalf("EmitirNFSe: %v", err)
+	}
+
+	if _, err := client.CancelarNFSe(resp.ChaveAcesso, testCNPJ, domain.MotivoCancelErroEmissao, "curto", cert); err == nil {
+		t.Error("cancellation with a short reason was accepted")
+	}
+
+	evento, err := client.CancelarNFSe(resp.ChaveAcesso, testCNPJ, domain.MotivoCancelErroEmissao, "Erro na emissão da nota de teste", cert)
+	if err != nil {
+		t.Fatalf("CancelarNFSe: %v", err)
+	}
+	if evento.TipoEvento != services.EventoCancelamento || evento.Protocolo == "" {
+		t.Errorf("evento = %+v", evento)
+	}
+
+	_, err = client.CancelarNFSe(resp.ChaveAcesso, testCNPJ, domain.MotivoCancelErroEmissao, "Erro na emissão da nota de teste", cert)
+	var rejeicao *services.NFSeRejeicao
+	if !errors.As(err, &rejeicao) || !rejeicao.HasCodigo("E1804") {
+		t.Errorf("second cancellation error = %v, want E1804", err)
+	}
+}
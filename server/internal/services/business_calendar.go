@@ -0,0 +1,183 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // the runtime image may lack a zoneinfo database
+)
+
+// maxCalendarDays bounds calendar walks so a misconfigured calendar can't loop forever
+const maxCalendarDays = 3660
+
+// DefaultBusinessTimezone is the timezone of the working hours when none is configured
+const DefaultBusinessTimezone = "America/Sao_Paulo"
+
+// BusinessCalendar describes working hours, working weekdays and holidays in the timezone of the
+// company, whatever the timezone of the server. A disabled calendar counts every minute (24x7).
+type BusinessCalendar struct {
+	Enabled  bool
+	StartMin int // minutes after midnight
+	EndMin   int
+	Days     map[time.Weekday]bool
+	Holidays map[string]bool // YYYY-MM-DD
+	Location *time.Location
+}
+
+// ParseBusinessCalendar builds a calendar from the settings values.
+// hours is "HH:MM-HH:MM" (empty disables the calendar), days is a comma separated
+// list of weekdays (0=Sunday), holidays a comma separated list of YYYY-MM-DD dates and
+// timezone an IANA name (empty means DefaultBusinessTimezone).
+func ParseBusinessCalendar(hours, days, holidays, timezone string) (*BusinessCalendar, error) {
+	timezone = strings.TrimSpace(timezone)
+	if timezone == "" {
+		timezone = DefaultBusinessTimezone
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("fuso horário inválido: %s", timezone)
+	}
+	cal := &BusinessCalendar{
+		Days:     map[time.Weekday]bool{},
+		Holidays: map[string]bool{},
+		Location: loc,
+	}
+
+	hours = strings.TrimSpace(hours)
+	if hours == "" {
+		return cal, nil
+	}
+
+	parts := strings.Split(hours, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("expediente inválido: %s", hours)
+	}
+	start, err := parseClock(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseClock(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if end <= start {
+		return nil, fmt.Errorf("expediente inválido: %s", hours)
+	}
+
+	cal.Enabled = true
+	cal.StartMin = start
+	cal.EndMin = end
+
+	if strings.TrimSpace(days) == "" {
+		days = "1,2,3,4,5"
+	}
+	for _, d := range strings.Split(days, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(d))
+		if err != nil || n < 0 || n > 6 {
+			return nil, fmt.Errorf("dia útil inválido: %s", d)
+		}
+		cal.Days[time.Weekday(n)] = true
+	}
+
+	for _, h := range strings.Split(holidays, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", h); err != nil {
+			return nil, fmt.Errorf("feriado inválido: %s", h)
+		}
+		cal.Holidays[h] = true
+	}
+
+	return cal, nil
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("horário inválido: %s", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// local returns t in the timezone of the calendar
+func (c *BusinessCalendar) local(t time.Time) time.Time {
+	if c.Location == nil {
+		return t
+	}
+	return t.In(c.Location)
+}
+
+// window returns the working interval of the day containing t, which must be in the calendar timezone
+func (c *BusinessCalendar) window(t time.Time) (time.Time, time.Time, bool) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if !c.Days[t.Weekday()] || c.Holidays[midnight.Format("2006-01-02")] {
+		return midnight, midnight, false
+	}
+	start := midnight.Add(time.Duration(c.StartMin) * time.Minute)
+	end := midnight.Add(time.Duration(c.EndMin) * time.Minute)
+	return start, end, true
+}
+
+func nextMidnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+}
+
+// Add returns the instant reached after d of business time has elapsed from `from`
+func (c *BusinessCalendar) Add(from time.Time, d time.Duration) time.Time {
+	if c == nil || !c.Enabled {
+		return from.Add(d)
+	}
+
+	t := c.local(from)
+	remaining := d
+	for i := 0; i < maxCalendarDays && remaining > 0; i++ {
+		start, end, ok := c.window(t)
+		if !ok || !t.Before(end) {
+			t = nextMidnight(t)
+			continue
+		}
+		if t.Before(start) {
+			t = start
+		}
+		available := end.Sub(t)
+		if remaining <= available {
+			return t.Add(remaining)
+		}
+		remaining -= available
+		t = nextMidnight(t)
+	}
+	return t
+}
+
+// Between returns the business time elapsed between a and b
+func (c *BusinessCalendar) Between(a, b time.Time) time.Duration {
+	if !b.After(a) {
+		return 0
+	}
+	if c == nil || !c.Enabled {
+		return b.Sub(a)
+	}
+
+	var total time.Duration
+	t := c.local(a)
+	for i := 0; i < maxCalendarDays && t.Before(b); i++ {
+		start, end, ok := c.window(t)
+		if ok {
+			from, to := start, end
+			if t.After(from) {
+				from = t
+			}
+			if b.Before(to) {
+				to = b
+			}
+			if to.After(from) {
+				total += to.Sub(from)
+			}
+		}
+		t = nextMidnight(t)
+	}
+	return total
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"inovar/internal/domain"
+)
+
+// Business hours of the tests: 08:00-18:00 in São Paulo, Monday to Friday, with All Souls' Day off
+func testCalendar(t *testing.T) *BusinessCalendar {
+	t.Helper()
+	cal, err := ParseBusinessCalendar("08:00-18:00", "1,2,3,4,5", "2026-11-02", "America/Sao_Paulo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cal
+}
+
+// brt is a wall-clock time in São Paulo (UTC-3)
+func brt(day, hour, min int, month time.Month) time.Time {
+	return time.Date(2026, month, day, hour+3, min, 0, 0, time.UTC)
+}
+
+func TestBusinessCalendarAdd(t *testing.T) {
+	cal := testCalendar(t)
+	tests := []struct {
+		name string
+		from time.Time
+		d    time.Duration
+		want time.Time
+	}{
+		{"within the day", brt(13, 10, 0, 10), 2 * time.Hour, brt(13, 12, 0, 10)},
+		{"ends at closing time", brt(12, 8, 0, 10), 10 * time.Hour, brt(12, 18, 0, 10)},
+		// 17:00 in São Paulo is 20:00 UTC, already past closing time in UTC
+		{"late afternoon in São Paulo", brt(13, 17, 0, 10), 2 * time.Hour, brt(14, 9, 0, 10)},
+		{"before opening", brt(13, 6, 30, 10), time.Hour, brt(13, 9, 0, 10)},
+		{"after closing", brt(13, 19, 0, 10), time.Hour, brt(14, 9, 0, 10)},
+		{"across the weekend", brt(16, 17, 0, 10), 3 * time.Hour, brt(19, 10, 0, 10)},
+		{"opened on Saturday", brt(17, 10, 0, 10), time.Hour, brt(19, 9, 0, 10)},
+		{"across a weekend and a holiday", brt(30, 17, 0, 10), 2 * time.Hour, brt(3, 9, 0, 11)},
+		{"several days", brt(13, 8, 0, 10), 24 * time.Hour, brt(15, 12, 0, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cal.Add(tt.from, tt.d); !got.Equal(tt.want) {
+				t.Errorf("Add(%v, %v) = %v, want %v", tt.from.In(cal.Location), tt.d, got.In(cal.Location), tt.want.In(cal.Location))
+			}
+		})
+	}
+}
+
+func TestBusinessCalendarBetween(t *testing.T) {
+	cal := testCalendar(t)
+	tests := []struct {
+		name string
+		a, b time.Time
+		want time.Duration
+	}{
+		{"within the day", brt(13, 9, 0, 10), brt(13, 11, 30, 10), 150 * time.Minute},
+		{"outside the hours", brt(13, 18, 30, 10), brt(14, 7, 0, 10), 0},
+		{"late afternoon in São Paulo", brt(13, 17, 0, 10), brt(14, 9, 0, 10), 2 * time.Hour},
+		{"paused over the weekend", brt(17, 10, 0, 10), brt(18, 20, 0, 10), 0},
+		{"paused from Friday to Monday", brt(16, 17, 0, 10), brt(19, 9, 0, 10), 2 * time.Hour},
+		{"paused across a holiday", brt(30, 17, 0, 10), brt(3, 9, 0, 11), 2 * time.Hour},
+		{"paused on the holiday", brt(2, 9, 0, 11), brt(2, 17, 0, 11), 0},
+		{"reversed", brt(14, 9, 0, 10), brt(13, 9, 0, 10), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cal.Between(tt.a, tt.b); got != tt.want {
+				t.Errorf("Between = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBusinessCalendarTimezone(t *testing.T) {
+	// The server may run in UTC: the window is in the configured timezone whatever the input location
+	cal := testCalendar(t)
+	from := time.Date(2026, 10, 13, 20, 0, 0, 0, time.UTC) // 17:00 in São Paulo
+	if got, want := cal.Add(from, 2*time.Hour), time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Add from UTC = %v, want %v", got.UTC(), want)
+	}
+
+	manaus, err := ParseBusinessCalendar("08:00-18:00", "", "", "America/Manaus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := manaus.Add(from, 2*time.Hour), time.Date(2026, 10, 13, 22, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Add in Manaus = %v, want %v", got.UTC(), want)
+	}
+
+	if _, err := ParseBusinessCalendar("08:00-18:00", "", "", "America/Atlantida"); err == nil {
+		t.Error("unknown timezone accepted")
+	}
+	def, err := ParseBusinessCalendar("08:00-18:00", "", "", "")
+	if err != nil || def.Location.String() != DefaultBusinessTimezone {
+		t.Errorf("default timezone = %v, %v; want %s", def, err, DefaultBusinessTimezone)
+	}
+}
+
+func TestBusinessCalendarDisabled(t *testing.T) {
+	cal, err := ParseBusinessCalendar("", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := brt(17, 23, 0, 10)
+	if got := cal.Add(from, 5*time.Hour); !got.Equal(from.Add(5 * time.Hour)) {
+		t.Errorf("24x7 Add = %v", got)
+	}
+	if got := cal.Between(from, from.Add(30*time.Hour)); got != 30*time.Hour {
+		t.Errorf("24x7 Between = %v", got)
+	}
+}
+
+func TestSLAApplyWithPause(t *testing.T) {
+	db := newTestDB(t, &domain.Setting{}, &domain.ContratoSLA{})
+	for key, value := range map[string]string{
+		"sla_alta":           "10",
+		"sla_business_hours": "08:00-18:00",
+		"sla_business_days":  "1,2,3,4,5",
+		"sla_holidays":       "2026-11-02",
+		"sla_timezone":       "America/Sao_Paulo",
+	} {
+		db.Create(&domain.Setting{Key: key, Value: value})
+	}
+	s := &SLAService{db: db}
+
+	tests := []struct {
+		name   string
+		opened time.Time
+		paused int // business minutes spent paused
+		want   time.Time
+	}{
+		{"no pause", brt(13, 8, 0, 10), 0, brt(13, 18, 0, 10)},
+		{"paused two hours", brt(13, 8, 0, 10), 120, brt(14, 10, 0, 10)},
+		{"paused into the weekend", brt(16, 8, 0, 10), 180, brt(19, 11, 0, 10)},
+		{"paused into the holiday", brt(30, 8, 0, 10), 60, brt(3, 9, 0, 11)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &domain.Solicitacao{Priority: domain.PriorityAlta, CreatedAt: tt.opened, SLAPausedMinutes: tt.paused}
+			s.Apply(req)
+			if !req.SLALimit.Equal(tt.want) {
+				t.Errorf("SLA limit = %v, want %v", req.SLALimit, tt.want.In(req.SLALimit.Location()))
+			}
+		})
+	}
+}
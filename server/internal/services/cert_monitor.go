@@ -0,0 +1,183 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+)
+
+// Days before expiry at which the provider admins are warned, most distant first
+var certAvisoDias = []int{30, 15, 7, 1}
+
+// Certificate situations
+const (
+	CertSituacaoAusente   = "AUSENTE"
+	CertSituacaoValido    = "VALIDO"
+	CertSituacaoExpirando = "EXPIRANDO"
+	CertSituacaoExpirado  = "EXPIRADO"
+)
+
+// CertificateStatus summarizes the certificate of a provider for the fiscal screens
+type CertificateStatus struct {
+	Situacao         string                     `json:"situacao"`
+	Certificado      *domain.CertificadoDigital `json:"certificado,omitempty"`
+	DiasRestantes    int                        `json:"diasRestantes"`
+	EmissaoBloqueada bool                       `json:"emissaoBloqueada"`
+	Mensagem         string                     `json:"mensagem"`
+}
+
+// CertificateMonitor warns the provider admins before their A1 certificate expires
+type CertificateMonitor struct {
+	db            *gorm.DB
+	notifications *NotificationService
+	email         *EmailService
+	interval      time.Duration
+}
+
+// NewCertificateMonitor creates a new certificate expiry monitor
+func NewCertificateMonitor(db *gorm.DB, notifications *NotificationService, email *EmailService, cfg *config.Config) *CertificateMonitor {
+	return &CertificateMonitor{
+		db:            db,
+		notifications: notifications,
+		email:         email,
+		interval:      time.Duration(cfg.CertCheckIntervalHours) * time.Hour,
+	}
+}
+
+// CheckCertificateValid refuses a certificate that is no longer usable for signing
+func CheckCertificateValid(cert *domain.CertificadoDigital, now time.Time) error {
+	if now.After(cert.Validade) {
+		return fmt.Errorf("certificado digital A1 expirou em %s; envie um novo certificado para voltar a emitir NFS-e", cert.Validade.Format("02/01/2006"))
+	}
+	return nil
+}
+
+// Status returns the certificate situation of a provider
+func (m *CertificateMonitor) Status(prestadorID string) (*CertificateStatus, error) {
+	var cert domain.CertificadoDigital
+	err := m.db.Where("prestador_id = ? AND ativo = ?", prestadorID, true).First(&cert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &CertificateStatus{
+			Situacao:         CertSituacaoAusente,
+			EmissaoBloqueada: true,
+			Mensagem:         "Certificado digital A1 não configurado",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dias := diasRestantes(cert.Validade, time.Now())
+	status := &CertificateStatus{Certificado: &cert, DiasRestantes: dias}
+	switch {
+	case CheckCertificateValid(&cert, time.Now()) != nil:
+		status.Situacao = CertSituacaoExpirado
+		status.EmissaoBloqueada = true
+		status.Mensagem = fmt.Sprintf("Certificado expirado em %s. A emissão de NFS-e está bloqueada.", cert.Validade.Format("02/01/2006"))
+	case dias <= certAvisoDias[0]:
+		status.Situacao = CertSituacaoExpirando
+		status.Mensagem = fmt.Sprintf("Certificado expira em %d dia(s), em %s. Renove-o para não interromper a emissão de NFS-e.", dias, cert.Validade.Format("02/01/2006"))
+	default:
+		status.Situacao = CertSituacaoValido
+		status.Mensagem = fmt.Sprintf("Certificado válido até %s", cert.Validade.Format("02/01/2006"))
+	}
+	return status, nil
+}
+
+// Check sends the pending expiry warnings and returns how many certificates were warned
+func (m *CertificateMonitor) Check() int {
+	var certs []domain.CertificadoDigital
+	limit := time.Now().AddDate(0, 0, certAvisoDias[0]+1)
+	if err := m.db.Where("ativo = ? AND validade < ?", true, limit).Find(&certs).Error; err != nil {
+		log.Printf("⚠️ Erro ao verificar validade dos certificados: %v", err)
+		return 0
+	}
+
+	count := 0
+	for i := range certs {
+		cert := &certs[i]
+		etapa, ok := etapaAviso(cert.Validade, time.Now())
+		if !ok || (cert.AvisoExpiracaoDias != nil && *cert.AvisoExpiracaoDias <= etapa) {
+			continue
+		}
+
+		// Record the stage first so a failing mail server never repeats the warning every cycle
+		if err := m.db.Model(cert).Update("aviso_expiracao_dias", etapa).Error; err != nil {
+			log.Printf("⚠️ Erro ao registrar aviso do certificado %s: %v", cert.ID, err)
+			continue
+		}
+		m.avisar(cert, etapa)
+		count++
+	}
+	return count
+}
+
+// avisar notifies and emails the users who receive the notices of the provider
+func (m *CertificateMonitor) avisar(cert *domain.CertificadoDigital, etapa int) {
+	title := "Certificado digital expirando"
+	notifType := "WARNING"
+	message := fmt.Sprintf("O certificado A1 %s expira em %d dia(s), em %s. Envie um novo certificado para não interromper a emissão de NFS-e.",
+		cert.Titular, diasRestantes(cert.Validade, time.Now()), cert.Validade.Format("02/01/2006"))
+	if etapa == 0 {
+		title = "Certificado digital expirado"
+		notifType = "ERROR"
+		message = fmt.Sprintf("O certificado A1 %s expirou em %s. A emissão de NFS-e está bloqueada até o envio de um novo certificado.",
+			cert.Titular, cert.Validade.Format("02/01/2006"))
+	}
+	m.notifications.NotifyCompanyAdmins(cert.PrestadorID, title, message, notifType, "/admin/fiscal")
+
+	admins := m.notifications.CompanyAdmins(cert.PrestadorID)
+	for _, admin := range admins {
+		if admin.Email == "" {
+			continue
+		}
+		go m.email.SendCertificateExpiry(admin.Email, admin.Name, cert.Titular, cert.Validade, etapa)
+	}
+	log.Printf("🔏 Aviso de expiração (%d dia(s)) do certificado %s enviado a %d administrador(es)", etapa, cert.ID, len(admins))
+}
+
+// Run checks certificate expiry periodically. It blocks.
+func (m *CertificateMonitor) Run() {
+	if m.interval <= 0 {
+		m.interval = 24 * time.Hour
+	}
+	log.Printf("🔏 Monitor de certificados digitais iniciado (intervalo %s)", m.interval)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		if n := m.Check(); n > 0 {
+			log.Printf("🔏 %d aviso(s) de expiração de certificado enviado(s)", n)
+		}
+		<-ticker.C
+	}
+}
+
+// etapaAviso returns the warning stage reached by a certificate: the smallest threshold not
+// below the remaining days, or 0 once expired
+func etapaAviso(validade, now time.Time) (int, bool) {
+	if now.After(validade) {
+		return 0, true
+	}
+	dias := diasRestantes(validade, now)
+	etapa, ok := 0, false
+	for _, d := range certAvisoDias {
+		if dias <= d {
+			etapa, ok = d, true
+		}
+	}
+	return etapa, ok
+}
+
+// diasRestantes counts the days left until expiry, rounding partial days up
+func diasRestantes(validade, now time.Time) int {
+	return int(math.Ceil(validade.Sub(now).Hours() / 24))
+}
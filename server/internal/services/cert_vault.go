@@ -0,0 +1,229 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+)
+
+// CertVault keeps the PKCS#12 bundles and their passwords encrypted with AES-256-GCM under
+// a server master key, in a directory that is never served over HTTP
+type CertVault struct {
+	db      *gorm.DB
+	storage *StorageService
+	dir     string
+	keys    map[string][]byte
+	current string
+}
+
+// NewCertVault creates the certificate vault; the first configured master key encrypts new data
+func NewCertVault(db *gorm.DB, storage *StorageService, cfg *config.Config) *CertVault {
+	v := &CertVault{db: db, storage: storage, dir: cfg.CertDir, keys: map[string][]byte{}}
+	for i, k := range cfg.CertMasterKeys {
+		if i == 0 {
+			v.current = k.ID
+		}
+		v.keys[k.ID] = k.Key
+	}
+	os.MkdirAll(v.dir, 0700)
+	return v
+}
+
+// Store encrypts the bundle and password into the vault and fills the storage fields of cert
+func (v *CertVault) Store(cert *domain.CertificadoDigital, pfx []byte, senha string) error {
+	key := v.keys[v.current]
+	data, err := seal(key, pfx, cert.ID)
+	if err != nil {
+		return err
+	}
+	encSenha, err := seal(key, []byte(senha), cert.ID)
+	if err != nil {
+		return err
+	}
+
+	// One file per key, so a rotation interrupted before the row is updated leaves the old file intact
+	path := filepath.Join(cert.PrestadorID, cert.ID+"."+v.current+".p12.enc")
+	if err := v.write(path, data); err != nil {
+		return err
+	}
+	cert.CertPath = path
+	cert.Senha = base64.StdEncoding.EncodeToString(encSenha)
+	cert.ChaveID = v.current
+	return nil
+}
+
+// Load decrypts and decodes a stored certificate
+func (v *CertVault) Load(cert *domain.CertificadoDigital) (*A1Certificate, error) {
+	pfx, senha, err := v.open(cert)
+	if err != nil {
+		return nil, err
+	}
+	return LoadA1Certificate(pfx, senha)
+}
+
+// Remove deletes the encrypted bundle of a certificate
+func (v *CertVault) Remove(cert *domain.CertificadoDigital) {
+	if cert.ChaveID == "" || cert.CertPath == "" {
+		return
+	}
+	if err := os.Remove(v.path(cert.CertPath)); err != nil && !os.IsNotExist(err) {
+		log.Printf("⚠️ Erro ao remover certificado %s: %v", cert.ID, err)
+	}
+}
+
+// Rotate re-encrypts every certificate not yet under the current master key. Certificates
+// uploaded before the vault existed are moved out of the public upload tree.
+func (v *CertVault) Rotate() (int, error) {
+	var certs []domain.CertificadoDigital
+	if err := v.db.Where("chave_id IS NULL OR chave_id <> ?", v.current).Find(&certs).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, cert := range certs {
+		pfx, senha, err := v.open(&cert)
+		if err != nil {
+			log.Printf("⚠️ Certificado %s não pôde ser recifrado: %v", cert.ID, err)
+			continue
+		}
+
+		old := cert
+		if err := v.Store(&cert, pfx, senha); err != nil {
+			return count, err
+		}
+		updates := map[string]interface{}{
+			"cert_path": cert.CertPath,
+			"senha":     cert.Senha,
+			"chave_id":  cert.ChaveID,
+		}
+		// Legacy rows carry a made-up validity; take the real one from the certificate
+		if old.ChaveID == "" {
+			if a1, err := LoadA1Certificate(pfx, senha); err == nil {
+				updates["valido_desde"] = a1.Certificate.NotBefore
+				updates["validade"] = a1.Certificate.NotAfter
+				updates["titular"] = a1.Certificate.Subject.CommonName
+				updates["cnpj"] = a1.CNPJ()
+				updates["emissor"] = a1.Certificate.Issuer.CommonName
+				updates["numero_serie"] = strings.ToUpper(a1.Certificate.SerialNumber.Text(16))
+				updates["impressao"] = a1.Fingerprint()
+			}
+		}
+		if err := v.db.Model(&domain.CertificadoDigital{}).Where("id = ?", cert.ID).Updates(updates).Error; err != nil {
+			return count, err
+		}
+		if old.ChaveID == "" {
+			v.storage.Delete(old.CertPath)
+		} else {
+			v.Remove(&old)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// open returns the plain bundle and password of a certificate
+func (v *CertVault) open(cert *domain.CertificadoDigital) ([]byte, string, error) {
+	// Legacy rows: plain file under the upload tree and plain password
+	if cert.ChaveID == "" {
+		pfx, err := os.ReadFile(v.storage.Path(cert.CertPath))
+		if err != nil {
+			return nil, "", fmt.Errorf("erro ao ler certificado: %w", err)
+		}
+		return pfx, cert.Senha, nil
+	}
+
+	key, ok := v.keys[cert.ChaveID]
+	if !ok {
+		return nil, "", fmt.Errorf("chave mestra %q do certificado não configurada", cert.ChaveID)
+	}
+	data, err := os.ReadFile(v.path(cert.CertPath))
+	if err != nil {
+		return nil, "", fmt.Errorf("erro ao ler certificado: %w", err)
+	}
+	pfx, err := unseal(key, data, cert.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	encSenha, err := base64.StdEncoding.DecodeString(cert.Senha)
+	if err != nil {
+		return nil, "", errors.New("senha do certificado corrompida")
+	}
+	senha, err := unseal(key, encSenha, cert.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return pfx, string(senha), nil
+}
+
+// path resolves a vault-relative path, refusing anything that escapes the vault directory
+func (v *CertVault) path(rel string) string {
+	return filepath.Join(v.dir, filepath.Clean("/"+rel))
+}
+
+// write stores a file atomically so an interrupted rotation never leaves a truncated bundle
+func (v *CertVault) write(rel string, data []byte) error {
+	full := v.path(rel)
+	if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+		return err
+	}
+	tmp := full + "." + randomHex(4) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, full)
+}
+
+// seal encrypts with AES-256-GCM, binding the ciphertext to the certificate ID; output is nonce||ciphertext
+func seal(key, plain []byte, id string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plain, []byte(id)), nil
+}
+
+func unseal(key, data []byte, id string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("certificado cifrado corrompido")
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, errors.New("não foi possível decifrar o certificado com a chave mestra")
+	}
+	return plain, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
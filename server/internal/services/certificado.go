@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidICPBrasilCNPJ  = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 3} // otherName carrying the CNPJ of an e-CNPJ
+)
+
+// A1Certificate is a PKCS#12 (A1) certificate loaded in memory
+type A1Certificate struct {
+	Certificate *x509.Certificate
+	PrivateKey  *rsa.PrivateKey
+	Chain       []*x509.Certificate // intermediate certificates shipped in the file
+}
+
+// LoadA1Certificate decodes a PKCS#12 file and returns the RSA key with its certificate
+func LoadA1Certificate(data []byte, password string) (*A1Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return nil, errors.New("senha do certificado incorreta")
+		}
+		return nil, fmt.Errorf("certificado PKCS#12 inválido: %w", err)
+	}
+
+	cert := &A1Certificate{}
+	var certs []*x509.Certificate
+	for _, block := range blocks {
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.New("o certificado deve conter uma chave RSA")
+			}
+			cert.PrivateKey = key
+		case "CERTIFICATE":
+			c, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("certificado X.509 inválido: %w", err)
+			}
+			certs = append(certs, c)
+		}
+	}
+	if cert.PrivateKey == nil {
+		return nil, errors.New("chave privada não encontrada no certificado")
+	}
+
+	// The end-entity certificate is the one matching the private key
+	pub, err := x509.MarshalPKIXPublicKey(&cert.PrivateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range certs {
+		if cert.Certificate == nil && bytes.Equal(c.RawSubjectPublicKeyInfo, pub) {
+			cert.Certificate = c
+			continue
+		}
+		cert.Chain = append(cert.Chain, c)
+	}
+	if cert.Certificate == nil {
+		return nil, errors.New("certificado correspondente à chave privada não encontrado")
+	}
+
+	return cert, nil
+}
+
+// LoadA1CertificateFile reads and decodes a PKCS#12 file from disk
+func LoadA1CertificateFile(path, password string) (*A1Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao ler certificado: %w", err)
+	}
+	return LoadA1Certificate(data, password)
+}
+
+// TLSCertificate returns the certificate as a TLS client credential
+func (c *A1Certificate) TLSCertificate() tls.Certificate {
+	chain := [][]byte{c.Certificate.Raw}
+	for _, ca := range c.Chain {
+		chain = append(chain, ca.Raw)
+	}
+	return tls.Certificate{Certificate: chain, PrivateKey: c.PrivateKey, Leaf: c.Certificate}
+}
+
+// CNPJ returns the CNPJ recorded by ICP-Brasil in the subject alternative name, or "" when absent
+func (c *A1Certificate) CNPJ() string {
+	for _, ext := range c.Certificate.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var names []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return ""
+		}
+		for _, name := range names {
+			// otherName ::= [0] IMPLICIT SEQUENCE { type-id OID, value [0] EXPLICIT ANY }
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+			var other struct {
+				TypeID asn1.ObjectIdentifier
+				Value  asn1.RawValue `asn1:"explicit,tag:0"`
+			}
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &other, "tag:0"); err != nil || !other.TypeID.Equal(oidICPBrasilCNPJ) {
+				continue
+			}
+			// The value is an OCTET STRING or a printable/UTF-8 string depending on the AC
+			var value asn1.RawValue
+			if _, err := asn1.Unmarshal(other.Value.Bytes, &value); err != nil {
+				return ""
+			}
+			return onlyDigits(string(value.Bytes))
+		}
+	}
+	return ""
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the certificate
+func (c *A1Certificate) Fingerprint() string {
+	sum := sha256.Sum256(c.Certificate.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// ValidateECNPJ checks that the certificate is a valid ICP-Brasil e-CNPJ of the given company
+func (c *A1Certificate) ValidateECNPJ(cnpj string, now time.Time) error {
+	if !isICPBrasil(c.Certificate.Issuer.Organization) {
+		return errors.New("o certificado não foi emitido por uma autoridade certificadora ICP-Brasil")
+	}
+	certCNPJ := c.CNPJ()
+	if certCNPJ == "" {
+		return errors.New("o certificado não é um e-CNPJ (CNPJ ausente)")
+	}
+	if certCNPJ != onlyDigits(cnpj) {
+		return fmt.Errorf("o certificado pertence ao CNPJ %s, diferente do CNPJ da empresa", certCNPJ)
+	}
+	if now.Before(c.Certificate.NotBefore) {
+		return fmt.Errorf("o certificado só é válido a partir de %s", c.Certificate.NotBefore.Format("02/01/2006"))
+	}
+	if now.After(c.Certificate.NotAfter) {
+		return fmt.Errorf("o certificado expirou em %s", c.Certificate.NotAfter.Format("02/01/2006"))
+	}
+	if c.Certificate.KeyUsage != 0 && c.Certificate.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return errors.New("o certificado não permite assinatura digital")
+	}
+	return nil
+}
+
+func isICPBrasil(organizations []string) bool {
+	for _, o := range organizations {
+		if strings.EqualFold(strings.TrimSpace(o), "ICP-Brasil") {
+			return true
+		}
+	}
+	return false
+}
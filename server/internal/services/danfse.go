@@ -1,18 +1,29 @@
 package services
 
 import (
+	"bytes"
 	"encoding/base64"
 	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"strings"
 	"time"
 
+	qrcode "github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+
 	"inovar/internal/domain"
-	"inovar/internal/infra/bridge"
-	"inovar/internal/infra/config"
+	"inovar/internal/infra/pdf"
 )
 
+// Public consultation of the NFS-e Nacional, encoded in the DANFS-e QR code
+const danfseConsultaURL = "https://www.nfse.gov.br/ConsultaPublica/?tpc=1&chave="
+
 // DANFSeService generates DANFS-e (Documento Auxiliar da NFS-e)
 type DANFSeService struct {
-	config *config.Config
+	db      *gorm.DB
+	storage *StorageService
 }
 
 // DANFSeData contains all data needed to generate DANFS-e
@@ -26,7 +37,7 @@ type DANFSeData struct {
 	PrestadorUF       string
 	PrestadorTelefone string
 	PrestadorEmail    string
-	PrestadorLogo     string // Base64 or URL
+	PrestadorLogo     []byte `json:"-"` // image bytes, nil when the provider has no logo
 
 	// NFS-e Info
 	NumeroNFSe        string
@@ -34,7 +45,11 @@ type DANFSeData struct {
 	DataEmissao       string
 	DataCompetencia   string
 	ChaveAcesso       string
+	SerieDPS          string
+	NumeroDPS         string
 	Ambiente          string
+	Homologacao       bool
+	Substituida       string // access key of the note replaced by this one
 
 	// Tomador (Cliente)
 	TomadorNome      string
@@ -45,9 +60,10 @@ type DANFSeData struct {
 	TomadorEmail     string
 
 	// Serviço
-	Discriminacao string
-	CodigoServico string
-	CNAE          string
+	Discriminacao  string
+	CodigoServico  string
+	CNAE           string
+	LocalPrestacao string
 
 	// Valores
 	ValorServicos string
@@ -63,88 +79,492 @@ type DANFSeData struct {
 	ValorINSS     string
 
 	// Extras
-	LinkVerificacao   string
-	QRCodeVerificacao string
+	LinkVerificacao string
 }
 
 // NewDANFSeService creates a new DANFS-e generator
-func NewDANFSeService(cfg *config.Config) *DANFSeService {
-	return &DANFSeService{config: cfg}
+func NewDANFSeService(db *gorm.DB, storage *StorageService) *DANFSeService {
+	return &DANFSeService{db: db, storage: storage}
 }
 
-// Generate creates the DANFS-e HTML that can be converted to PDF
-func (s *DANFSeService) Generate(nfse *domain.NotaFiscal, prestador *domain.Prestador, tomador *domain.Cliente) (string, error) {
-	// Build data
-	data := DANFSeData{
+// Data gathers the DANFS-e contents of an issued note
+func (s *DANFSeService) Data(nfse *domain.NotaFiscal) (*DANFSeData, error) {
+	var prestador domain.Prestador
+	if err := s.db.Preload("Endereco").First(&prestador, "id = ?", nfse.PrestadorID).Error; err != nil {
+		return nil, fmt.Errorf("prestador não encontrado")
+	}
+	var fiscal domain.ConfiguracaoFiscal
+	s.db.Where("prestador_id = ?", nfse.PrestadorID).First(&fiscal)
+	var solicitacao domain.Solicitacao
+	s.db.Preload("Client").Preload("Client.Endereco").First(&solicitacao, "id = ?", nfse.SolicitacaoID)
+
+	ambiente := nfse.Ambiente
+	if ambiente == "" {
+		ambiente = Ambiente(&fiscal)
+	}
+
+	data := &DANFSeData{
 		PrestadorNome:     prestador.RazaoSocial,
 		PrestadorCNPJ:     formatCNPJ(prestador.CNPJ),
+		PrestadorIM:       fiscal.InscricaoMunicipal,
 		PrestadorEndereco: buildEndereco(prestador.Endereco),
-		PrestadorLogo:     prestador.LogoURL,
+		PrestadorTelefone: prestador.Phone,
+		PrestadorEmail:    prestador.Email,
+		PrestadorLogo:     s.logo(prestador.LogoURL),
 
 		NumeroNFSe:        nfse.Numero,
 		CodigoVerificacao: nfse.CodigoVerificacao,
 		DataEmissao:       formatDate(nfse.DataEmissao),
-		DataCompetencia:   formatDate(&nfse.DataCompetencia),
-		ChaveAcesso:       nfse.XMLPath, // Stored as access key
+		DataCompetencia:   nfse.DataCompetencia.Format("01/2006"),
+		ChaveAcesso:       nfse.ChaveAcesso,
+		SerieDPS:          nfse.SerieDPS,
+		Ambiente:          "Produção",
+		Homologacao:       ambiente != AmbienteProducao,
 
 		TomadorNome:      nfse.TomadorNome,
 		TomadorDocumento: formatDocument(nfse.TomadorDocumento),
 		TomadorEndereco:  nfse.TomadorEndereco,
+		TomadorEmail:     solicitacao.Client.Email,
 
 		Discriminacao: nfse.Discriminacao,
 		CodigoServico: nfse.CodigoServico,
+		CNAE:          nfse.CNAE,
 
 		ValorServicos: formatMoney(nfse.ValorServicos),
 		ValorDeducoes: formatMoney(nfse.ValorDeducoes),
 		ValorLiquido:  formatMoney(nfse.ValorLiquido),
 		AliquotaISS:   fmt.Sprintf("%.2f%%", nfse.AliquotaISS),
 		ValorISS:      formatMoney(nfse.ValorISS),
+		ISSRetido:     fiscal.ISSRetido,
 		ValorPIS:      formatMoney(nfse.ValorPIS),
 		ValorCOFINS:   formatMoney(nfse.ValorCOFINS),
 		ValorCSLL:     formatMoney(nfse.ValorCSLL),
 		ValorIR:       formatMoney(nfse.ValorIR),
 		ValorINSS:     formatMoney(nfse.ValorINSS),
-		CNAE:          nfse.CNAE,
 
-		Ambiente:        "Produção",
-		LinkVerificacao: fmt.Sprintf("https://nfse.gov.br/consulta?chave=%s", nfse.CodigoVerificacao),
+		LinkVerificacao: danfseConsultaURL + nfse.ChaveAcesso,
+	}
+	if data.Homologacao {
+		data.Ambiente = "Homologação - sem valor jurídico"
+	}
+	if nfse.NumeroDPS > 0 {
+		data.NumeroDPS = fmt.Sprintf("%d", nfse.NumeroDPS)
+	}
+	if prestador.Endereco != nil {
+		data.PrestadorCidade, data.PrestadorUF = prestador.Endereco.City, prestador.Endereco.State
+	}
+	if end := solicitacao.Client.Endereco; end != nil {
+		if data.TomadorEndereco == "" {
+			data.TomadorEndereco = buildEndereco(end)
+		}
+		data.TomadorCidade, data.TomadorUF = end.City, end.State
+	}
+	if m := Municipios().Endereco(solicitacao.Client.Endereco); m != nil {
+		data.LocalPrestacao = fmt.Sprintf("%s/%s", m.Nome, m.UF)
+	} else if data.PrestadorCidade != "" {
+		data.LocalPrestacao = fmt.Sprintf("%s/%s", data.PrestadorCidade, data.PrestadorUF)
+	}
+	if nfse.SubstituiID != "" {
+		var original domain.NotaFiscal
+		if s.db.Select("chave_acesso").First(&original, "id = ?", nfse.SubstituiID).Error == nil {
+			data.Substituida = original.ChaveAcesso
+		}
+	}
+	return data, nil
+}
+
+// PDF returns the DANFS-e of an issued note, rendering and storing it next to the XML on first use
+func (s *DANFSeService) PDF(nfse *domain.NotaFiscal) ([]byte, error) {
+	if nfse.PDFPath != "" {
+		if data, err := s.storage.ReadFiscal(nfse.PDFPath); err == nil {
+			return data, nil
+		}
 	}
 
-	// Generate HTML from template
-	html, err := s.renderHTML(data)
+	data, err := s.Data(nfse)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	out, err := RenderDANFSePDF(data)
+	if err != nil {
+		return nil, err
 	}
 
-	return html, nil
+	path, err := s.storage.SaveFiscal(fmt.Sprintf("nfse/%s/%s.pdf", nfse.PrestadorID, nfse.ChaveAcesso), out)
+	if err != nil {
+		return nil, err
+	}
+	nfse.PDFPath = path
+	if err := s.db.Model(&domain.NotaFiscal{}).Where("id = ?", nfse.ID).Update("pdf_path", path).Error; err != nil {
+		log.Printf("⚠️ Falha ao registrar DANFS-e da NFS-e %s: %v", nfse.ID, err)
+	}
+	return out, nil
 }
 
-// SavePDF generates the DANFS-e HTML (PDF conversion can be done client-side)
-func (s *DANFSeService) SavePDF(nfse *domain.NotaFiscal, prestador *domain.Prestador, tomador *domain.Cliente) (string, error) {
-	html, err := s.Generate(nfse, prestador, tomador)
+// HTML renders the DANFS-e of an issued note for printing in the browser
+func (s *DANFSeService) HTML(nfse *domain.NotaFiscal) (string, error) {
+	data, err := s.Data(nfse)
 	if err != nil {
 		return "", err
 	}
+	return RenderDANFSeHTML(data)
+}
+
+// logo loads the provider logo from a data URI or the upload tree
+func (s *DANFSeService) logo(url string) []byte {
+	switch {
+	case url == "":
+		return nil
+	case strings.HasPrefix(url, "data:"):
+		if i := strings.Index(url, ";base64,"); i > 0 {
+			data, err := base64.StdEncoding.DecodeString(url[i+len(";base64,"):])
+			if err == nil {
+				return data
+			}
+		}
+		return nil
+	case strings.HasPrefix(url, "/uploads/"):
+		data, err := os.ReadFile(s.storage.Path(url))
+		if err != nil {
+			log.Printf("⚠️ Logo do prestador indisponível para o DANFS-e: %v", err)
+			return nil
+		}
+		return data
+	}
+	return nil
+}
+
+// danfseLayout keeps the PDF cursor of the DANFS-e
+type danfseLayout struct {
+	doc  *pdf.Document
+	y    float64
+	data *DANFSeData
+}
+
+const (
+	danfseMargin = 28.0
+	danfseWidth  = pdf.PageWidth - 2*danfseMargin
+	danfseBottom = pdf.PageHeight - 40
+)
+
+// page starts a page, painting the homologation watermark under the contents
+func (l *danfseLayout) page() {
+	l.doc.AddPage()
+	l.y = danfseMargin
+	if l.data.Homologacao {
+		l.doc.SetColor(226, 232, 240)
+		l.doc.SetFont(pdf.Bold, 64)
+		l.doc.TextRotated(pdf.PageWidth/2, pdf.PageHeight/2, 55, "SEM VALOR FISCAL")
+		l.doc.SetFont(pdf.Bold, 22)
+		l.doc.TextRotated(pdf.PageWidth/2+46, pdf.PageHeight/2+40, 55, "AMBIENTE DE HOMOLOGAÇÃO")
+	}
+	l.doc.SetColor(15, 23, 42)
+	l.doc.SetStrokeColor(100, 116, 139)
+}
+
+func (l *danfseLayout) ensure(h float64) {
+	if l.y+h > danfseBottom {
+		l.page()
+	}
+}
+
+func (l *danfseLayout) section(title string) {
+	l.ensure(32)
+	l.y += 6
+	l.doc.SetColor(226, 232, 240)
+	l.doc.Rect(danfseMargin, l.y, danfseWidth, 13, true)
+	l.doc.SetColor(15, 23, 42)
+	l.doc.SetLineWidth(0.5)
+	l.doc.Rect(danfseMargin, l.y, danfseWidth, 13, false)
+	l.doc.SetFont(pdf.Bold, 8)
+	l.doc.Text(danfseMargin+4, l.y+9.5, title)
+	l.y += 13
+}
+
+// cells draws a row of boxes with a small label over a wrapped value. widths are fractions of the page.
+func (l *danfseLayout) cells(widths []float64, labels, values []string) {
+	l.doc.SetFont(pdf.Regular, 8.5)
+	wrapped := make([][]string, len(values))
+	lines := 1
+	for i, v := range values {
+		if v == "" {
+			v = "-"
+		}
+		wrapped[i] = l.doc.WrapText(v, widths[i]*danfseWidth-8)
+		if len(wrapped[i]) > lines {
+			lines = len(wrapped[i])
+		}
+	}
+	h := 12 + float64(lines)*10
+	l.ensure(h)
 
-	// Return HTML content directly (no local file storage)
-	// PDF conversion can be handled client-side or via a cloud service
-	return html, nil
+	x := danfseMargin
+	for i := range values {
+		w := widths[i] * danfseWidth
+		l.doc.SetLineWidth(0.5)
+		l.doc.Rect(x, l.y, w, h, false)
+		l.doc.SetFont(pdf.Regular, 6)
+		l.doc.SetColor(71, 85, 105)
+		l.doc.Text(x+4, l.y+7, strings.ToUpper(labels[i]))
+		l.doc.SetColor(15, 23, 42)
+		l.doc.SetFont(pdf.Bold, 8.5)
+		for j, line := range wrapped[i] {
+			l.doc.Text(x+4, l.y+17+float64(j)*10, line)
+		}
+		x += w
+	}
+	l.y += h
+}
+
+// text draws a long wrapped paragraph inside a box, breaking pages as needed
+func (l *danfseLayout) text(s string) {
+	l.doc.SetFont(pdf.Regular, 8.5)
+	lines := l.doc.WrapText(s, danfseWidth-8)
+	for len(lines) > 0 {
+		l.ensure(20)
+		n := int((danfseBottom - l.y - 6) / 10)
+		if n > len(lines) {
+			n = len(lines)
+		}
+		h := float64(n)*10 + 6
+		l.doc.SetLineWidth(0.5)
+		l.doc.Rect(danfseMargin, l.y, danfseWidth, h, false)
+		l.doc.SetFont(pdf.Regular, 8.5)
+		for j, line := range lines[:n] {
+			l.doc.Text(danfseMargin+4, l.y+11+float64(j)*10, line)
+		}
+		l.y += h
+		lines = lines[n:]
+	}
 }
 
-func (s *DANFSeService) renderHTML(data DANFSeData) (string, error) {
-	resp, err := bridge.CallPython("render_danfse", map[string]interface{}{
-		"data": data,
-	})
+// qrCode draws the verification QR code as vector modules with its top-left corner at (x, y)
+func (l *danfseLayout) qrCode(content string, x, y, size float64) error {
+	qr, err := qrcode.New(content, qrcode.Medium)
 	if err != nil {
-		return "", err
+		return err
 	}
+	qr.DisableBorder = true
+	bitmap := qr.Bitmap()
+	module := size / float64(len(bitmap))
 
-	html, ok := resp.Data["html"].(string)
-	if !ok {
-		return "", fmt.Errorf("invalid response from bridge: missing html")
+	l.doc.SetColor(0, 0, 0)
+	for row, cols := range bitmap {
+		// One rectangle per horizontal run of dark modules
+		for col := 0; col < len(cols); col++ {
+			if !cols[col] {
+				continue
+			}
+			start := col
+			for col+1 < len(cols) && cols[col+1] {
+				col++
+			}
+			l.doc.Rect(x+float64(start)*module, y+float64(row)*module, float64(col-start+1)*module, module, true)
+		}
+	}
+	l.doc.SetColor(15, 23, 42)
+	return nil
+}
+
+// RenderDANFSePDF renders the DANFS-e layout as PDF
+func RenderDANFSePDF(data *DANFSeData) ([]byte, error) {
+	doc := pdf.New()
+	doc.SetTitle("DANFS-e " + data.NumeroNFSe)
+	l := &danfseLayout{doc: doc, data: data}
+	l.page()
+
+	// Header: logo, title and QR code
+	const headerH = 92.0
+	doc.SetLineWidth(1)
+	doc.Rect(danfseMargin, l.y, danfseWidth, headerH, false)
+	if len(data.PrestadorLogo) > 0 {
+		if img, err := doc.AddImage(data.PrestadorLogo); err == nil {
+			w, h := doc.ImageSize(img)
+			scale := 72.0 / float64(w)
+			if s := 60.0 / float64(h); s < scale {
+				scale = s
+			}
+			doc.DrawImage(img, danfseMargin+8, l.y+(headerH-float64(h)*scale)/2, float64(w)*scale, float64(h)*scale)
+		}
+	}
+	center := danfseMargin + danfseWidth/2 - 20
+	doc.SetFont(pdf.Bold, 15)
+	doc.TextCenter(center, l.y+26, "DANFS-e")
+	doc.SetFont(pdf.Regular, 8.5)
+	doc.TextCenter(center, l.y+39, "Documento Auxiliar da Nota Fiscal de Serviço Eletrônica")
+	doc.SetFont(pdf.Bold, 10)
+	doc.TextCenter(center, l.y+56, "NFS-e nº "+data.NumeroNFSe)
+	doc.SetFont(pdf.Regular, 8)
+	if data.Homologacao {
+		doc.SetColor(185, 28, 28)
+		doc.SetFont(pdf.Bold, 8)
+	}
+	doc.TextCenter(center, l.y+70, "Ambiente: "+data.Ambiente)
+	doc.SetColor(15, 23, 42)
+	if err := l.qrCode(data.LinkVerificacao, danfseMargin+danfseWidth-84, l.y+8, 76); err != nil {
+		return nil, err
 	}
+	l.y += headerH
 
-	return html, nil
+	l.cells([]float64{1}, []string{"Chave de acesso da NFS-e"}, []string{formatChave(data.ChaveAcesso)})
+	dps := data.NumeroDPS
+	if data.SerieDPS != "" && dps != "" {
+		dps = data.SerieDPS + " / " + dps
+	}
+	l.cells([]float64{0.2, 0.2, 0.3, 0.3},
+		[]string{"Número da NFS-e", "Competência", "Data e hora da emissão", "Série / número da DPS"},
+		[]string{data.NumeroNFSe, data.DataCompetencia, data.DataEmissao, dps})
+
+	l.section("EMITENTE DA NFS-e")
+	l.cells([]float64{0.55, 0.25, 0.2},
+		[]string{"Nome / razão social", "CNPJ", "Inscrição municipal"},
+		[]string{data.PrestadorNome, data.PrestadorCNPJ, data.PrestadorIM})
+	l.cells([]float64{0.55, 0.2, 0.25},
+		[]string{"Endereço", "Telefone", "E-mail"},
+		[]string{data.PrestadorEndereco, data.PrestadorTelefone, data.PrestadorEmail})
+
+	l.section("TOMADOR DO SERVIÇO")
+	l.cells([]float64{0.55, 0.25, 0.2},
+		[]string{"Nome / razão social", "CPF / CNPJ", "Município"},
+		[]string{data.TomadorNome, data.TomadorDocumento, joinCidade(data.TomadorCidade, data.TomadorUF)})
+	l.cells([]float64{0.7, 0.3},
+		[]string{"Endereço", "E-mail"},
+		[]string{data.TomadorEndereco, data.TomadorEmail})
+
+	l.section("SERVIÇO PRESTADO")
+	l.cells([]float64{0.3, 0.2, 0.5},
+		[]string{"Código de tributação nacional", "CNAE", "Local da prestação"},
+		[]string{data.CodigoServico, data.CNAE, data.LocalPrestacao})
+	l.section("DISCRIMINAÇÃO DO SERVIÇO")
+	l.text(data.Discriminacao)
+
+	l.section("TRIBUTAÇÃO MUNICIPAL")
+	retido := "Não"
+	if data.ISSRetido {
+		retido = "Sim"
+	}
+	l.cells([]float64{0.25, 0.25, 0.2, 0.15, 0.15},
+		[]string{"Valor do serviço", "Deduções", "ISSQN apurado", "Alíquota", "ISS retido"},
+		[]string{data.ValorServicos, data.ValorDeducoes, data.ValorISS, data.AliquotaISS, retido})
+
+	l.section("RETENÇÕES FEDERAIS")
+	l.cells([]float64{0.2, 0.2, 0.2, 0.2, 0.2},
+		[]string{"IRRF", "PIS", "COFINS", "CSLL", "CP (INSS)"},
+		[]string{data.ValorIR, data.ValorPIS, data.ValorCOFINS, data.ValorCSLL, data.ValorINSS})
+
+	l.ensure(30)
+	doc.SetColor(241, 245, 249)
+	doc.Rect(danfseMargin, l.y, danfseWidth, 22, true)
+	doc.SetColor(15, 23, 42)
+	doc.Rect(danfseMargin, l.y, danfseWidth, 22, false)
+	doc.SetFont(pdf.Bold, 11)
+	doc.Text(danfseMargin+6, l.y+15, "VALOR LÍQUIDO DA NFS-e")
+	doc.TextRight(danfseMargin+danfseWidth-6, l.y+15, data.ValorLiquido)
+	l.y += 22
+
+	l.section("INFORMAÇÕES COMPLEMENTARES")
+	info := "Consulte a autenticidade desta NFS-e em " + data.LinkVerificacao
+	if data.CodigoVerificacao != "" {
+		info += "\nCódigo de verificação: " + data.CodigoVerificacao
+	}
+	if data.Substituida != "" {
+		info += "\nEsta NFS-e substitui a NFS-e de chave de acesso " + formatChave(data.Substituida)
+	}
+	if data.Homologacao {
+		info += "\nNFS-e emitida em ambiente de homologação, sem valor jurídico."
+	}
+	l.text(info)
+
+	return doc.Bytes()
+}
+
+var danfseTemplate = template.Must(template.New("danfse").Funcs(template.FuncMap{"chave": formatChave, "cidade": joinCidade}).Parse(`<!DOCTYPE html>
+<html lang="pt-BR">
+<head>
+<meta charset="utf-8">
+<title>DANFS-e {{.Data.NumeroNFSe}}</title>
+<style>
+body { font-family: Arial, Helvetica, sans-serif; font-size: 11px; color: #0f172a; margin: 24px; position: relative; }
+table { width: 100%; border-collapse: collapse; }
+td { border: 1px solid #64748b; padding: 3px 5px; vertical-align: top; }
+td small { display: block; font-size: 8px; color: #475569; text-transform: uppercase; }
+td b { font-size: 11px; }
+.header td { border-width: 2px; }
+.section { background: #e2e8f0; font-weight: bold; font-size: 10px; margin-top: 6px; border: 1px solid #64748b; padding: 2px 5px; }
+.total { background: #f1f5f9; font-size: 14px; font-weight: bold; }
+.homolog { color: #b91c1c; font-weight: bold; }
+.watermark { position: fixed; top: 40%; left: 0; width: 100%; text-align: center; font-size: 72px; font-weight: bold; color: rgba(148, 163, 184, .25); transform: rotate(-35deg); z-index: -1; }
+.discriminacao { white-space: pre-wrap; }
+@media print { body { margin: 0; } }
+</style>
+</head>
+<body>
+{{with .Data}}
+{{if .Homologacao}}<div class="watermark">SEM VALOR FISCAL</div>{{end}}
+<table class="header"><tr>
+<td style="width:90px;text-align:center;vertical-align:middle">{{if $.Logo}}<img src="{{$.Logo}}" alt="" style="max-width:80px;max-height:60px">{{end}}</td>
+<td style="text-align:center;vertical-align:middle"><div style="font-size:18px;font-weight:bold">DANFS-e</div>Documento Auxiliar da Nota Fiscal de Serviço Eletrônica<div style="font-size:13px;font-weight:bold;margin-top:6px">NFS-e nº {{.NumeroNFSe}}</div><div{{if .Homologacao}} class="homolog"{{end}}>Ambiente: {{.Ambiente}}</div></td>
+<td style="width:110px;text-align:center"><img src="{{$.QRCode}}" alt="QR Code" style="width:100px;height:100px"></td>
+</tr></table>
+<table>
+<tr><td colspan="4"><small>Chave de acesso da NFS-e</small><b>{{chave .ChaveAcesso}}</b></td></tr>
+<tr><td><small>Número da NFS-e</small><b>{{.NumeroNFSe}}</b></td><td><small>Competência</small><b>{{.DataCompetencia}}</b></td><td><small>Data e hora da emissão</small><b>{{.DataEmissao}}</b></td><td><small>Série / número da DPS</small><b>{{.SerieDPS}} / {{.NumeroDPS}}</b></td></tr>
+</table>
+<div class="section">EMITENTE DA NFS-e</div>
+<table>
+<tr><td><small>Nome / razão social</small><b>{{.PrestadorNome}}</b></td><td><small>CNPJ</small><b>{{.PrestadorCNPJ}}</b></td><td><small>Inscrição municipal</small><b>{{.PrestadorIM}}</b></td></tr>
+<tr><td><small>Endereço</small><b>{{.PrestadorEndereco}}</b></td><td><small>Telefone</small><b>{{.PrestadorTelefone}}</b></td><td><small>E-mail</small><b>{{.PrestadorEmail}}</b></td></tr>
+</table>
+<div class="section">TOMADOR DO SERVIÇO</div>
+<table>
+<tr><td><small>Nome / razão social</small><b>{{.TomadorNome}}</b></td><td><small>CPF / CNPJ</small><b>{{.TomadorDocumento}}</b></td><td><small>Município</small><b>{{cidade .TomadorCidade .TomadorUF}}</b></td></tr>
+<tr><td colspan="2"><small>Endereço</small><b>{{.TomadorEndereco}}</b></td><td><small>E-mail</small><b>{{.TomadorEmail}}</b></td></tr>
+</table>
+<div class="section">SERVIÇO PRESTADO</div>
+<table>
+<tr><td><small>Código de tributação nacional</small><b>{{.CodigoServico}}</b></td><td><small>CNAE</small><b>{{.CNAE}}</b></td><td><small>Local da prestação</small><b>{{.LocalPrestacao}}</b></td></tr>
+<tr><td colspan="3"><small>Discriminação do serviço</small><div class="discriminacao">{{.Discriminacao}}</div></td></tr>
+</table>
+<div class="section">TRIBUTAÇÃO MUNICIPAL</div>
+<table>
+<tr><td><small>Valor do serviço</small><b>{{.ValorServicos}}</b></td><td><small>Deduções</small><b>{{.ValorDeducoes}}</b></td><td><small>ISSQN apurado</small><b>{{.ValorISS}}</b></td><td><small>Alíquota</small><b>{{.AliquotaISS}}</b></td><td><small>ISS retido</small><b>{{if .ISSRetido}}Sim{{else}}Não{{end}}</b></td></tr>
+</table>
+<div class="section">RETENÇÕES FEDERAIS</div>
+<table>
+<tr><td><small>IRRF</small><b>{{.ValorIR}}</b></td><td><small>PIS</small><b>{{.ValorPIS}}</b></td><td><small>COFINS</small><b>{{.ValorCOFINS}}</b></td><td><small>CSLL</small><b>{{.ValorCSLL}}</b></td><td><small>CP (INSS)</small><b>{{.ValorINSS}}</b></td></tr>
+<tr class="total"><td colspan="4">VALOR LÍQUIDO DA NFS-e</td><td>{{.ValorLiquido}}</td></tr>
+</table>
+<div class="section">INFORMAÇÕES COMPLEMENTARES</div>
+<table><tr><td>
+Consulte a autenticidade desta NFS-e em <a href="{{.LinkVerificacao}}">{{.LinkVerificacao}}</a>
+{{if .CodigoVerificacao}}<br>Código de verificação: {{.CodigoVerificacao}}{{end}}
+{{if .Substituida}}<br>Esta NFS-e substitui a NFS-e de chave de acesso {{chave .Substituida}}{{end}}
+{{if .Homologacao}}<br><span class="homolog">NFS-e emitida em ambiente de homologação, sem valor jurídico.</span>{{end}}
+</td></tr></table>
+{{end}}
+</body>
+</html>`))
+
+// RenderDANFSeHTML renders the DANFS-e layout as printable HTML
+func RenderDANFSeHTML(data *DANFSeData) (string, error) {
+	png, err := qrcode.Encode(data.LinkVerificacao, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	view := struct {
+		Data   *DANFSeData
+		QRCode template.URL
+		Logo   template.URL
+	}{Data: data, QRCode: template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))}
+	if len(data.PrestadorLogo) > 0 {
+		view.Logo = template.URL("data:image;base64," + base64.StdEncoding.EncodeToString(data.PrestadorLogo))
+	}
+
+	var buf bytes.Buffer
+	if err := danfseTemplate.Execute(&buf, view); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
 }
 
 // Helper functions
@@ -163,6 +583,18 @@ func formatDocument(doc string) string {
 	return formatCNPJ(doc)
 }
 
+// formatChave groups the access key in blocks of four digits
+func formatChave(chave string) string {
+	var b strings.Builder
+	for i, r := range chave {
+		if i > 0 && i%4 == 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 func formatDate(t *time.Time) string {
 	if t == nil {
 		return "-"
@@ -182,13 +614,9 @@ func buildEndereco(end *domain.Endereco) string {
 		end.Street, end.Number, end.District, end.City, end.State)
 }
 
-// DANFSeTemplate is now handled by the Python bridge
-
-// GetDANFSeAsBase64 returns the DANFS-e HTML encoded as Base64
-func (s *DANFSeService) GetDANFSeAsBase64(nfse *domain.NotaFiscal, prestador *domain.Prestador, tomador *domain.Cliente) (string, error) {
-	html, err := s.Generate(nfse, prestador, tomador)
-	if err != nil {
-		return "", err
+func joinCidade(cidade, uf string) string {
+	if cidade == "" {
+		return ""
 	}
-	return base64.StdEncoding.EncodeToString([]byte(html)), nil
+	return cidade + "/" + uf
 }
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"inovar/internal/domain"
+)
+
+var serieDPSPattern = regexp.MustCompile(`^[0-9]{1,5}$`)
+
+// DPSNumeracao hands out sequential, gap-free DPS numbers per provider and series.
+// Numbers are reserved inside the transaction that creates the note, so a rollback
+// never burns a number, and numbers of rejected DPS are reused by the next note.
+type DPSNumeracao struct {
+	db *gorm.DB
+}
+
+// NewDPSNumeracao creates a new DPS numbering service
+func NewDPSNumeracao(db *gorm.DB) *DPSNumeracao {
+	return &DPSNumeracao{db: db}
+}
+
+// LacunaDPS is a number of a series that was not authorized by the SEFIN
+type LacunaDPS struct {
+	Numero       int64  `json:"numero"`
+	Situacao     string `json:"situacao"` // RESERVADO, LIBERADO, SEM_RESERVA
+	NotaFiscalID string `json:"notaFiscalId,omitempty"`
+}
+
+// FaixaDPS is a contiguous range of unused numbers, as requested in an inutilização
+type FaixaDPS struct {
+	Inicio int64 `json:"inicio"`
+	Fim    int64 `json:"fim"`
+}
+
+// RelatorioLacunasDPS lists the unused numbers of a series
+type RelatorioLacunasDPS struct {
+	Serie        string      `json:"serie"`
+	UltimoNumero int64       `json:"ultimoNumero"`
+	Utilizados   int64       `json:"utilizados"`
+	Lacunas      []LacunaDPS `json:"lacunas"`
+	Faixas       []FaixaDPS  `json:"faixas"` // unused numbers not held by any note
+}
+
+// ValidarSerie checks the format of a series (up to five digits)
+func ValidarSerie(serie string) error {
+	if !serieDPSPattern.MatchString(serie) {
+		return errors.New("série da DPS deve ter de 1 a 5 dígitos")
+	}
+	return nil
+}
+
+// SeriePadrao returns the series used for new notes of a provider
+func (n *DPSNumeracao) SeriePadrao(prestadorID string) string {
+	return seriePadrao(n.db, prestadorID)
+}
+
+func seriePadrao(tx *gorm.DB, prestadorID string) string {
+	var serie domain.SerieDPS
+	if err := tx.Where("prestador_id = ? AND padrao = ?", prestadorID, true).First(&serie).Error; err == nil {
+		return serie.Serie
+	}
+	return SerieDPSPadrao
+}
+
+// Series lists the series of a provider, creating the default one on first use
+func (n *DPSNumeracao) Series(prestadorID string) ([]domain.SerieDPS, error) {
+	if _, err := n.serie(n.db, prestadorID, n.SeriePadrao(prestadorID)); err != nil {
+		return nil, err
+	}
+	var series []domain.SerieDPS
+	err := n.db.Where("prestador_id = ?", prestadorID).Order("serie").Find(&series).Error
+	return series, err
+}
+
+// CriarSerie opens a new series starting at the given number
+func (n *DPSNumeracao) CriarSerie(prestadorID, serie string, proximoNumero int64, padrao bool) (*domain.SerieDPS, error) {
+	if err := ValidarSerie(serie); err != nil {
+		return nil, err
+	}
+	if proximoNumero < 1 {
+		proximoNumero = 1
+	}
+
+	s := &domain.SerieDPS{
+		ID:           uuid.New().String(),
+		PrestadorID:  prestadorID,
+		Serie:        serie,
+		UltimoNumero: proximoNumero - 1,
+	}
+	err := n.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		tx.Model(&domain.SerieDPS{}).Where("prestador_id = ? AND serie = ?", prestadorID, serie).Count(&count)
+		if count > 0 {
+			return fmt.Errorf("série %s já existe", serie)
+		}
+		if err := tx.Create(s).Error; err != nil {
+			return err
+		}
+		if padrao {
+			return n.definirPadrao(tx, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Redefinir moves the counter of a series so the next note gets proximoNumero. It cannot go back
+// over numbers already authorized or held by a note.
+func (n *DPSNumeracao) Redefinir(prestadorID, serie string, proximoNumero int64) (*domain.SerieDPS, error) {
+	if proximoNumero < 1 {
+		return nil, errors.New("próximo número deve ser maior que zero")
+	}
+
+	var s *domain.SerieDPS
+	err := n.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if s, err = n.serie(tx, prestadorID, serie); err != nil {
+			return err
+		}
+
+		var emUso int64
+		tx.Model(&domain.ReservaDPS{}).
+			Where("prestador_id = ? AND serie = ? AND status IN ?", prestadorID, serie, []string{domain.ReservaDPSReservado, domain.ReservaDPSUtilizado}).
+			Select("COALESCE(MAX(numero), 0)").Scan(&emUso)
+		if legado := maxNumeroNotas(tx, prestadorID, serie); legado > emUso {
+			emUso = legado
+		}
+		if proximoNumero <= emUso {
+			return fmt.Errorf("o número %d já foi utilizado; o próximo número deve ser maior que %d", proximoNumero, emUso)
+		}
+
+		// Released numbers above the new start would be handed out again out of order
+		if err := tx.Where("prestador_id = ? AND serie = ? AND status = ? AND numero >= ?", prestadorID, serie, domain.ReservaDPSLiberado, proximoNumero).
+			Delete(&domain.ReservaDPS{}).Error; err != nil {
+			return err
+		}
+
+		s.UltimoNumero = proximoNumero - 1
+		return tx.Model(s).Update("ultimo_numero", s.UltimoNumero).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DefinirPadrao makes a series the one used for new notes
+func (n *DPSNumeracao) DefinirPadrao(prestadorID, serie string) (*domain.SerieDPS, error) {
+	var s *domain.SerieDPS
+	err := n.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if s, err = n.serie(tx, prestadorID, serie); err != nil {
+			return err
+		}
+		return n.definirPadrao(tx, s)
+	})
+	return s, err
+}
+
+func (n *DPSNumeracao) definirPadrao(tx *gorm.DB, s *domain.SerieDPS) error {
+	if err := tx.Model(&domain.SerieDPS{}).Where("prestador_id = ? AND id <> ?", s.PrestadorID, s.ID).Update("padrao", false).Error; err != nil {
+		return err
+	}
+	s.Padrao = true
+	return tx.Model(s).Update("padrao", true).Error
+}
+
+// Reservar assigns a DPS number of the provider's default series to a note within tx. A note that
+// already holds a reservation keeps it, so resending a failed note reuses its number.
+func (n *DPSNumeracao) Reservar(tx *gorm.DB, nf *domain.NotaFiscal) error {
+	if nf.NumeroDPS > 0 {
+		var atual domain.ReservaDPS
+		err := tx.Where("nota_fiscal_id = ? AND status = ?", nf.ID, domain.ReservaDPSReservado).First(&atual).Error
+		if err == nil {
+			nf.SerieDPS, nf.NumeroDPS = atual.Serie, atual.Numero
+			return nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	serie := seriePadrao(tx, nf.PrestadorID)
+	s, err := n.serie(tx, nf.PrestadorID, serie)
+	if err != nil {
+		return err
+	}
+
+	// Numbers of rejected DPS come first so the series has no holes
+	var liberada domain.ReservaDPS
+	err = tx.Where("prestador_id = ? AND serie = ? AND status = ?", nf.PrestadorID, serie, domain.ReservaDPSLiberado).
+		Order("numero").First(&liberada).Error
+	if err == nil {
+		res := tx.Model(&domain.ReservaDPS{}).
+			Where("id = ? AND status = ?", liberada.ID, domain.ReservaDPSLiberado).
+			Updates(map[string]interface{}{"status": domain.ReservaDPSReservado, "nota_fiscal_id": nf.ID, "motivo": ""})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 1 {
+			nf.SerieDPS, nf.NumeroDPS = serie, liberada.Numero
+			return nil
+		}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	// The increment locks the counter row until commit, serializing concurrent reservations
+	if err := tx.Model(&domain.SerieDPS{}).Where("id = ?", s.ID).
+		Update("ultimo_numero", gorm.Expr("ultimo_numero + 1")).Error; err != nil {
+		return err
+	}
+	if err := tx.First(s, "id = ?", s.ID).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Create(&domain.ReservaDPS{
+		ID:           uuid.New().String(),
+		PrestadorID:  nf.PrestadorID,
+		Serie:        serie,
+		Numero:       s.UltimoNumero,
+		NotaFiscalID: nf.ID,
+		Status:       domain.ReservaDPSReservado,
+	}).Error; err != nil {
+		return err
+	}
+	nf.SerieDPS, nf.NumeroDPS = serie, s.UltimoNumero
+	return nil
+}
+
+// Utilizar marks the number of an authorized note as used
+func (n *DPSNumeracao) Utilizar(tx *gorm.DB, notaID string) error {
+	return tx.Model(&domain.ReservaDPS{}).
+		Where("nota_fiscal_id = ? AND status = ?", notaID, domain.ReservaDPSReservado).
+		Update("status", domain.ReservaDPSUtilizado).Error
+}
+
+// Liberar returns the number of a note rejected by the SEFIN to its series
+func (n *DPSNumeracao) Liberar(notaID, motivo string) error {
+	return n.db.Model(&domain.ReservaDPS{}).
+		Where("nota_fiscal_id = ? AND status = ?", notaID, domain.ReservaDPSReservado).
+		Updates(map[string]interface{}{"status": domain.ReservaDPSLiberado, "nota_fiscal_id": "", "motivo": motivo}).Error
+}
+
+// Lacunas lists the numbers of a series that were not authorized, grouping the free ones in ranges
+func (n *DPSNumeracao) Lacunas(prestadorID, serie string) (*RelatorioLacunasDPS, error) {
+	s, err := n.serie(n.db, prestadorID, serie)
+	if err != nil {
+		return nil, err
+	}
+
+	var reservas []domain.ReservaDPS
+	if err := n.db.Where("prestador_id = ? AND serie = ?", prestadorID, serie).Find(&reservas).Error; err != nil {
+		return nil, err
+	}
+	porNumero := make(map[int64]domain.ReservaDPS, len(reservas))
+	for _, r := range reservas {
+		porNumero[r.Numero] = r
+	}
+
+	// Notes authorized before reservations existed also count as used
+	var legado []int64
+	n.db.Model(&domain.NotaFiscal{}).
+		Where("prestador_id = ? AND serie_dps = ? AND status IN ?", prestadorID, serie, []string{domain.NFSeStatusEmitida, domain.NFSeStatusCancelada}).
+		Pluck("numero_dps", &legado)
+	usados := make(map[int64]bool, len(legado))
+	for _, numero := range legado {
+		usados[numero] = true
+	}
+
+	rel := &RelatorioLacunasDPS{Serie: serie, UltimoNumero: s.UltimoNumero, Lacunas: []LacunaDPS{}, Faixas: []FaixaDPS{}}
+	for numero := int64(1); numero <= s.UltimoNumero; numero++ {
+		r, ok := porNumero[numero]
+		if usados[numero] || (ok && r.Status == domain.ReservaDPSUtilizado) {
+			rel.Utilizados++
+			continue
+		}
+
+		lacuna := LacunaDPS{Numero: numero, Situacao: "SEM_RESERVA"}
+		if ok {
+			lacuna.Situacao, lacuna.NotaFiscalID = r.Status, r.NotaFiscalID
+		}
+		rel.Lacunas = append(rel.Lacunas, lacuna)
+
+		if lacuna.Situacao == domain.ReservaDPSReservado {
+			continue
+		}
+		if last := len(rel.Faixas) - 1; last >= 0 && rel.Faixas[last].Fim == numero-1 {
+			rel.Faixas[last].Fim = numero
+		} else {
+			rel.Faixas = append(rel.Faixas, FaixaDPS{Inicio: numero, Fim: numero})
+		}
+	}
+
+	return rel, nil
+}
+
+// serie loads the counter of a series, creating it on first use after any number already in the notes
+func (n *DPSNumeracao) serie(tx *gorm.DB, prestadorID, serie string) (*domain.SerieDPS, error) {
+	if err := ValidarSerie(serie); err != nil {
+		return nil, err
+	}
+
+	var s domain.SerieDPS
+	err := tx.Where("prestador_id = ? AND serie = ?", prestadorID, serie).First(&s).Error
+	if err == nil {
+		return &s, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	s = domain.SerieDPS{
+		ID:           uuid.New().String(),
+		PrestadorID:  prestadorID,
+		Serie:        serie,
+		UltimoNumero: maxNumeroNotas(tx, prestadorID, serie),
+		Padrao:       serie == SerieDPSPadrao,
+	}
+	// A concurrent first use may have created it already
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&s).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("prestador_id = ? AND serie = ?", prestadorID, serie).First(&s).Error; err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// maxNumeroNotas returns the highest DPS number already present in the notes of a series
+func maxNumeroNotas(tx *gorm.DB, prestadorID, serie string) int64 {
+	var max int64
+	tx.Model(&domain.NotaFiscal{}).
+		Where("prestador_id = ? AND serie_dps = ?", prestadorID, serie).
+		Select("COALESCE(MAX(numero_dps), 0)").Scan(&max)
+	return max
+}
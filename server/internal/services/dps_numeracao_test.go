@@ -0,0 +1,206 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+func newTestNumeracao(t *testing.T) *DPSNumeracao {
+	t.Helper()
+	return NewDPSNumeracao(newTestDB(t, &domain.SerieDPS{}, &domain.ReservaDPS{}, &domain.NotaFiscal{}))
+}
+
+// reservar reserves a number for a new note of the provider
+func reservar(t *testing.T, n *DPSNumeracao, prestadorID string) *domain.NotaFiscal {
+	t.Helper()
+	nf := &domain.NotaFiscal{ID: uuid.New().String(), PrestadorID: prestadorID}
+	if err := n.db.Transaction(func(tx *gorm.DB) error { return n.Reservar(tx, nf) }); err != nil {
+		t.Fatal(err)
+	}
+	return nf
+}
+
+func TestReservarConcurrent(t *testing.T) {
+	n := newTestNumeracao(t)
+	errRejeitada := errors.New("nota não gravada")
+
+	var mu sync.Mutex
+	var numeros []int64
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(falha bool) {
+			defer wg.Done()
+			nf := &domain.NotaFiscal{ID: uuid.New().String(), PrestadorID: "prestador"}
+			err := n.db.Transaction(func(tx *gorm.DB) error {
+				if err := n.Reservar(tx, nf); err != nil {
+					return err
+				}
+				// A note that fails to be stored rolls its reservation back
+				if falha {
+					return errRejeitada
+				}
+				return nil
+			})
+			if err == nil {
+				mu.Lock()
+				numeros = append(numeros, nf.NumeroDPS)
+				mu.Unlock()
+			} else if !errors.Is(err, errRejeitada) {
+				t.Error(err)
+			}
+		}(i%2 == 1)
+	}
+	wg.Wait()
+
+	sort.Slice(numeros, func(i, j int) bool { return numeros[i] < numeros[j] })
+	want := []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	if !reflect.DeepEqual(numeros, want) {
+		t.Errorf("reserved numbers = %v, want %v with no duplicates or holes", numeros, want)
+	}
+
+	var reservas int64
+	n.db.Model(&domain.ReservaDPS{}).Count(&reservas)
+	series, _ := n.Series("prestador")
+	if reservas != 10 || len(series) != 1 || series[0].UltimoNumero != 10 {
+		t.Errorf("%d reservation(s), series %+v; want 10 and counter at 10", reservas, series)
+	}
+
+	// Another provider has a numbering of its own
+	if nf := reservar(t, n, "outro"); nf.NumeroDPS != 1 || nf.SerieDPS != SerieDPSPadrao {
+		t.Errorf("other provider got %s/%d, want %s/1", nf.SerieDPS, nf.NumeroDPS, SerieDPSPadrao)
+	}
+}
+
+func TestReservarReusesReleasedNumber(t *testing.T) {
+	n := newTestNumeracao(t)
+	notas := []*domain.NotaFiscal{reservar(t, n, "prestador"), reservar(t, n, "prestador"), reservar(t, n, "prestador")}
+
+	// Resending a note that is still waiting keeps its number
+	if err := n.db.Transaction(func(tx *gorm.DB) error { return n.Reservar(tx, notas[0]) }); err != nil || notas[0].NumeroDPS != 1 {
+		t.Errorf("resent note got %d (%v), want to keep 1", notas[0].NumeroDPS, err)
+	}
+
+	// The SEFIN rejected the second DPS
+	if err := n.Liberar(notas[1].ID, "E0014 - DPS rejeitada"); err != nil {
+		t.Fatal(err)
+	}
+	if nf := reservar(t, n, "prestador"); nf.NumeroDPS != 2 {
+		t.Errorf("next note got %d, want the released 2", nf.NumeroDPS)
+	}
+	if nf := reservar(t, n, "prestador"); nf.NumeroDPS != 4 {
+		t.Errorf("following note got %d, want 4", nf.NumeroDPS)
+	}
+
+	// The rejected note resent later gets a new number, since its own was taken
+	if err := n.db.Transaction(func(tx *gorm.DB) error { return n.Reservar(tx, notas[1]) }); err != nil || notas[1].NumeroDPS != 5 {
+		t.Errorf("rejected note resent got %d (%v), want 5", notas[1].NumeroDPS, err)
+	}
+
+	// An authorized number is never released
+	n.db.Transaction(func(tx *gorm.DB) error { return n.Utilizar(tx, notas[2].ID) })
+	n.Liberar(notas[2].ID, "tarde demais")
+	var r domain.ReservaDPS
+	n.db.First(&r, "numero = ?", 3)
+	if r.Status != domain.ReservaDPSUtilizado {
+		t.Errorf("authorized number 3 is %s", r.Status)
+	}
+}
+
+func TestRedefinirNumeracao(t *testing.T) {
+	n := newTestNumeracao(t)
+	notas := []*domain.NotaFiscal{reservar(t, n, "prestador"), reservar(t, n, "prestador"), reservar(t, n, "prestador")}
+	n.db.Transaction(func(tx *gorm.DB) error { return n.Utilizar(tx, notas[0].ID) })
+	n.Liberar(notas[2].ID, "rejeitada")
+
+	tests := []struct {
+		name    string
+		proximo int64
+		wantErr bool
+	}{
+		{"zero", 0, true},
+		{"over an authorized number", 1, true},
+		{"over a reserved number", 2, true},
+		// 3 was released: the counter may go back over it
+		{"over a released number", 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := n.Redefinir("prestador", SerieDPSPadrao, tt.proximo)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Redefinir(%d) error = %v, want error %v", tt.proximo, err, tt.wantErr)
+			}
+		})
+	}
+	if nf := reservar(t, n, "prestador"); nf.NumeroDPS != 3 {
+		t.Errorf("after reset to 3 the next note got %d", nf.NumeroDPS)
+	}
+
+	// Jumping ahead drops released numbers above the new start, so they are not handed out late
+	n.Liberar(notas[1].ID, "rejeitada")
+	s, err := n.Redefinir("prestador", SerieDPSPadrao, 10)
+	if err != nil || s.UltimoNumero != 9 {
+		t.Fatalf("Redefinir(10) = %+v, %v", s, err)
+	}
+	if nf := reservar(t, n, "prestador"); nf.NumeroDPS != 2 {
+		t.Errorf("released 2 below the new start got %d, want it reused", nf.NumeroDPS)
+	}
+	if nf := reservar(t, n, "prestador"); nf.NumeroDPS != 10 {
+		t.Errorf("next note got %d, want 10", nf.NumeroDPS)
+	}
+
+	// Notes issued before reservations existed also block going back
+	n.db.Create(&domain.NotaFiscal{ID: uuid.New().String(), PrestadorID: "prestador", SerieDPS: SerieDPSPadrao, NumeroDPS: 50, Status: domain.NFSeStatusEmitida})
+	if _, err := n.Redefinir("prestador", SerieDPSPadrao, 30); err == nil {
+		t.Error("reset below a legacy note accepted")
+	}
+	if _, err := n.Redefinir("prestador", "abc", 30); err == nil {
+		t.Error("invalid series accepted")
+	}
+}
+
+func TestLacunasDPS(t *testing.T) {
+	n := newTestNumeracao(t)
+	notas := []*domain.NotaFiscal{reservar(t, n, "prestador"), reservar(t, n, "prestador"), reservar(t, n, "prestador")}
+	n.db.Transaction(func(tx *gorm.DB) error { return n.Utilizar(tx, notas[0].ID) })
+	n.Liberar(notas[1].ID, "rejeitada")
+	// 4 was authorized before reservations existed; 5 and 6 were skipped by a reset
+	n.db.Create(&domain.NotaFiscal{ID: uuid.New().String(), PrestadorID: "prestador", SerieDPS: SerieDPSPadrao, NumeroDPS: 4, Status: domain.NFSeStatusEmitida})
+	if _, err := n.Redefinir("prestador", SerieDPSPadrao, 7); err != nil {
+		t.Fatal(err)
+	}
+
+	rel, err := n.Lacunas("prestador", SerieDPSPadrao)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.UltimoNumero != 6 || rel.Utilizados != 2 {
+		t.Errorf("last number %d, used %d; want 6 and 2", rel.UltimoNumero, rel.Utilizados)
+	}
+	wantLacunas := []LacunaDPS{
+		{Numero: 2, Situacao: domain.ReservaDPSLiberado},
+		{Numero: 3, Situacao: domain.ReservaDPSReservado, NotaFiscalID: notas[2].ID},
+		{Numero: 5, Situacao: "SEM_RESERVA"},
+		{Numero: 6, Situacao: "SEM_RESERVA"},
+	}
+	if !reflect.DeepEqual(rel.Lacunas, wantLacunas) {
+		t.Errorf("gaps = %+v, want %+v", rel.Lacunas, wantLacunas)
+	}
+	// Reserved numbers may still be authorized, so they split the ranges to inutilizar
+	if want := []FaixaDPS{{2, 2}, {5, 6}}; !reflect.DeepEqual(rel.Faixas, want) {
+		t.Errorf("ranges = %+v, want %+v", rel.Faixas, want)
+	}
+
+	empty, err := n.Lacunas("outro", SerieDPSPadrao)
+	if err != nil || empty.UltimoNumero != 0 || len(empty.Lacunas) != 0 || len(empty.Faixas) != 0 {
+		t.Errorf("new series report = %+v, %v", empty, err)
+	}
+}
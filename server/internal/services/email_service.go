@@ -6,6 +6,7 @@ import (
 	"inovar/internal/infra/bridge"
 	"inovar/internal/infra/config"
 	"log"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -219,3 +220,30 @@ func (s *EmailService) SendOSFinalized(toEmail, clientName, osNumber, pdfLink st
 
 	return s.send(toEmail, fmt.Sprintf("OS #%s Finalizada ✅", osNumber), s.wrapEmail(content))
 }
+
+// SendCertificateExpiry warns an admin that the A1 certificate is about to expire or has expired
+func (s *EmailService) SendCertificateExpiry(toEmail, userName, titular string, validade time.Time, dias int) error {
+	title := fmt.Sprintf("Certificado Digital Expira em %d Dia(s) ⏳", dias)
+	aviso := "Envie um novo certificado A1 antes do vencimento para não interromper a emissão de notas fiscais."
+	color := "#f59e0b"
+	if dias == 0 {
+		title = "Certificado Digital Expirado 🚫"
+		aviso = "A emissão de NFS-e está bloqueada até que um novo certificado A1 seja enviado."
+		color = "#dc2626"
+	}
+
+	content := fmt.Sprintf(`
+		<h2 style="color: %s; margin: 0 0 20px 0;">%s</h2>
+		<p style="color: #334155;">Olá <b>%s</b>,</p>
+		<div style="background-color: #fef3c7; border-radius: 8px; padding: 16px; margin: 16px 0; border: 1px solid #fbbf24;">
+			<p style="margin: 4px 0;"><b>🔏 Certificado:</b> %s</p>
+			<p style="margin: 4px 0;"><b>📅 Validade:</b> %s</p>
+		</div>
+		<p style="color: #334155;">%s</p>
+		<div style="text-align: center; margin: 24px 0;">
+			<a href="%s/admin/fiscal" style="background-color: %s; color: white; padding: 12px 32px; text-decoration: none; border-radius: 8px; font-weight: bold; display: inline-block;">Atualizar Certificado</a>
+		</div>
+	`, color, title, userName, titular, validade.Format("02/01/2006 15:04"), aviso, s.frontendURL, color)
+
+	return s.send(toEmail, title, s.wrapEmail(content))
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+	"inovar/internal/websocket"
+)
+
+// Jobs left running longer than this are considered abandoned by a crashed worker
+const jobStaleAfter = 10 * time.Minute
+
+// Upper bound of the retry backoff
+const jobMaxBackoff = time.Hour
+
+// JobFunc executes a job; returning an error schedules a retry unless it is permanent
+type JobFunc func(job *domain.Job) error
+
+// JobDeadLetterFunc is called once a job is moved to the dead letter state
+type JobDeadLetterFunc func(job *domain.Job, cause error)
+
+type jobType struct {
+	run        JobFunc
+	deadLetter JobDeadLetterFunc
+}
+
+// PermanentError marks a job failure that retrying cannot fix
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps an error so the job goes straight to the dead letter state
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// JobQueue is a table-backed queue of background jobs with retry and exponential backoff
+type JobQueue struct {
+	db          *gorm.DB
+	hub         *websocket.Hub
+	types       map[string]jobType
+	interval    time.Duration
+	backoffBase time.Duration
+	maxAttempts int
+	wake        chan struct{}
+	now         func() time.Time // replaced by tests
+}
+
+// NewJobQueue creates a new job queue
+func NewJobQueue(db *gorm.DB, hub *websocket.Hub, cfg *config.Config) *JobQueue {
+	return &JobQueue{
+		db:          db,
+		hub:         hub,
+		types:       map[string]jobType{},
+		interval:    time.Duration(cfg.JobPollIntervalSecs) * time.Second,
+		backoffBase: time.Duration(cfg.JobBackoffBaseSecs) * time.Second,
+		maxAttempts: cfg.JobMaxAttempts,
+		wake:        make(chan struct{}, 1),
+		now:         time.Now,
+	}
+}
+
+// Register sets the function that runs a job type and, optionally, the dead letter hook
+func (q *JobQueue) Register(tipo string, run JobFunc, deadLetter JobDeadLetterFunc) {
+	q.types[tipo] = jobType{run: run, deadLetter: deadLetter}
+}
+
+// Enqueue persists a new job. When tx is a caller's transaction the job only becomes visible on
+// commit, so the caller should Wake the queue afterwards.
+func (q *JobQueue) Enqueue(tx *gorm.DB, tipo, referenciaID, companyID, userID string, payload interface{}) (*domain.Job, error) {
+	job := &domain.Job{
+		ID:               uuid.New().String(),
+		Tipo:             tipo,
+		Status:           domain.JobStatusPendente,
+		ReferenciaID:     referenciaID,
+		CompanyID:        companyID,
+		UserID:           userID,
+		MaxTentativas:    q.maxAttempts,
+		ProximaTentativa: q.now(),
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		job.Payload = string(data)
+	}
+	db := q.db
+	if tx != nil {
+		db = tx
+	}
+	if err := db.Create(job).Error; err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		q.Wake()
+	}
+	return job, nil
+}
+
+// Wake makes the worker look for due jobs without waiting for the next poll
+func (q *JobQueue) Wake() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Active reports whether a job of one of the given types is pending or running for a reference
+func (q *JobQueue) Active(referenciaID string, tipos ...string) bool {
+	var count int64
+	q.db.Model(&domain.Job{}).
+		Where("referencia_id = ? AND tipo IN ? AND status IN ?", referenciaID, tipos, []string{domain.JobStatusPendente, domain.JobStatusExecutando}).
+		Count(&count)
+	return count > 0
+}
+
+// Retry moves a dead letter job back to the queue with a fresh attempt budget
+func (q *JobQueue) Retry(id string) (*domain.Job, error) {
+	var job domain.Job
+	if err := q.db.First(&job, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	if job.Status != domain.JobStatusFalhou {
+		return nil, errors.New("apenas jobs com falha podem ser reprocessados")
+	}
+
+	res := q.db.Model(&domain.Job{}).
+		Where("id = ? AND status = ?", id, domain.JobStatusFalhou).
+		Updates(map[string]interface{}{
+			"status":            domain.JobStatusPendente,
+			"tentativas":        0,
+			"max_tentativas":    q.maxAttempts,
+			"proxima_tentativa": q.now(),
+			"concluido_em":      nil,
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, errors.New("job já foi reprocessado")
+	}
+
+	q.db.First(&job, "id = ?", id)
+	q.broadcast(&job)
+	q.Wake()
+	return &job, nil
+}
+
+// Run processes due jobs until the process exits
+func (q *JobQueue) Run() {
+	log.Println("📬 Fila de jobs iniciada")
+
+	// A single worker runs per process, so anything left running was interrupted by a restart
+	if n := q.requeue(q.now()); n > 0 {
+		log.Printf("📬 %d job(s) interrompido(s) devolvido(s) à fila", n)
+	}
+
+	for {
+		for q.next() {
+		}
+
+		select {
+		case <-q.wake:
+		case <-time.After(q.interval):
+			q.requeue(q.now().Add(-jobStaleAfter))
+		}
+	}
+}
+
+// requeue returns running jobs started before the given time to the pending state
+func (q *JobQueue) requeue(before time.Time) int64 {
+	res := q.db.Model(&domain.Job{}).
+		Where("status = ? AND iniciado_em < ?", domain.JobStatusExecutando, before).
+		Updates(map[string]interface{}{"status": domain.JobStatusPendente, "proxima_tentativa": q.now()})
+	if res.Error != nil {
+		log.Printf("⚠️ Erro ao recuperar jobs interrompidos: %v", res.Error)
+		return 0
+	}
+	return res.RowsAffected
+}
+
+// next claims and runs the oldest due job, reporting whether one was found
+func (q *JobQueue) next() bool {
+	var job domain.Job
+	err := q.db.Where("status = ? AND proxima_tentativa <= ?", domain.JobStatusPendente, q.now()).
+		Order("proxima_tentativa").First(&job).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("⚠️ Erro ao consultar fila de jobs: %v", err)
+		}
+		return false
+	}
+
+	// Conditional update so a job is never claimed twice
+	now := q.now()
+	res := q.db.Model(&domain.Job{}).
+		Where("id = ? AND status = ?", job.ID, domain.JobStatusPendente).
+		Updates(map[string]interface{}{
+			"status":      domain.JobStatusExecutando,
+			"tentativas":  gorm.Expr("tentativas + 1"),
+			"iniciado_em": now,
+		})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return res.Error == nil
+	}
+	job.Status = domain.JobStatusExecutando
+	job.Tentativas++
+	job.IniciadoEm = &now
+	q.broadcast(&job)
+
+	q.execute(&job)
+	return true
+}
+
+// execute runs a claimed job and records its outcome
+func (q *JobQueue) execute(job *domain.Job) {
+	t, ok := q.types[job.Tipo]
+	var err error
+	if !ok {
+		err = Permanent(fmt.Errorf("tipo de job desconhecido: %s", job.Tipo))
+	} else {
+		err = q.safeRun(t.run, job)
+	}
+
+	now := q.now()
+	var permanent *PermanentError
+	switch {
+	case err == nil:
+		job.Status = domain.JobStatusConcluido
+		job.UltimoErro = ""
+		job.ConcluidoEm = &now
+	case errors.As(err, &permanent) || job.Tentativas >= job.MaxTentativas:
+		job.Status = domain.JobStatusFalhou
+		job.UltimoErro = err.Error()
+		job.ConcluidoEm = &now
+		log.Printf("☠️ Job %s (%s) falhou definitivamente após %d tentativa(s): %v", job.ID, job.Tipo, job.Tentativas, err)
+	default:
+		job.Status = domain.JobStatusPendente
+		job.UltimoErro = err.Error()
+		job.ProximaTentativa = now.Add(q.backoff(job.Tentativas))
+		log.Printf("🔁 Job %s (%s) tentativa %d/%d falhou, nova tentativa às %s: %v", job.ID, job.Tipo, job.Tentativas, job.MaxTentativas, job.ProximaTentativa.Format("15:04:05"), err)
+	}
+
+	if err := q.db.Model(&domain.Job{}).Where("id = ?", job.ID).Updates(map[string]interface{}{
+		"status":            job.Status,
+		"ultimo_erro":       job.UltimoErro,
+		"proxima_tentativa": job.ProximaTentativa,
+		"concluido_em":      job.ConcluidoEm,
+	}).Error; err != nil {
+		log.Printf("⚠️ Erro ao registrar resultado do job %s: %v", job.ID, err)
+	}
+	q.broadcast(job)
+
+	if job.Status == domain.JobStatusFalhou && ok && t.deadLetter != nil {
+		t.deadLetter(job, err)
+	}
+}
+
+// safeRun keeps a panicking job from taking the worker down
+func (q *JobQueue) safeRun(run JobFunc, job *domain.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = Permanent(fmt.Errorf("panic: %v", r))
+		}
+	}()
+	return run(job)
+}
+
+// backoff doubles the wait after each failed attempt
+func (q *JobQueue) backoff(attempt int) time.Duration {
+	wait := q.backoffBase
+	for i := 1; i < attempt && wait < jobMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > jobMaxBackoff {
+		wait = jobMaxBackoff
+	}
+	return wait
+}
+
+func (q *JobQueue) broadcast(job *domain.Job) {
+	q.hub.Broadcast(job.CompanyID, "job:updated", job)
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+	"inovar/internal/websocket"
+)
+
+// fakeClock is the time seen by the queue; tests move it forward by hand
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestQueue creates a queue with 3 attempts and a 30s backoff, driven by a fake clock
+func newTestQueue(t *testing.T) (*JobQueue, *fakeClock) {
+	t.Helper()
+	db := newTestDB(t, &domain.Job{})
+	hub := websocket.NewHub()
+	go hub.Run()
+
+	q := NewJobQueue(db, hub, &config.Config{JobPollIntervalSecs: 1, JobBackoffBaseSecs: 30, JobMaxAttempts: 3})
+	clock := &fakeClock{t: time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC)}
+	q.now = clock.now
+	return q, clock
+}
+
+// failing returns a handler failing the first n calls, counting every call
+func failing(n int, calls *int) JobFunc {
+	return func(job *domain.Job) error {
+		*calls++
+		if *calls <= n {
+			return errors.New("SEFIN indisponível")
+		}
+		return nil
+	}
+}
+
+func reload(t *testing.T, q *JobQueue, id string) domain.Job {
+	t.Helper()
+	var job domain.Job
+	if err := q.db.First(&job, "id = ?", id).Error; err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestJobQueueRetryWithBackoff(t *testing.T) {
+	q, clock := newTestQueue(t)
+	calls := 0
+	q.Register("TESTE", failing(2, &calls), nil)
+	job, err := q.Enqueue(nil, "TESTE", "ref", "company", "user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !q.next() {
+		t.Fatal("due job not run")
+	}
+	got := reload(t, q, job.ID)
+	if got.Status != domain.JobStatusPendente || got.Tentativas != 1 || got.UltimoErro != "SEFIN indisponível" {
+		t.Fatalf("after the first failure: status %s, attempts %d, error %q", got.Status, got.Tentativas, got.UltimoErro)
+	}
+	if want := clock.t.Add(30 * time.Second); !got.ProximaTentativa.Equal(want) {
+		t.Errorf("next attempt at %v, want %v", got.ProximaTentativa, want)
+	}
+
+	// Not due before the backoff elapses
+	clock.advance(29 * time.Second)
+	if q.next() {
+		t.Fatal("job retried before its backoff")
+	}
+	clock.advance(time.Second)
+	if !q.next() {
+		t.Fatal("job not retried after its backoff")
+	}
+	if got := reload(t, q, job.ID); !got.ProximaTentativa.Equal(clock.t.Add(time.Minute)) {
+		t.Errorf("second backoff until %v, want doubled to %v", got.ProximaTentativa, clock.t.Add(time.Minute))
+	}
+
+	clock.advance(time.Minute)
+	if !q.next() {
+		t.Fatal("job not retried after the second backoff")
+	}
+	got = reload(t, q, job.ID)
+	if got.Status != domain.JobStatusConcluido || got.Tentativas != 3 || got.UltimoErro != "" || got.ConcluidoEm == nil {
+		t.Errorf("after success: status %s, attempts %d, error %q, done %v", got.Status, got.Tentativas, got.UltimoErro, got.ConcluidoEm)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want 3", calls)
+	}
+	if q.next() {
+		t.Error("finished job run again")
+	}
+}
+
+func TestJobQueueBackoff(t *testing.T) {
+	q, _ := newTestQueue(t)
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := q.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestJobQueueDeadLetter(t *testing.T) {
+	q, clock := newTestQueue(t)
+	calls := 0
+	var dead []string
+	var cause error
+	q.Register("TESTE", failing(100, &calls), func(job *domain.Job, err error) {
+		dead = append(dead, job.ID)
+		cause = err
+	})
+	job, _ := q.Enqueue(nil, "TESTE", "ref", "company", "user", nil)
+
+	for i := 0; i < 10; i++ {
+		q.next()
+		clock.advance(time.Hour)
+	}
+	got := reload(t, q, job.ID)
+	if got.Status != domain.JobStatusFalhou || got.Tentativas != 3 || got.ConcluidoEm == nil {
+		t.Errorf("exhausted job: status %s, attempts %d, done %v", got.Status, got.Tentativas, got.ConcluidoEm)
+	}
+	if calls != 3 {
+		t.Errorf("handler called %d times, want the 3 attempts", calls)
+	}
+	if len(dead) != 1 || dead[0] != job.ID || cause == nil || cause.Error() != "SEFIN indisponível" {
+		t.Errorf("dead letter hook called for %v with %v", dead, cause)
+	}
+	if q.Active("ref", "TESTE") {
+		t.Error("dead letter job reported active")
+	}
+
+	// Retry gives a fresh attempt budget
+	if _, err := q.Retry(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !q.next() {
+		t.Fatal("retried job not run")
+	}
+	if got := reload(t, q, job.ID); got.Status != domain.JobStatusPendente || got.Tentativas != 1 {
+		t.Errorf("retried job: status %s, attempts %d", got.Status, got.Tentativas)
+	}
+	if _, err := q.Retry(job.ID); err == nil {
+		t.Error("pending job retried")
+	}
+}
+
+func TestJobQueuePermanentFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		run  JobFunc
+		want string
+	}{
+		{"permanent error", func(*domain.Job) error { return Permanent(errors.New("CNPJ inválido")) }, "CNPJ inválido"},
+		{"panic", func(*domain.Job) error { panic("nil map") }, "panic: nil map"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, _ := newTestQueue(t)
+			dead := 0
+			q.Register("TESTE", tt.run, func(*domain.Job, error) { dead++ })
+			job, _ := q.Enqueue(nil, "TESTE", "ref", "company", "user", nil)
+
+			q.next()
+			got := reload(t, q, job.ID)
+			if got.Status != domain.JobStatusFalhou || got.Tentativas != 1 || got.UltimoErro != tt.want || dead != 1 {
+				t.Errorf("status %s, attempts %d, error %q, dead letters %d; want %s after one attempt",
+					got.Status, got.Tentativas, got.UltimoErro, dead, domain.JobStatusFalhou)
+			}
+		})
+	}
+
+	q, _ := newTestQueue(t)
+	job, _ := q.Enqueue(nil, "DESCONHECIDO", "ref", "company", "user", nil)
+	q.next()
+	if got := reload(t, q, job.ID); got.Status != domain.JobStatusFalhou {
+		t.Errorf("unknown job type left %s", got.Status)
+	}
+}
+
+func TestJobQueueResumeAfterRestart(t *testing.T) {
+	q, clock := newTestQueue(t)
+	calls := 0
+	q.Register("TESTE", failing(0, &calls), nil)
+
+	// The process died while the job ran
+	job, _ := q.Enqueue(nil, "TESTE", "ref", "company", "user", nil)
+	started := clock.t.Add(-time.Minute)
+	q.db.Model(&domain.Job{}).Where("id = ?", job.ID).
+		Updates(map[string]interface{}{"status": domain.JobStatusExecutando, "tentativas": 1, "iniciado_em": started})
+	if q.next() {
+		t.Fatal("running job claimed again")
+	}
+
+	// Only jobs running for longer than jobStaleAfter are taken back while the worker runs
+	if n := q.requeue(clock.t.Add(-jobStaleAfter)); n != 0 {
+		t.Errorf("recent job requeued as stale (%d)", n)
+	}
+
+	// On restart the single worker takes back whatever was running
+	if n := q.requeue(clock.t); n != 1 {
+		t.Fatalf("requeue on restart = %d, want 1", n)
+	}
+	if !q.next() {
+		t.Fatal("interrupted job not resumed")
+	}
+	got := reload(t, q, job.ID)
+	if got.Status != domain.JobStatusConcluido || got.Tentativas != 2 || calls != 1 {
+		t.Errorf("resumed job: status %s, attempts %d, calls %d", got.Status, got.Tentativas, calls)
+	}
+}
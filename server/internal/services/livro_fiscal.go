@@ -0,0 +1,442 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+// LivroFiscalNota is a line of the register of services rendered
+type LivroFiscalNota struct {
+	NotaID           string     `json:"notaId"`
+	Numero           string     `json:"numero"`
+	ChaveAcesso      string     `json:"chaveAcesso"`
+	SerieDPS         string     `json:"serieDps"`
+	NumeroDPS        int64      `json:"numeroDps"`
+	DataEmissao      *time.Time `json:"dataEmissao,omitempty"`
+	DataCompetencia  time.Time  `json:"dataCompetencia"`
+	Solicitacoes     string     `json:"solicitacoes"` // OS numbers invoiced by the note
+	TomadorNome      string     `json:"tomadorNome"`
+	TomadorDocumento string     `json:"tomadorDocumento"`
+	CodigoServico    string     `json:"codigoServico"`
+	Status           string     `json:"status"`
+	ValorServicos    float64    `json:"valorServicos"`
+	ValorDeducoes    float64    `json:"valorDeducoes"`
+	AliquotaISS      float64    `json:"aliquotaIss"`
+	ValorISS         float64    `json:"valorIss"`
+	ISSRetido        bool       `json:"issRetido"`
+	ValorPIS         float64    `json:"valorPis"`
+	ValorCOFINS      float64    `json:"valorCofins"`
+	ValorCSLL        float64    `json:"valorCsll"`
+	ValorIR          float64    `json:"valorIr"`
+	ValorINSS        float64    `json:"valorInss"`
+	ValorLiquido     float64    `json:"valorLiquido"`
+	Substitui        string     `json:"substitui,omitempty"`      // number of the note this one replaces
+	SubstituidaPor   string     `json:"substituidaPor,omitempty"` // number of the note that replaced it
+
+	nota *domain.NotaFiscal
+}
+
+// LivroFiscalEvento is a cancellation or substitution registered in the month, whatever the
+// competência of its note
+type LivroFiscalEvento struct {
+	Data            time.Time `json:"data"`
+	Tipo            string    `json:"tipo"` // CANCELAMENTO, SUBSTITUICAO
+	Numero          string    `json:"numero"`
+	ChaveAcesso     string    `json:"chaveAcesso"`
+	DataCompetencia time.Time `json:"dataCompetencia"`
+	TomadorNome     string    `json:"tomadorNome"`
+	ValorServicos   float64   `json:"valorServicos"`
+	ValorISS        float64   `json:"valorIss"`
+	Protocolo       string    `json:"protocolo,omitempty"`
+	Motivo          string    `json:"motivo,omitempty"`
+}
+
+// LivroFiscalTotais sums the notes of the month still in force, per tax
+type LivroFiscalTotais struct {
+	NotasEmitidas     int     `json:"notasEmitidas"`
+	NotasCanceladas   int     `json:"notasCanceladas"`
+	NotasSubstituidas int     `json:"notasSubstituidas"`
+	ValorServicos     float64 `json:"valorServicos"` // receita bruta of the month
+	ValorDeducoes     float64 `json:"valorDeducoes"`
+	ValorISS          float64 `json:"valorIss"`
+	ValorISSRetido    float64 `json:"valorIssRetido"`   // withheld and paid by the tomadores
+	ValorISSRecolher  float64 `json:"valorIssRecolher"` // owed by the provider (inside the DAS under the Simples)
+	ValorPIS          float64 `json:"valorPis"`
+	ValorCOFINS       float64 `json:"valorCofins"`
+	ValorCSLL         float64 `json:"valorCsll"`
+	ValorIR           float64 `json:"valorIr"`
+	ValorINSS         float64 `json:"valorInss"`
+	TotalRetido       float64 `json:"totalRetido"`
+	ValorLiquido      float64 `json:"valorLiquido"`
+	AliquotaSimples   float64 `json:"aliquotaSimples,omitempty"`  // effective rate of the last apuração
+	ValorDASEstimado  float64 `json:"valorDasEstimado,omitempty"` // receita bruta x effective rate
+}
+
+// LivroFiscal is the monthly register of services rendered of a provider
+type LivroFiscal struct {
+	PrestadorID      string              `json:"prestadorId"`
+	RazaoSocial      string              `json:"razaoSocial"`
+	CNPJ             string              `json:"cnpj"`
+	InscricaoMunic   string              `json:"inscricaoMunicipal,omitempty"`
+	RegimeTributario string              `json:"regimeTributario"`
+	Competencia      string              `json:"competencia"` // YYYY-MM
+	Notas            []LivroFiscalNota   `json:"notas"`
+	Eventos          []LivroFiscalEvento `json:"eventos"`
+	Totais           LivroFiscalTotais   `json:"totais"`
+}
+
+// LivroFiscalService builds the monthly fiscal register and the package sent to the accountant
+type LivroFiscalService struct {
+	db     *gorm.DB
+	danfse *DANFSeService
+}
+
+// NewLivroFiscalService creates a new fiscal register service
+func NewLivroFiscalService(db *gorm.DB, storage *StorageService) *LivroFiscalService {
+	return &LivroFiscalService{db: db, danfse: NewDANFSeService(db, storage)}
+}
+
+// Gerar lists the notes issued for a competência, with their cancellations and substitutions and
+// the totals per tax of the ones still in force
+func (s *LivroFiscalService) Gerar(prestadorID string, competencia time.Time) (*LivroFiscal, error) {
+	var prestador domain.Prestador
+	if err := s.db.First(&prestador, "id = ?", prestadorID).Error; err != nil {
+		return nil, errors.New("prestador não encontrado")
+	}
+	var fiscal domain.ConfiguracaoFiscal
+	if err := s.db.Where("prestador_id = ?", prestadorID).First(&fiscal).Error; err != nil {
+		return nil, errors.New("configuração fiscal não encontrada")
+	}
+
+	inicio := time.Date(competencia.Year(), competencia.Month(), 1, 0, 0, 0, 0, competencia.Location())
+	fim := inicio.AddDate(0, 1, 0)
+	livro := &LivroFiscal{
+		PrestadorID:      prestadorID,
+		RazaoSocial:      prestador.RazaoSocial,
+		CNPJ:             prestador.CNPJ,
+		InscricaoMunic:   fiscal.InscricaoMunicipal,
+		RegimeTributario: regimeOf(&fiscal),
+		Competencia:      inicio.Format("2006-01"),
+		Notas:            []LivroFiscalNota{},
+		Eventos:          []LivroFiscalEvento{},
+	}
+
+	var notas []domain.NotaFiscal
+	err := s.db.Preload("Solicitacoes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("numero_solicitacao")
+	}).Where("prestador_id = ? AND status IN ? AND data_competencia >= ? AND data_competencia < ?", prestadorID,
+		[]string{domain.NFSeStatusEmitida, domain.NFSeStatusCancelada, domain.NFSeStatusSubstituida}, inicio, fim).
+		Order("data_emissao, numero_dps").Find(&notas).Error
+	if err != nil {
+		return nil, err
+	}
+	numeros, err := s.numerosRelacionados(notas)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &livro.Totais
+	for i := range notas {
+		nf := &notas[i]
+		livro.Notas = append(livro.Notas, livroNota(nf, numeros))
+
+		switch nf.Status {
+		case domain.NFSeStatusCancelada:
+			t.NotasCanceladas++
+			continue
+		case domain.NFSeStatusSubstituida:
+			t.NotasSubstituidas++
+			continue
+		}
+		t.NotasEmitidas++
+		t.ValorServicos += nf.ValorServicos
+		t.ValorDeducoes += nf.ValorDeducoes
+		t.ValorISS += nf.ValorISS
+		if nf.ISSRetido {
+			t.ValorISSRetido += nf.ValorISS
+		}
+		t.ValorPIS += nf.ValorPIS
+		t.ValorCOFINS += nf.ValorCOFINS
+		t.ValorCSLL += nf.ValorCSLL
+		t.ValorIR += nf.ValorIR
+		t.ValorINSS += nf.ValorINSS
+		t.ValorLiquido += nf.ValorLiquido
+	}
+	t.ValorServicos = round2(t.ValorServicos)
+	t.ValorDeducoes = round2(t.ValorDeducoes)
+	t.ValorISS = round2(t.ValorISS)
+	t.ValorISSRetido = round2(t.ValorISSRetido)
+	t.ValorISSRecolher = round2(t.ValorISS - t.ValorISSRetido)
+	t.ValorPIS = round2(t.ValorPIS)
+	t.ValorCOFINS = round2(t.ValorCOFINS)
+	t.ValorCSLL = round2(t.ValorCSLL)
+	t.ValorIR = round2(t.ValorIR)
+	t.ValorINSS = round2(t.ValorINSS)
+	t.TotalRetido = round2(t.ValorISSRetido + t.ValorPIS + t.ValorCOFINS + t.ValorCSLL + t.ValorIR + t.ValorINSS)
+	t.ValorLiquido = round2(t.ValorLiquido)
+	if livro.RegimeTributario == domain.RegimeSimplesNac && fiscal.AliquotaSimplesNac > 0 {
+		t.AliquotaSimples = fiscal.AliquotaSimplesNac
+		t.ValorDASEstimado = round2(t.ValorServicos * fiscal.AliquotaSimplesNac / 100)
+	}
+
+	if livro.Eventos, err = s.eventos(prestadorID, inicio, fim); err != nil {
+		return nil, err
+	}
+	return livro, nil
+}
+
+// numerosRelacionados maps the ids of the notes replaced by or replacing the given ones to their numbers
+func (s *LivroFiscalService) numerosRelacionados(notas []domain.NotaFiscal) (map[string]string, error) {
+	var ids []string
+	for _, nf := range notas {
+		if nf.SubstituiID != "" {
+			ids = append(ids, nf.SubstituiID)
+		}
+		if nf.SubstituidaPorID != "" {
+			ids = append(ids, nf.SubstituidaPorID)
+		}
+	}
+	numeros := make(map[string]string, len(ids))
+	if len(ids) == 0 {
+		return numeros, nil
+	}
+	var relacionadas []domain.NotaFiscal
+	if err := s.db.Select("id", "numero").Where("id IN ?", ids).Find(&relacionadas).Error; err != nil {
+		return nil, err
+	}
+	for _, nf := range relacionadas {
+		numeros[nf.ID] = nf.Numero
+	}
+	return numeros, nil
+}
+
+// eventos lists the cancellations and substitutions homologated in the period
+func (s *LivroFiscalService) eventos(prestadorID string, inicio, fim time.Time) ([]LivroFiscalEvento, error) {
+	var rows []struct {
+		domain.NFSeEvento
+		Numero          string
+		ChaveAcesso     string
+		DataCompetencia time.Time
+		TomadorNome     string
+		ValorServicos   float64
+		ValorISS        float64
+	}
+	err := s.db.Table("nfse_eventos e").
+		Select("e.*, nf.numero, nf.chave_acesso, nf.data_competencia, nf.tomador_nome, nf.valor_servicos, nf.valor_iss").
+		Joins("JOIN notas_fiscais nf ON nf.id = e.nf_se_id").
+		Where("nf.prestador_id = ? AND e.created_at >= ? AND e.created_at < ?", prestadorID, inicio, fim).
+		Where("(e.tipo = ? AND e.status = ?) OR (e.tipo = ? AND e.status = ?)",
+			domain.NFSeEventoCancelamento, domain.NFSeStatusCancelada, domain.NFSeEventoSubstituicao, domain.NFSeStatusSubstituida).
+		Order("e.created_at").Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	eventos := make([]LivroFiscalEvento, 0, len(rows))
+	for _, r := range rows {
+		motivo := r.Motivo
+		if motivo == "" {
+			motivo = r.Mensagem
+		}
+		eventos = append(eventos, LivroFiscalEvento{
+			Data:            r.CreatedAt,
+			Tipo:            r.Tipo,
+			Numero:          r.Numero,
+			ChaveAcesso:     r.ChaveAcesso,
+			DataCompetencia: r.DataCompetencia,
+			TomadorNome:     r.TomadorNome,
+			ValorServicos:   r.ValorServicos,
+			ValorISS:        r.ValorISS,
+			Protocolo:       r.Protocolo,
+			Motivo:          motivo,
+		})
+	}
+	return eventos, nil
+}
+
+func livroNota(nf *domain.NotaFiscal, numeros map[string]string) LivroFiscalNota {
+	ordens := make([]string, 0, len(nf.Solicitacoes))
+	for _, l := range nf.Solicitacoes {
+		ordens = append(ordens, "#"+strconv.Itoa(l.NumeroSolicitacao))
+	}
+	return LivroFiscalNota{
+		NotaID:           nf.ID,
+		Numero:           nf.Numero,
+		ChaveAcesso:      nf.ChaveAcesso,
+		SerieDPS:         nf.SerieDPS,
+		NumeroDPS:        nf.NumeroDPS,
+		DataEmissao:      nf.DataEmissao,
+		DataCompetencia:  nf.DataCompetencia,
+		Solicitacoes:     strings.Join(ordens, " "),
+		TomadorNome:      nf.TomadorNome,
+		TomadorDocumento: nf.TomadorDocumento,
+		CodigoServico:    nf.CodigoServico,
+		Status:           nf.Status,
+		ValorServicos:    nf.ValorServicos,
+		ValorDeducoes:    nf.ValorDeducoes,
+		AliquotaISS:      nf.AliquotaISS,
+		ValorISS:         nf.ValorISS,
+		ISSRetido:        nf.ISSRetido,
+		ValorPIS:         nf.ValorPIS,
+		ValorCOFINS:      nf.ValorCOFINS,
+		ValorCSLL:        nf.ValorCSLL,
+		ValorIR:          nf.ValorIR,
+		ValorINSS:        nf.ValorINSS,
+		ValorLiquido:     nf.ValorLiquido,
+		Substitui:        numeros[nf.SubstituiID],
+		SubstituidaPor:   numeros[nf.SubstituidaPorID],
+		nota:             nf,
+	}
+}
+
+// WriteCSV writes the register with a section for the notes, one for the events and one for the
+// totals; values use a decimal comma and fields are separated by semicolons, as spreadsheets in
+// pt-BR expect
+func (s *LivroFiscalService) WriteCSV(w io.Writer, livro *LivroFiscal) error {
+	writer := csv.NewWriter(w)
+	writer.Comma = ';'
+
+	writer.Write([]string{"Livro de Registro de Serviços Prestados", livro.RazaoSocial, formatCNPJ(livro.CNPJ), "Competência " + livro.Competencia, livro.RegimeTributario})
+	writer.Write(nil)
+
+	writer.Write([]string{"Numero", "Chave de Acesso", "Serie DPS", "Numero DPS", "Emissao", "Competencia", "OS",
+		"Tomador", "Documento", "Codigo Servico", "Situacao", "Valor Servicos", "Deducoes", "Aliquota ISS",
+		"ISS", "ISS Retido", "PIS", "COFINS", "CSLL", "IR", "INSS", "Valor Liquido", "Substitui", "Substituida Por"})
+	for _, n := range livro.Notas {
+		issRetido := "Não"
+		if n.ISSRetido {
+			issRetido = "Sim"
+		}
+		writer.Write([]string{
+			n.Numero, n.ChaveAcesso, n.SerieDPS, strconv.FormatInt(n.NumeroDPS, 10),
+			formatDate(n.DataEmissao), n.DataCompetencia.Format("01/2006"), n.Solicitacoes,
+			n.TomadorNome, formatDocument(n.TomadorDocumento), n.CodigoServico, n.Status,
+			decimalBR(n.ValorServicos), decimalBR(n.ValorDeducoes), decimalBR(n.AliquotaISS),
+			decimalBR(n.ValorISS), issRetido, decimalBR(n.ValorPIS), decimalBR(n.ValorCOFINS),
+			decimalBR(n.ValorCSLL), decimalBR(n.ValorIR), decimalBR(n.ValorINSS), decimalBR(n.ValorLiquido),
+			n.Substitui, n.SubstituidaPor,
+		})
+	}
+
+	writer.Write(nil)
+	writer.Write([]string{"Cancelamentos e substituições no mês"})
+	writer.Write([]string{"Data", "Evento", "Numero", "Chave de Acesso", "Competencia", "Tomador", "Valor Servicos", "ISS", "Protocolo", "Motivo"})
+	for _, e := range livro.Eventos {
+		writer.Write([]string{
+			e.Data.Format("02/01/2006 15:04"), e.Tipo, e.Numero, e.ChaveAcesso, e.DataCompetencia.Format("01/2006"),
+			e.TomadorNome, decimalBR(e.ValorServicos), decimalBR(e.ValorISS), e.Protocolo, e.Motivo,
+		})
+	}
+
+	t := livro.Totais
+	writer.Write(nil)
+	writer.Write([]string{"Totais das notas em vigor"})
+	for _, linha := range [][2]string{
+		{"Notas emitidas", strconv.Itoa(t.NotasEmitidas)},
+		{"Notas canceladas", strconv.Itoa(t.NotasCanceladas)},
+		{"Notas substituídas", strconv.Itoa(t.NotasSubstituidas)},
+		{"Receita bruta (valor dos serviços)", decimalBR(t.ValorServicos)},
+		{"Deduções", decimalBR(t.ValorDeducoes)},
+		{"ISS apurado", decimalBR(t.ValorISS)},
+		{"ISS retido pelos tomadores", decimalBR(t.ValorISSRetido)},
+		{"ISS a recolher", decimalBR(t.ValorISSRecolher)},
+		{"PIS retido", decimalBR(t.ValorPIS)},
+		{"COFINS retida", decimalBR(t.ValorCOFINS)},
+		{"CSLL retida", decimalBR(t.ValorCSLL)},
+		{"IR retido", decimalBR(t.ValorIR)},
+		{"INSS retido", decimalBR(t.ValorINSS)},
+		{"Total retido", decimalBR(t.TotalRetido)},
+		{"Valor líquido", decimalBR(t.ValorLiquido)},
+	} {
+		writer.Write(linha[:])
+	}
+	if t.ValorDASEstimado > 0 {
+		writer.Write([]string{"Alíquota efetiva do Simples (%)", decimalBR(t.AliquotaSimples)})
+		writer.Write([]string{"DAS estimado", decimalBR(t.ValorDASEstimado)})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteZIP writes the accountant package: the register as CSV plus the authorized XML and the
+// DANFS-e of every note of the month. Files that cannot be read are listed instead of failing.
+func (s *LivroFiscalService) WriteZIP(w io.Writer, livro *LivroFiscal) error {
+	zw := zip.NewWriter(w)
+	prefixo := "livro_fiscal_" + livro.Competencia
+
+	f, err := zw.CreateHeader(zipHeader(prefixo + ".csv"))
+	if err != nil {
+		return err
+	}
+	if err := s.WriteCSV(f, livro); err != nil {
+		return err
+	}
+
+	var ausentes []string
+	for _, n := range livro.Notas {
+		nome := n.ChaveAcesso
+		if nome == "" {
+			nome = n.NotaID
+		}
+
+		if xml, err := s.xml(n.nota); err != nil {
+			ausentes = append(ausentes, fmt.Sprintf("NFS-e %s: XML não encontrado", n.Numero))
+		} else if err := zipFile(zw, "xml/"+nome+".xml", xml); err != nil {
+			return err
+		}
+
+		pdf, err := s.danfse.PDF(n.nota)
+		if err != nil {
+			log.Printf("⚠️ DANFS-e da NFS-e %s fora do pacote do contador: %v", n.NotaID, err)
+			ausentes = append(ausentes, fmt.Sprintf("NFS-e %s: DANFS-e não gerado (%v)", n.Numero, err))
+		} else if err := zipFile(zw, "danfse/"+nome+".pdf", pdf); err != nil {
+			return err
+		}
+	}
+
+	if len(ausentes) > 0 {
+		if err := zipFile(zw, "arquivos_ausentes.txt", []byte(strings.Join(ausentes, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// xml reads the authorized XML of a note
+func (s *LivroFiscalService) xml(nf *domain.NotaFiscal) ([]byte, error) {
+	if nf.XMLPath == "" {
+		return nil, os.ErrNotExist
+	}
+	return s.danfse.storage.ReadFiscal(nf.XMLPath)
+}
+
+func zipHeader(name string) *zip.FileHeader {
+	return &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+}
+
+func zipFile(zw *zip.Writer, name string, data []byte) error {
+	f, err := zw.CreateHeader(zipHeader(name))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// decimalBR formats a value with two decimals and a decimal comma
+func decimalBR(v float64) string {
+	return strings.Replace(strconv.FormatFloat(v, 'f', 2, 64), ".", ",", 1)
+}
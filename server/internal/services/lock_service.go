@@ -0,0 +1,213 @@
+package services
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+	"inovar/internal/websocket"
+)
+
+// LockInfo describes who holds the edit lock of a request
+type LockInfo struct {
+	RequestID  string    `json:"id"`
+	LockedBy   string    `json:"lockedBy"`
+	LockedName string    `json:"lockedName"`
+	LockedAt   time.Time `json:"lockedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	CompanyID  string    `json:"-"`
+}
+
+// LockService manages the edit locks of requests
+type LockService struct {
+	db             *gorm.DB
+	hub            *websocket.Hub
+	defaultTimeout int
+}
+
+// NewLockService creates a new edit-lock service
+func NewLockService(db *gorm.DB, hub *websocket.Hub, cfg *config.Config) *LockService {
+	return &LockService{db: db, hub: hub, defaultTimeout: cfg.LockTimeoutSecs}
+}
+
+// Timeout returns how long a lock lives without renewal (system setting lock_timeout, in seconds)
+func (s *LockService) Timeout() time.Duration {
+	return time.Duration(getSettingInt(s.db, "", "lock_timeout", s.defaultTimeout)) * time.Second
+}
+
+// info returns the current lock of a request of the company, or nil when it is free or expired
+func (s *LockService) info(requestID, companyID string) (*LockInfo, error) {
+	var req domain.Solicitacao
+	if err := s.db.Select("id", "company_id", "locked_by", "locked_at").
+		First(&req, "id = ? AND company_id = ?", requestID, companyID).Error; err != nil {
+		return nil, err
+	}
+	if req.LockedBy == nil || *req.LockedBy == "" || req.LockedAt == nil {
+		return nil, nil
+	}
+
+	expires := req.LockedAt.Add(s.Timeout())
+	if !expires.After(time.Now()) {
+		return nil, nil
+	}
+
+	var user domain.User
+	s.db.Select("name").First(&user, "id = ?", *req.LockedBy)
+
+	return &LockInfo{
+		RequestID:  requestID,
+		LockedBy:   *req.LockedBy,
+		LockedName: user.Name,
+		LockedAt:   *req.LockedAt,
+		ExpiresAt:  expires,
+		CompanyID:  req.CompanyID,
+	}, nil
+}
+
+// company returns the company of a request, whose clients receive its lock events
+func (s *LockService) company(requestID string) string {
+	var companyIDs []string
+	s.db.Model(&domain.Solicitacao{}).Where("id = ?", requestID).Pluck("company_id", &companyIDs)
+	if len(companyIDs) == 0 {
+		return ""
+	}
+	return companyIDs[0]
+}
+
+// Acquire takes or renews the lock for userID. When another user holds it, that lock is returned with ok=false.
+func (s *LockService) Acquire(requestID, companyID, userID string) (*LockInfo, bool, error) {
+	now := time.Now()
+	result := s.db.Model(&domain.Solicitacao{}).
+		Where("id = ? AND company_id = ? AND (locked_by IS NULL OR locked_by = '' OR locked_by = ? OR locked_at IS NULL OR locked_at <= ?)",
+			requestID, companyID, userID, now.Add(-s.Timeout())).
+		Updates(map[string]interface{}{"locked_by": userID, "locked_at": now})
+	if result.Error != nil {
+		return nil, false, result.Error
+	}
+
+	lock, err := s.info(requestID, companyID)
+	if err != nil {
+		return nil, false, err
+	}
+	if result.RowsAffected == 0 {
+		return lock, false, nil
+	}
+
+	s.hub.Broadcast(lock.CompanyID, "request:locked", lock)
+	return lock, true, nil
+}
+
+// HeldByOther returns the lock when someone other than userID holds it.
+// A lock held by userID is renewed.
+func (s *LockService) HeldByOther(requestID, companyID, userID string) (*LockInfo, error) {
+	lock, err := s.info(requestID, companyID)
+	if err != nil || lock == nil {
+		return nil, err
+	}
+	if lock.LockedBy != userID {
+		return lock, nil
+	}
+	s.Renew(requestID, userID)
+	return nil, nil
+}
+
+// Renew extends the lock held by userID. Returns false when the user does not hold it.
+func (s *LockService) Renew(requestID, userID string) bool {
+	result := s.db.Model(&domain.Solicitacao{}).
+		Where("id = ? AND locked_by = ? AND locked_at > ?", requestID, userID, time.Now().Add(-s.Timeout())).
+		Update("locked_at", time.Now())
+	return result.Error == nil && result.RowsAffected > 0
+}
+
+// Release frees the lock held by userID
+func (s *LockService) Release(requestID, userID string) (bool, error) {
+	result := s.db.Model(&domain.Solicitacao{}).
+		Where("id = ? AND locked_by = ?", requestID, userID).
+		Updates(map[string]interface{}{"locked_by": nil, "locked_at": nil})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected > 0 {
+		s.hub.Broadcast(s.company(requestID), "request:unlocked", map[string]interface{}{"id": requestID, "reason": "released"})
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ForceRelease frees the lock regardless of its holder and returns the lock that was removed
+func (s *LockService) ForceRelease(requestID, companyID, byUserID string) (*LockInfo, error) {
+	previous, err := s.info(requestID, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.db.Model(&domain.Solicitacao{}).Where("id = ? AND company_id = ?", requestID, companyID).
+		Updates(map[string]interface{}{"locked_by": nil, "locked_at": nil}).Error; err != nil {
+		return nil, err
+	}
+
+	s.hub.Broadcast(companyID, "request:unlocked", map[string]interface{}{"id": requestID, "reason": "forced", "by": byUserID})
+	return previous, nil
+}
+
+// Sweep clears expired locks and announces them. Returns how many were cleared.
+func (s *LockService) Sweep() int {
+	var ids []string
+	s.db.Model(&domain.Solicitacao{}).
+		Where("locked_by IS NOT NULL AND locked_by <> '' AND (locked_at IS NULL OR locked_at <= ?)", time.Now().Add(-s.Timeout())).
+		Pluck("id", &ids)
+
+	cleared := 0
+	for _, id := range ids {
+		result := s.db.Model(&domain.Solicitacao{}).
+			Where("id = ? AND (locked_at IS NULL OR locked_at <= ?)", id, time.Now().Add(-s.Timeout())).
+			Updates(map[string]interface{}{"locked_by": nil, "locked_at": nil})
+		if result.Error == nil && result.RowsAffected > 0 {
+			s.hub.Broadcast(s.company(id), "request:unlocked", map[string]interface{}{"id": id, "reason": "expired"})
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// Run sweeps expired locks periodically. It blocks.
+func (s *LockService) Run() {
+	log.Println("🔒 Limpeza de bloqueios de edição iniciada")
+
+	for {
+		// Re-read each round so changes to lock_timeout apply without a restart
+		time.Sleep(s.sweepInterval())
+		if n := s.Sweep(); n > 0 {
+			log.Printf("🔓 %d bloqueio(s) de edição expirado(s) liberado(s)", n)
+		}
+	}
+}
+
+// sweepInterval checks a few times per timeout, between 5s and 1min
+func (s *LockService) sweepInterval() time.Duration {
+	interval := s.Timeout() / 4
+	if interval < 5*time.Second {
+		interval = 5 * time.Second
+	}
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	return interval
+}
+
+// HandleMessage renews locks from "lock:heartbeat" websocket events ({"requestId": "..."})
+func (s *LockService) HandleMessage(userID, event string, data json.RawMessage) {
+	if event != "lock:heartbeat" {
+		return
+	}
+	var payload struct {
+		RequestID string `json:"requestId"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil || payload.RequestID == "" {
+		return
+	}
+	s.Renew(payload.RequestID, userID)
+}
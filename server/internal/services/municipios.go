@@ -0,0 +1,336 @@
+package services
+
+import (
+	"embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+
+	"inovar/internal/domain"
+)
+
+// Embedded fiscal datasets; a directory with files of the same name replaces them (FISCAL_DATA_DIR).
+// The embedded municipios.csv is partial (capitals and Espírito Santo) until regenerated from the
+// IBGE with go generate. A client city missing from the catalog blocks the emission (see BuildDPS).
+//
+//go:generate python3 ../../../infra/scripts/ibge_municipios.py
+//go:embed data/municipios.csv data/cep_faixas.csv data/iss_municipios.csv
+var dadosFiscais embed.FS
+
+// MunicipiosIBGE is the size of the complete territorial division; smaller catalogs are partial
+const MunicipiosIBGE = 5570
+
+// Municipio is a municipality of the IBGE territorial division
+type Municipio struct {
+	Codigo int    `json:"codigo"`
+	Nome   string `json:"nome"`
+	UF     string `json:"uf"`
+}
+
+// RegraISS is the ISS treatment of a LC 116 item in a municipality
+type RegraISS struct {
+	CodigoMunicipio           int     `json:"codigoMunicipio"`
+	ItemLC116                 string  `json:"itemLc116"`                          // e.g. 14.01
+	CodigoTributacaoNacional  string  `json:"codigoTributacaoNacional,omitempty"` // empty applies to the whole item
+	CodigoTributacaoMunicipal string  `json:"codigoTributacaoMunicipal,omitempty"`
+	Aliquota                  float64 `json:"aliquota"`
+}
+
+type faixaCEP struct {
+	inicio, fim string
+	municipio   *Municipio
+}
+
+// MunicipioCatalog looks up municipalities by IBGE code, name and UF or CEP, and their ISS rules
+type MunicipioCatalog struct {
+	porCodigo map[int]*Municipio
+	porNome   map[string]*Municipio
+	faixas    []faixaCEP
+	regras    map[string][]RegraISS
+}
+
+var (
+	catalogoMu sync.RWMutex
+	catalogo   *MunicipioCatalog
+)
+
+// Municipios returns the municipality catalog in use, loading the embedded one on first use
+func Municipios() *MunicipioCatalog {
+	catalogoMu.RLock()
+	c := catalogo
+	catalogoMu.RUnlock()
+	if c != nil {
+		return c
+	}
+
+	catalogoMu.Lock()
+	defer catalogoMu.Unlock()
+	if catalogo == nil {
+		var err error
+		if catalogo, err = LoadMunicipioCatalog(""); err != nil {
+			log.Fatalf("❌ Catálogo de municípios embutido inválido: %v", err)
+		}
+	}
+	return catalogo
+}
+
+// SetMunicipioCatalog replaces the catalog in use
+func SetMunicipioCatalog(c *MunicipioCatalog) {
+	catalogoMu.Lock()
+	catalogo = c
+	catalogoMu.Unlock()
+}
+
+// LoadMunicipioCatalog reads the datasets, preferring the files found in dir over the embedded ones
+func LoadMunicipioCatalog(dir string) (*MunicipioCatalog, error) {
+	c := &MunicipioCatalog{
+		porCodigo: map[int]*Municipio{},
+		porNome:   map[string]*Municipio{},
+		regras:    map[string][]RegraISS{},
+	}
+
+	err := lerDadosFiscais(dir, "municipios.csv", 3, func(rec []string) error {
+		codigo, err := strconv.Atoi(rec[0])
+		if err != nil || codigo < 1000000 || codigo > 9999999 {
+			return fmt.Errorf("código IBGE inválido %q", rec[0])
+		}
+		m := &Municipio{Codigo: codigo, Nome: strings.TrimSpace(rec[1]), UF: strings.ToUpper(strings.TrimSpace(rec[2]))}
+		c.porCodigo[codigo] = m
+		c.porNome[chaveMunicipio(m.Nome, m.UF)] = m
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = lerDadosFiscais(dir, "cep_faixas.csv", 3, func(rec []string) error {
+		codigo, _ := strconv.Atoi(rec[0])
+		m, ok := c.porCodigo[codigo]
+		inicio, fim := onlyDigits(rec[1]), onlyDigits(rec[2])
+		if !ok || len(inicio) != 8 || len(fim) != 8 || inicio > fim {
+			return fmt.Errorf("faixa de CEP inválida para o município %q", rec[0])
+		}
+		c.faixas = append(c.faixas, faixaCEP{inicio: inicio, fim: fim, municipio: m})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(c.faixas, func(i, j int) bool { return c.faixas[i].inicio < c.faixas[j].inicio })
+
+	err = lerDadosFiscais(dir, "iss_municipios.csv", 5, func(rec []string) error {
+		codigo, _ := strconv.Atoi(rec[0])
+		item := ItemLC116(rec[1])
+		aliquota, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(rec[4]), ",", ".", 1), 64)
+		if _, ok := c.porCodigo[codigo]; !ok || item == "" || err != nil || validarAliquotaISS(aliquota) != nil {
+			return fmt.Errorf("regra de ISS inválida para o município %q, item %q", rec[0], rec[1])
+		}
+		regra := RegraISS{
+			CodigoMunicipio:           codigo,
+			ItemLC116:                 item,
+			CodigoTributacaoNacional:  onlyDigits(rec[2]),
+			CodigoTributacaoMunicipal: strings.TrimSpace(rec[3]),
+			Aliquota:                  aliquota,
+		}
+		key := chaveRegra(codigo, item)
+		c.regras[key] = append(c.regras[key], regra)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// lerDadosFiscais parses a semicolon separated dataset, skipping its header and # comments
+func lerDadosFiscais(dir, name string, campos int, fn func([]string) error) error {
+	var f io.ReadCloser
+	var err error
+	if path := filepath.Join(dir, name); dir != "" && fileExists(path) {
+		f, err = os.Open(path)
+	} else {
+		f, err = dadosFiscais.Open("data/" + name)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comma = ';'
+	r.Comment = '#'
+	r.FieldsPerRecord = campos
+	header := true
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if header {
+			header = false
+			continue
+		}
+		if err := fn(rec); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// Len returns how many municipalities the catalog holds
+func (c *MunicipioCatalog) Len() int {
+	return len(c.porCodigo)
+}
+
+// Completo tells whether the catalog holds the whole IBGE territorial division
+func (c *MunicipioCatalog) Completo() bool {
+	return c.Len() >= MunicipiosIBGE
+}
+
+// Codigo returns the municipality of an IBGE code
+func (c *MunicipioCatalog) Codigo(codigo int) *Municipio {
+	return c.porCodigo[codigo]
+}
+
+// Buscar finds a municipality by name and UF, ignoring case, accents and punctuation
+func (c *MunicipioCatalog) Buscar(nome, uf string) *Municipio {
+	return c.porNome[chaveMunicipio(nome, uf)]
+}
+
+// BuscarCEP finds the municipality of a CEP; a prefix of at least 5 digits is completed with zeros
+func (c *MunicipioCatalog) BuscarCEP(cep string) *Municipio {
+	cep = onlyDigits(cep)
+	if len(cep) < 5 || len(cep) > 8 {
+		return nil
+	}
+	cep += strings.Repeat("0", 8-len(cep))
+	i := sort.Search(len(c.faixas), func(i int) bool { return c.faixas[i].inicio > cep })
+	if i > 0 && cep <= c.faixas[i-1].fim {
+		return c.faixas[i-1].municipio
+	}
+	return nil
+}
+
+// Pesquisar lists the municipalities whose name starts with the query, optionally within a UF
+func (c *MunicipioCatalog) Pesquisar(q, uf string, limit int) []Municipio {
+	prefixo := normalizarNome(q)
+	uf = strings.ToUpper(strings.TrimSpace(uf))
+	result := []Municipio{}
+	for _, m := range c.porCodigo {
+		if (uf == "" || m.UF == uf) && strings.HasPrefix(normalizarNome(m.Nome), prefixo) {
+			result = append(result, *m)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Nome != result[j].Nome {
+			return normalizarNome(result[i].Nome) < normalizarNome(result[j].Nome)
+		}
+		return result[i].UF < result[j].UF
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result
+}
+
+// Endereco resolves the municipality of an address by city and UF, falling back to its CEP
+func (c *MunicipioCatalog) Endereco(end *domain.Endereco) *Municipio {
+	if end == nil {
+		return nil
+	}
+	if m := c.Buscar(end.City, end.State); m != nil {
+		return m
+	}
+	if m := c.BuscarCEP(end.ZipCode); m != nil && (end.State == "" || strings.EqualFold(m.UF, strings.TrimSpace(end.State))) {
+		return m
+	}
+	log.Printf("⚠️ Código IBGE não encontrado para %s-%s (CEP %s)", end.City, end.State, end.ZipCode)
+	return nil
+}
+
+// RegraISS returns the ISS rule of a national service code in a municipality; a rule for the exact
+// desdobro wins over one for the whole item
+func (c *MunicipioCatalog) RegraISS(codigoMunicipio int, codigoServico string) *RegraISS {
+	codigo := onlyDigits(codigoServico)
+	var geral *RegraISS
+	regras := c.regras[chaveRegra(codigoMunicipio, ItemLC116(codigo))]
+	for i := range regras {
+		switch regras[i].CodigoTributacaoNacional {
+		case codigo:
+			return &regras[i]
+		case "":
+			geral = &regras[i]
+		}
+	}
+	return geral
+}
+
+// CodigoMunicipioEndereco returns the IBGE code of an address, or 0 when unknown
+func CodigoMunicipioEndereco(end *domain.Endereco) int {
+	if m := Municipios().Endereco(end); m != nil {
+		return m.Codigo
+	}
+	return 0
+}
+
+// ItemLC116 returns the LC 116 item (e.g. 14.01) of a national service code or item
+func ItemLC116(codigo string) string {
+	digits := onlyDigits(codigo)
+	if len(digits) == 3 {
+		// Items below 10 written without the leading zero (7.02)
+		digits = "0" + digits
+	}
+	if len(digits) < 4 {
+		return ""
+	}
+	return digits[:2] + "." + digits[2:4]
+}
+
+func chaveRegra(codigoMunicipio int, item string) string {
+	return strconv.Itoa(codigoMunicipio) + "|" + item
+}
+
+func chaveMunicipio(nome, uf string) string {
+	return normalizarNome(nome) + "|" + strings.ToUpper(strings.TrimSpace(uf))
+}
+
+var semAcentos = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// normalizarNome uppercases a name without accents, apostrophes and repeated separators
+func normalizarNome(nome string) string {
+	s, _, err := transform.String(semAcentos, nome)
+	if err != nil {
+		s = nome
+	}
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\'' || r == '’' || r == '`':
+			return -1
+		case r == '-' || unicode.IsSpace(r):
+			return ' '
+		}
+		return unicode.ToUpper(r)
+	}, s)
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+)
+
+// catalogWith builds a catalog of the given size holding Vitória-ES and filler codes
+func catalogWith(size int) *MunicipioCatalog {
+	vitoria := &Municipio{Codigo: 3205309, Nome: "Vitória", UF: "ES"}
+	c := &MunicipioCatalog{
+		porCodigo: map[int]*Municipio{vitoria.Codigo: vitoria},
+		porNome:   map[string]*Municipio{chaveMunicipio(vitoria.Nome, vitoria.UF): vitoria},
+		regras:    map[string][]RegraISS{},
+	}
+	for codigo := 1100001; c.Len() < size; codigo++ {
+		c.porCodigo[codigo] = &Municipio{Codigo: codigo}
+	}
+	return c
+}
+
+func TestEmbeddedCatalogIsPartial(t *testing.T) {
+	c, err := LoadMunicipioCatalog("")
+	if err != nil {
+		t.Fatalf("LoadMunicipioCatalog: %v", err)
+	}
+	if c.Completo() {
+		t.Errorf("embedded catalog with %d municipalities reported as complete", c.Len())
+	}
+	if m := c.Buscar("Vitória", "ES"); m == nil || m.Codigo != 3205309 {
+		t.Errorf("Vitória-ES = %+v, want 3205309", m)
+	}
+}
+
+func TestBuildDPSUnknownClientMunicipality(t *testing.T) {
+	t.Cleanup(func() { SetMunicipioCatalog(nil) })
+
+	e := &NFSeEmissor{cfg: &config.Config{}}
+	nf := &domain.NotaFiscal{
+		TomadorNome:      "Cliente Teste",
+		TomadorDocumento: "12345678909",
+		Discriminacao:    "Manutenção",
+		CodigoServico:    "140101",
+		ValorServicos:    100,
+		DataCompetencia:  time.Now(),
+		SerieDPS:         SerieDPSPadrao,
+		NumeroDPS:        1,
+	}
+	prestador := &domain.Prestador{CNPJ: "11222333000181"}
+	fiscal := &domain.ConfiguracaoFiscal{CodigoMunicipio: 3205309}
+	cliente := &domain.Cliente{Endereco: &domain.Endereco{Street: "Rua A", City: "Cidade Desconhecida", State: "PI", ZipCode: "64000000"}}
+
+	// Partial catalog: the municipality is not guessed
+	SetMunicipioCatalog(catalogWith(10))
+	if _, err := e.BuildDPS(nf, prestador, fiscal, cliente); err == nil || !strings.Contains(err.Error(), "FISCAL_DATA_DIR") {
+		t.Errorf("BuildDPS with a partial catalog = %v, want an error pointing at FISCAL_DATA_DIR", err)
+	}
+
+	// Complete catalog: the address is wrong and the note is not built
+	SetMunicipioCatalog(catalogWith(MunicipiosIBGE))
+	if _, err := e.BuildDPS(nf, prestador, fiscal, cliente); err == nil {
+		t.Error("BuildDPS accepted a client municipality missing from the complete catalog")
+	}
+
+	// A known municipality is the place of the service
+	cliente.Endereco.City, cliente.Endereco.State = "Vitória", "ES"
+	dps, err := e.BuildDPS(nf, prestador, fiscal, cliente)
+	if err != nil {
+		t.Fatalf("BuildDPS: %v", err)
+	}
+	if got := dps.InfDPS.Serv.LocPrest.CLocPrestacao; got != 3205309 {
+		t.Errorf("local da prestação = %d, want 3205309", got)
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+// Cancellation window used when the fiscal config does not set the municipal one
+const prazoCancelamentoPadraoDias = 30
+
+// MotivoCancelamento is a reason code accepted by the cancellation event
+type MotivoCancelamento struct {
+	Codigo    string `json:"codigo"`
+	Descricao string `json:"descricao"`
+}
+
+// MotivosCancelamento lists the cancellation reasons in the order they are offered
+var MotivosCancelamento = []MotivoCancelamento{
+	{Codigo: domain.MotivoCancelErroEmissao, Descricao: "Erro na Emissão"},
+	{Codigo: domain.MotivoCancelServNaoRealizado, Descricao: "Serviço não Prestado"},
+	{Codigo: domain.MotivoCancelDuplicidade, Descricao: "Duplicidade de Nota"},
+	{Codigo: domain.MotivoCancelErroPreenchimento, Descricao: "Erro de Preenchimento"},
+}
+
+// ForaDoPrazoCancelamento is returned when the municipal window to cancel a note has passed;
+// the note must then be replaced by a substitute
+type ForaDoPrazoCancelamento struct {
+	Prazo time.Time
+	Dias  int
+}
+
+func (e *ForaDoPrazoCancelamento) Error() string {
+	return fmt.Sprintf("prazo de cancelamento de %d dias encerrado em %s; emita uma NFS-e substituta",
+		e.Dias, e.Prazo.Format("02/01/2006"))
+}
+
+// ValidarCancelamento checks the reason code and the justification sent in the event (xMotivo)
+func ValidarCancelamento(codigoMotivo, motivo string) error {
+	valido := false
+	for _, m := range MotivosCancelamento {
+		if m.Codigo == codigoMotivo {
+			valido = true
+			break
+		}
+	}
+	if !valido {
+		return fmt.Errorf("código de motivo de cancelamento inválido: %q", codigoMotivo)
+	}
+	switch n := len([]rune(strings.TrimSpace(motivo))); {
+	case n < 15:
+		return errors.New("justifique o cancelamento com ao menos 15 caracteres")
+	case n > 255:
+		return errors.New("a justificativa do cancelamento deve ter no máximo 255 caracteres")
+	}
+	return nil
+}
+
+// PrazoCancelamento returns the last moment a note can be cancelled and the window in days
+func PrazoCancelamento(nf *domain.NotaFiscal, fiscal *domain.ConfiguracaoFiscal) (time.Time, int) {
+	dias := fiscal.PrazoCancelamentoDias
+	if dias <= 0 {
+		dias = prazoCancelamentoPadraoDias
+	}
+	emissao := nf.CreatedAt
+	if nf.DataEmissao != nil {
+		emissao = *nf.DataEmissao
+	}
+	return emissao.AddDate(0, 0, dias), dias
+}
+
+// SolicitarCancelamento validates the cancellation of an issued note and queues its event for the
+// SEFIN. The note stays issued until the SEFIN returns the protocol of the event.
+func (e *NFSeEmissor) SolicitarCancelamento(nf *domain.NotaFiscal, codigoMotivo, motivo, userID string) (*domain.Job, error) {
+	motivo = strings.TrimSpace(motivo)
+	if err := ValidarCancelamento(codigoMotivo, motivo); err != nil {
+		return nil, err
+	}
+
+	switch nf.Status {
+	case domain.NFSeStatusEmitida:
+	case domain.NFSeStatusCancelada:
+		return nil, errors.New("NFS-e já cancelada")
+	case domain.NFSeStatusSubstituida:
+		return nil, errors.New("NFS-e substituída não pode ser cancelada")
+	default:
+		return nil, errors.New("NFS-e ainda não foi emitida")
+	}
+	if nf.ChaveAcesso == "" {
+		return nil, errors.New("NFS-e sem chave de acesso")
+	}
+	if e.queue.Active(nf.ID, domain.JobNFSeCancelar) {
+		return nil, errors.New("cancelamento já está em processamento")
+	}
+	var substitutas int64
+	e.db.Model(&domain.NotaFiscal{}).Where("substitui_id = ? AND status = ?", nf.ID, domain.NFSeStatusProcessando).Count(&substitutas)
+	if substitutas > 0 {
+		return nil, errors.New("substituição em processamento para esta NFS-e")
+	}
+
+	var fiscal domain.ConfiguracaoFiscal
+	e.db.Where("prestador_id = ?", nf.PrestadorID).First(&fiscal)
+	if prazo, dias := PrazoCancelamento(nf, &fiscal); time.Now().After(prazo) {
+		return nil, &ForaDoPrazoCancelamento{Prazo: prazo, Dias: dias}
+	}
+	if _, err := e.Certificate(nf.PrestadorID); err != nil {
+		return nil, err
+	}
+
+	var job *domain.Job
+	err := e.db.Transaction(func(tx *gorm.DB) error {
+		event := domain.NFSeEvento{
+			ID:       uuid.New().String(),
+			NFSeID:   nf.ID,
+			Tipo:     domain.NFSeEventoCancelamento,
+			Status:   domain.NFSeStatusProcessando,
+			Mensagem: "Cancelamento solicitado à SEFIN Nacional | Código: " + codigoMotivo,
+			Motivo:   motivo,
+			UserID:   userID,
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		var err error
+		job, err = e.EnfileirarCancelamento(tx, nf, codigoMotivo, motivo, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.queue.Wake()
+	return job, nil
+}
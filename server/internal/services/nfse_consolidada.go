@@ -0,0 +1,103 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"inovar/internal/domain"
+)
+
+// Maximum length of the service description (xDescServ) in the DPS
+const discriminacaoMaxLen = 2000
+
+// NotasDaSolicitacao scopes a note query to the notes invoicing a request, alone or consolidated
+func NotasDaSolicitacao(solicitacaoID string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("notas_fiscais.id IN (SELECT nota_fiscal_id FROM notas_fiscais_solicitacoes WHERE solicitacao_id = ?)", solicitacaoID)
+	}
+}
+
+// PrepararConsolidada builds a single note invoicing several completed requests of the same client,
+// with one block per OS in the discriminação. Requests must come as PrepararEmissao expects them.
+func (e *NFSeEmissor) PrepararConsolidada(solicitacoes []*domain.Solicitacao, prestador *domain.Prestador, fiscal *domain.ConfiguracaoFiscal) (*domain.NotaFiscal, *TaxCalculationResult, error) {
+	if len(solicitacoes) < 2 {
+		return nil, nil, errors.New("selecione ao menos duas solicitações para consolidar")
+	}
+	vistas := make(map[string]bool, len(solicitacoes))
+	for _, s := range solicitacoes {
+		if vistas[s.ID] {
+			return nil, nil, fmt.Errorf("OS #%d informada mais de uma vez", s.Numero)
+		}
+		vistas[s.ID] = true
+	}
+	return e.prepararNota(solicitacoes, prestador, fiscal, DiscriminacaoConsolidada(solicitacoes, time.Now()))
+}
+
+// DiscriminacaoConsolidada describes the services of several requests with a line per budget item
+// under each OS. When that exceeds the DPS limit each OS is summarized in a single line.
+func DiscriminacaoConsolidada(solicitacoes []*domain.Solicitacao, competencia time.Time) string {
+	cabecalho := fmt.Sprintf("Serviços de Manutenção de Ar Condicionado - competência %s - %d ordens de serviço",
+		competencia.Format("01/2006"), len(solicitacoes))
+
+	var total float64
+	var detalhada, resumida strings.Builder
+	detalhada.WriteString(cabecalho)
+	resumida.WriteString(cabecalho)
+	for _, s := range solicitacoes {
+		subtotal := ValorSolicitacao(s)
+		total += subtotal
+
+		titulo := fmt.Sprintf("OS #%d", s.Numero)
+		if s.DataAssinatura != nil {
+			titulo += " de " + s.DataAssinatura.Format("02/01/2006")
+		}
+		if s.ServiceType != "" {
+			titulo += " - " + s.ServiceType
+		}
+
+		fmt.Fprintf(&detalhada, "\n\n%s", titulo)
+		for _, item := range s.OrcamentoItens {
+			fmt.Fprintf(&detalhada, "\n- %s: %s x %s = %s", item.Descricao,
+				strconv.FormatFloat(item.Quantidade, 'f', -1, 64), formatMoney(item.ValorUnit), formatMoney(item.ValorUnit*item.Quantidade))
+		}
+		fmt.Fprintf(&detalhada, "\nSubtotal: %s", formatMoney(subtotal))
+
+		fmt.Fprintf(&resumida, "\n%s (%d itens): %s", titulo, len(s.OrcamentoItens), formatMoney(subtotal))
+	}
+	rodape := "\n\nTotal: " + formatMoney(total)
+	detalhada.WriteString(rodape)
+	resumida.WriteString(rodape)
+
+	if text := detalhada.String(); len([]rune(text)) <= discriminacaoMaxLen {
+		return text
+	}
+	text := []rune(resumida.String())
+	if len(text) > discriminacaoMaxLen {
+		text = append(text[:discriminacaoMaxLen-1], '…')
+	}
+	return string(text)
+}
+
+// CopiarSolicitacoes links a substitute note to the requests of the note it replaces
+func CopiarSolicitacoes(tx *gorm.DB, de, para string) error {
+	var links []domain.NotaFiscalSolicitacao
+	if err := tx.Where("nota_fiscal_id = ?", de).Find(&links).Error; err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	for i := range links {
+		links[i].ID = uuid.New().String()
+		links[i].NotaFiscalID = para
+		links[i].CreatedAt = time.Time{}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error
+}
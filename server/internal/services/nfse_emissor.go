@@ -0,0 +1,566 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+	"inovar/internal/websocket"
+)
+
+var (
+	// ErrNFSeJaEmitida is returned when a request is already invoiced by an issued note
+	ErrNFSeJaEmitida = errors.New("NFS-e já emitida para esta solicitação")
+	// ErrNFSeEmProcessamento is returned when a request is already invoiced by a note in progress
+	ErrNFSeEmProcessamento = errors.New("NFS-e já está em processamento")
+)
+
+// NFSeEmissor issues notes through the SEFIN Nacional and records the outcome
+type NFSeEmissor struct {
+	db            *gorm.DB
+	hub           *websocket.Hub
+	storage       *StorageService
+	vault         *CertVault
+	queue         *JobQueue
+	numeracao     *DPSNumeracao
+	notifications *NotificationService
+	cfg           *config.Config
+}
+
+// NewNFSeEmissor creates a new NFS-e issuing service and registers its jobs in the queue
+func NewNFSeEmissor(db *gorm.DB, hub *websocket.Hub, storage *StorageService, vault *CertVault, queue *JobQueue, numeracao *DPSNumeracao, notifications *NotificationService, cfg *config.Config) *NFSeEmissor {
+	e := &NFSeEmissor{db: db, hub: hub, storage: storage, vault: vault, queue: queue, numeracao: numeracao, notifications: notifications, cfg: cfg}
+	queue.Register(domain.JobNFSeEmitir, e.runEmitir, e.emissaoFalhou)
+	queue.Register(domain.JobNFSeCancelar, e.runCancelar, e.cancelamentoFalhou)
+	queue.Register(domain.JobNFSeConsultar, e.runConsultar, e.consultaFalhou)
+	return e
+}
+
+// Ambiente returns the SEFIN environment of a fiscal configuration (homologação unless set to produção)
+func Ambiente(fiscal *domain.ConfiguracaoFiscal) string {
+	if fiscal != nil && fiscal.Ambiente == AmbienteProducao {
+		return AmbienteProducao
+	}
+	return AmbienteHomologacao
+}
+
+// Client returns the SEFIN client for the environment of a provider
+func (e *NFSeEmissor) Client(fiscal *domain.ConfiguracaoFiscal) *NFSeNacionalService {
+	return NewNFSeNacionalService(e.cfg, Ambiente(fiscal))
+}
+
+// Certificate loads the active A1 certificate of a provider
+func (e *NFSeEmissor) Certificate(prestadorID string) (*A1Certificate, error) {
+	var cert domain.CertificadoDigital
+	if err := e.db.Where("prestador_id = ? AND ativo = ?", prestadorID, true).First(&cert).Error; err != nil {
+		return nil, errors.New("certificado digital A1 não configurado")
+	}
+	if err := CheckCertificateValid(&cert, time.Now()); err != nil {
+		return nil, err
+	}
+	return e.vault.Load(&cert)
+}
+
+// BuildDPS assembles the DPS of a note from the provider, client and fiscal configuration
+func (e *NFSeEmissor) BuildDPS(nf *domain.NotaFiscal, prestador *domain.Prestador, fiscal *domain.ConfiguracaoFiscal, cliente *domain.Cliente) (*DPS, error) {
+	codEmissao := fiscal.CodigoMunicipio
+	if codEmissao == 0 && prestador.Endereco != nil {
+		codEmissao = CodigoMunicipioEndereco(prestador.Endereco)
+	}
+	if codEmissao == 0 {
+		return nil, errors.New("código IBGE do município do prestador não configurado")
+	}
+	if nf.CodigoServico == "" {
+		return nil, errors.New("código de tributação nacional do serviço não configurado")
+	}
+
+	var endereco *EnderecoNFSe
+	codPrestacao := codEmissao
+	if cliente != nil && cliente.Endereco != nil {
+		cod := CodigoMunicipioEndereco(cliente.Endereco)
+		switch {
+		case cod != 0:
+			codPrestacao = cod
+			endereco = &EnderecoNFSe{
+				EndNac:  EnderecoNacional{CMun: cod, CEP: onlyDigits(cliente.Endereco.ZipCode)},
+				XLgr:    cliente.Endereco.Street,
+				Nro:     cliente.Endereco.Number,
+				XCpl:    cliente.Endereco.Complement,
+				XBairro: cliente.Endereco.District,
+			}
+		case Municipios().Completo():
+			return nil, fmt.Errorf("município do tomador %s-%s não encontrado no IBGE, corrija o endereço do cliente",
+				cliente.Endereco.City, cliente.Endereco.State)
+		default:
+			return nil, fmt.Errorf("município do tomador %s-%s não encontrado no catálogo de municípios, que está incompleto: corrija o endereço do cliente ou configure FISCAL_DATA_DIR com a tabela completa do IBGE",
+				cliente.Endereco.City, cliente.Endereco.State)
+		}
+	}
+
+	dps := BuildDPSFromRequest(
+		prestador.CNPJ,
+		fiscal.InscricaoMunicipal,
+		nf.TomadorDocumento,
+		nf.TomadorNome,
+		endereco,
+		nf.Discriminacao,
+		nf.CodigoServico,
+		nf.ValorServicos,
+		codEmissao,
+		codPrestacao,
+		fiscal.NaturezaOperacao,
+		nf.CNAE,
+	)
+	dps.InfDPS.TpAmb = e.Client(fiscal).TpAmb()
+	dps.InfDPS.DCompet = nf.DataCompetencia.Format("2006-01-02")
+	dps.SetNumero(nf.SerieDPS, nf.NumeroDPS)
+
+	if nf.SubstituiID != "" {
+		var original domain.NotaFiscal
+		if err := e.db.First(&original, "id = ?", nf.SubstituiID).Error; err != nil {
+			return nil, errors.New("NFS-e substituída não encontrada")
+		}
+		if original.ChaveAcesso == "" {
+			return nil, errors.New("NFS-e substituída não possui chave de acesso")
+		}
+		dps.InfDPS.Subst = &Substituicao{
+			ChNFSeSubst: original.ChaveAcesso,
+			CMotivo:     nf.CodigoMotivoSubst,
+			XMotivo:     nf.MotivoSubst,
+		}
+	}
+
+	switch {
+	case fiscal.IsMEI || fiscal.RegimeTributario == domain.RegimeMEI:
+		dps.InfDPS.Prest.RegTrib.OpSimpNac = 2
+	case fiscal.OptanteSimplesNac || fiscal.RegimeTributario == domain.RegimeSimplesNac:
+		dps.InfDPS.Prest.RegTrib.OpSimpNac = 3
+	}
+
+	trib := &dps.InfDPS.Valores.Trib
+	if trib.TribMun.TribISSQN == 1 {
+		trib.TribMun.PAliq = Decimal(nf.AliquotaISS)
+	}
+	if fiscal.ISSRetido {
+		trib.TribMun.TpRetISSQN = 2
+	}
+	if nf.ValorIR > 0 || nf.ValorCSLL > 0 || nf.ValorINSS > 0 {
+		trib.TribFed = &TributacaoFederal{
+			VRetCP:   Decimal(nf.ValorINSS),
+			VRetIRRF: Decimal(nf.ValorIR),
+			VRetCSLL: Decimal(nf.ValorCSLL),
+		}
+	}
+
+	return dps, nil
+}
+
+// ValorSolicitacao sums the budget items of a request
+func ValorSolicitacao(solicitacao *domain.Solicitacao) float64 {
+	var total float64
+	for _, item := range solicitacao.OrcamentoItens {
+		total += item.ValorUnit * item.Quantidade
+	}
+	return total
+}
+
+// PrepararEmissao builds the note of a completed request with its taxes and validates its DPS.
+// The request must come with its client, the client address and its budget items. A note that
+// failed before is reused, so it is sent again with its DPS data.
+func (e *NFSeEmissor) PrepararEmissao(solicitacao *domain.Solicitacao, prestador *domain.Prestador, fiscal *domain.ConfiguracaoFiscal) (*domain.NotaFiscal, *TaxCalculationResult, error) {
+	return e.prepararNota([]*domain.Solicitacao{solicitacao}, prestador, fiscal,
+		"Serviços de Manutenção de Ar Condicionado - Chamado #"+solicitacao.ID[:8])
+}
+
+// prepararNota builds the note invoicing one or more requests of the same client
+func (e *NFSeEmissor) prepararNota(solicitacoes []*domain.Solicitacao, prestador *domain.Prestador, fiscal *domain.ConfiguracaoFiscal, discriminacao string) (*domain.NotaFiscal, *TaxCalculationResult, error) {
+	// Errors of a consolidated note name the request they refer to
+	rotulo := func(s *domain.Solicitacao) string {
+		if len(solicitacoes) == 1 {
+			return ""
+		}
+		return fmt.Sprintf("OS #%d: ", s.Numero)
+	}
+
+	principal := solicitacoes[0]
+	var valorTotal float64
+	for _, s := range solicitacoes {
+		if s.Status != domain.StatusConcluida && s.Status != domain.StatusFinalizada {
+			return nil, nil, errors.New(rotulo(s) + "solicitação deve estar CONCLUÍDA para emitir NFS-e")
+		}
+		if s.ClientID != principal.ClientID || s.CompanyID != principal.CompanyID {
+			return nil, nil, errors.New(rotulo(s) + "uma NFS-e consolidada só pode reunir solicitações do mesmo cliente")
+		}
+		valorTotal += ValorSolicitacao(s)
+	}
+	if valorTotal <= 0 {
+		return nil, nil, errors.New("solicitação sem valor definido")
+	}
+
+	taxResult, err := NewTaxCalculationService().CalculateAt(valorTotal, 0, fiscal, CodigoMunicipioEndereco(principal.Client.Endereco))
+	if err != nil {
+		return nil, nil, errors.New("cálculo de tributos: " + err.Error())
+	}
+
+	// A request invoiced by a note in progress or issued must not be invoiced again; a failed note
+	// covering exactly the same requests is resent with its DPS number. SolicitarEmissao checks
+	// again under lock, this read only answers early.
+	var reenvio *domain.NotaFiscal
+	for _, s := range solicitacoes {
+		var nf domain.NotaFiscal
+		err := e.db.Scopes(NotasDaSolicitacao(s.ID)).Where("status NOT IN ?", []string{domain.NFSeStatusCancelada, domain.NFSeStatusSubstituida}).
+			Order("created_at desc").First(&nf).Error
+		switch {
+		case err == nil && nf.Status == domain.NFSeStatusEmitida:
+			return nil, nil, fmt.Errorf("%s%w", rotulo(s), ErrNFSeJaEmitida)
+		case err == nil && nf.Status != domain.NFSeStatusErro:
+			return nil, nil, fmt.Errorf("%s%w", rotulo(s), ErrNFSeEmProcessamento)
+		case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, nil, err
+		case err == nil && reenvio == nil && e.mesmasSolicitacoes(&nf, solicitacoes):
+			reenvio = &nf
+		}
+	}
+
+	nf := reenvio
+	if nf == nil {
+		nf = &domain.NotaFiscal{
+			ID:            uuid.New().String(),
+			SolicitacaoID: principal.ID,
+			PrestadorID:   principal.CompanyID,
+		}
+	}
+
+	// A failed substitute keeps the corrected data it was created with
+	if nf.SubstituiID == "" {
+		nf.TomadorNome = principal.ClientName
+		nf.TomadorDocumento = principal.Client.Document
+		ApplyTaxResult(nf, taxResult)
+		nf.Discriminacao = discriminacao
+		nf.CodigoServico = fiscal.CodigoServico
+		nf.CNAE = fiscal.CNAE
+		nf.Ambiente = Ambiente(fiscal)
+		nf.DataCompetencia = time.Now()
+	}
+	nf.Status = domain.NFSeStatusProcessando
+	nf.MensagemErro = ""
+	nf.Solicitacoes = make([]domain.NotaFiscalSolicitacao, len(solicitacoes))
+	for i, s := range solicitacoes {
+		nf.Solicitacoes[i] = domain.NotaFiscalSolicitacao{
+			ID:                uuid.New().String(),
+			NotaFiscalID:      nf.ID,
+			SolicitacaoID:     s.ID,
+			NumeroSolicitacao: s.Numero,
+			Valor:             ValorSolicitacao(s),
+		}
+	}
+
+	// Validate the DPS now; the queue rebuilds it on each attempt
+	if _, err := e.BuildDPS(nf, prestador, fiscal, &principal.Client); err != nil {
+		return nil, nil, err
+	}
+	return nf, taxResult, nil
+}
+
+// mesmasSolicitacoes reports whether a note invoices exactly the given requests
+func (e *NFSeEmissor) mesmasSolicitacoes(nf *domain.NotaFiscal, solicitacoes []*domain.Solicitacao) bool {
+	var ids []string
+	e.db.Model(&domain.NotaFiscalSolicitacao{}).Where("nota_fiscal_id = ?", nf.ID).Pluck("solicitacao_id", &ids)
+	if len(ids) != len(solicitacoes) {
+		return false
+	}
+	vinculadas := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		vinculadas[id] = true
+	}
+	for _, s := range solicitacoes {
+		if !vinculadas[s.ID] {
+			return false
+		}
+	}
+	return true
+}
+
+// SolicitarEmissao persists a prepared note with its request links, DPS number, emission event and job in tx:
+// a rollback never burns a number and a restart never loses the request. Wake the queue after commit.
+func (e *NFSeEmissor) SolicitarEmissao(tx *gorm.DB, nf *domain.NotaFiscal, taxResult *TaxCalculationResult, userID string) error {
+	if err := travarFaturamento(tx, nf); err != nil {
+		return err
+	}
+	if err := e.numeracao.Reservar(tx, nf); err != nil {
+		return err
+	}
+	if err := tx.Omit(clause.Associations).Save(nf).Error; err != nil {
+		return err
+	}
+	// A resent note already has its links
+	if len(nf.Solicitacoes) > 0 {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&nf.Solicitacoes).Error; err != nil {
+			return err
+		}
+	}
+	event := domain.NFSeEvento{
+		ID:       uuid.New().String(),
+		NFSeID:   nf.ID,
+		Tipo:     domain.NFSeEventoEmissao,
+		Status:   domain.NFSeStatusProcessando,
+		Mensagem: fmt.Sprintf("DPS %s nº %d | Regime: %s | ISS: %.2f%% | Total Tributos: R$ %.2f", nf.SerieDPS, nf.NumeroDPS, taxResult.RegimeTributario, taxResult.AliquotaISS, taxResult.TotalTributos),
+		UserID:   userID,
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return err
+	}
+	_, err := e.Enfileirar(tx, nf, userID)
+	return err
+}
+
+// travarFaturamento locks the requests of a note until tx ends and refuses them when another note
+// in progress or issued already invoices one of them. A substitute invoices the requests of the
+// note it replaces and is not checked.
+func travarFaturamento(tx *gorm.DB, nf *domain.NotaFiscal) error {
+	if nf.SubstituiID != "" || len(nf.Solicitacoes) == 0 {
+		return nil
+	}
+	ids := make([]string, len(nf.Solicitacoes))
+	for i, link := range nf.Solicitacoes {
+		ids[i] = link.SolicitacaoID
+	}
+
+	// The no-op update takes the write lock (the rows in PostgreSQL, the database in SQLite), so
+	// concurrent emissions for the same requests run the check below one at a time
+	err := tx.Model(&domain.Solicitacao{}).Where("id IN ?", ids).
+		UpdateColumn("updated_at", gorm.Expr("updated_at")).Error
+	if err != nil {
+		return err
+	}
+
+	for _, link := range nf.Solicitacoes {
+		var outra domain.NotaFiscal
+		err := tx.Scopes(NotasDaSolicitacao(link.SolicitacaoID)).
+			Where("notas_fiscais.id <> ? AND status NOT IN ?", nf.ID,
+				[]string{domain.NFSeStatusCancelada, domain.NFSeStatusSubstituida, domain.NFSeStatusErro}).
+			Limit(1).Find(&outra).Error
+		if err != nil {
+			return err
+		}
+		if outra.ID == "" {
+			continue
+		}
+		rotulo := ""
+		if len(nf.Solicitacoes) > 1 {
+			rotulo = fmt.Sprintf("OS #%d: ", link.NumeroSolicitacao)
+		}
+		if outra.Status == domain.NFSeStatusEmitida {
+			return fmt.Errorf("%s%w", rotulo, ErrNFSeJaEmitida)
+		}
+		return fmt.Errorf("%s%w", rotulo, ErrNFSeEmProcessamento)
+	}
+	return nil
+}
+
+// ApplyTaxResult copies the calculated values into a note; the federal fields of the note hold
+// the amounts withheld by the tomador, as declared in the DPS
+func ApplyTaxResult(nf *domain.NotaFiscal, taxResult *TaxCalculationResult) {
+	nf.ValorServicos = taxResult.ValorServicos
+	nf.ValorDeducoes = taxResult.ValorDeducoes
+	nf.ValorLiquido = taxResult.ValorLiquido
+	nf.AliquotaISS = taxResult.AliquotaISS
+	nf.ValorISS = taxResult.ValorISS
+	nf.ISSRetido = taxResult.ISSRetido
+	nf.ValorPIS = taxResult.ValorRetPIS
+	nf.ValorCOFINS = taxResult.ValorRetCOFINS
+	nf.ValorCSLL = taxResult.ValorRetCSLL
+	nf.ValorIR = taxResult.ValorRetIR
+	nf.ValorINSS = taxResult.ValorRetINSS
+}
+
+// MoveFiscalDocuments moves the XMLs and DANFS-e that earlier versions kept in the public upload
+// tree into the private fiscal storage. Returns how many files were moved.
+func (e *NFSeEmissor) MoveFiscalDocuments() (int, error) {
+	var notas []domain.NotaFiscal
+	if err := e.db.Select("id", "xml_path", "pdf_path").
+		Where("xml_path LIKE ? OR pdf_path LIKE ?", "/uploads/%", "/uploads/%").Find(&notas).Error; err != nil {
+		return 0, err
+	}
+
+	moved := 0
+	for _, nf := range notas {
+		for column, path := range map[string]string{"xml_path": nf.XMLPath, "pdf_path": nf.PDFPath} {
+			if !strings.HasPrefix(path, "/uploads/") {
+				continue
+			}
+			// A missing file is forgotten: the DANFS-e is rendered again on download
+			key := ""
+			data, err := os.ReadFile(e.storage.Path(path))
+			if err != nil && !os.IsNotExist(err) {
+				return moved, err
+			}
+			if err == nil {
+				if key, err = e.storage.SaveFiscal(strings.TrimPrefix(path, "/uploads/"), data); err != nil {
+					return moved, err
+				}
+			}
+			if err := e.db.Model(&domain.NotaFiscal{}).Where("id = ?", nf.ID).Update(column, key).Error; err != nil {
+				return moved, err
+			}
+			if key != "" {
+				e.storage.Delete(path)
+				moved++
+			}
+		}
+	}
+	return moved, nil
+}
+
+// Autorizar stores the authorized XML and marks the note as issued
+func (e *NFSeEmissor) Autorizar(nf *domain.NotaFiscal, resp *NFSeResponse, userID string) error {
+	if resp.XMLBase64 != "" {
+		xmlData, err := DecodeNFSeXML(resp.XMLBase64)
+		if err != nil {
+			return err
+		}
+		path, err := e.storage.SaveFiscal(fmt.Sprintf("nfse/%s/%s.xml", nf.PrestadorID, resp.ChaveAcesso), xmlData)
+		if err != nil {
+			return err
+		}
+		nf.XMLPath = path
+	}
+
+	ApplyNFSeResponse(nf, resp)
+	var original *domain.NotaFiscal
+	err := e.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(nf).Error; err != nil {
+			return err
+		}
+		if nf.SubstituiID != "" {
+			original = &domain.NotaFiscal{}
+			if err := tx.First(original, "id = ?", nf.SubstituiID).Error; err != nil {
+				return err
+			}
+			original.Status = domain.NFSeStatusSubstituida
+			original.SubstituidaPorID = nf.ID
+			if err := tx.Save(original).Error; err != nil {
+				return err
+			}
+		}
+		return e.numeracao.Utilizar(tx, nf.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	// The DANFS-e is rendered on download when this fails
+	if _, err := NewDANFSeService(e.db, e.storage).PDF(nf); err != nil {
+		log.Printf("⚠️ Falha ao gerar o DANFS-e da NFS-e %s: %v", nf.Numero, err)
+	}
+
+	mensagem := fmt.Sprintf("NFS-e %s autorizada pela SEFIN Nacional", nf.Numero)
+	for _, alerta := range resp.Alertas {
+		mensagem += " | Alerta " + alerta.String()
+	}
+	e.evento(nf, domain.NFSeEventoAutorizacao, domain.NFSeStatusEmitida, resp.ChaveAcesso, mensagem, userID)
+	e.historico(nf, userID, "Nota Fiscal", fmt.Sprintf("NFS-e %s emitida com sucesso", nf.Numero))
+	if original != nil {
+		e.evento(original, domain.NFSeEventoSubstituicao, domain.NFSeStatusSubstituida, nf.ChaveAcesso,
+			fmt.Sprintf("NFS-e substituída pela NFS-e %s", nf.Numero), userID)
+		e.evento(nf, domain.NFSeEventoSubstituicao, domain.NFSeStatusEmitida, original.ChaveAcesso,
+			fmt.Sprintf("NFS-e %s substitui a NFS-e %s", nf.Numero, original.Numero), userID)
+		e.historico(nf, userID, "Nota Fiscal", fmt.Sprintf("NFS-e %s substituída pela NFS-e %s", original.Numero, nf.Numero))
+		e.hub.Broadcast(original.PrestadorID, "nfse:updated", original)
+	}
+	e.hub.Broadcast(nf.PrestadorID, "nfse:updated", nf)
+	e.progressoLote(nf)
+	return nil
+}
+
+// ValidarSubstituicao checks the reason code and description of a substitution
+func ValidarSubstituicao(codigoMotivo, motivo string) error {
+	switch codigoMotivo {
+	case domain.MotivoSubstDesenquadramentoSN, domain.MotivoSubstEnquadramentoSN,
+		domain.MotivoSubstInclusaoImunidade, domain.MotivoSubstExclusaoImunidade,
+		domain.MotivoSubstRejeicaoTomador:
+	case domain.MotivoSubstOutros:
+		if len([]rune(strings.TrimSpace(motivo))) < 15 {
+			return errors.New("descreva o motivo da substituição com ao menos 15 caracteres")
+		}
+	default:
+		return fmt.Errorf("código de motivo de substituição inválido: %q", codigoMotivo)
+	}
+	return nil
+}
+
+// ApplyNFSeResponse maps an authorization returned by the SEFIN into the note
+func ApplyNFSeResponse(nf *domain.NotaFiscal, resp *NFSeResponse) {
+	nf.Status = domain.NFSeStatusEmitida
+	nf.MensagemErro = ""
+	nf.ChaveAcesso = resp.ChaveAcesso
+	nf.Numero = resp.NumeroNFSe
+	nf.CodigoVerificacao = resp.CodigoVerificacao
+
+	emissao := time.Now()
+	for _, value := range []string{resp.DataEmissao, resp.DataHoraProcessamento} {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			emissao = t
+			break
+		}
+	}
+	nf.DataEmissao = &emissao
+}
+
+// falha records a failed emission
+func (e *NFSeEmissor) falha(nf *domain.NotaFiscal, cause error, userID string) {
+	nf.Status = domain.NFSeStatusErro
+	nf.MensagemErro = cause.Error()
+	if err := e.db.Save(nf).Error; err != nil {
+		log.Printf("⚠️ Falha ao registrar erro da NFS-e %s: %v", nf.ID, err)
+	}
+
+	tipo := domain.NFSeEventoEmissao
+	var rejeicao *NFSeRejeicao
+	if errors.As(cause, &rejeicao) {
+		tipo = domain.NFSeEventoRejeicao
+	}
+	e.evento(nf, tipo, domain.NFSeStatusErro, "", cause.Error(), userID)
+	e.historico(nf, userID, "Nota Fiscal", "Falha na emissão da NFS-e: "+cause.Error())
+	e.hub.Broadcast(nf.PrestadorID, "nfse:updated", nf)
+	e.progressoLote(nf)
+}
+
+func (e *NFSeEmissor) evento(nf *domain.NotaFiscal, tipo, status, protocolo, mensagem, userID string) {
+	e.db.Create(&domain.NFSeEvento{
+		ID:        uuid.New().String(),
+		NFSeID:    nf.ID,
+		Tipo:      tipo,
+		Status:    status,
+		Protocolo: protocolo,
+		Mensagem:  mensagem,
+		UserID:    userID,
+	})
+}
+
+// historico records an entry in the history of every request the note invoices
+func (e *NFSeEmissor) historico(nf *domain.NotaFiscal, userID, action, details string) {
+	var ids []string
+	e.db.Model(&domain.NotaFiscalSolicitacao{}).Where("nota_fiscal_id = ?", nf.ID).Pluck("solicitacao_id", &ids)
+	if len(ids) == 0 {
+		ids = []string{nf.SolicitacaoID}
+	}
+	for _, id := range ids {
+		e.db.Create(&domain.SolicitacaoHistorico{
+			ID:            uuid.New().String(),
+			SolicitacaoID: id,
+			UserID:        userID,
+			Action:        action,
+			Details:       details,
+		})
+	}
+}
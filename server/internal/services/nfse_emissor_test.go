@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+)
+
+// notaTeste stores a note with the given status invoicing the requests
+func notaTeste(t *testing.T, db *gorm.DB, status string, solicitacoes ...*domain.Solicitacao) *domain.NotaFiscal {
+	t.Helper()
+	nf := &domain.NotaFiscal{
+		ID:               uuid.New().String(),
+		SolicitacaoID:    solicitacoes[0].ID,
+		PrestadorID:      solicitacoes[0].CompanyID,
+		TomadorNome:      "Cliente Teste",
+		TomadorDocumento: "12345678909",
+		Discriminacao:    "Manutenção",
+		Status:           status,
+	}
+	for _, s := range solicitacoes {
+		nf.Solicitacoes = append(nf.Solicitacoes, domain.NotaFiscalSolicitacao{
+			ID: uuid.New().String(), NotaFiscalID: nf.ID, SolicitacaoID: s.ID, NumeroSolicitacao: s.Numero,
+		})
+	}
+	if err := db.Create(nf).Error; err != nil {
+		t.Fatal(err)
+	}
+	return nf
+}
+
+func TestTravarFaturamento(t *testing.T) {
+	db := newTestDB(t, &domain.Solicitacao{}, &domain.NotaFiscal{}, &domain.NotaFiscalSolicitacao{})
+	companyID := uuid.New().String()
+	var solicitacoes []*domain.Solicitacao
+	for numero := 1001; numero <= 1002; numero++ {
+		s := &domain.Solicitacao{ID: uuid.New().String(), Numero: numero, ClientID: uuid.New().String(), ClientName: "Cliente Teste",
+			CompanyID: companyID, Status: domain.StatusConcluida, Priority: "MEDIA"}
+		if err := db.Create(s).Error; err != nil {
+			t.Fatal(err)
+		}
+		solicitacoes = append(solicitacoes, s)
+	}
+
+	// Both emissions passed the early check before either note was stored
+	primeira := notaTeste(t, db, domain.NFSeStatusProcessando, solicitacoes[0])
+	segunda := &domain.NotaFiscal{ID: uuid.New().String(), Solicitacoes: []domain.NotaFiscalSolicitacao{
+		{SolicitacaoID: solicitacoes[0].ID, NumeroSolicitacao: 1001}, {SolicitacaoID: solicitacoes[1].ID, NumeroSolicitacao: 1002},
+	}}
+
+	err := db.Transaction(func(tx *gorm.DB) error { return travarFaturamento(tx, segunda) })
+	if !errors.Is(err, ErrNFSeEmProcessamento) || err.Error() != "OS #1001: "+ErrNFSeEmProcessamento.Error() {
+		t.Errorf("second note error = %v, want OS #1001 em processamento", err)
+	}
+
+	// The note itself is resent without conflict
+	if err := db.Transaction(func(tx *gorm.DB) error { return travarFaturamento(tx, primeira) }); err != nil {
+		t.Errorf("resending the same note: %v", err)
+	}
+
+	db.Model(primeira).Update("status", domain.NFSeStatusEmitida)
+	if err := db.Transaction(func(tx *gorm.DB) error { return travarFaturamento(tx, segunda) }); !errors.Is(err, ErrNFSeJaEmitida) {
+		t.Errorf("second note error = %v, want ErrNFSeJaEmitida", err)
+	}
+
+	// A substitute invoices the requests of the note it replaces
+	substituta := &domain.NotaFiscal{ID: uuid.New().String(), SubstituiID: primeira.ID, Solicitacoes: segunda.Solicitacoes}
+	if err := db.Transaction(func(tx *gorm.DB) error { return travarFaturamento(tx, substituta) }); err != nil {
+		t.Errorf("substitute: %v", err)
+	}
+
+	// A cancelled note frees its requests
+	db.Model(primeira).Update("status", domain.NFSeStatusCancelada)
+	if err := db.Transaction(func(tx *gorm.DB) error { return travarFaturamento(tx, segunda) }); err != nil {
+		t.Errorf("after cancellation: %v", err)
+	}
+}
+
+func TestMoveFiscalDocuments(t *testing.T) {
+	db := newTestDB(t, &domain.Solicitacao{}, &domain.NotaFiscal{}, &domain.NotaFiscalSolicitacao{})
+	dir := t.TempDir()
+	storage := NewStorageService(&config.Config{UploadDir: filepath.Join(dir, "uploads"), FiscalDocsDir: filepath.Join(dir, "fiscal")})
+	e := &NFSeEmissor{db: db, storage: storage}
+
+	solicitacao := &domain.Solicitacao{ID: uuid.New().String(), CompanyID: "prestador"}
+	nf := notaTeste(t, db, domain.NFSeStatusEmitida, solicitacao)
+	xmlURL, err := storage.Save("nfse/prestador/chave.xml", []byte("<NFSe/>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The DANFS-e file is gone, so it is rendered again on download
+	db.Model(nf).Updates(map[string]interface{}{"xml_path": xmlURL, "pdf_path": "/uploads/nfse/prestador/chave.pdf"})
+
+	moved, err := e.MoveFiscalDocuments()
+	if err != nil || moved != 1 {
+		t.Fatalf("MoveFiscalDocuments = %d, %v; want 1 file moved", moved, err)
+	}
+	if _, err := os.Stat(storage.Path(xmlURL)); !os.IsNotExist(err) {
+		t.Error("XML left in the public upload tree")
+	}
+
+	var got domain.NotaFiscal
+	db.First(&got, "id = ?", nf.ID)
+	if got.XMLPath != "nfse/prestador/chave.xml" || got.PDFPath != "" {
+		t.Errorf("paths = %q, %q; want the fiscal key and no DANFS-e", got.XMLPath, got.PDFPath)
+	}
+	if data, err := storage.ReadFiscal(got.XMLPath); err != nil || string(data) != "<NFSe/>" {
+		t.Errorf("ReadFiscal = %q, %v", data, err)
+	}
+
+	// Escaping the fiscal directory resolves inside it
+	if _, err := storage.ReadFiscal("../uploads/nfse/prestador/chave.xml"); !os.IsNotExist(err) {
+		t.Errorf("ReadFiscal outside the fiscal directory = %v", err)
+	}
+
+	if moved, err := e.MoveFiscalDocuments(); err != nil || moved != 0 {
+		t.Errorf("second run = %d, %v; want nothing to move", moved, err)
+	}
+}
@@ -0,0 +1,333 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+// Notes processing for longer than this without a job are checked against the SEFIN
+const nfseReconcileAfter = 2 * time.Minute
+
+// NFSeCancelamento holds the parameters of a cancellation job
+type NFSeCancelamento struct {
+	CodigoMotivo string `json:"codigoMotivo"`
+	Motivo       string `json:"motivo"`
+}
+
+// Enfileirar queues the emission of a note; tx lets the job share the transaction that created the note
+func (e *NFSeEmissor) Enfileirar(tx *gorm.DB, nf *domain.NotaFiscal, userID string) (*domain.Job, error) {
+	return e.queue.Enqueue(tx, domain.JobNFSeEmitir, nf.ID, nf.PrestadorID, userID, nil)
+}
+
+// EnfileirarCancelamento queues the cancellation of an issued note
+func (e *NFSeEmissor) EnfileirarCancelamento(tx *gorm.DB, nf *domain.NotaFiscal, codigoMotivo, motivo, userID string) (*domain.Job, error) {
+	return e.queue.Enqueue(tx, domain.JobNFSeCancelar, nf.ID, nf.PrestadorID, userID, NFSeCancelamento{CodigoMotivo: codigoMotivo, Motivo: motivo})
+}
+
+// Run periodically reconciles notes left in PROCESSANDO
+func (e *NFSeEmissor) Run() {
+	interval := time.Duration(e.cfg.NFSeReconcileIntervalMins) * time.Minute
+	log.Println("🧾 Reconciliação de NFS-e iniciada")
+
+	for {
+		if n := e.Reconciliar(); n > 0 {
+			log.Printf("🧾 %d NFS-e em processamento enviada(s) para consulta na SEFIN", n)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Reconciliar queues a SEFIN lookup for every note processing without an active job
+func (e *NFSeEmissor) Reconciliar() int {
+	var notas []domain.NotaFiscal
+	e.db.Where("status = ? AND updated_at < ?", domain.NFSeStatusProcessando, time.Now().Add(-nfseReconcileAfter)).Find(&notas)
+
+	count := 0
+	for _, nf := range notas {
+		if e.queue.Active(nf.ID, domain.JobNFSeEmitir, domain.JobNFSeConsultar) {
+			continue
+		}
+		if _, err := e.queue.Enqueue(nil, domain.JobNFSeConsultar, nf.ID, nf.PrestadorID, e.emitente(&nf), nil); err != nil {
+			log.Printf("⚠️ Erro ao agendar consulta da NFS-e %s: %v", nf.ID, err)
+			continue
+		}
+		count++
+	}
+	return count
+}
+
+// runEmitir submits the DPS of a note. The DPS keeps its series and number across attempts, so
+// a resend either finds the note already issued or is refused as a duplicate, never issued twice.
+func (e *NFSeEmissor) runEmitir(job *domain.Job) error {
+	nf, err := e.nota(job.ReferenciaID)
+	if err != nil {
+		return err
+	}
+	switch nf.Status {
+	case domain.NFSeStatusEmitida, domain.NFSeStatusCancelada:
+		return nil
+	case domain.NFSeStatusErro:
+		// Retried from the dead letter state; a rejected note gave its number back and needs a new one
+		nf.Status = domain.NFSeStatusProcessando
+		nf.MensagemErro = ""
+		err := e.db.Transaction(func(tx *gorm.DB) error {
+			if err := e.numeracao.Reservar(tx, nf); err != nil {
+				return err
+			}
+			return tx.Save(nf).Error
+		})
+		if err != nil {
+			return err
+		}
+		e.hub.Broadcast(nf.PrestadorID, "nfse:updated", nf)
+	}
+
+	dps, client, cert, err := e.preparar(nf)
+	if err != nil {
+		return err
+	}
+
+	// An earlier attempt may have reached the SEFIN before failing on our side
+	if e.reenvio(job) {
+		resp, err := e.buscarPorDPS(client, dps.InfDPS.ID, cert)
+		if err == nil {
+			return e.Autorizar(nf, resp, job.UserID)
+		}
+		if !errors.Is(err, ErrNFSeNaoEncontrada) {
+			return e.tentativaFalhou(nf, job, err)
+		}
+	}
+
+	resp, err := client.EmitirNFSe(dps, cert)
+	if err != nil {
+		var rejeicao *NFSeRejeicao
+		if !errors.As(err, &rejeicao) {
+			return e.tentativaFalhou(nf, job, err)
+		}
+		if !rejeicao.HasCodigo(ErroDPSDuplicada) {
+			return Permanent(err)
+		}
+		if resp, err = e.buscarPorDPS(client, dps.InfDPS.ID, cert); err != nil {
+			return e.tentativaFalhou(nf, job, err)
+		}
+	}
+
+	return e.Autorizar(nf, resp, job.UserID)
+}
+
+// emissaoFalhou marks the note as failed once its emission job is dead
+func (e *NFSeEmissor) emissaoFalhou(job *domain.Job, cause error) {
+	nf, err := e.nota(job.ReferenciaID)
+	if err != nil || nf.Status != domain.NFSeStatusProcessando {
+		return
+	}
+
+	// A rejected DPS was not registered, so its number goes back to the series
+	var rejeicao *NFSeRejeicao
+	if errors.As(cause, &rejeicao) {
+		if err := e.numeracao.Liberar(nf.ID, cause.Error()); err != nil {
+			log.Printf("⚠️ Erro ao liberar número da DPS %s/%d: %v", nf.SerieDPS, nf.NumeroDPS, err)
+		} else {
+			nf.NumeroDPS = 0
+		}
+	}
+	e.falha(nf, cause, job.UserID)
+}
+
+// runCancelar registers the cancellation event of an issued note
+func (e *NFSeEmissor) runCancelar(job *domain.Job) error {
+	nf, err := e.nota(job.ReferenciaID)
+	if err != nil {
+		return err
+	}
+	if nf.Status == domain.NFSeStatusCancelada {
+		return nil
+	}
+	if nf.Status != domain.NFSeStatusEmitida || nf.ChaveAcesso == "" {
+		return Permanent(errors.New("NFS-e não está emitida"))
+	}
+
+	var params NFSeCancelamento
+	if err := json.Unmarshal([]byte(job.Payload), &params); err != nil {
+		return Permanent(fmt.Errorf("parâmetros de cancelamento inválidos: %w", err))
+	}
+
+	var fiscal domain.ConfiguracaoFiscal
+	e.db.Where("prestador_id = ?", nf.PrestadorID).First(&fiscal)
+	var prestador domain.Prestador
+	if err := e.db.First(&prestador, "id = ?", nf.PrestadorID).Error; err != nil {
+		return Permanent(errors.New("prestador não encontrado"))
+	}
+	cert, err := e.Certificate(nf.PrestadorID)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	resp, err := e.Client(&fiscal).CancelarNFSe(nf.ChaveAcesso, onlyDigits(prestador.CNPJ), params.CodigoMotivo, params.Motivo, cert)
+	if err != nil {
+		var rejeicao *NFSeRejeicao
+		if errors.As(err, &rejeicao) {
+			return Permanent(err)
+		}
+		return err
+	}
+	if resp.Protocolo == "" {
+		return Permanent(errors.New("SEFIN não retornou o protocolo do cancelamento"))
+	}
+
+	// The note is only cancelled together with the event holding the protocol of the SEFIN
+	err = e.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&domain.NFSeEvento{
+			ID:        uuid.New().String(),
+			NFSeID:    nf.ID,
+			Tipo:      domain.NFSeEventoCancelamento,
+			Status:    domain.NFSeStatusCancelada,
+			Protocolo: resp.Protocolo,
+			Mensagem:  "Cancelamento homologado pela SEFIN Nacional | Código: " + params.CodigoMotivo,
+			Motivo:    params.Motivo,
+			UserID:    job.UserID,
+		}).Error; err != nil {
+			return err
+		}
+		nf.Status = domain.NFSeStatusCancelada
+		nf.MensagemErro = ""
+		return tx.Save(nf).Error
+	})
+	if err != nil {
+		return err
+	}
+	e.historico(nf, job.UserID, "NFS-e cancelada", params.Motivo)
+	e.hub.Broadcast(nf.PrestadorID, "nfse:updated", nf)
+	return nil
+}
+
+// cancelamentoFalhou records a cancellation that could not be registered; the note stays issued
+func (e *NFSeEmissor) cancelamentoFalhou(job *domain.Job, cause error) {
+	nf, err := e.nota(job.ReferenciaID)
+	if err != nil || nf.Status != domain.NFSeStatusEmitida {
+		return
+	}
+	nf.MensagemErro = "Cancelamento não realizado: " + cause.Error()
+	e.db.Save(nf)
+
+	e.evento(nf, domain.NFSeEventoCancelamento, domain.NFSeStatusErro, "", nf.MensagemErro, job.UserID)
+	e.historico(nf, job.UserID, "Nota Fiscal", nf.MensagemErro)
+	e.hub.Broadcast(nf.PrestadorID, "nfse:updated", nf)
+}
+
+// runConsultar looks up a processing note in the SEFIN and either authorizes it or sends its DPS again
+func (e *NFSeEmissor) runConsultar(job *domain.Job) error {
+	nf, err := e.nota(job.ReferenciaID)
+	if err != nil {
+		return err
+	}
+	if nf.Status != domain.NFSeStatusProcessando {
+		return nil
+	}
+
+	dps, client, cert, err := e.preparar(nf)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.buscarPorDPS(client, dps.InfDPS.ID, cert)
+	switch {
+	case err == nil:
+		e.evento(nf, domain.NFSeEventoConsulta, domain.NFSeStatusEmitida, resp.ChaveAcesso, "NFS-e localizada na SEFIN pela DPS "+dps.InfDPS.ID, job.UserID)
+		return e.Autorizar(nf, resp, job.UserID)
+	case errors.Is(err, ErrNFSeNaoEncontrada):
+		// The SEFIN never got the DPS, so sending it again under the same number is safe
+		e.evento(nf, domain.NFSeEventoConsulta, domain.NFSeStatusProcessando, "", "DPS não recebida pela SEFIN; reenviando", job.UserID)
+		_, err := e.Enfileirar(nil, nf, job.UserID)
+		return err
+	default:
+		return err
+	}
+}
+
+// consultaFalhou gives up on a note whose situation could not be confirmed
+func (e *NFSeEmissor) consultaFalhou(job *domain.Job, cause error) {
+	nf, err := e.nota(job.ReferenciaID)
+	if err != nil || nf.Status != domain.NFSeStatusProcessando {
+		return
+	}
+	e.falha(nf, fmt.Errorf("situação da NFS-e não confirmada na SEFIN: %w", cause), job.UserID)
+}
+
+// preparar rebuilds the DPS of a note and loads the client and certificate to send it
+func (e *NFSeEmissor) preparar(nf *domain.NotaFiscal) (*DPS, *NFSeNacionalService, *A1Certificate, error) {
+	var fiscal domain.ConfiguracaoFiscal
+	if err := e.db.Where("prestador_id = ?", nf.PrestadorID).First(&fiscal).Error; err != nil {
+		return nil, nil, nil, Permanent(errors.New("configuração fiscal não encontrada"))
+	}
+	var prestador domain.Prestador
+	if err := e.db.Preload("Endereco").First(&prestador, "id = ?", nf.PrestadorID).Error; err != nil {
+		return nil, nil, nil, Permanent(errors.New("prestador não encontrado"))
+	}
+	var solicitacao domain.Solicitacao
+	e.db.Preload("Client").Preload("Client.Endereco").First(&solicitacao, "id = ?", nf.SolicitacaoID)
+
+	dps, err := e.BuildDPS(nf, &prestador, &fiscal, &solicitacao.Client)
+	if err != nil {
+		return nil, nil, nil, Permanent(err)
+	}
+	cert, err := e.Certificate(nf.PrestadorID)
+	if err != nil {
+		return nil, nil, nil, Permanent(err)
+	}
+	return dps, e.Client(&fiscal), cert, nil
+}
+
+// buscarPorDPS retrieves the NFS-e generated from a DPS
+func (e *NFSeEmissor) buscarPorDPS(client *NFSeNacionalService, idDPS string, cert *A1Certificate) (*NFSeResponse, error) {
+	chave, err := client.ConsultarDPS(idDPS, cert)
+	if err != nil {
+		return nil, err
+	}
+	return client.ConsultarNFSe(chave, cert)
+}
+
+// reenvio reports whether the DPS of a job may already have been sent
+func (e *NFSeEmissor) reenvio(job *domain.Job) bool {
+	if job.Tentativas > 1 {
+		return true
+	}
+	var count int64
+	e.db.Model(&domain.Job{}).Where("tipo = ? AND referencia_id = ? AND id <> ?", domain.JobNFSeEmitir, job.ReferenciaID, job.ID).Count(&count)
+	return count > 0
+}
+
+// tentativaFalhou records a transient failure on the note and hands the error back for a retry
+func (e *NFSeEmissor) tentativaFalhou(nf *domain.NotaFiscal, job *domain.Job, cause error) error {
+	nf.MensagemErro = fmt.Sprintf("Tentativa %d de %d falhou: %v", job.Tentativas, job.MaxTentativas, cause)
+	if err := e.db.Save(nf).Error; err != nil {
+		log.Printf("⚠️ Falha ao registrar erro da NFS-e %s: %v", nf.ID, err)
+	}
+	e.evento(nf, domain.NFSeEventoEmissao, domain.NFSeStatusProcessando, "", nf.MensagemErro, job.UserID)
+	e.hub.Broadcast(nf.PrestadorID, "nfse:updated", nf)
+	return cause
+}
+
+// nota loads the note a job refers to
+func (e *NFSeEmissor) nota(id string) (*domain.NotaFiscal, error) {
+	var nf domain.NotaFiscal
+	if err := e.db.First(&nf, "id = ?", id).Error; err != nil {
+		return nil, Permanent(fmt.Errorf("nota fiscal %s não encontrada", id))
+	}
+	return &nf, nil
+}
+
+// emitente returns the user who requested the emission of a note
+func (e *NFSeEmissor) emitente(nf *domain.NotaFiscal) string {
+	var evento domain.NFSeEvento
+	e.db.Where("nf_se_id = ? AND user_id <> ''", nf.ID).Order("created_at").First(&evento)
+	return evento.UserID
+}
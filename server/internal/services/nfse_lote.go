@@ -0,0 +1,138 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"inovar/internal/domain"
+)
+
+// LoteFiltro selects the completed requests of a batch emission
+type LoteFiltro struct {
+	Inicio         string   `json:"inicio,omitempty"` // YYYY-MM-DD, by completion date
+	Fim            string   `json:"fim,omitempty"`
+	ClientID       string   `json:"clientId,omitempty"`
+	Status         []string `json:"status,omitempty"`         // CONCLUIDA and/or FINALIZADA
+	SolicitacaoIDs []string `json:"solicitacaoIds,omitempty"` // requests picked on the preview
+}
+
+// LoteIgnorada is a request left out of a batch emission
+type LoteIgnorada struct {
+	SolicitacaoID string `json:"solicitacaoId"`
+	Numero        int    `json:"numero"`
+	Cliente       string `json:"cliente"`
+	Motivo        string `json:"motivo"`
+}
+
+// CandidatosLote lists the completed requests of a provider matching the filter that have no note
+// issued or in progress. Requests come with what PrepararEmissao needs.
+func (e *NFSeEmissor) CandidatosLote(prestadorID string, filtro *LoteFiltro) ([]domain.Solicitacao, error) {
+	status := []string{domain.StatusConcluida, domain.StatusFinalizada}
+	if len(filtro.Status) > 0 {
+		for _, s := range filtro.Status {
+			if s != domain.StatusConcluida && s != domain.StatusFinalizada {
+				return nil, fmt.Errorf("status %q não permite emissão de NFS-e", s)
+			}
+		}
+		status = filtro.Status
+	}
+
+	q := e.db.Preload("Client").Preload("Client.Endereco").Preload("OrcamentoItens").
+		Where("company_id = ? AND status IN ?", prestadorID, status).
+		Where(`NOT EXISTS (SELECT 1 FROM notas_fiscais_solicitacoes l JOIN notas_fiscais nf ON nf.id = l.nota_fiscal_id
+			WHERE l.solicitacao_id = solicitacoes.id AND nf.status IN ?)`,
+			[]string{domain.NFSeStatusPendente, domain.NFSeStatusProcessando, domain.NFSeStatusEmitida})
+
+	// Requests are completed when signed at FINALIZADA; older ones fall back to their last change
+	if filtro.Inicio != "" {
+		inicio, err := time.ParseInLocation("2006-01-02", filtro.Inicio, time.Local)
+		if err != nil {
+			return nil, errors.New("data inicial inválida, use AAAA-MM-DD")
+		}
+		q = q.Where("COALESCE(data_assinatura, updated_at) >= ?", inicio)
+	}
+	if filtro.Fim != "" {
+		fim, err := time.ParseInLocation("2006-01-02", filtro.Fim, time.Local)
+		if err != nil {
+			return nil, errors.New("data final inválida, use AAAA-MM-DD")
+		}
+		q = q.Where("COALESCE(data_assinatura, updated_at) < ?", fim.AddDate(0, 0, 1))
+	}
+	if filtro.ClientID != "" {
+		q = q.Where("client_id = ?", filtro.ClientID)
+	}
+	if len(filtro.SolicitacaoIDs) > 0 {
+		q = q.Where("id IN ?", filtro.SolicitacaoIDs)
+	}
+
+	var solicitacoes []domain.Solicitacao
+	if err := q.Order("numero").Find(&solicitacoes).Error; err != nil {
+		return nil, err
+	}
+	return solicitacoes, nil
+}
+
+// progressoLote recounts the batch of a settled note, broadcasts its progress and tells whoever
+// started it once every note is issued or rejected
+func (e *NFSeEmissor) progressoLote(nf *domain.NotaFiscal) {
+	if nf.LoteID == "" {
+		return
+	}
+	var lote domain.LoteNFSe
+	if err := e.db.First(&lote, "id = ?", nf.LoteID).Error; err != nil {
+		return
+	}
+
+	var contagem []struct {
+		Status string
+		Total  int
+	}
+	if err := e.db.Model(&domain.NotaFiscal{}).Select("status, COUNT(*) AS total").
+		Where("lote_id = ?", lote.ID).Group("status").Scan(&contagem).Error; err != nil {
+		log.Printf("⚠️ Erro ao apurar o lote de NFS-e %s: %v", lote.ID, err)
+		return
+	}
+
+	pendentes := 0
+	lote.Emitidas, lote.Rejeitadas = 0, 0
+	for _, c := range contagem {
+		switch c.Status {
+		case domain.NFSeStatusEmitida, domain.NFSeStatusCancelada, domain.NFSeStatusSubstituida:
+			lote.Emitidas += c.Total
+		case domain.NFSeStatusErro:
+			lote.Rejeitadas += c.Total
+		default:
+			pendentes += c.Total
+		}
+	}
+
+	concluido := pendentes == 0 && lote.Status == domain.LoteNFSeProcessando
+	if concluido {
+		now := time.Now()
+		lote.Status = domain.LoteNFSeConcluido
+		lote.ConcluidoEm = &now
+	}
+	if err := e.db.Model(&lote).Updates(map[string]interface{}{
+		"emitidas":     lote.Emitidas,
+		"rejeitadas":   lote.Rejeitadas,
+		"status":       lote.Status,
+		"concluido_em": lote.ConcluidoEm,
+	}).Error; err != nil {
+		log.Printf("⚠️ Erro ao atualizar o lote de NFS-e %s: %v", lote.ID, err)
+		return
+	}
+	e.hub.Broadcast(lote.PrestadorID, "nfse:lote", lote)
+
+	if concluido {
+		notifType := "SUCCESS"
+		if lote.Rejeitadas > 0 {
+			notifType = "WARNING"
+		}
+		message := fmt.Sprintf("%d de %d NFS-e emitida(s), %d rejeitada(s).", lote.Emitidas, lote.Total, lote.Rejeitadas)
+		if _, err := e.notifications.CreateNotification(lote.UserID, "Lote de NFS-e concluído", message, notifType, "/admin/fiscal"); err != nil {
+			log.Printf("⚠️ Erro ao notificar conclusão do lote de NFS-e %s: %v", lote.ID, err)
+		}
+	}
+}
@@ -3,24 +3,31 @@ package services
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
-	"inovar/internal/infra/bridge"
 	"inovar/internal/infra/config"
 )
 
 // NFSeNacionalService handles NFS-e Nacional (GOV.BR) integration
 type NFSeNacionalService struct {
-	config     *config.Config
-	httpClient *http.Client
-	ambiente   string // PRODUCAO or HOMOLOGACAO
+	config   *config.Config
+	ambiente string // PRODUCAO or HOMOLOGACAO
+	baseURL  string // overrides the official endpoint (e.g. local fake SEFIN)
+	rootCAs  *x509.CertPool
+	timeout  time.Duration
 }
 
 // API Endpoints
@@ -31,9 +38,32 @@ const (
 	AmbienteHomologacao = "HOMOLOGACAO"
 )
 
+// Layout constants of the DPS and events
+const (
+	NFSeNamespace     = "http://www.sped.fazenda.gov.br/nfse"
+	NFSeVersaoLeiaute = "1.00"
+	NFSeVerAplic      = "INOVAR_1.0"
+	SerieDPSPadrao    = "1"
+
+	EventoCancelamento = "101101"
+	nfseDateTimeLayout = "2006-01-02T15:04:05-07:00"
+)
+
+// Decimal is a monetary value or rate rendered with two decimal places
+type Decimal float64
+
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(d), 'f', 2, 64)), nil
+}
+
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return json.Marshal(float64(d))
+}
+
 // DPS - Declaração Prévia de Serviços (input for generating NFS-e)
 type DPS struct {
-	XMLName xml.Name `xml:"DPS" json:"-"`
+	XMLName xml.Name `xml:"http://www.sped.fazenda.gov.br/nfse DPS" json:"-"`
+	Versao  string   `xml:"versao,attr" json:"versao"`
 	InfDPS  InfDPS   `xml:"infDPS" json:"infDPS"`
 }
 
@@ -47,146 +77,531 @@ type InfDPS struct {
 	DCompet   string        `xml:"dCompet" json:"dCompet"`   // Data de competência
 	TpEmit    int           `xml:"tpEmit" json:"tpEmit"`     // Tipo emitente
 	CLocEmi   int           `xml:"cLocEmi" json:"cLocEmi"`   // Código município emissão (IBGE)
-	TpOp      int           `xml:"tpOp" json:"tpOp"`         // 1=Dentro Mun, 2=Fora, 3=Exportação
 	Subst     *Substituicao `xml:"subst,omitempty" json:"subst,omitempty"`
-	Prest     Prestador     `xml:"prest" json:"prest"`     // Prestador
-	Toma      Tomador       `xml:"toma" json:"toma"`       // Tomador (cliente)
-	Serv      Servico       `xml:"serv" json:"serv"`       // Serviço
-	Valores   Valores       `xml:"valores" json:"valores"` // Valores
+	Prest     Prestador     `xml:"prest" json:"prest"`                   // Prestador
+	Toma      *Tomador      `xml:"toma,omitempty" json:"toma,omitempty"` // Tomador (cliente)
+	Serv      Servico       `xml:"serv" json:"serv"`                     // Serviço
+	Valores   Valores       `xml:"valores" json:"valores"`               // Valores
 }
 
 type Substituicao struct {
-	ChNFSeSubst string `xml:"chNFSeSubst" json:"chNFSeSubst"` // Chave NFS-e a substituir
+	ChNFSeSubst string `xml:"chSubstda" json:"chSubstda"` // Chave NFS-e a substituir
+	CMotivo     string `xml:"cMotivo" json:"cMotivo"`
+	XMotivo     string `xml:"xMotivo,omitempty" json:"xMotivo,omitempty"`
 }
 
 type Prestador struct {
-	CNPJ string `xml:"CNPJ" json:"CNPJ"`
-	IM   string `xml:"IM,omitempty" json:"IM,omitempty"` // Inscrição Municipal
+	CNPJ    string         `xml:"CNPJ" json:"CNPJ"`
+	IM      string         `xml:"IM,omitempty" json:"IM,omitempty"` // Inscrição Municipal
+	RegTrib RegimeTributos `xml:"regTrib" json:"regTrib"`
+}
+
+type RegimeTributos struct {
+	OpSimpNac  int `xml:"opSimpNac" json:"opSimpNac"`   // 1=Não optante, 2=MEI, 3=ME/EPP
+	RegEspTrib int `xml:"regEspTrib" json:"regEspTrib"` // 0=Nenhum
 }
 
 type Tomador struct {
-	CNPJ  string       `xml:"CNPJ,omitempty" json:"CNPJ,omitempty"`
-	CPF   string       `xml:"CPF,omitempty" json:"CPF,omitempty"`
-	NIF   string       `xml:"NIF,omitempty" json:"NIF,omitempty"` // Estrangeiro
-	XNome string       `xml:"xNome" json:"xNome"`
-	End   EnderecoNFSe `xml:"end,omitempty" json:"end,omitempty"`
-	Fone  string       `xml:"fone,omitempty" json:"fone,omitempty"`
-	Email string       `xml:"email,omitempty" json:"email,omitempty"`
+	CNPJ  string        `xml:"CNPJ,omitempty" json:"CNPJ,omitempty"`
+	CPF   string        `xml:"CPF,omitempty" json:"CPF,omitempty"`
+	NIF   string        `xml:"NIF,omitempty" json:"NIF,omitempty"` // Estrangeiro
+	XNome string        `xml:"xNome" json:"xNome"`
+	End   *EnderecoNFSe `xml:"end,omitempty" json:"end,omitempty"`
+	Fone  string        `xml:"fone,omitempty" json:"fone,omitempty"`
+	Email string        `xml:"email,omitempty" json:"email,omitempty"`
 }
 
 type EnderecoNFSe struct {
-	XLgr    string `xml:"xLgr" json:"xLgr"`                     // Logradouro
-	Nro     string `xml:"nro" json:"nro"`                       // Número
-	XCpl    string `xml:"xCpl,omitempty" json:"xCpl,omitempty"` // Complemento
-	XBairro string `xml:"xBairro" json:"xBairro"`               // Bairro
-	CMun    int    `xml:"cMun" json:"cMun"`                     // Código IBGE município
-	UF      string `xml:"UF" json:"UF"`
-	CEP     string `xml:"CEP" json:"CEP"`
+	EndNac  EnderecoNacional `xml:"endNac" json:"endNac"`
+	XLgr    string           `xml:"xLgr" json:"xLgr"`                     // Logradouro
+	Nro     string           `xml:"nro" json:"nro"`                       // Número
+	XCpl    string           `xml:"xCpl,omitempty" json:"xCpl,omitempty"` // Complemento
+	XBairro string           `xml:"xBairro" json:"xBairro"`               // Bairro
+}
+
+type EnderecoNacional struct {
+	CMun int    `xml:"cMun" json:"cMun"` // Código IBGE município
+	CEP  string `xml:"CEP" json:"CEP"`
 }
 
 type Servico struct {
-	CLocPrestacao     int    `xml:"cLocPrestacao" json:"cLocPrestacao"` // Código IBGE local prestação
-	CServ             CServ  `xml:"cServ" json:"cServ"`                 // Código serviço
-	DiscriminacaoServ string `xml:"xDescServ" json:"xDescServ"`         // Descrição detalhada
+	LocPrest LocalPrestacao `xml:"locPrest" json:"locPrest"`
+	CServ    CServ          `xml:"cServ" json:"cServ"` // Código serviço
+}
+
+type LocalPrestacao struct {
+	CLocPrestacao int `xml:"cLocPrestacao" json:"cLocPrestacao"` // Código IBGE local prestação
 }
 
 type CServ struct {
 	CodTribNac string `xml:"cTribNac" json:"cTribNac"`                     // Código tributação nacional
 	CodTribMun string `xml:"cTribMun,omitempty" json:"cTribMun,omitempty"` // Código municipal
-	CNAE       string `xml:"CNAE,omitempty" json:"CNAE,omitempty"`
-	XDescServ  string `xml:"xDescServ,omitempty" json:"xDescServ,omitempty"` // Descrição serviço
+	XDescServ  string `xml:"xDescServ" json:"xDescServ"`                   // Descrição detalhada
+	CNAE       string `xml:"-" json:"CNAE,omitempty"`                      // informational, not part of the layout
 }
 
 type Valores struct {
-	VServPrest  ValorServPrest   `xml:"vServPrest" json:"vServPrest"`
-	VDescIncond float64          `xml:"vDescIncond,omitempty" json:"vDescIncond,omitempty"` // Desconto
-	VTDC        *ValorTribDedCon `xml:"vTDC,omitempty" json:"vTDC,omitempty"`
+	VServPrest ValorServPrest `xml:"vServPrest" json:"vServPrest"`
+	Trib       Tributacao     `xml:"trib" json:"trib"`
 }
 
 type ValorServPrest struct {
-	VReceb float64 `xml:"vReceb" json:"vReceb"` // Valor a receber
+	VServ Decimal `xml:"vServ" json:"vServ"` // Valor do serviço
+}
+
+type Tributacao struct {
+	TribMun TributacaoMunicipal `xml:"tribMun" json:"tribMun"`
+	TribFed *TributacaoFederal  `xml:"tribFed,omitempty" json:"tribFed,omitempty"`
+	TotTrib TotalTributos       `xml:"totTrib" json:"totTrib"`
+}
+
+type TributacaoMunicipal struct {
+	TribISSQN  int     `xml:"tribISSQN" json:"tribISSQN"`             // 1=Tributável, 2=Imunidade, 3=Exportação, 4=Não incidência
+	PAliq      Decimal `xml:"pAliq,omitempty" json:"pAliq,omitempty"` // Alíquota ISS (%)
+	TpRetISSQN int     `xml:"tpRetISSQN" json:"tpRetISSQN"`           // 1=Não retido, 2=Retido pelo tomador
+}
+
+type TributacaoFederal struct {
+	VRetCP   Decimal `xml:"vRetCP,omitempty" json:"vRetCP,omitempty"` // INSS
+	VRetIRRF Decimal `xml:"vRetIRRF,omitempty" json:"vRetIRRF,omitempty"`
+	VRetCSLL Decimal `xml:"vRetCSLL,omitempty" json:"vRetCSLL,omitempty"`
 }
 
-type ValorTribDedCon struct {
-	VBaseCalc  float64 `xml:"vBC" json:"vBC"`               // Base de cálculo
-	PAliqTot   float64 `xml:"pAliqAplic" json:"pAliqAplic"` // Alíquota total
-	VISSQNCalc float64 `xml:"vISSQN" json:"vISSQN"`         // Valor ISSQN calculado
-	TISSQN     int     `xml:"tISSQN" json:"tISSQN"`         // Tipo ISSQN (1=Operação normal)
-	VRetPIS    float64 `xml:"vRetPIS,omitempty" json:"vRetPIS,omitempty"`
-	VRetCOFINS float64 `xml:"vRetCOFINS,omitempty" json:"vRetCOFINS,omitempty"`
-	VRetCSLL   float64 `xml:"vRetCSLL,omitempty" json:"vRetCSLL,omitempty"`
-	VRetIR     float64 `xml:"vRetIR,omitempty" json:"vRetIR,omitempty"`
-	VRetINSS   float64 `xml:"vRetINSS,omitempty" json:"vRetINSS,omitempty"`
+type TotalTributos struct {
+	IndTotTrib int `xml:"indTotTrib" json:"indTotTrib"` // 0=Não informar (Decreto 8.264/2014)
 }
 
 // NFSeResponse - Response from NFS-e Nacional API
 type NFSeResponse struct {
-	ChaveAcesso       string `json:"chaveAcesso"`
-	NumeroNFSe        string `json:"numero"`
-	CodigoVerificacao string `json:"codVerif"`
-	DataEmissao       string `json:"dhEmi"`
-	XMLBase64         string `json:"nfseXmlGZipB64"` // NFS-e XML compactado em GZip + Base64
-	Status            string `json:"status"`
-	Mensagem          string `json:"mensagem,omitempty"`
+	ChaveAcesso           string     `json:"chaveAcesso"`
+	IDDPS                 string     `json:"idDps,omitempty"`
+	NumeroNFSe            string     `json:"numero"`
+	CodigoVerificacao     string     `json:"codVerif"`
+	DataEmissao           string     `json:"dhEmi"`
+	DataHoraProcessamento string     `json:"dataHoraProcessamento,omitempty"`
+	XMLBase64             string     `json:"nfseXmlGZipB64"` // NFS-e XML compactado em GZip + Base64
+	Status                string     `json:"status"`
+	Mensagem              string     `json:"mensagem,omitempty"`
+	Alertas               []APIError `json:"alertas,omitempty"`
+}
+
+// EventoResponse - Response to an event (e.g. cancellation) registered in the NFS-e Nacional
+type EventoResponse struct {
+	ChaveAcesso           string `json:"chaveAcesso"`
+	TipoEvento            string `json:"tipoEvento"`
+	Protocolo             string `json:"protocolo,omitempty"`
+	DataHoraProcessamento string `json:"dataHoraProcessamento,omitempty"`
+	XMLBase64             string `json:"eventoXmlGZipB64"` // Evento XML compactado em GZip + Base64
 }
 
 type APIError struct {
-	Codigo   string `json:"codigo"`
-	Mensagem string `json:"mensagem"`
-	Campo    string `json:"campo,omitempty"`
+	Codigo    string `json:"codigo"`
+	Mensagem  string `json:"mensagem,omitempty"`
+	Descricao string `json:"descricao,omitempty"` // field name used by the SEFIN for the message
+	Campo     string `json:"campo,omitempty"`
+}
+
+func (e APIError) String() string {
+	msg := e.Mensagem
+	if msg == "" {
+		msg = e.Descricao
+	}
+	if e.Campo != "" {
+		msg += " (" + e.Campo + ")"
+	}
+	return e.Codigo + ": " + msg
 }
 
 type APIResponse struct {
-	Sucesso bool          `json:"sucesso"`
-	Data    *NFSeResponse `json:"data,omitempty"`
-	Erros   []APIError    `json:"erros,omitempty"`
+	Sucesso bool        `json:"sucesso"`
+	Data    interface{} `json:"data,omitempty"`
+	Erros   []APIError  `json:"erros,omitempty"`
+}
+
+// NFSeRejeicao is a business rejection returned by the SEFIN. Resending the same DPS won't help.
+type NFSeRejeicao struct {
+	StatusCode int
+	Erros      []APIError
+}
+
+func (r *NFSeRejeicao) Error() string {
+	if len(r.Erros) == 0 {
+		return fmt.Sprintf("rejeitada pela SEFIN (HTTP %d)", r.StatusCode)
+	}
+	msgs := make([]string, len(r.Erros))
+	for i, e := range r.Erros {
+		msgs[i] = e.String()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// HasCodigo reports whether the rejection carries the given error code
+func (r *NFSeRejeicao) HasCodigo(codigo string) bool {
+	for _, e := range r.Erros {
+		if e.Codigo == codigo {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNFSeNaoEncontrada is returned by the consultation API when the document does not exist
+var ErrNFSeNaoEncontrada = errors.New("documento não encontrado na SEFIN")
+
+// Rejection code for a DPS that was already turned into an NFS-e
+const ErroDPSDuplicada = "E0014"
+
+// notFound maps a 404 rejection of the consultation API to ErrNFSeNaoEncontrada
+func notFound(err error) error {
+	var rejeicao *NFSeRejeicao
+	if errors.As(err, &rejeicao) && rejeicao.StatusCode == http.StatusNotFound {
+		return ErrNFSeNaoEncontrada
+	}
+	return err
 }
 
 // NewNFSeNacionalService creates a new NFS-e Nacional service instance
 func NewNFSeNacionalService(cfg *config.Config, ambiente string) *NFSeNacionalService {
-	return &NFSeNacionalService{
+	s := &NFSeNacionalService{
 		config:   cfg,
 		ambiente: ambiente,
+		baseURL:  cfg.NFSeBaseURL,
+		timeout:  30 * time.Second,
+	}
+
+	if cfg.NFSeCAFile != "" {
+		pem, err := os.ReadFile(cfg.NFSeCAFile)
+		if err != nil {
+			log.Printf("⚠️ Não foi possível ler NFSE_CA_FILE: %v", err)
+		} else {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			pool.AppendCertsFromPEM(pem)
+			s.rootCAs = pool
+		}
+	}
+
+	return s
+}
+
+// WithEndpoint points the client to another SEFIN endpoint, trusting rootCAs for its TLS certificate
+func (s *NFSeNacionalService) WithEndpoint(baseURL string, rootCAs *x509.CertPool) *NFSeNacionalService {
+	c := *s
+	c.baseURL = strings.TrimRight(baseURL, "/")
+	c.rootCAs = rootCAs
+	return &c
+}
+
+func (s *NFSeNacionalService) GetBaseURL() string {
+	if s.baseURL != "" {
+		return s.baseURL
+	}
+	if s.ambiente == AmbienteProducao {
+		return NFSeURLProducao
+	}
+	return NFSeURLHomologacao
+}
+
+// TpAmb returns the tpAmb code of the configured environment
+func (s *NFSeNacionalService) TpAmb() int {
+	if s.ambiente == AmbienteProducao {
+		return 1
 	}
+	return 2
 }
 
-// EmitirNFSe emits a new NFS-e from DPS via Python Bridge
-func (s *NFSeNacionalService) EmitirNFSe(dps *DPS, certPath string) (*NFSeResponse, error) {
-	// Prepare parameters for Python
-	resp, err := bridge.CallPython("emit_nfse", map[string]interface{}{
-		"dps_data":  dps,
-		"cert_path": certPath,
-		"url":       s.GetBaseURL() + "/nfse",
-	})
+// EmitirNFSe signs the DPS with the A1 certificate and submits it to the SEFIN Nacional
+func (s *NFSeNacionalService) EmitirNFSe(dps *DPS, cert *A1Certificate) (*NFSeResponse, error) {
+	if dps.InfDPS.TpAmb == 0 {
+		dps.InfDPS.TpAmb = s.TpAmb()
+	}
+	signed, err := SignDPS(dps, cert)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := EncodeNFSeXML(signed)
 	if err != nil {
 		return nil, err
 	}
 
-	dataJSON, _ := json.Marshal(resp.Data)
-	var nfseResp NFSeResponse
-	if err := json.Unmarshal(dataJSON, &nfseResp); err != nil {
-		return nil, fmt.Errorf("failed to parse bridge response: %w", err)
+	var resp NFSeResponse
+	if err := s.post(cert, "/nfse", map[string]string{"dpsXmlGZipB64": payload}, &resp); err != nil {
+		return nil, err
 	}
 
-	return &nfseResp, nil
+	if err := completeNFSeResponse(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
 }
 
-// CancelarNFSe cancels an existing NFS-e via Python Bridge
-func (s *NFSeNacionalService) CancelarNFSe(chaveAcesso, motivo, certPath string) error {
-	_, err := bridge.CallPython("cancel_nfse", map[string]interface{}{
-		"chave_acesso": chaveAcesso,
-		"x_motivo":     motivo,
-		"cert_path":    certPath,
-		"url":          s.GetBaseURL() + "/nfse/cancelar",
-	})
-	return err
+// completeNFSeResponse fills the fields the official API leaves out from the returned document
+func completeNFSeResponse(resp *NFSeResponse) error {
+	if resp.XMLBase64 != "" {
+		xmlData, err := DecodeNFSeXML(resp.XMLBase64)
+		if err != nil {
+			return err
+		}
+		info, err := ParseNFSeXML(xmlData)
+		if err != nil {
+			return err
+		}
+		if resp.ChaveAcesso == "" {
+			resp.ChaveAcesso = info.ChaveAcesso
+		}
+		if resp.NumeroNFSe == "" {
+			resp.NumeroNFSe = info.Numero
+		}
+		if resp.DataEmissao == "" {
+			resp.DataEmissao = info.DataProcessamento
+		}
+	}
+	if resp.ChaveAcesso == "" {
+		return errors.New("resposta da SEFIN sem chave de acesso")
+	}
+	if resp.CodigoVerificacao == "" && len(resp.ChaveAcesso) == 50 {
+		resp.CodigoVerificacao = resp.ChaveAcesso[40:49] // código numérico da chave
+	}
+
+	return nil
 }
 
-func (s *NFSeNacionalService) GetBaseURL() string {
-	if s.ambiente == AmbienteProducao {
-		return NFSeURLProducao
+// ConsultarDPS looks up the NFS-e generated from a DPS and returns its access key.
+// A DPS the SEFIN never received is reported as ErrNFSeNaoEncontrada.
+func (s *NFSeNacionalService) ConsultarDPS(idDPS string, cert *A1Certificate) (string, error) {
+	var resp NFSeResponse
+	if err := s.do(cert, http.MethodGet, "/dps/"+idDPS, nil, &resp); err != nil {
+		return "", notFound(err)
 	}
-	return NFSeURLHomologacao
+	if resp.ChaveAcesso == "" {
+		return "", ErrNFSeNaoEncontrada
+	}
+	return resp.ChaveAcesso, nil
+}
+
+// ConsultarNFSe retrieves an issued NFS-e by its access key
+func (s *NFSeNacionalService) ConsultarNFSe(chaveAcesso string, cert *A1Certificate) (*NFSeResponse, error) {
+	var resp NFSeResponse
+	if err := s.do(cert, http.MethodGet, "/nfse/"+chaveAcesso, nil, &resp); err != nil {
+		return nil, notFound(err)
+	}
+	if resp.ChaveAcesso == "" {
+		resp.ChaveAcesso = chaveAcesso
+	}
+	if err := completeNFSeResponse(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelarNFSe registers a signed cancellation event (e101101) for an NFS-e
+func (s *NFSeNacionalService) CancelarNFSe(chaveAcesso, cnpjAutor, codigoMotivo, motivo string, cert *A1Certificate) (*EventoResponse, error) {
+	ped := PedidoRegistroEvento{
+		Versao: NFSeVersaoLeiaute,
+		InfPedReg: InfPedidoRegistro{
+			ID:            fmt.Sprintf("PRE%s%s%03d", chaveAcesso, EventoCancelamento, 1),
+			TpAmb:         s.TpAmb(),
+			VerAplic:      NFSeVerAplic,
+			DhEvento:      time.Now().Format(nfseDateTimeLayout),
+			CNPJAutor:     cnpjAutor,
+			ChNFSe:        chaveAcesso,
+			NPedRegEvento: 1,
+			Cancelamento: &EventoCancelamentoNFSe{
+				XDesc:   "Cancelamento de NFS-e",
+				CMotivo: codigoMotivo,
+				XMotivo: motivo,
+			},
+		},
+	}
+
+	doc, err := xml.Marshal(ped)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := SignXML(doc, ped.InfPedReg.ID, cert)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := EncodeNFSeXML(signed)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp EventoResponse
+	if err := s.post(cert, "/nfse/"+chaveAcesso+"/eventos", map[string]string{"pedidoRegistroEventoXmlGZipB64": payload}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.ChaveAcesso == "" {
+		resp.ChaveAcesso = chaveAcesso
+	}
+	if resp.TipoEvento == "" {
+		resp.TipoEvento = EventoCancelamento
+	}
+	return &resp, nil
+}
+
+// PedidoRegistroEvento is the signed request to register an event of an NFS-e
+type PedidoRegistroEvento struct {
+	XMLName   xml.Name          `xml:"http://www.sped.fazenda.gov.br/nfse pedRegEvento"`
+	Versao    string            `xml:"versao,attr"`
+	InfPedReg InfPedidoRegistro `xml:"infPedReg"`
+}
+
+type InfPedidoRegistro struct {
+	ID            string                  `xml:"Id,attr"`
+	TpAmb         int                     `xml:"tpAmb"`
+	VerAplic      string                  `xml:"verAplic"`
+	DhEvento      string                  `xml:"dhEvento"`
+	CNPJAutor     string                  `xml:"CNPJAutor"`
+	ChNFSe        string                  `xml:"chNFSe"`
+	NPedRegEvento int                     `xml:"nPedRegEvento"`
+	Cancelamento  *EventoCancelamentoNFSe `xml:"e101101,omitempty"`
+}
+
+type EventoCancelamentoNFSe struct {
+	XDesc   string `xml:"xDesc"`
+	CMotivo string `xml:"cMotivo"`
+	XMotivo string `xml:"xMotivo"`
+}
+
+// post sends a JSON request over mutual TLS and decodes the SEFIN answer into out
+func (s *NFSeNacionalService) post(cert *A1Certificate, path string, body interface{}, out interface{}) error {
+	return s.do(cert, http.MethodPost, path, body, out)
+}
+
+// do sends a request over mutual TLS and decodes the SEFIN response
+func (s *NFSeNacionalService) do(cert *A1Certificate, method, path string, body interface{}, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	client := &http.Client{
+		Timeout: s.timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert.TLSCertificate()},
+				RootCAs:      s.rootCAs,
+				MinVersion:   tls.VersionTLS12,
+			},
+		},
+	}
+
+	req, err := http.NewRequest(method, s.GetBaseURL()+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("falha de comunicação com a SEFIN: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("falha ao ler resposta da SEFIN: %w", err)
+	}
+
+	return parseSEFINResponse(resp.StatusCode, data, out)
+}
+
+// parseSEFINResponse accepts both the wrapped APIResponse and the bare document returned by the official API
+func parseSEFINResponse(status int, body []byte, out interface{}) error {
+	var wrapper struct {
+		Sucesso *bool           `json:"sucesso"`
+		Data    json.RawMessage `json:"data"`
+		Erros   []APIError      `json:"erros"`
+	}
+	if err := json.Unmarshal(body, &wrapper); err != nil {
+		if status >= 500 || status == http.StatusTooManyRequests {
+			return fmt.Errorf("SEFIN indisponível (HTTP %d)", status)
+		}
+		return fmt.Errorf("resposta inválida da SEFIN (HTTP %d): %w", status, err)
+	}
+
+	failed := len(wrapper.Erros) > 0 || (wrapper.Sucesso != nil && !*wrapper.Sucesso) || status >= 400
+	if failed {
+		// Server-side failures without a business error may succeed on retry
+		if len(wrapper.Erros) == 0 && (status >= 500 || status == http.StatusTooManyRequests) {
+			return fmt.Errorf("SEFIN indisponível (HTTP %d)", status)
+		}
+		return &NFSeRejeicao{StatusCode: status, Erros: wrapper.Erros}
+	}
+
+	if len(wrapper.Data) > 0 && string(wrapper.Data) != "null" {
+		return json.Unmarshal(wrapper.Data, out)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// SignDPS serializes the DPS and signs infDPS with the certificate
+func SignDPS(dps *DPS, cert *A1Certificate) ([]byte, error) {
+	if dps.Versao == "" {
+		dps.Versao = NFSeVersaoLeiaute
+	}
+	doc, err := xml.Marshal(dps)
+	if err != nil {
+		return nil, fmt.Errorf("erro ao gerar XML da DPS: %w", err)
+	}
+	return SignXML(doc, dps.InfDPS.ID, cert)
+}
+
+// DPSID builds the Id of infDPS: "DPS" + município(7) + tipo inscrição(1) + CNPJ(14) + série(5) + número(15)
+func DPSID(codMunicipio int, cnpj, serie string, numero int64) string {
+	serieNum, _ := strconv.Atoi(serie)
+	return fmt.Sprintf("DPS%07d2%014s%05d%015d", codMunicipio, onlyDigits(cnpj), serieNum, numero)
+}
+
+// NFSeXMLInfo holds the identification of an authorized NFS-e document
+type NFSeXMLInfo struct {
+	ChaveAcesso       string
+	Numero            string
+	DataProcessamento string
+	Ambiente          string
+}
+
+// ParseNFSeXML extracts the identification fields of an NFS-e XML
+func ParseNFSeXML(data []byte) (*NFSeXMLInfo, error) {
+	var doc struct {
+		InfNFSe struct {
+			ID     string `xml:"Id,attr"`
+			NNFSe  string `xml:"nNFSe"`
+			DhProc string `xml:"dhProc"`
+			AmbGer string `xml:"ambGer"`
+		} `xml:"infNFSe"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("XML da NFS-e inválido: %w", err)
+	}
+	return &NFSeXMLInfo{
+		ChaveAcesso:       strings.TrimPrefix(doc.InfNFSe.ID, "NFS"),
+		Numero:            doc.InfNFSe.NNFSe,
+		DataProcessamento: doc.InfNFSe.DhProc,
+		Ambiente:          doc.InfNFSe.AmbGer,
+	}, nil
+}
+
+// EncodeNFSeXML compresses an XML with GZip and encodes it in Base64, as the API expects
+func EncodeNFSeXML(xmlData []byte) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(xmlData); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
 
 // DecodeNFSeXML decodes the Base64+GZip XML from API response
@@ -217,7 +632,7 @@ func BuildDPSFromRequest(
 	prestadorIM string,
 	tomadorDoc string,
 	tomadorNome string,
-	tomadorEndereco EnderecoNFSe,
+	tomadorEndereco *EnderecoNFSe,
 	discriminacao string,
 	codigoServico string,
 	valor float64,
@@ -228,78 +643,81 @@ func BuildDPSFromRequest(
 ) *DPS {
 	now := time.Now()
 
-	// Default Op Type based on nature
-	tpOp := 1 // Dentro muni
-	if naturezaOperacao == "TRIBUTACAO_FORA_MUNICIPIO" {
-		tpOp = 2
+	// ISSQN taxation based on the nature of the operation
+	tribISSQN := 1 // Operação tributável
+	switch naturezaOperacao {
+	case "IMUNIDADE":
+		tribISSQN = 2
+	case "ISENCAO", "SUSPENSAO", "EXIGIBILIDADE_SUSPENSA":
+		tribISSQN = 4
 	}
 
 	dps := &DPS{
+		Versao: NFSeVersaoLeiaute,
 		InfDPS: InfDPS{
-			ID:        fmt.Sprintf("DPS%d", now.UnixNano()),
-			DhEmi:     now.Format(time.RFC3339),
-			VerAplic:  "INOVAR_1.0",
-			SeriesDPS: "NFS",
-			NumDPS:    now.UnixNano() / 1000000,
+			DhEmi:     now.Format(nfseDateTimeLayout),
+			VerAplic:  NFSeVerAplic,
+			SeriesDPS: SerieDPSPadrao,
 			DCompet:   now.Format("2006-01-02"),
 			TpEmit:    1, // Prestador
 			CLocEmi:   codMunicipioEmissao,
-			TpOp:      tpOp,
 			Prest: Prestador{
-				CNPJ: prestadorCNPJ,
-				IM:   prestadorIM,
+				CNPJ:    onlyDigits(prestadorCNPJ),
+				IM:      prestadorIM,
+				RegTrib: RegimeTributos{OpSimpNac: 1},
 			},
-			Toma: Tomador{
+			Toma: &Tomador{
 				XNome: tomadorNome,
 				End:   tomadorEndereco,
 			},
 			Serv: Servico{
-				CLocPrestacao: codMunicipioPrestacao,
+				LocPrest: LocalPrestacao{CLocPrestacao: codMunicipioPrestacao},
 				CServ: CServ{
 					CodTribNac: codigoServico,
+					XDescServ:  discriminacao,
 					CNAE:       cnae,
 				},
-				DiscriminacaoServ: discriminacao,
 			},
 			Valores: Valores{
-				VServPrest: ValorServPrest{
-					VReceb: valor,
-				},
-				VTDC: &ValorTribDedCon{
-					VBaseCalc: valor,
-					TISSQN:    1, // Normal
+				VServPrest: ValorServPrest{VServ: Decimal(valor)},
+				Trib: Tributacao{
+					TribMun: TributacaoMunicipal{
+						TribISSQN:  tribISSQN,
+						TpRetISSQN: 1, // Não retido
+					},
 				},
 			},
 		},
 	}
 
+	// Municipal service code of the municipality where the service is performed
+	if regra := Municipios().RegraISS(codMunicipioPrestacao, codigoServico); regra != nil {
+		dps.InfDPS.Serv.CServ.CodTribMun = regra.CodigoTributacaoMunicipal
+	}
+
 	// Set document (CPF or CNPJ)
-	if len(tomadorDoc) == 11 {
-		dps.InfDPS.Toma.CPF = tomadorDoc
-	} else if len(tomadorDoc) == 14 {
-		dps.InfDPS.Toma.CNPJ = tomadorDoc
+	doc := onlyDigits(tomadorDoc)
+	if len(doc) == 11 {
+		dps.InfDPS.Toma.CPF = doc
+	} else if len(doc) == 14 {
+		dps.InfDPS.Toma.CNPJ = doc
 	}
 
 	return dps
 }
 
-// GetCodigoMunicipioIBGE returns IBGE code for known cities
-func GetCodigoMunicipioIBGE(cidade, uf string) int {
-	// Common cities in ES (Espírito Santo)
-	codigos := map[string]int{
-		"SERRA-ES":      3205002,
-		"VITORIA-ES":    3205309,
-		"VILA VELHA-ES": 3205200,
-		"CARIACICA-ES":  3201308,
-		"VIANA-ES":      3205101,
-		"GUARAPARI-ES":  3202405,
-	}
-
-	key := fmt.Sprintf("%s-%s", cidade, uf)
-	if cod, ok := codigos[key]; ok {
-		return cod
-	}
+// SetNumero assigns the series/number of the DPS and derives its Id
+func (d *DPS) SetNumero(serie string, numero int64) {
+	d.InfDPS.SeriesDPS = serie
+	d.InfDPS.NumDPS = numero
+	d.InfDPS.ID = DPSID(d.InfDPS.CLocEmi, d.InfDPS.Prest.CNPJ, serie, numero)
+}
 
-	log.Printf("⚠️ Código IBGE não encontrado para %s-%s", cidade, uf)
-	return 0
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
 }
@@ -3,6 +3,7 @@ package services
 import (
 	"inovar/internal/domain"
 	"inovar/internal/websocket"
+	"log"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,12 +11,13 @@ import (
 )
 
 type NotificationService struct {
-	db  *gorm.DB
-	hub *websocket.Hub
+	db         *gorm.DB
+	hub        *websocket.Hub
+	permissoes *PermissaoService
 }
 
-func NewNotificationService(db *gorm.DB, hub *websocket.Hub) *NotificationService {
-	return &NotificationService{db: db, hub: hub}
+func NewNotificationService(db *gorm.DB, hub *websocket.Hub, permissoes *PermissaoService) *NotificationService {
+	return &NotificationService{db: db, hub: hub, permissoes: permissoes}
 }
 
 // CreateNotification creates a new notification for a user
@@ -36,11 +38,35 @@ func (s *NotificationService) CreateNotification(userID, title, message, notifTy
 	}
 
 	// Broadcast notification to user
-	s.hub.Broadcast("notification:new", notification)
+	s.hub.SendToUser(notification.UserID, "notification:new", notification)
 
 	return notification, nil
 }
 
+// CompanyAdmins returns the users who receive the notices of a company
+func (s *NotificationService) CompanyAdmins(companyID string) []domain.User {
+	return s.permissoes.UsersWith(companyID, domain.PermNotificationsAdmin)
+}
+
+// NotifyCompanyAdmins notifies the users who receive the notices of a company plus any extra users, once each
+func (s *NotificationService) NotifyCompanyAdmins(companyID, title, message, notifType, link string, extraUserIDs ...string) {
+	recipients := map[string]bool{}
+	for _, id := range extraUserIDs {
+		if id != "" {
+			recipients[id] = true
+		}
+	}
+	for _, admin := range s.CompanyAdmins(companyID) {
+		recipients[admin.ID] = true
+	}
+
+	for userID := range recipients {
+		if _, err := s.CreateNotification(userID, title, message, notifType, link); err != nil {
+			log.Printf("⚠️ Falha ao notificar %s: %v", userID, err)
+		}
+	}
+}
+
 // GetUserNotifications returns all notifications for a user, ordered by date
 func (s *NotificationService) GetUserNotifications(userID string) ([]domain.Notification, error) {
 	var notifications []domain.Notification
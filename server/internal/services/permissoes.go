@@ -0,0 +1,102 @@
+package services
+
+import (
+	"sync"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+// PermissaoService resolves the permissions of a user from the configurable role assigned to them,
+// falling back to the defaults of the built-in role. Results are cached per user until invalidated.
+type PermissaoService struct {
+	db    *gorm.DB
+	mu    sync.RWMutex
+	cache map[string]permissoesCache
+}
+
+// permissoesCache is the resolved set of a user for the role their token carries
+type permissoesCache struct {
+	role  string
+	perms map[string]bool
+}
+
+// NewPermissaoService creates a new permission service
+func NewPermissaoService(db *gorm.DB) *PermissaoService {
+	return &PermissaoService{db: db, cache: map[string]permissoesCache{}}
+}
+
+// Permissions returns the permission set of a user
+func (s *PermissaoService) Permissions(userID, role string) map[string]bool {
+	if role == domain.RoleSuperAdmin {
+		return setPermissoes(domain.PermissoesPadrao[domain.RoleAdmin])
+	}
+
+	s.mu.RLock()
+	cached, ok := s.cache[userID]
+	s.mu.RUnlock()
+	if ok && cached.role == role {
+		return cached.perms
+	}
+
+	perms := setPermissoes(domain.PermissoesPadrao[role])
+	var user domain.User
+	if err := s.db.Select("id", "role", "perfil_id").First(&user, "id = ?", userID).Error; err == nil {
+		switch {
+		case user.Role != role:
+			// The token predates a role change: nothing until the user gets a new one
+			perms = map[string]bool{}
+		case user.PerfilID != nil:
+			var perfil domain.Perfil
+			if err := s.db.First(&perfil, "id = ?", *user.PerfilID).Error; err == nil {
+				perms = setPermissoes(perfil.Permissoes)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[userID] = permissoesCache{role: role, perms: perms}
+	s.mu.Unlock()
+	return perms
+}
+
+// Has reports whether the user holds the permission
+func (s *PermissaoService) Has(userID, role, permissao string) bool {
+	return s.Permissions(userID, role)[permissao]
+}
+
+// UsersWith returns the active users of a company holding the permission
+func (s *PermissaoService) UsersWith(companyID, permissao string) []domain.User {
+	var users []domain.User
+	s.db.Where("active = ? AND company_id = ? AND role IN ?", true, companyID, []string{domain.RoleAdmin, domain.RoleTecnico}).
+		Find(&users)
+	holders := users[:0]
+	for _, u := range users {
+		if s.Has(u.ID, u.Role, permissao) {
+			holders = append(holders, u)
+		}
+	}
+	return holders
+}
+
+// Invalidate drops the cached permissions of the users, or of everyone when none is given
+func (s *PermissaoService) Invalidate(userIDs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(userIDs) == 0 {
+		s.cache = map[string]permissoesCache{}
+		return
+	}
+	for _, id := range userIDs {
+		delete(s.cache, id)
+	}
+}
+
+func setPermissoes(list []string) map[string]bool {
+	set := make(map[string]bool, len(list))
+	for _, p := range list {
+		set[p] = true
+	}
+	return set
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+
+	"inovar/internal/domain"
+)
+
+func TestUsersWith(t *testing.T) {
+	db := newTestDB(t, &domain.User{}, &domain.Perfil{})
+	company, outra := "company-a", "company-b"
+
+	perfil := func(id, base string, perms ...string) *string {
+		db.Create(&domain.Perfil{ID: id, CompanyID: company, Nome: id, Base: base, Permissoes: perms})
+		return &id
+	}
+	sem := perfil("sem-avisos", domain.RoleAdmin, domain.PermFiscalView)
+	com := perfil("com-avisos", domain.RoleTecnico, domain.PermNotificationsAdmin)
+
+	users := []domain.User{
+		{ID: "admin", Role: domain.RoleAdmin, Active: true, CompanyID: &company},
+		{ID: "admin-inativo", Role: domain.RoleAdmin, Active: false, CompanyID: &company},
+		{ID: "admin-outra", Role: domain.RoleAdmin, Active: true, CompanyID: &outra},
+		{ID: "perfil-admin-sem-avisos", Role: domain.RoleAdmin, Active: true, CompanyID: &company, PerfilID: sem},
+		{ID: "perfil-tecnico-com-avisos", Role: domain.RoleTecnico, Active: true, CompanyID: &company, PerfilID: com},
+		{ID: "tecnico", Role: domain.RoleTecnico, Active: true, CompanyID: &company},
+		{ID: "cliente", Role: domain.RoleCliente, Active: true, CompanyID: &company},
+	}
+	for i := range users {
+		users[i].Email = users[i].ID + "@inovar.test"
+		users[i].Name = users[i].ID
+		if err := db.Create(&users[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Active defaults to true on create
+	db.Model(&domain.User{}).Where("id = ?", "admin-inativo").Update("active", false)
+
+	got := map[string]bool{}
+	for _, u := range NewPermissaoService(db).UsersWith(company, domain.PermNotificationsAdmin) {
+		got[u.ID] = true
+	}
+	want := map[string]bool{"admin": true, "perfil-tecnico-com-avisos": true}
+	if len(got) != len(want) {
+		t.Errorf("UsersWith = %v, want %v", got, want)
+	}
+	for id := range want {
+		if !got[id] {
+			t.Errorf("UsersWith misses %s", id)
+		}
+	}
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+
+	"inovar/internal/infra/pdf"
+)
+
+var pmocFuncs = template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("02/01/2006") },
+	"datePtr": func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format("02/01/2006")
+	},
+	"doc":      formatDocument,
+	"endereco": buildEndereco,
+}
+
+var pmocTemplate = template.Must(template.New("pmoc").Funcs(pmocFuncs).Parse(`<!DOCTYPE html>
+<html lang="pt-BR">
+<head>
+<meta charset="utf-8">
+<title>PMOC - {{.Cliente.Name}}</title>
+<style>
+body { font-family: Arial, Helvetica, sans-serif; font-size: 12px; color: #1e293b; margin: 24px; }
+h1 { font-size: 18px; margin: 0 0 4px; }
+h2 { font-size: 14px; margin: 24px 0 8px; border-bottom: 2px solid #1e293b; padding-bottom: 4px; }
+table { width: 100%; border-collapse: collapse; margin-bottom: 8px; }
+th, td { border: 1px solid #cbd5e1; padding: 4px 6px; text-align: left; vertical-align: top; }
+th { background: #f1f5f9; }
+.pendente { color: #b91c1c; font-weight: bold; }
+.ok { color: #15803d; }
+.meta td { border: none; padding: 2px 6px 2px 0; }
+.assinatura { margin-top: 48px; width: 320px; border-top: 1px solid #1e293b; text-align: center; padding-top: 4px; }
+@media print { body { margin: 0; } h2 { page-break-after: avoid; } }
+</style>
+</head>
+<body>
+<h1>PMOC - Plano de Manutenção, Operação e Controle</h1>
+<p>Lei nº 13.589/2018 · Período de {{date .From}} a {{date .To}}</p>
+
+<h2>1. Identificação do ambiente</h2>
+<table class="meta">
+<tr><td><b>Cliente:</b> {{.Cliente.Name}}</td><td><b>CPF/CNPJ:</b> {{doc .Cliente.Document}}</td></tr>
+<tr><td colspan="2"><b>Endereço:</b> {{endereco .Cliente.Endereco}}</td></tr>
+<tr><td><b>Ambiente:</b> {{.Plano.Ambiente}}</td><td><b>Área climatizada:</b> {{if .Plano.AreaM2}}{{.Plano.AreaM2}} m²{{else}}-{{end}} · <b>Ocupantes:</b> {{if .Plano.Ocupantes}}{{.Plano.Ocupantes}}{{else}}-{{end}}</td></tr>
+<tr><td><b>Vigência:</b> {{date .Plano.VigenciaInicio}} a {{datePtr .Plano.VigenciaFim}}</td><td></td></tr>
+</table>
+
+<h2>2. Responsável técnico</h2>
+<table class="meta">
+<tr><td><b>Nome:</b> {{.Plano.ResponsavelNome}}</td><td><b>Registro (CREA/CFT):</b> {{.Plano.ResponsavelRegistro}}</td></tr>
+<tr><td><b>ART/TRT:</b> {{if .Plano.ResponsavelART}}{{.Plano.ResponsavelART}}{{else}}-{{end}}</td><td><b>Empresa:</b> {{.Prestador.RazaoSocial}} {{if .Prestador.CNPJ}}({{doc .Prestador.CNPJ}}){{end}}</td></tr>
+</table>
+
+<h2>3. Equipamentos</h2>
+<table>
+<tr><th>Local</th><th>Marca / Modelo</th><th>Capacidade</th><th>Nº de série</th></tr>
+{{range .Equipamentos}}<tr><td>{{.Location}}</td><td>{{.Brand}} {{.Model}}</td><td>{{.BTU}} BTU/h</td><td>{{if .SerialNumber}}{{.SerialNumber}}{{else}}-{{end}}</td></tr>
+{{else}}<tr><td colspan="4">Nenhum equipamento ativo.</td></tr>{{end}}
+</table>
+
+<h2>4. Plano de atividades</h2>
+<table>
+<tr><th>Equipamento</th><th>Atividade</th><th>Periodicidade</th><th>Última execução</th><th>Próxima</th><th>Situação</th></tr>
+{{range .Linhas}}<tr><td>{{.Equipamento.Location}}</td><td>{{.Atividade.Descricao}}</td><td>{{.Atividade.Frequencia}}</td><td>{{datePtr .UltimaExecucao}}</td><td>{{date .ProximaData}}</td><td>{{if .Pendente}}<span class="pendente">Pendente</span>{{else}}<span class="ok">Em dia</span>{{end}}</td></tr>
+{{end}}
+</table>
+
+<h2>5. Registro de execução</h2>
+<table>
+<tr><th>Data</th><th>Equipamento</th><th>Atividade</th><th>Técnico</th><th>Observação</th></tr>
+{{range .Execucoes}}<tr><td>{{date .ExecutadoEm}}</td><td>{{.Equipamento.Location}}</td><td>{{.Atividade.Descricao}}</td><td>{{.TecnicoNome}}</td><td>{{.Observacao}}</td></tr>
+{{else}}<tr><td colspan="5">Nenhuma execução registrada no período.</td></tr>{{end}}
+</table>
+
+{{if .Plano.Observacoes}}<h2>Observações</h2><p>{{.Plano.Observacoes}}</p>{{end}}
+
+<div class="assinatura">{{.Plano.ResponsavelNome}}<br>Registro {{.Plano.ResponsavelRegistro}}</div>
+<p style="margin-top:24px;font-size:10px;color:#64748b">Documento gerado em {{.GeneratedAt.Format "02/01/2006 15:04"}}</p>
+</body>
+</html>`))
+
+// RenderPMOCHTML renders the PMOC document as printable HTML
+func RenderPMOCHTML(r *PMOCReport) (string, error) {
+	var buf bytes.Buffer
+	if err := pmocTemplate.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// pmocLayout keeps the PDF cursor and breaks pages when needed
+type pmocLayout struct {
+	doc *pdf.Document
+	y   float64
+}
+
+const (
+	pmocMargin = 36.0
+	pmocBottom = pdf.PageHeight - 48
+	pmocWidth  = pdf.PageWidth - 2*pmocMargin
+)
+
+func (l *pmocLayout) ensure(h float64) {
+	if l.y+h > pmocBottom {
+		l.doc.AddPage()
+		l.y = pmocMargin
+	}
+}
+
+func (l *pmocLayout) section(title string) {
+	l.ensure(40)
+	l.y += 14
+	l.doc.SetFont(pdf.Bold, 11)
+	l.doc.Text(pmocMargin, l.y, title)
+	l.y += 4
+	l.doc.SetLineWidth(1)
+	l.doc.Line(pmocMargin, l.y, pmocMargin+pmocWidth, l.y)
+	l.y += 12
+}
+
+func (l *pmocLayout) field(label, value string) {
+	l.doc.SetFont(pdf.Bold, 9)
+	l.ensure(12)
+	l.doc.Text(pmocMargin, l.y, label)
+	w := l.doc.TextWidth(label + " ")
+	l.doc.SetFont(pdf.Regular, 9)
+	for i, line := range l.doc.WrapText(value, pmocWidth-w) {
+		if i > 0 {
+			l.y += 11
+			l.ensure(12)
+		}
+		l.doc.Text(pmocMargin+w, l.y, line)
+	}
+	l.y += 13
+}
+
+// table draws rows with wrapped cells, repeating the header on each page.
+// widths are fractions of the content width.
+func (l *pmocLayout) table(widths []float64, header []string, rows [][]string) {
+	wrap := func(cells []string, style int) ([][]string, float64) {
+		l.doc.SetFont(style, 8)
+		wrapped := make([][]string, len(cells))
+		lines := 1
+		for i, cell := range cells {
+			wrapped[i] = l.doc.WrapText(cell, widths[i]*pmocWidth-6)
+			if len(wrapped[i]) > lines {
+				lines = len(wrapped[i])
+			}
+		}
+		return wrapped, float64(lines)*10 + 6
+	}
+
+	draw := func(wrapped [][]string, h float64, style int) {
+		l.doc.SetFont(style, 8)
+		if style == pdf.Bold {
+			l.doc.SetColor(241, 245, 249)
+			l.doc.Rect(pmocMargin, l.y, pmocWidth, h, true)
+			l.doc.SetColor(30, 41, 59)
+		}
+		l.doc.SetLineWidth(0.5)
+		x := pmocMargin
+		for i, cellLines := range wrapped {
+			w := widths[i] * pmocWidth
+			l.doc.Rect(x, l.y, w, h, false)
+			for j, line := range cellLines {
+				l.doc.Text(x+3, l.y+10+float64(j)*10, line)
+			}
+			x += w
+		}
+		l.y += h
+	}
+
+	head, headH := wrap(header, pdf.Bold)
+	l.ensure(headH + 16)
+	draw(head, headH, pdf.Bold)
+	for _, row := range rows {
+		cells, h := wrap(row, pdf.Regular)
+		if l.y+h > pmocBottom {
+			l.doc.AddPage()
+			l.y = pmocMargin
+			draw(head, headH, pdf.Bold)
+		}
+		draw(cells, h, pdf.Regular)
+	}
+	l.y += 4
+}
+
+// RenderPMOCPDF renders the PMOC document as PDF
+func RenderPMOCPDF(r *PMOCReport) ([]byte, error) {
+	doc := pdf.New()
+	doc.SetTitle("PMOC - " + r.Cliente.Name)
+	doc.AddPage()
+	l := &pmocLayout{doc: doc, y: pmocMargin + 8}
+
+	doc.SetColor(30, 41, 59)
+	doc.SetStrokeColor(203, 213, 225)
+	doc.SetFont(pdf.Bold, 15)
+	doc.Text(pmocMargin, l.y, "PMOC - Plano de Manutenção, Operação e Controle")
+	l.y += 16
+	doc.SetFont(pdf.Regular, 9)
+	doc.Text(pmocMargin, l.y, fmt.Sprintf("Lei nº 13.589/2018 · Período de %s a %s", r.From.Format("02/01/2006"), r.To.Format("02/01/2006")))
+	l.y += 8
+
+	orDash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+
+	l.section("1. Identificação do ambiente")
+	l.field("Cliente:", r.Cliente.Name)
+	l.field("CPF/CNPJ:", orDash(formatDocument(r.Cliente.Document)))
+	l.field("Endereço:", orDash(buildEndereco(r.Cliente.Endereco)))
+	l.field("Ambiente:", orDash(r.Plano.Ambiente))
+	area := "-"
+	if r.Plano.AreaM2 > 0 {
+		area = fmt.Sprintf("%.0f m²", r.Plano.AreaM2)
+	}
+	ocupantes := "-"
+	if r.Plano.Ocupantes > 0 {
+		ocupantes = fmt.Sprintf("%d", r.Plano.Ocupantes)
+	}
+	l.field("Área climatizada:", area+"   Ocupantes: "+ocupantes)
+	fim := "-"
+	if r.Plano.VigenciaFim != nil {
+		fim = r.Plano.VigenciaFim.Format("02/01/2006")
+	}
+	l.field("Vigência:", r.Plano.VigenciaInicio.Format("02/01/2006")+" a "+fim)
+
+	l.section("2. Responsável técnico")
+	l.field("Nome:", r.Plano.ResponsavelNome)
+	l.field("Registro (CREA/CFT):", r.Plano.ResponsavelRegistro)
+	l.field("ART/TRT:", orDash(r.Plano.ResponsavelART))
+	empresa := r.Prestador.RazaoSocial
+	if r.Prestador.CNPJ != "" {
+		empresa += " (" + formatCNPJ(r.Prestador.CNPJ) + ")"
+	}
+	l.field("Empresa:", orDash(empresa))
+
+	l.section("3. Equipamentos")
+	rows := [][]string{}
+	for _, eq := range r.Equipamentos {
+		rows = append(rows, []string{eq.Location, eq.Brand + " " + eq.Model, fmt.Sprintf("%d BTU/h", eq.BTU), orDash(eq.SerialNumber)})
+	}
+	if len(rows) == 0 {
+		rows = append(rows, []string{"Nenhum equipamento ativo.", "", "", ""})
+	}
+	l.table([]float64{0.35, 0.30, 0.15, 0.20}, []string{"Local", "Marca / Modelo", "Capacidade", "Nº de série"}, rows)
+
+	l.section("4. Plano de atividades")
+	rows = [][]string{}
+	for _, line := range r.Linhas {
+		last := "-"
+		if line.UltimaExecucao != nil {
+			last = line.UltimaExecucao.Format("02/01/2006")
+		}
+		situacao := "Em dia"
+		if line.Pendente {
+			situacao = "PENDENTE"
+		}
+		rows = append(rows, []string{line.Equipamento.Location, line.Atividade.Descricao, line.Atividade.Frequencia, last, line.ProximaData.Format("02/01/2006"), situacao})
+	}
+	l.table([]float64{0.18, 0.34, 0.12, 0.12, 0.12, 0.12}, []string{"Equipamento", "Atividade", "Periodicidade", "Última", "Próxima", "Situação"}, rows)
+
+	l.section("5. Registro de execução")
+	rows = [][]string{}
+	for _, exec := range r.Execucoes {
+		rows = append(rows, []string{exec.ExecutadoEm.Format("02/01/2006"), exec.Equipamento.Location, exec.Atividade.Descricao, exec.TecnicoNome, exec.Observacao})
+	}
+	if len(rows) == 0 {
+		rows = append(rows, []string{"-", "Nenhuma execução registrada no período.", "", "", ""})
+	}
+	l.table([]float64{0.11, 0.18, 0.31, 0.17, 0.23}, []string{"Data", "Equipamento", "Atividade", "Técnico", "Observação"}, rows)
+
+	if r.Plano.Observacoes != "" {
+		l.section("Observações")
+		doc.SetFont(pdf.Regular, 9)
+		for _, line := range doc.WrapText(r.Plano.Observacoes, pmocWidth) {
+			l.ensure(12)
+			doc.Text(pmocMargin, l.y, line)
+			l.y += 11
+		}
+	}
+
+	// Signature
+	l.ensure(70)
+	l.y += 45
+	doc.SetLineWidth(0.8)
+	doc.Line(pmocMargin, l.y, pmocMargin+230, l.y)
+	doc.SetFont(pdf.Regular, 9)
+	doc.TextCenter(pmocMargin+115, l.y+12, r.Plano.ResponsavelNome)
+	doc.TextCenter(pmocMargin+115, l.y+23, "Registro "+r.Plano.ResponsavelRegistro)
+
+	doc.SetFont(pdf.Regular, 7)
+	doc.Text(pmocMargin, pdf.PageHeight-24, "Documento gerado em "+r.GeneratedAt.Format("02/01/2006 15:04"))
+
+	return doc.Bytes()
+}
+
+// PMOCPeriodoPadrao returns the default report period: the last 12 months up to today
+func PMOCPeriodoPadrao(now time.Time) (time.Time, time.Time) {
+	to := time.Date(now.Year(), now.Month(), now.Day(), 23, 59, 59, 0, now.Location())
+	from := time.Date(now.Year()-1, now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return from, to
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/websocket"
+)
+
+// pmocTolerance lets an activity be recorded slightly before its period ends
+const pmocTolerance = 7 * 24 * time.Hour
+
+// PMOCService manages maintenance plans and their execution log
+type PMOCService struct {
+	db  *gorm.DB
+	hub *websocket.Hub
+}
+
+// NewPMOCService creates a new PMOC service
+func NewPMOCService(db *gorm.DB, hub *websocket.Hub) *PMOCService {
+	return &PMOCService{db: db, hub: hub}
+}
+
+// ValidFrequency reports whether f is a known PMOC frequency
+func ValidFrequency(f string) bool {
+	_, ok := domain.PMOCFrequencyMonths[f]
+	return ok
+}
+
+// DefaultActivities builds the standard activities for a new plan
+func (s *PMOCService) DefaultActivities(planoID string) []domain.PMOCAtividade {
+	atividades := make([]domain.PMOCAtividade, 0, len(domain.PMOCAtividadesPadrao))
+	for _, a := range domain.PMOCAtividadesPadrao {
+		atividades = append(atividades, domain.PMOCAtividade{
+			ID:         uuid.New().String(),
+			PlanoID:    planoID,
+			Descricao:  a.Descricao,
+			Frequencia: a.Frequencia,
+		})
+	}
+	return atividades
+}
+
+// appliesTo reports whether an activity covers the given unit
+func appliesTo(a *domain.PMOCAtividade, equipamentoID string) bool {
+	return a.EquipamentoID == nil || *a.EquipamentoID == equipamentoID
+}
+
+// lastExecution returns the latest execution of an activity on a unit up to `until`
+func (s *PMOCService) lastExecution(atividadeID, equipamentoID string, until time.Time) *domain.PMOCExecucao {
+	var exec domain.PMOCExecucao
+	err := s.db.Where("atividade_id = ? AND equipamento_id = ? AND executado_em <= ?", atividadeID, equipamentoID, until).
+		Order("executado_em DESC").First(&exec).Error
+	if err != nil {
+		return nil
+	}
+	return &exec
+}
+
+// NextDue returns when an activity is due again after its last execution
+func NextDue(a *domain.PMOCAtividade, last *domain.PMOCExecucao, planStart time.Time) time.Time {
+	if last == nil {
+		return planStart
+	}
+	return last.ExecutadoEm.AddDate(0, domain.PMOCFrequencyMonths[a.Frequencia], 0)
+}
+
+// RecordFromRequest logs the plan activities performed in a finished request.
+// Preventive requests record every due activity of their units; other requests only
+// the activities matching checked checklist items. Returns how many were recorded.
+func (s *PMOCService) RecordFromRequest(req *domain.Solicitacao, tecnicoID, tecnicoNome string) (int, error) {
+	var plano domain.PMOCPlano
+	err := s.db.Preload("Atividades").Where("client_id = ? AND active = ?", req.ClientID, true).First(&plano).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var equipamentoIDs []string
+	s.db.Model(&domain.SolicitacaoEquipamento{}).Where("solicitacao_id = ?", req.ID).Pluck("equipamento_id", &equipamentoIDs)
+
+	// Checked checklist items per unit ("" = request level)
+	var checklists []domain.Checklist
+	s.db.Where("solicitacao_id = ? AND checked = ?", req.ID, true).Find(&checklists)
+	checked := map[string]map[string]bool{}
+	for _, item := range checklists {
+		key := ""
+		if item.EquipamentoID != nil {
+			key = *item.EquipamentoID
+		}
+		if checked[key] == nil {
+			checked[key] = map[string]bool{}
+		}
+		checked[key][normalizeActivity(item.Description)] = true
+	}
+
+	preventive := req.ServiceType == domain.ServiceTypePreventiva
+	now := time.Now()
+	reqID := req.ID
+	recorded := 0
+
+	for _, eqID := range equipamentoIDs {
+		for i := range plano.Atividades {
+			a := &plano.Atividades[i]
+			if !appliesTo(a, eqID) {
+				continue
+			}
+
+			if preventive {
+				last := s.lastExecution(a.ID, eqID, now)
+				if NextDue(a, last, plano.VigenciaInicio).After(now.Add(pmocTolerance)) {
+					continue
+				}
+			} else {
+				desc := normalizeActivity(a.Descricao)
+				if !checked[eqID][desc] && !checked[""][desc] {
+					continue
+				}
+			}
+
+			exec := domain.PMOCExecucao{
+				ID:            uuid.New().String(),
+				PlanoID:       plano.ID,
+				AtividadeID:   a.ID,
+				EquipamentoID: eqID,
+				SolicitacaoID: &reqID,
+				ExecutadoEm:   now,
+				TecnicoID:     tecnicoID,
+				TecnicoNome:   tecnicoNome,
+				Observacao:    fmt.Sprintf("Registrado pelo chamado #%d", req.Numero),
+			}
+			if err := s.db.Create(&exec).Error; err != nil {
+				return recorded, err
+			}
+			recorded++
+		}
+	}
+
+	if recorded > 0 {
+		s.hub.Broadcast(plano.CompanyID, "pmoc:executed", map[string]interface{}{
+			"planoId":       plano.ID,
+			"solicitacaoId": req.ID,
+			"count":         recorded,
+		})
+	}
+
+	return recorded, nil
+}
+
+func normalizeActivity(s string) string {
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// PMOCScheduleLine is the situation of one activity on one unit in the report period
+type PMOCScheduleLine struct {
+	Equipamento    domain.Equipamento    `json:"equipamento"`
+	Atividade      domain.PMOCAtividade  `json:"atividade"`
+	Execucoes      []domain.PMOCExecucao `json:"execucoes"`
+	UltimaExecucao *time.Time            `json:"ultimaExecucao,omitempty"`
+	ProximaData    time.Time             `json:"proximaData"`
+	Pendente       bool                  `json:"pendente"`
+}
+
+// PMOCReport is the full PMOC document of a period
+type PMOCReport struct {
+	Plano        domain.PMOCPlano      `json:"plano"`
+	Cliente      domain.Cliente        `json:"cliente"`
+	Prestador    domain.Prestador      `json:"prestador"`
+	Equipamentos []domain.Equipamento  `json:"equipamentos"`
+	Linhas       []PMOCScheduleLine    `json:"linhas"`
+	Execucoes    []domain.PMOCExecucao `json:"execucoes"`
+	From         time.Time             `json:"from"`
+	To           time.Time             `json:"to"`
+	GeneratedAt  time.Time             `json:"generatedAt"`
+}
+
+// Pendentes counts the schedule lines overdue at the end of the period
+func (r *PMOCReport) Pendentes() int {
+	n := 0
+	for _, l := range r.Linhas {
+		if l.Pendente {
+			n++
+		}
+	}
+	return n
+}
+
+// Report assembles the PMOC document and execution log of a plan for [from, to]
+func (s *PMOCService) Report(planoID string, from, to time.Time) (*PMOCReport, error) {
+	var plano domain.PMOCPlano
+	if err := s.db.Preload("Atividades").First(&plano, "id = ?", planoID).Error; err != nil {
+		return nil, err
+	}
+
+	report := &PMOCReport{Plano: plano, From: from, To: to, GeneratedAt: time.Now()}
+
+	if err := s.db.Preload("Endereco").First(&report.Cliente, "id = ?", plano.ClientID).Error; err != nil {
+		return nil, err
+	}
+	s.db.Preload("Endereco").First(&report.Prestador, "id = ?", plano.CompanyID)
+
+	s.db.Where("client_id = ? AND active = ?", plano.ClientID, true).Order("location").Find(&report.Equipamentos)
+
+	s.db.Preload("Atividade").Preload("Equipamento").
+		Where("plano_id = ? AND executado_em BETWEEN ? AND ?", plano.ID, from, to).
+		Order("executado_em").Find(&report.Execucoes)
+
+	// Reference date for pending status: end of period, or now for an ongoing period
+	ref := to
+	if now := time.Now(); now.Before(ref) {
+		ref = now
+	}
+
+	for _, eq := range report.Equipamentos {
+		for _, a := range plano.Atividades {
+			if !appliesTo(&a, eq.ID) {
+				continue
+			}
+			line := PMOCScheduleLine{Equipamento: eq, Atividade: a, Execucoes: []domain.PMOCExecucao{}}
+			for _, exec := range report.Execucoes {
+				if exec.AtividadeID == a.ID && exec.EquipamentoID == eq.ID {
+					line.Execucoes = append(line.Execucoes, exec)
+				}
+			}
+
+			last := s.lastExecution(a.ID, eq.ID, to)
+			if last != nil {
+				line.UltimaExecucao = &last.ExecutadoEm
+			}
+			line.ProximaData = NextDue(&a, last, plano.VigenciaInicio)
+			line.Pendente = line.ProximaData.Before(ref)
+			report.Linhas = append(report.Linhas, line)
+		}
+	}
+
+	sort.SliceStable(report.Linhas, func(i, j int) bool {
+		if report.Linhas[i].Equipamento.ID != report.Linhas[j].Equipamento.ID {
+			return report.Linhas[i].Equipamento.Location < report.Linhas[j].Equipamento.Location
+		}
+		return domain.PMOCFrequencyMonths[report.Linhas[i].Atividade.Frequencia] < domain.PMOCFrequencyMonths[report.Linhas[j].Atividade.Frequencia]
+	})
+
+	return report, nil
+}
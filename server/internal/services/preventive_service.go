@@ -0,0 +1,320 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+	"inovar/internal/websocket"
+)
+
+// PreventiveItem is a unit due for preventive maintenance
+type PreventiveItem struct {
+	EquipamentoID string    `json:"equipamentoId"`
+	Brand         string    `json:"brand"`
+	Model         string    `json:"model"`
+	Location      string    `json:"location"`
+	DueDate       time.Time `json:"dueDate"`
+	Overdue       bool      `json:"overdue"`
+}
+
+// PreventivePlan groups the due units of a client site into one work order
+type PreventivePlan struct {
+	ClientID    string           `json:"clientId"`
+	ClientName  string           `json:"clientName"`
+	CompanyID   string           `json:"companyId"`
+	SiteID      string           `json:"siteId,omitempty"`
+	SiteAddress string           `json:"siteAddress,omitempty"`
+	DueDate     time.Time        `json:"dueDate"` // earliest due date of the group
+	Equipments  []PreventiveItem `json:"equipments"`
+}
+
+// PreventiveService generates preventive work orders from equipment schedules
+type PreventiveService struct {
+	db       *gorm.DB
+	hub      *websocket.Hub
+	sla      *SLAService
+	interval time.Duration
+}
+
+// NewPreventiveService creates a new preventive maintenance scheduler
+func NewPreventiveService(db *gorm.DB, hub *websocket.Hub, sla *SLAService, cfg *config.Config) *PreventiveService {
+	return &PreventiveService{
+		db:       db,
+		hub:      hub,
+		sla:      sla,
+		interval: time.Duration(cfg.PreventiveCheckIntervalHours) * time.Hour,
+	}
+}
+
+// HorizonDays returns how many days ahead the scheduler looks for due units of the company
+func (s *PreventiveService) HorizonDays(companyID string) int {
+	return getSettingInt(s.db, companyID, "preventive_horizon_days", 15)
+}
+
+// NextDate returns when the next preventive is due after `from` for an equipment.
+// Equipment intervals are in months; the default of the company is in days.
+func (s *PreventiveService) NextDate(eq *domain.Equipamento, from time.Time) time.Time {
+	if eq.PreventiveInterval > 0 {
+		return from.AddDate(0, eq.PreventiveInterval, 0)
+	}
+	return from.AddDate(0, 0, getSettingInt(s.db, eq.CompanyID, "preventive_interval", 90))
+}
+
+// dueDate returns the scheduled date of the next preventive of an equipment
+func (s *PreventiveService) dueDate(eq *domain.Equipamento) time.Time {
+	if eq.NextPreventiveDate != nil {
+		return *eq.NextPreventiveDate
+	}
+	base := eq.CreatedAt
+	if eq.LastPreventiveDate != nil {
+		base = *eq.LastPreventiveDate
+	}
+	return s.NextDate(eq, base)
+}
+
+// Plan lists the work orders that would be generated. An empty companyID plans for all companies;
+// without a horizon each company looks as far ahead as its settings say.
+func (s *PreventiveService) Plan(companyID string, horizonDays int) ([]PreventivePlan, error) {
+	now := time.Now()
+	limits := map[string]time.Time{}
+	limit := func(companyID string) time.Time {
+		if horizonDays > 0 {
+			return now.AddDate(0, 0, horizonDays)
+		}
+		if _, ok := limits[companyID]; !ok {
+			limits[companyID] = now.AddDate(0, 0, s.HorizonDays(companyID))
+		}
+		return limits[companyID]
+	}
+
+	query := s.db.Preload("Client.Endereco").Preload("Endereco").Where("active = ?", true)
+	if companyID != "" {
+		query = query.Where("company_id = ?", companyID)
+	}
+
+	// Units already in an open preventive request are not planned again
+	closedStatuses := []string{domain.StatusConcluida, domain.StatusCancelada}
+	query = query.Where(`id NOT IN (
+		SELECT se.equipamento_id FROM solicitacao_equipamentos se
+		JOIN solicitacoes s ON s.id = se.solicitacao_id
+		WHERE s.service_type = ? AND s.status NOT IN ? AND s.deleted_at IS NULL)`,
+		domain.ServiceTypePreventiva, closedStatuses)
+
+	var equipments []domain.Equipamento
+	if err := query.Find(&equipments).Error; err != nil {
+		return nil, err
+	}
+
+	groups := map[string]*PreventivePlan{}
+	for i := range equipments {
+		eq := &equipments[i]
+		due := s.dueDate(eq)
+		if due.After(limit(eq.CompanyID)) {
+			continue
+		}
+
+		// Units without a site of their own are serviced at the address of the client
+		site := eq.Endereco
+		if site == nil {
+			site = eq.Client.Endereco
+		}
+		siteID := ""
+		if site != nil {
+			siteID = site.ID
+		}
+
+		key := eq.ClientID + "|" + siteID
+		plan, ok := groups[key]
+		if !ok {
+			plan = &PreventivePlan{
+				ClientID:    eq.ClientID,
+				ClientName:  eq.Client.Name,
+				CompanyID:   eq.CompanyID,
+				SiteID:      siteID,
+				SiteAddress: buildEndereco(site),
+				DueDate:     due,
+			}
+			groups[key] = plan
+		}
+		if due.Before(plan.DueDate) {
+			plan.DueDate = due
+		}
+		plan.Equipments = append(plan.Equipments, PreventiveItem{
+			EquipamentoID: eq.ID,
+			Brand:         eq.Brand,
+			Model:         eq.Model,
+			Location:      eq.Location,
+			DueDate:       due,
+			Overdue:       due.Before(now),
+		})
+	}
+
+	plans := make([]PreventivePlan, 0, len(groups))
+	for _, p := range groups {
+		plans = append(plans, *p)
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].DueDate.Before(plans[j].DueDate) })
+
+	return plans, nil
+}
+
+// Generate opens one PREVENTIVA request per planned client site, with the standard checklist per unit
+func (s *PreventiveService) Generate(companyID string, horizonDays int) ([]domain.Solicitacao, error) {
+	plans, err := s.Plan(companyID, horizonDays)
+	if err != nil {
+		return nil, err
+	}
+
+	created := []domain.Solicitacao{}
+	for _, plan := range plans {
+		req, err := s.createRequest(plan)
+		if err != nil {
+			log.Printf("⚠️ Falha ao gerar preventiva para %s: %v", plan.ClientName, err)
+			continue
+		}
+		created = append(created, *req)
+		s.hub.Broadcast(req.CompanyID, "request:created", req)
+	}
+
+	return created, nil
+}
+
+func (s *PreventiveService) createRequest(plan PreventivePlan) (*domain.Solicitacao, error) {
+	now := time.Now()
+
+	units := make([]string, 0, len(plan.Equipments))
+	for _, item := range plan.Equipments {
+		units = append(units, fmt.Sprintf("- %s %s (%s) - prevista para %s", item.Brand, item.Model, item.Location, item.DueDate.Format("02/01/2006")))
+	}
+
+	description := fmt.Sprintf("Manutenção preventiva programada - %d equipamento(s):\n%s", len(plan.Equipments), strings.Join(units, "\n"))
+	if plan.SiteAddress != "" {
+		description = fmt.Sprintf("Local: %s\n%s", plan.SiteAddress, description)
+	}
+
+	req := domain.Solicitacao{
+		ID:          uuid.New().String(),
+		ClientID:    plan.ClientID,
+		ClientName:  plan.ClientName,
+		CompanyID:   plan.CompanyID,
+		Status:      domain.StatusAberta,
+		Priority:    domain.PriorityBaixa,
+		ServiceType: domain.ServiceTypePreventiva,
+		Description: description,
+		CreatedAt:   now,
+	}
+	if plan.DueDate.After(now) {
+		due := plan.DueDate
+		req.ScheduledAt = &due
+	}
+	s.sla.Apply(&req)
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := CreateNumberedRequest(tx, &req); err != nil {
+			return err
+		}
+
+		for _, item := range plan.Equipments {
+			eqID := item.EquipamentoID
+			if err := tx.Create(&domain.SolicitacaoEquipamento{
+				ID:            uuid.New().String(),
+				SolicitacaoID: req.ID,
+				EquipamentoID: eqID,
+			}).Error; err != nil {
+				return err
+			}
+			for _, desc := range domain.ChecklistPreventivaPadrao {
+				if err := tx.Create(&domain.Checklist{
+					ID:            uuid.New().String(),
+					SolicitacaoID: req.ID,
+					EquipamentoID: &eqID,
+					Description:   desc,
+				}).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Create(&domain.SolicitacaoHistorico{
+			ID:            uuid.New().String(),
+			SolicitacaoID: req.ID,
+			UserID:        SystemUserID,
+			UserName:      "Sistema",
+			Action:        "Chamado criado",
+			Details:       "Preventiva gerada automaticamente pelo agendador",
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+// Complete rolls the preventive dates of the request's units forward.
+// When next is nil the next date is computed from each unit's interval.
+func (s *PreventiveService) Complete(requestID string, doneAt time.Time, next *time.Time) error {
+	var links []domain.SolicitacaoEquipamento
+	if err := s.db.Preload("Equipamento").Where("solicitacao_id = ?", requestID).Find(&links).Error; err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		eq := link.Equipamento
+		if eq.ID == "" {
+			continue
+		}
+		last := doneAt
+		nextDate := s.NextDate(&eq, doneAt)
+		if next != nil {
+			nextDate = *next
+		}
+		eq.LastPreventiveDate = &last
+		eq.NextPreventiveDate = &nextDate
+		if err := s.db.Model(&eq).Updates(map[string]interface{}{
+			"last_preventive_date": last,
+			"next_preventive_date": nextDate,
+		}).Error; err != nil {
+			return err
+		}
+		s.hub.Broadcast(eq.CompanyID, "equipment:updated", eq)
+	}
+
+	return nil
+}
+
+// Run generates due preventives periodically for the companies that enable it in their settings. It blocks.
+func (s *PreventiveService) Run() {
+	if s.interval <= 0 {
+		s.interval = 24 * time.Hour
+	}
+	log.Printf("🗓️ Agendador de preventivas iniciado (intervalo %s)", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		var companies []string
+		s.db.Model(&domain.Prestador{}).Pluck("id", &companies)
+		for _, companyID := range companies {
+			if getSetting(s.db, companyID, "preventive_auto_generate") == "false" {
+				continue
+			}
+			created, err := s.Generate(companyID, 0)
+			if err != nil {
+				log.Printf("⚠️ Falha ao gerar preventivas da empresa %s: %v", companyID, err)
+			} else if len(created) > 0 {
+				log.Printf("🗓️ %d chamado(s) de preventiva gerado(s) para a empresa %s", len(created), companyID)
+			}
+		}
+		<-ticker.C
+	}
+}
@@ -0,0 +1,312 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+)
+
+// Revenue ceiling alerts
+const (
+	AlertaTetoMEIProximo       = "MEI_PROXIMO"
+	AlertaTetoMEIExcedido      = "MEI_EXCEDIDO"
+	AlertaTetoSimplesSublimite = "SIMPLES_SUBLIMITE"
+	AlertaTetoSimplesProximo   = "SIMPLES_PROXIMO"
+	AlertaTetoSimplesExcedido  = "SIMPLES_EXCEDIDO"
+)
+
+// Share of a ceiling from which the company is warned, in %
+const alertaTetoPercentual = 80.0
+
+// ApuracaoRBT12 is the revenue of a provider in the 12 months before a competência
+type ApuracaoRBT12 struct {
+	ConfiguracaoID string    `json:"configuracaoId"`
+	PrestadorID    string    `json:"prestadorId"`
+	Competencia    string    `json:"competencia"` // YYYY-MM
+	PeriodoInicio  time.Time `json:"periodoInicio"`
+	PeriodoFim     time.Time `json:"periodoFim"` // exclusive
+	NotasEmitidas  int64     `json:"notasEmitidas"`
+	ReceitaSistema float64   `json:"receitaSistema"` // issued notes of the window
+	// Months of the window before the system history, counted from the monthly revenue informed by
+	// the company or, when missing, from the average of the stored RBT12
+	MesesForaSistema   int     `json:"mesesForaSistema,omitempty"`
+	MesesEstimados     int     `json:"mesesEstimados,omitempty"`
+	ReceitaForaSistema float64 `json:"receitaForaSistema,omitempty"`
+	RBT12              float64 `json:"rbt12"`
+	RBT12Anterior      float64 `json:"rbt12Anterior"`
+	Faixa              string  `json:"faixa,omitempty"`
+	FaixaAnterior      string  `json:"faixaAnterior,omitempty"`
+	Aliquota           float64 `json:"aliquota,omitempty"` // effective Simples rate
+	AliquotaAnterior   float64 `json:"aliquotaAnterior,omitempty"`
+	Alerta             string  `json:"alerta,omitempty"`
+	Mensagem           string  `json:"mensagem,omitempty"`
+}
+
+// Resumo describes the apuração for the audit log
+func (a *ApuracaoRBT12) Resumo() string {
+	resumo := fmt.Sprintf("RBT12 %s: R$ %.2f → R$ %.2f (%d NFS-e)", a.Competencia, a.RBT12Anterior, a.RBT12, a.NotasEmitidas)
+	if a.MesesForaSistema > 0 {
+		resumo += fmt.Sprintf(" | R$ %.2f de %d mês(es) anteriores ao sistema", a.ReceitaForaSistema, a.MesesForaSistema)
+		if a.MesesEstimados > 0 {
+			resumo += fmt.Sprintf(", %d estimado(s) pelo RBT12 anterior", a.MesesEstimados)
+		}
+	}
+	if a.Faixa != a.FaixaAnterior {
+		resumo += fmt.Sprintf(" | Faixa %s → %s", a.FaixaAnterior, a.Faixa)
+	}
+	if a.Aliquota != a.AliquotaAnterior {
+		resumo += fmt.Sprintf(" | Alíquota efetiva %.4f%% → %.4f%%", a.AliquotaAnterior, a.Aliquota)
+	}
+	return resumo
+}
+
+// Valores returns the fiscal values before and after the apuração
+func (a *ApuracaoRBT12) Valores() (before, after map[string]interface{}) {
+	before = map[string]interface{}{"receitaBruta12Meses": a.RBT12Anterior, "faixaSimplesNac": a.FaixaAnterior, "aliquotaSimplesNac": a.AliquotaAnterior}
+	after = map[string]interface{}{"receitaBruta12Meses": a.RBT12, "faixaSimplesNac": a.Faixa, "aliquotaSimplesNac": a.Aliquota}
+	return before, after
+}
+
+// ReceitaBrutaService keeps the RBT12, Simples bracket and effective rate of each provider
+// current from the NFS-e it issued
+type ReceitaBrutaService struct {
+	db            *gorm.DB
+	notifications *NotificationService
+	interval      time.Duration
+}
+
+// NewReceitaBrutaService creates a new RBT12 apuração service
+func NewReceitaBrutaService(db *gorm.DB, notifications *NotificationService, cfg *config.Config) *ReceitaBrutaService {
+	return &ReceitaBrutaService{
+		db:            db,
+		notifications: notifications,
+		interval:      time.Duration(cfg.RBT12CheckIntervalHours) * time.Hour,
+	}
+}
+
+// Calcular sums the revenue of the 12 months before the competência without storing anything.
+// Only the months the system has history for are taken from the issued notes; the earlier ones
+// come from the monthly revenue informed by the company, or from the stored RBT12 when absent.
+func (s *ReceitaBrutaService) Calcular(fiscal *domain.ConfiguracaoFiscal, competencia time.Time) (*ApuracaoRBT12, error) {
+	fim := time.Date(competencia.Year(), competencia.Month(), 1, 0, 0, 0, 0, competencia.Location())
+	a := &ApuracaoRBT12{
+		ConfiguracaoID:   fiscal.ID,
+		PrestadorID:      fiscal.PrestadorID,
+		Competencia:      fim.Format("2006-01"),
+		PeriodoInicio:    fim.AddDate(-1, 0, 0),
+		PeriodoFim:       fim,
+		RBT12Anterior:    fiscal.ReceitaBruta12Meses,
+		FaixaAnterior:    fiscal.FaixaSimplesNac,
+		AliquotaAnterior: fiscal.AliquotaSimplesNac,
+	}
+
+	var soma struct {
+		Notas int64
+		Total float64
+	}
+	err := s.db.Model(&domain.NotaFiscal{}).
+		Select("COUNT(*) AS notas, COALESCE(SUM(valor_servicos), 0) AS total").
+		Where("prestador_id = ? AND status = ? AND data_competencia >= ? AND data_competencia < ?",
+			fiscal.PrestadorID, domain.NFSeStatusEmitida, a.PeriodoInicio, a.PeriodoFim).
+		Scan(&soma).Error
+	if err != nil {
+		return nil, err
+	}
+	a.NotasEmitidas = soma.Notas
+	a.ReceitaSistema = round2(soma.Total)
+
+	if err := s.receitaForaSistema(fiscal, a); err != nil {
+		return nil, err
+	}
+	a.RBT12 = round2(a.ReceitaSistema + a.ReceitaForaSistema)
+
+	regime := regimeOf(fiscal)
+	a.Faixa, a.Aliquota = a.FaixaAnterior, a.AliquotaAnterior
+	if regime == domain.RegimeSimplesNac {
+		a.Faixa, a.Aliquota = "", 0
+		if faixa, err := faixaAnexoIII(a.RBT12); err == nil {
+			a.Faixa = faixa.Faixa
+			a.Aliquota = faixa.Aliquota
+			if a.RBT12 > 0 {
+				a.Aliquota = round4(faixa.efetiva(a.RBT12))
+			}
+		}
+	}
+	a.Alerta, a.Mensagem = alertaTeto(regime, a.RBT12)
+	return a, nil
+}
+
+// inicioHistorico returns the first month the system holds the revenue of the provider: the month
+// its fiscal configuration was created, or of an earlier note (e.g. imported)
+func (s *ReceitaBrutaService) inicioHistorico(fiscal *domain.ConfiguracaoFiscal) time.Time {
+	inicio := fiscal.CreatedAt
+
+	// Plain column rather than MIN() so SQLite keeps the datetime type
+	var primeira []time.Time
+	s.db.Model(&domain.NotaFiscal{}).
+		Where("prestador_id = ? AND status = ?", fiscal.PrestadorID, domain.NFSeStatusEmitida).
+		Order("data_competencia").Limit(1).Pluck("data_competencia", &primeira)
+	if len(primeira) > 0 && (inicio.IsZero() || primeira[0].Before(inicio)) {
+		inicio = primeira[0]
+	}
+	if inicio.IsZero() {
+		return inicio
+	}
+	return time.Date(inicio.Year(), inicio.Month(), 1, 0, 0, 0, 0, inicio.Location())
+}
+
+// receitaForaSistema adds the revenue of the months of the window before the system history
+func (s *ReceitaBrutaService) receitaForaSistema(fiscal *domain.ConfiguracaoFiscal, a *ApuracaoRBT12) error {
+	inicio := s.inicioHistorico(fiscal)
+	if !inicio.IsZero() && !inicio.After(a.PeriodoInicio) {
+		return nil
+	}
+
+	var informadas []domain.ReceitaMensal
+	if err := s.db.Where("prestador_id = ?", fiscal.PrestadorID).Find(&informadas).Error; err != nil {
+		return err
+	}
+	porMes := make(map[string]float64, len(informadas))
+	for _, r := range informadas {
+		porMes[r.Competencia] = r.Valor
+	}
+
+	// Without an informed value the month is estimated from the RBT12 stored before
+	media := fiscal.ReceitaBruta12Meses / 12
+	for mes := a.PeriodoInicio; mes.Before(a.PeriodoFim) && (inicio.IsZero() || mes.Before(inicio)); mes = mes.AddDate(0, 1, 0) {
+		a.MesesForaSistema++
+		if valor, ok := porMes[mes.Format("2006-01")]; ok {
+			a.ReceitaForaSistema += valor
+			continue
+		}
+		a.MesesEstimados++
+		a.ReceitaForaSistema += media
+	}
+	a.ReceitaForaSistema = round2(a.ReceitaForaSistema)
+	return nil
+}
+
+// Apurar recalculates and stores the RBT12 of a provider for a competência, warning its admins
+// when it reaches a new revenue ceiling
+func (s *ReceitaBrutaService) Apurar(prestadorID string, competencia time.Time) (*ApuracaoRBT12, error) {
+	var fiscal domain.ConfiguracaoFiscal
+	if err := s.db.Where("prestador_id = ?", prestadorID).First(&fiscal).Error; err != nil {
+		return nil, errors.New("configuração fiscal não encontrada")
+	}
+
+	a, err := s.Calcular(&fiscal, competencia)
+	if err != nil {
+		return nil, err
+	}
+
+	alertaNovo := a.Alerta != "" && a.Alerta != fiscal.AlertaTetoReceita
+	err = s.db.Model(&fiscal).Updates(map[string]interface{}{
+		"receita_bruta12_meses": a.RBT12,
+		"faixa_simples_nac":     a.Faixa,
+		"aliquota_simples_nac":  a.Aliquota,
+		"rbt12_competencia":     a.Competencia,
+		"alerta_teto_receita":   a.Alerta,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if alertaNovo {
+		notifType := "WARNING"
+		if a.Alerta == AlertaTetoMEIExcedido || a.Alerta == AlertaTetoSimplesExcedido {
+			notifType = "ERROR"
+		}
+		s.notifications.NotifyCompanyAdmins(prestadorID, "Limite de faturamento", a.Mensagem, notifType, "/admin/fiscal")
+	}
+	return a, nil
+}
+
+// ApurarTodos runs the apuração of every provider not yet apurado in the competência
+func (s *ReceitaBrutaService) ApurarTodos(competencia time.Time) int {
+	ref := competencia.Format("2006-01")
+	var configs []domain.ConfiguracaoFiscal
+	if err := s.db.Where("rbt12_competencia IS NULL OR rbt12_competencia <> ?", ref).Find(&configs).Error; err != nil {
+		log.Printf("⚠️ Erro ao listar configurações fiscais para o RBT12: %v", err)
+		return 0
+	}
+
+	count := 0
+	for _, fiscal := range configs {
+		a, err := s.Apurar(fiscal.PrestadorID, competencia)
+		if err != nil {
+			log.Printf("⚠️ Falha na apuração do RBT12 do prestador %s: %v", fiscal.PrestadorID, err)
+			continue
+		}
+		s.auditar(a)
+		count++
+	}
+	return count
+}
+
+// auditar records an automatic apuração in the audit log
+func (s *ReceitaBrutaService) auditar(a *ApuracaoRBT12) {
+	before, after := a.Valores()
+	b, _ := json.Marshal(before)
+	af, _ := json.Marshal(after)
+	s.db.Create(&domain.AuditLog{
+		ID:          uuid.New().String(),
+		UserID:      SystemUserID,
+		UserName:    "Sistema",
+		Entity:      "ConfiguracaoFiscal",
+		EntityID:    a.ConfiguracaoID,
+		Action:      "RBT12",
+		Details:     a.Resumo(),
+		BeforeValue: string(b),
+		AfterValue:  string(af),
+	})
+}
+
+// Run recalculates the RBT12 once per competência. It blocks.
+func (s *ReceitaBrutaService) Run() {
+	if s.interval <= 0 {
+		s.interval = 6 * time.Hour
+	}
+	log.Printf("📈 Apuração mensal do RBT12 iniciada (verificação a cada %s)", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if n := s.ApurarTodos(time.Now()); n > 0 {
+			log.Printf("📈 RBT12 apurado para %d prestador(es)", n)
+		}
+		<-ticker.C
+	}
+}
+
+// alertaTeto returns the revenue ceiling reached by a RBT12 in the regime, if any
+func alertaTeto(regime string, rbt12 float64) (string, string) {
+	switch regime {
+	case domain.RegimeMEI:
+		if rbt12 > LimiteMEI {
+			return AlertaTetoMEIExcedido, fmt.Sprintf("A receita bruta dos últimos 12 meses (R$ %.2f) ultrapassou o limite do MEI de R$ %.2f. Com excesso de até 20%% o desenquadramento vale a partir do ano seguinte; acima disso, retroage a janeiro.", rbt12, LimiteMEI)
+		}
+		if rbt12 >= LimiteMEI*alertaTetoPercentual/100 {
+			return AlertaTetoMEIProximo, fmt.Sprintf("A receita bruta dos últimos 12 meses (R$ %.2f) atingiu %.0f%% do limite do MEI de R$ %.2f.", rbt12, rbt12/LimiteMEI*100, LimiteMEI)
+		}
+	case domain.RegimeSimplesNac:
+		if rbt12 > LimiteSimplesNac {
+			return AlertaTetoSimplesExcedido, fmt.Sprintf("A receita bruta dos últimos 12 meses (R$ %.2f) ultrapassou o limite do Simples Nacional de R$ %.2f. A empresa deve comunicar a exclusão do regime.", rbt12, LimiteSimplesNac)
+		}
+		if rbt12 >= LimiteSimplesNac*alertaTetoPercentual/100 {
+			return AlertaTetoSimplesProximo, fmt.Sprintf("A receita bruta dos últimos 12 meses (R$ %.2f) atingiu %.0f%% do limite do Simples Nacional de R$ %.2f.", rbt12, rbt12/LimiteSimplesNac*100, LimiteSimplesNac)
+		}
+		if rbt12 > SublimiteSimplesNac {
+			return AlertaTetoSimplesSublimite, fmt.Sprintf("A receita bruta dos últimos 12 meses (R$ %.2f) ultrapassou o sublimite de R$ %.2f: o ISS passa a ser recolhido fora do DAS.", rbt12, SublimiteSimplesNac)
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+// newTestDB opens an in-memory database with the tables of the given models
+func newTestDB(t *testing.T, models ...interface{}) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: is a database of its own
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(models...); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func emitirNotaTeste(t *testing.T, db *gorm.DB, prestadorID string, competencia time.Time, valor float64) {
+	t.Helper()
+	err := db.Create(&domain.NotaFiscal{
+		ID:               uuid.New().String(),
+		SolicitacaoID:    uuid.New().String(),
+		PrestadorID:      prestadorID,
+		TomadorNome:      "Cliente Teste",
+		TomadorDocumento: "12345678909",
+		Discriminacao:    "Manutenção",
+		ValorServicos:    valor,
+		Status:           domain.NFSeStatusEmitida,
+		DataCompetencia:  competencia,
+	}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCalcularRBT12(t *testing.T) {
+	competencia := time.Date(2026, 7, 1, 0, 0, 0, 0, time.Local)
+	mes := func(m time.Month) time.Time { return time.Date(2026, m, 10, 0, 0, 0, 0, time.Local) }
+
+	tests := []struct {
+		name       string
+		criadaEm   time.Time
+		informadas map[string]float64
+		rbt12      float64
+		foraSist   int
+		estimados  int
+	}{
+		// Configured before the window: the notes are the whole revenue
+		{"full history", time.Date(2025, 1, 5, 0, 0, 0, 0, time.Local), nil, 3000, 0, 0},
+		// Configured in April 2026: July 2025 to March 2026 come from the stored RBT12 (120000 / 12)
+		{"estimated from stored RBT12", mes(4), nil, 9*10000 + 3000, 9, 9},
+		{"informed months", mes(4), map[string]float64{"2025-07": 4000, "2026-03": 6000}, 7*10000 + 10000 + 3000, 9, 7},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, &domain.NotaFiscal{}, &domain.ConfiguracaoFiscal{}, &domain.ReceitaMensal{})
+			prestadorID := uuid.New().String()
+			fiscal := &domain.ConfiguracaoFiscal{
+				ID:                  uuid.New().String(),
+				PrestadorID:         prestadorID,
+				RegimeTributario:    domain.RegimeSimplesNac,
+				ReceitaBruta12Meses: 120000,
+				CreatedAt:           tt.criadaEm,
+			}
+			if err := db.Create(fiscal).Error; err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range []time.Month{4, 5, 6} {
+				emitirNotaTeste(t, db, prestadorID, mes(m), 1000)
+			}
+			// Outside the window
+			emitirNotaTeste(t, db, prestadorID, mes(7), 5000)
+			for ref, valor := range tt.informadas {
+				db.Create(&domain.ReceitaMensal{ID: uuid.New().String(), PrestadorID: prestadorID, Competencia: ref, Valor: valor})
+			}
+
+			a, err := (&ReceitaBrutaService{db: db}).Calcular(fiscal, competencia)
+			if err != nil {
+				t.Fatalf("Calcular: %v", err)
+			}
+			if a.ReceitaSistema != 3000 || a.NotasEmitidas != 3 {
+				t.Errorf("receita do sistema %v em %d notas, want 3000 em 3", a.ReceitaSistema, a.NotasEmitidas)
+			}
+			if a.RBT12 != tt.rbt12 {
+				t.Errorf("RBT12 = %v, want %v", a.RBT12, tt.rbt12)
+			}
+			if a.MesesForaSistema != tt.foraSist || a.MesesEstimados != tt.estimados {
+				t.Errorf("%d meses fora do sistema, %d estimados; want %d, %d", a.MesesForaSistema, a.MesesEstimados, tt.foraSist, tt.estimados)
+			}
+		})
+	}
+}
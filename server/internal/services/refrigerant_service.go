@@ -0,0 +1,193 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/websocket"
+)
+
+// RefrigerantService keeps the refrigerant ledger of the units
+type RefrigerantService struct {
+	db            *gorm.DB
+	hub           *websocket.Hub
+	notifications *NotificationService
+}
+
+// NewRefrigerantService creates a new refrigerant ledger service
+func NewRefrigerantService(db *gorm.DB, hub *websocket.Hub, notifications *NotificationService) *RefrigerantService {
+	return &RefrigerantService{db: db, hub: hub, notifications: notifications}
+}
+
+// LeakRatePoint is the leak estimate derived from one recharge
+type LeakRatePoint struct {
+	MovimentacaoID  string    `json:"movimentacaoId"`
+	Data            time.Time `json:"data"`
+	QuantidadeKg    float64   `json:"quantidadeKg"`
+	IntervaloDias   int       `json:"intervaloDias"`             // since the previous charge or recovery
+	PercentualCarga *float64  `json:"percentualCarga,omitempty"` // kg added / nominal charge
+	TaxaAnual       *float64  `json:"taxaAnual,omitempty"`       // annualized leak rate (%)
+}
+
+// GasReportLine is the yearly consumption of one refrigerant
+type GasReportLine struct {
+	GasType       string  `json:"gasType"`
+	CargaKg       float64 `json:"cargaKg"`
+	RecolhidoKg   float64 `json:"recolhidoKg"`
+	Movimentacoes int     `json:"movimentacoes"`
+	Equipamentos  int     `json:"equipamentos"`
+	GWP           float64 `json:"gwp"`
+	EmissoesTCO2e float64 `json:"emissoesTco2e"` // charged gas replaces leaked gas
+}
+
+// Record stores a ledger entry and checks for repeated recharges
+func (s *RefrigerantService) Record(mov *domain.MovimentacaoGas) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(mov).Error; err != nil {
+			return err
+		}
+		// Units without a registered refrigerant learn it from their first movement
+		return tx.Model(&domain.Equipamento{}).
+			Where("id = ? AND (gas_type IS NULL OR gas_type = '')", mov.EquipamentoID).
+			Update("gas_type", mov.GasType).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.hub.Broadcast(mov.CompanyID, "equipment:gas_recorded", mov)
+
+	if mov.Tipo == domain.GasCarga {
+		s.checkRepeatedRecharge(mov)
+	}
+	return nil
+}
+
+// checkRepeatedRecharge alerts when a unit was recharged too often within the configured window
+func (s *RefrigerantService) checkRepeatedRecharge(mov *domain.MovimentacaoGas) {
+	windowDays := getSettingInt(s.db, mov.CompanyID, "gas_recharge_window_days", 180)
+	limit := getSettingInt(s.db, mov.CompanyID, "gas_recharge_alert_count", 2)
+
+	since := mov.RealizadoEm.AddDate(0, 0, -windowDays)
+	var count int64
+	s.db.Model(&domain.MovimentacaoGas{}).
+		Where("equipamento_id = ? AND tipo = ? AND realizado_em BETWEEN ? AND ?", mov.EquipamentoID, domain.GasCarga, since, mov.RealizadoEm).
+		Count(&count)
+	if int(count) < limit {
+		return
+	}
+
+	var eq domain.Equipamento
+	if err := s.db.Preload("Client").First(&eq, "id = ?", mov.EquipamentoID).Error; err != nil {
+		return
+	}
+
+	s.hub.Broadcast(eq.CompanyID, "equipment:gas_alert", map[string]interface{}{
+		"equipamentoId": eq.ID,
+		"recargas":      count,
+		"janelaDias":    windowDays,
+	})
+
+	message := fmt.Sprintf("%s %s (%s - %s) recebeu %d recargas de %s nos últimos %d dias. Verifique possível vazamento.",
+		eq.Brand, eq.Model, eq.Client.Name, eq.Location, count, mov.GasType, windowDays)
+	s.notifications.NotifyCompanyAdmins(eq.CompanyID, "Recargas de gás repetidas", message, "WARNING",
+		fmt.Sprintf("/equipments/%s", eq.ID), mov.TecnicoID)
+}
+
+// LeakHistory estimates the leak rate of each recharge of a unit
+func (s *RefrigerantService) LeakHistory(eq *domain.Equipamento) ([]LeakRatePoint, error) {
+	var movs []domain.MovimentacaoGas
+	if err := s.db.Where("equipamento_id = ? AND tipo IN ?", eq.ID, []string{domain.GasCarga, domain.GasRecolhimento}).
+		Order("realizado_em").Find(&movs).Error; err != nil {
+		return nil, err
+	}
+
+	points := []LeakRatePoint{}
+	previous := eq.CreatedAt
+	for _, mov := range movs {
+		if mov.Tipo == domain.GasRecolhimento {
+			// After a recovery the unit is recharged from empty; restart the interval
+			previous = mov.RealizadoEm
+			continue
+		}
+
+		days := int(mov.RealizadoEm.Sub(previous).Hours() / 24)
+		if days < 0 {
+			days = 0 // backdated entry older than the unit's registration
+		}
+		point := LeakRatePoint{
+			MovimentacaoID: mov.ID,
+			Data:           mov.RealizadoEm,
+			QuantidadeKg:   mov.QuantidadeKg,
+			IntervaloDias:  days,
+		}
+		if eq.CargaNominalKg > 0 {
+			pct := round2(mov.QuantidadeKg / eq.CargaNominalKg * 100)
+			point.PercentualCarga = &pct
+			if days > 0 {
+				annual := round2(pct * 365 / float64(days))
+				point.TaxaAnual = &annual
+			}
+		}
+		points = append(points, point)
+		previous = mov.RealizadoEm
+	}
+
+	return points, nil
+}
+
+// AnnualReport sums the refrigerant handled in a year per gas. An empty companyID covers all companies.
+func (s *RefrigerantService) AnnualReport(companyID string, year int) ([]GasReportLine, error) {
+	from := time.Date(year, 1, 1, 0, 0, 0, 0, time.Local)
+	to := from.AddDate(1, 0, 0)
+
+	query := s.db.Where("realizado_em >= ? AND realizado_em < ?", from, to)
+	if companyID != "" {
+		query = query.Where("company_id = ?", companyID)
+	}
+
+	var movs []domain.MovimentacaoGas
+	if err := query.Find(&movs).Error; err != nil {
+		return nil, err
+	}
+
+	lines := map[string]*GasReportLine{}
+	units := map[string]map[string]bool{}
+	for _, mov := range movs {
+		line, ok := lines[mov.GasType]
+		if !ok {
+			line = &GasReportLine{GasType: mov.GasType, GWP: domain.GasGWP[mov.GasType]}
+			lines[mov.GasType] = line
+			units[mov.GasType] = map[string]bool{}
+		}
+		switch mov.Tipo {
+		case domain.GasCarga:
+			line.CargaKg += mov.QuantidadeKg
+		case domain.GasRecolhimento:
+			line.RecolhidoKg += mov.QuantidadeKg
+		}
+		line.Movimentacoes++
+		units[mov.GasType][mov.EquipamentoID] = true
+	}
+
+	report := make([]GasReportLine, 0, len(lines))
+	for gas, line := range lines {
+		line.Equipamentos = len(units[gas])
+		line.CargaKg = round2(line.CargaKg)
+		line.RecolhidoKg = round2(line.RecolhidoKg)
+		line.EmissoesTCO2e = round2(line.CargaKg * line.GWP / 1000)
+		report = append(report, *line)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].GasType < report[j].GasType })
+
+	return report, nil
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+// SystemUserID identifies actions performed by background jobs in history entries
+const SystemUserID = "SYSTEM"
+
+// How many times a request number is allocated again after losing it to a concurrent insert
+const requestNumberAttempts = 5
+
+// NextRequestNumber returns the next sequential request number of a company
+func NextRequestNumber(db *gorm.DB, companyID string) (int, error) {
+	var last int
+	err := db.Model(&domain.Solicitacao{}).Unscoped().Where("company_id = ?", companyID).
+		Select("COALESCE(MAX(numero), 1000)").Scan(&last).Error
+	if err != nil {
+		return 0, err
+	}
+	return last + 1, nil
+}
+
+// CreateNumberedRequest stores a new request under the next number of its company. The number
+// is read and used in one transaction, and taken again when a concurrent insert got it first.
+func CreateNumberedRequest(db *gorm.DB, req *domain.Solicitacao) error {
+	var err error
+	for attempt := 0; attempt < requestNumberAttempts; attempt++ {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			numero, err := NextRequestNumber(tx, req.CompanyID)
+			if err != nil {
+				return err
+			}
+			req.Numero = numero
+			return tx.Create(req).Error
+		})
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return err
+		}
+	}
+	return err
+}
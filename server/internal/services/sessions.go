@@ -0,0 +1,216 @@
+package services
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+)
+
+// How long the answer of Active is trusted before the session is read again
+const sessionCheckTTL = 30 * time.Second
+
+var (
+	// ErrRefreshInvalid is returned for unknown or revoked refresh tokens
+	ErrRefreshInvalid = errors.New("refresh token inválido")
+	// ErrRefreshExpired is returned for refresh tokens past their expiration
+	ErrRefreshExpired = errors.New("refresh token expirado")
+	// ErrRefreshReused is returned when a rotated token is presented again; the session is revoked
+	ErrRefreshReused = errors.New("refresh token reutilizado; sessão encerrada por segurança")
+)
+
+// Session is an active sign-in of a user, as listed to them
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	StartedAt  time.Time `json:"startedAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+type sessionState struct {
+	active    bool
+	checkedAt time.Time
+}
+
+// SessionService issues and rotates refresh tokens. The tokens of a sign-in form a family whose ID
+// is the session ID carried by the access tokens, so revoking the family also cuts off those.
+type SessionService struct {
+	db         *gorm.DB
+	expireDays int
+	mu         sync.Mutex
+	states     map[string]sessionState
+}
+
+// NewSessionService creates a new session service
+func NewSessionService(db *gorm.DB, cfg *config.Config) *SessionService {
+	return &SessionService{db: db, expireDays: cfg.RefreshExpireDays, states: map[string]sessionState{}}
+}
+
+// Start opens a session for the user and returns its first refresh token
+func (s *SessionService) Start(userID, userAgent, ip string) (string, *domain.RefreshToken, error) {
+	now := time.Now()
+	familyID := uuid.New().String()
+	token := randomHex(32)
+	rt := domain.RefreshToken{
+		ID:         familyID,
+		UserID:     userID,
+		FamilyID:   familyID,
+		Token:      hashToken(token),
+		UserAgent:  truncate(userAgent, 500),
+		IPAddress:  ip,
+		StartedAt:  now,
+		LastUsedAt: now,
+		ExpiresAt:  now.AddDate(0, 0, s.expireDays),
+	}
+	if err := s.db.Create(&rt).Error; err != nil {
+		return "", nil, err
+	}
+	return token, &rt, nil
+}
+
+// Rotate exchanges a refresh token for a new one of the same session. Presenting a token that was
+// already rotated means it leaked: the whole session is revoked.
+func (s *SessionService) Rotate(token, userAgent, ip string) (string, *domain.RefreshToken, error) {
+	var current domain.RefreshToken
+	if err := s.db.Where("token = ?", hashToken(token)).First(&current).Error; err != nil {
+		return "", nil, ErrRefreshInvalid
+	}
+	if current.Revoked {
+		if current.ReplacedByID != nil {
+			s.RevokeSession(current.UserID, current.FamilyID, domain.SessionRevokedReuse)
+			return "", nil, ErrRefreshReused
+		}
+		return "", nil, ErrRefreshInvalid
+	}
+	if current.ExpiresAt.Before(time.Now()) {
+		return "", nil, ErrRefreshExpired
+	}
+
+	now := time.Now()
+	next := randomHex(32)
+	rt := domain.RefreshToken{
+		ID:         uuid.New().String(),
+		UserID:     current.UserID,
+		FamilyID:   current.FamilyID,
+		Token:      hashToken(next),
+		UserAgent:  truncate(userAgent, 500),
+		IPAddress:  ip,
+		StartedAt:  current.StartedAt,
+		LastUsedAt: now,
+		ExpiresAt:  now.AddDate(0, 0, s.expireDays),
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Only one of two concurrent refreshes with the same token wins; the other counts as reuse
+		res := tx.Model(&domain.RefreshToken{}).Where("id = ? AND revoked = ?", current.ID, false).Updates(map[string]interface{}{
+			"revoked":        true,
+			"revoked_at":     now,
+			"revoked_reason": domain.SessionRevokedRotated,
+			"replaced_by_id": rt.ID,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrRefreshReused
+		}
+		return tx.Create(&rt).Error
+	})
+	if errors.Is(err, ErrRefreshReused) {
+		s.RevokeSession(current.UserID, current.FamilyID, domain.SessionRevokedReuse)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	return next, &rt, nil
+}
+
+// List returns the active sessions of the user
+func (s *SessionService) List(userID, currentID string) ([]Session, error) {
+	var tokens []domain.RefreshToken
+	err := s.db.Where("user_id = ? AND revoked = ? AND expires_at > ?", userID, false, time.Now()).
+		Order("last_used_at DESC").Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(tokens))
+	for _, t := range tokens {
+		sessions = append(sessions, Session{
+			ID:         t.FamilyID,
+			UserAgent:  t.UserAgent,
+			IPAddress:  t.IPAddress,
+			StartedAt:  t.StartedAt,
+			LastUsedAt: t.LastUsedAt,
+			ExpiresAt:  t.ExpiresAt,
+			Current:    t.FamilyID == currentID,
+		})
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one session of the user; it reports whether the session was active
+func (s *SessionService) RevokeSession(userID, sessionID, reason string) (bool, error) {
+	res := s.revoke(s.db.Where("user_id = ? AND family_id = ?", userID, sessionID), reason)
+	s.forget(sessionID)
+	return res.RowsAffected > 0, res.Error
+}
+
+// RevokeAll ends every session of the user but the one kept (if any) and returns how many ended
+func (s *SessionService) RevokeAll(userID, keepID, reason string) (int64, error) {
+	var families []string
+	s.db.Model(&domain.RefreshToken{}).Where("user_id = ? AND revoked = ?", userID, false).Distinct().Pluck("family_id", &families)
+
+	q := s.db.Where("user_id = ?", userID)
+	if keepID != "" {
+		q = q.Where("family_id <> ?", keepID)
+	}
+	res := s.revoke(q, reason)
+	for _, id := range families {
+		if id != keepID {
+			s.forget(id)
+		}
+	}
+	return res.RowsAffected, res.Error
+}
+
+// Active reports whether the session of an access token is still open. Answers are cached for a
+// short while; revocations made by this process take effect at once.
+func (s *SessionService) Active(sessionID string) bool {
+	s.mu.Lock()
+	state, ok := s.states[sessionID]
+	s.mu.Unlock()
+	if ok && time.Since(state.checkedAt) < sessionCheckTTL {
+		return state.active
+	}
+
+	var count int64
+	s.db.Model(&domain.RefreshToken{}).
+		Where("family_id = ? AND revoked = ? AND expires_at > ?", sessionID, false, time.Now()).Count(&count)
+
+	s.mu.Lock()
+	s.states[sessionID] = sessionState{active: count > 0, checkedAt: time.Now()}
+	s.mu.Unlock()
+	return count > 0
+}
+
+func (s *SessionService) revoke(q *gorm.DB, reason string) *gorm.DB {
+	return q.Model(&domain.RefreshToken{}).Where("revoked = ?", false).Updates(map[string]interface{}{
+		"revoked":        true,
+		"revoked_at":     time.Now(),
+		"revoked_reason": reason,
+	})
+}
+
+func (s *SessionService) forget(sessionID string) {
+	s.mu.Lock()
+	s.states[sessionID] = sessionState{active: false, checkedAt: time.Now()}
+	s.mu.Unlock()
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"strconv"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+)
+
+// SystemSettings apply to every company alike; only the super admin changes them
+var SystemSettings = map[string]bool{
+	"lock_timeout": true, // the lock sweeper runs for all companies
+}
+
+// DefaultSettings are the values used when neither the company nor the system defines a setting
+var DefaultSettings = map[string]string{
+	"sla_baixa":                "72", // hours
+	"sla_media":                "48",
+	"sla_alta":                 "24",
+	"sla_emergencial":          "6",
+	"lock_timeout":             "300", // seconds
+	"confirm_days":             "7",
+	"preventive_interval":      "90", // days, default 3 months
+	"sla_warning_percent":      "80",
+	"sla_business_hours":       "", // empty = 24x7
+	"sla_business_days":        "1,2,3,4,5",
+	"sla_holidays":             "",
+	"sla_timezone":             DefaultBusinessTimezone,
+	"preventive_horizon_days":  "15",
+	"preventive_auto_generate": "true",
+	"gas_recharge_window_days": "180",
+	"gas_recharge_alert_count": "2",
+	"totp_obrigatorio":         "", // roles, comma separated
+	"totp_dispositivo_dias":    "30",
+}
+
+// Settings returns the effective settings of a company: its own values over the shared ones over
+// the defaults. An empty companyID returns the shared settings.
+func Settings(db *gorm.DB, companyID string) (map[string]string, error) {
+	settings := make(map[string]string, len(DefaultSettings))
+	for key, value := range DefaultSettings {
+		settings[key] = value
+	}
+
+	var shared []domain.Setting
+	if err := db.Find(&shared).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range shared {
+		settings[s.Key] = s.Value
+	}
+
+	if companyID == "" {
+		return settings, nil
+	}
+	var own []domain.CompanySetting
+	if err := db.Where("company_id = ?", companyID).Find(&own).Error; err != nil {
+		return nil, err
+	}
+	for _, s := range own {
+		if !SystemSettings[s.Key] {
+			settings[s.Key] = s.Value
+		}
+	}
+	return settings, nil
+}
+
+// getSetting reads a setting of the company, falling back to the shared value; "" when absent
+func getSetting(db *gorm.DB, companyID, key string) string {
+	if companyID != "" && !SystemSettings[key] {
+		var own domain.CompanySetting
+		if err := db.First(&own, "company_id = ? AND key = ?", companyID, key).Error; err == nil {
+			return own.Value
+		}
+	}
+
+	var setting domain.Setting
+	if err := db.First(&setting, "key = ?", key).Error; err != nil {
+		return ""
+	}
+	return setting.Value
+}
+
+// companyOf returns the company whose settings apply to a user, "" for the super admin
+func companyOf(user *domain.User) string {
+	if user.CompanyID == nil {
+		return ""
+	}
+	return *user.CompanyID
+}
+
+// getSettingInt reads a positive integer setting of the company, falling back to def
+func getSettingInt(db *gorm.DB, companyID, key string, def int) int {
+	if v, err := strconv.Atoi(getSetting(db, companyID, key)); err == nil && v > 0 {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+
+	"inovar/internal/domain"
+)
+
+func TestCompanySettings(t *testing.T) {
+	db := newTestDB(t, &domain.Setting{}, &domain.CompanySetting{}, &domain.ContratoSLA{})
+	db.Create(&domain.Setting{Key: "sla_alta", Value: "24"})
+	db.Create(&domain.Setting{Key: "lock_timeout", Value: "600"})
+	db.Create(&domain.CompanySetting{CompanyID: "company-a", Key: "sla_alta", Value: "8"})
+	// System settings are the same for every company, whatever a company stored
+	db.Create(&domain.CompanySetting{CompanyID: "company-a", Key: "lock_timeout", Value: "5"})
+
+	tests := []struct {
+		company, key, want string
+	}{
+		{"company-a", "sla_alta", "8"},
+		{"company-b", "sla_alta", "24"},
+		{"", "sla_alta", "24"},
+		{"company-a", "lock_timeout", "600"},
+		{"company-a", "sla_media", ""},
+	}
+	for _, tt := range tests {
+		if got := getSetting(db, tt.company, tt.key); got != tt.want {
+			t.Errorf("getSetting(%q, %s) = %q, want %q", tt.company, tt.key, got, tt.want)
+		}
+	}
+
+	settings, err := Settings(db, "company-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"sla_alta": "8", "lock_timeout": "600", "sla_media": DefaultSettings["sla_media"]} {
+		if settings[key] != want {
+			t.Errorf("Settings(company-a)[%s] = %q, want %q", key, settings[key], want)
+		}
+	}
+
+	// The SLA of each company follows its own settings
+	s := &SLAService{db: db}
+	if a, b := s.HoursFor("company-a", "", domain.PriorityAlta), s.HoursFor("company-b", "", domain.PriorityAlta); a != 8 || b != 24 {
+		t.Errorf("SLA hours = %d and %d, want 8 and 24", a, b)
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+	"inovar/internal/websocket"
+)
+
+// Default SLA hours by priority, used when the settings table has no value
+var defaultSLAHours = map[string]int{
+	domain.PriorityBaixa:       72,
+	domain.PriorityMedia:       48,
+	domain.PriorityAlta:        24,
+	domain.PriorityEmergencial: 6,
+}
+
+// Settings keys for each priority
+var slaSettingKeys = map[string]string{
+	domain.PriorityBaixa:       "sla_baixa",
+	domain.PriorityMedia:       "sla_media",
+	domain.PriorityAlta:        "sla_alta",
+	domain.PriorityEmergencial: "sla_emergencial",
+}
+
+// Statuses in which the SLA clock is running
+var slaRunningStatuses = []string{
+	domain.StatusAberta,
+	domain.StatusAtribuida,
+	domain.StatusAgendada,
+	domain.StatusEmAndamento,
+}
+
+// SLAService computes SLA limits and watches requests for warnings and breaches
+type SLAService struct {
+	db            *gorm.DB
+	hub           *websocket.Hub
+	notifications *NotificationService
+	interval      time.Duration
+}
+
+// NewSLAService creates a new SLA service
+func NewSLAService(db *gorm.DB, hub *websocket.Hub, notifications *NotificationService, cfg *config.Config) *SLAService {
+	return &SLAService{
+		db:            db,
+		hub:           hub,
+		notifications: notifications,
+		interval:      time.Duration(cfg.SLACheckIntervalSecs) * time.Second,
+	}
+}
+
+// Calendar returns the business calendar configured in the settings of the company
+func (s *SLAService) Calendar(companyID string) *BusinessCalendar {
+	cal, err := ParseBusinessCalendar(getSetting(s.db, companyID, "sla_business_hours"), getSetting(s.db, companyID, "sla_business_days"),
+		getSetting(s.db, companyID, "sla_holidays"), getSetting(s.db, companyID, "sla_timezone"))
+	if err != nil {
+		log.Printf("⚠️ Calendário de SLA inválido, usando 24x7: %v", err)
+		return &BusinessCalendar{}
+	}
+	return cal
+}
+
+// HoursFor resolves the SLA hours for a priority, preferring the client's contract over the company settings
+func (s *SLAService) HoursFor(companyID, clientID, priority string) int {
+	var contrato domain.ContratoSLA
+	if clientID != "" {
+		if err := s.db.Where("client_id = ? AND priority = ?", clientID, priority).First(&contrato).Error; err == nil && contrato.Hours > 0 {
+			return contrato.Hours
+		}
+	}
+
+	key, ok := slaSettingKeys[priority]
+	if !ok {
+		return 0
+	}
+	return getSettingInt(s.db, companyID, key, defaultSLAHours[priority])
+}
+
+// Apply (re)computes the SLA limit of a request from its priority, client and paused time.
+// Alerts already sent are cleared when the new limit moves them back into the future.
+func (s *SLAService) Apply(req *domain.Solicitacao) {
+	req.SLAHours = s.HoursFor(req.CompanyID, req.ClientID, req.Priority)
+	if req.SLAHours == 0 {
+		return
+	}
+
+	start := slaStart(req)
+
+	budget := time.Duration(req.SLAHours)*time.Hour + time.Duration(req.SLAPausedMinutes)*time.Minute
+	req.SLALimit = s.Calendar(req.CompanyID).Add(start, budget)
+
+	now := time.Now()
+	if req.SLALimit.After(now) {
+		req.SLABreachedAt = nil
+		if s.warnAt(req).After(now) {
+			req.SLAWarnedAt = nil
+		}
+	}
+}
+
+// OnStatusChange stops the clock when a request is paused and resumes it afterwards
+func (s *SLAService) OnStatusChange(req *domain.Solicitacao, oldStatus string) {
+	now := time.Now()
+
+	if req.Status == domain.StatusPausada && oldStatus != domain.StatusPausada {
+		req.SLAPausedAt = &now
+		return
+	}
+
+	if oldStatus == domain.StatusPausada && req.Status != domain.StatusPausada && req.SLAPausedAt != nil {
+		paused := s.Calendar(req.CompanyID).Between(*req.SLAPausedAt, now)
+		req.SLAPausedMinutes += int(paused / time.Minute)
+		req.SLAPausedAt = nil
+		s.Apply(req)
+	}
+}
+
+// warnAt returns when the warning threshold (percentage of the SLA budget) is reached
+func (s *SLAService) warnAt(req *domain.Solicitacao) time.Time {
+	percent := getSettingInt(s.db, req.CompanyID, "sla_warning_percent", 80)
+	if percent > 100 {
+		percent = 100
+	}
+	budget := time.Duration(req.SLAHours) * time.Hour * time.Duration(percent) / 100
+	budget += time.Duration(req.SLAPausedMinutes) * time.Minute
+	return s.Calendar(req.CompanyID).Add(slaStart(req), budget)
+}
+
+// slaStart returns when the SLA clock starts. Scheduled preventives count from their due date.
+func slaStart(req *domain.Solicitacao) time.Time {
+	start := req.CreatedAt
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if req.ServiceType == domain.ServiceTypePreventiva && req.ScheduledAt != nil && req.ScheduledAt.After(start) {
+		return *req.ScheduledAt
+	}
+	return start
+}
+
+// Run checks SLAs periodically. It blocks and should be started in a goroutine.
+func (s *SLAService) Run() {
+	if s.interval <= 0 {
+		s.interval = time.Minute
+	}
+	log.Printf("⏱️ Monitor de SLA iniciado (intervalo %s)", s.interval)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	s.Check()
+	for range ticker.C {
+		s.Check()
+	}
+}
+
+// Check emits warnings and breaches for running requests
+func (s *SLAService) Check() {
+	var requests []domain.Solicitacao
+	if err := s.db.Where("status IN ? AND sla_hours > 0 AND sla_breached_at IS NULL", slaRunningStatuses).Find(&requests).Error; err != nil {
+		log.Printf("⚠️ Falha ao verificar SLAs: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range requests {
+		req := &requests[i]
+
+		if now.After(req.SLALimit) {
+			req.SLABreachedAt = &now
+			s.db.Model(req).Update("sla_breached_at", now)
+			s.alert(req, "request:sla_breached", "SLA estourado",
+				fmt.Sprintf("O chamado #%d (%s) ultrapassou o prazo de SLA em %s", req.Numero, req.ClientName, req.SLALimit.Format("02/01/2006 15:04")),
+				"ERROR")
+			continue
+		}
+
+		if req.SLAWarnedAt == nil && !now.Before(s.warnAt(req)) {
+			req.SLAWarnedAt = &now
+			s.db.Model(req).Update("sla_warned_at", now)
+			s.alert(req, "request:sla_warning", "SLA próximo do limite",
+				fmt.Sprintf("O chamado #%d (%s) vence em %s", req.Numero, req.ClientName, req.SLALimit.Format("02/01/2006 15:04")),
+				"WARNING")
+		}
+	}
+}
+
+// alert broadcasts the SLA event and notifies the responsible technician and the company admins
+func (s *SLAService) alert(req *domain.Solicitacao, topic, title, message, notifType string) {
+	s.hub.Broadcast(req.CompanyID, topic, map[string]interface{}{
+		"id":            req.ID,
+		"numero":        req.Numero,
+		"slaLimit":      req.SLALimit,
+		"responsibleId": req.ResponsibleID,
+	})
+
+	responsible := ""
+	if req.ResponsibleID != nil {
+		responsible = *req.ResponsibleID
+	}
+	s.notifications.NotifyCompanyAdmins(req.CompanyID, title, message, notifType, fmt.Sprintf("/requests/%s", req.ID), responsible)
+}
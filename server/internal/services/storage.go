@@ -16,6 +16,7 @@ import (
 type StorageService struct {
 	Config    *config.Config
 	UploadDir string
+	FiscalDir string // never served over HTTP, unlike UploadDir
 }
 
 func NewStorageService(cfg *config.Config) *StorageService {
@@ -24,12 +25,19 @@ func NewStorageService(cfg *config.Config) *StorageService {
 		uploadDir = "./data/uploads"
 	}
 
+	fiscalDir := cfg.FiscalDocsDir
+	if fiscalDir == "" {
+		fiscalDir = "./data/fiscal"
+	}
+
 	// Ensure upload directory exists
 	os.MkdirAll(uploadDir, 0755)
+	os.MkdirAll(fiscalDir, 0700)
 
 	return &StorageService{
 		Config:    cfg,
 		UploadDir: uploadDir,
+		FiscalDir: fiscalDir,
 	}
 }
 
@@ -84,15 +92,57 @@ func (s *StorageService) ProcessLogo(file *multipart.FileHeader, companyID strin
 	return s.Upload(file, logoKey)
 }
 
+// Save writes generated content under key and returns its URL path
+func (s *StorageService) Save(key string, data []byte) (string, error) {
+	safeKey := strings.ReplaceAll(key, "/", string(os.PathSeparator))
+	fullPath := filepath.Join(s.UploadDir, safeKey)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return "", fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	if err := os.WriteFile(fullPath, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+
+	return "/uploads/" + strings.ReplaceAll(safeKey, string(os.PathSeparator), "/"), nil
+}
+
+// Path converts a URL path returned by Upload/Save into the file path on disk
+// /uploads/filename.ext -> UploadDir/filename.ext
+func (s *StorageService) Path(urlPath string) string {
+	relativePath := filepath.Clean("/" + strings.TrimPrefix(urlPath, "/uploads/"))
+	return filepath.Join(s.UploadDir, relativePath)
+}
+
+// SaveFiscal writes a fiscal document (authorized XML, DANFS-e) under key in FiscalDir and returns the key
+func (s *StorageService) SaveFiscal(key string, data []byte) (string, error) {
+	fullPath := s.fiscalPath(key)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0700); err != nil {
+		return "", fmt.Errorf("failed to create fiscal directory: %w", err)
+	}
+	if err := os.WriteFile(fullPath, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write file: %w", err)
+	}
+	return key, nil
+}
+
+// ReadFiscal reads a fiscal document saved by SaveFiscal
+func (s *StorageService) ReadFiscal(key string) ([]byte, error) {
+	return os.ReadFile(s.fiscalPath(key))
+}
+
+// fiscalPath resolves a key inside FiscalDir, refusing anything that escapes it
+func (s *StorageService) fiscalPath(key string) string {
+	return filepath.Join(s.FiscalDir, filepath.Clean("/"+key))
+}
+
 func (s *StorageService) Delete(path string) error {
 	if path == "" {
 		return nil
 	}
 
 	// Convert URL path to file path
-	// /uploads/filename.ext -> UploadDir/filename.ext
-	relativePath := strings.TrimPrefix(path, "/uploads/")
-	fullPath := filepath.Join(s.UploadDir, relativePath)
+	fullPath := s.Path(path)
 
 	// Only delete if file exists
 	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
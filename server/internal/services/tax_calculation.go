@@ -2,13 +2,16 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 
 	"inovar/internal/domain"
 	"inovar/internal/infra/bridge"
 )
 
-// TaxCalculationService calculates taxes automatically based on regime tributário
+// TaxCalculationService calculates the taxes of a service invoice from the regime tributário
 type TaxCalculationService struct{}
 
 // TaxCalculationResult holds the result of tax calculations
@@ -19,11 +22,12 @@ type TaxCalculationResult struct {
 	BaseCalculo   float64 `json:"baseCalculo"`
 
 	// ISS
-	AliquotaISS float64 `json:"aliquotaIss"`
-	ValorISS    float64 `json:"valorIss"`
-	ISSRetido   bool    `json:"issRetido"`
+	AliquotaISS    float64 `json:"aliquotaIss"`
+	ValorISS       float64 `json:"valorIss"`
+	ISSRetido      bool    `json:"issRetido"`
+	ValorISSRetido float64 `json:"valorIssRetido,omitempty"`
 
-	// Other taxes (non-Simples)
+	// Taxes owed by the provider (non-Simples)
 	AliquotaPIS    float64 `json:"aliquotaPis,omitempty"`
 	ValorPIS       float64 `json:"valorPis,omitempty"`
 	AliquotaCOFINS float64 `json:"aliquotaCofins,omitempty"`
@@ -32,95 +36,326 @@ type TaxCalculationResult struct {
 	ValorCSLL      float64 `json:"valorCsll,omitempty"`
 	AliquotaIRPJ   float64 `json:"aliquotaIrpj,omitempty"`
 	ValorIRPJ      float64 `json:"valorIrpj,omitempty"`
-	AliquotaINSS   float64 `json:"aliquotaInss,omitempty"`
-	ValorINSS      float64 `json:"valorInss,omitempty"`
+
+	// Federal withholdings by the tomador
+	ValorRetIR     float64 `json:"valorRetIr,omitempty"`
+	ValorRetPIS    float64 `json:"valorRetPis,omitempty"`
+	ValorRetCOFINS float64 `json:"valorRetCofins,omitempty"`
+	ValorRetCSLL   float64 `json:"valorRetCsll,omitempty"`
+	ValorRetINSS   float64 `json:"valorRetInss,omitempty"`
+	TotalRetido    float64 `json:"totalRetido"`
 
 	// Simples Nacional
-	AliquotaSimplesNac float64 `json:"aliquotaSimplesNac,omitempty"`
+	FaixaSimplesNac    string  `json:"faixaSimplesNac,omitempty"`
+	RBT12              float64 `json:"rbt12,omitempty"`
+	AliquotaNominal    float64 `json:"aliquotaNominal,omitempty"`
+	AliquotaSimplesNac float64 `json:"aliquotaSimplesNac,omitempty"` // effective rate
 	ValorSimplesNac    float64 `json:"valorSimplesNac,omitempty"`
 
 	// Totals
 	TotalTributos float64 `json:"totalTributos"`
-	ValorLiquido  float64 `json:"valorLiquido"`
+	ValorLiquido  float64 `json:"valorLiquido"` // services minus withholdings
 
 	// Info
 	RegimeTributario string `json:"regimeTributario"`
 	Observacoes      string `json:"observacoes,omitempty"`
 }
 
+// faixaSimples is a bracket of the Simples Nacional Anexo III (LC 123/2006, as of LC 155/2016)
+type faixaSimples struct {
+	Faixa    string
+	Limite   float64 // RBT12 upper bound
+	Aliquota float64 // nominal rate, %
+	Deducao  float64 // parcela a deduzir
+	// Repartição dos tributos, % of the effective rate
+	IRPJ, CSLL, COFINS, PIS, CPP, ISS float64
+}
+
+var anexoIII = []faixaSimples{
+	{domain.SimplesNacFaixa1, 180000, 6.00, 0, 4.00, 3.50, 12.82, 2.78, 43.40, 33.50},
+	{domain.SimplesNacFaixa2, 360000, 11.20, 9360, 4.00, 3.50, 14.05, 3.05, 43.40, 32.00},
+	{domain.SimplesNacFaixa3, 720000, 13.50, 17640, 4.00, 3.50, 13.64, 2.96, 43.40, 32.50},
+	{domain.SimplesNacFaixa4, 1800000, 16.00, 35640, 4.00, 3.50, 13.64, 2.96, 43.40, 32.50},
+	{domain.SimplesNacFaixa5, 3600000, 21.00, 125640, 4.00, 3.50, 12.82, 2.78, 43.40, 33.50},
+	{domain.SimplesNacFaixa6, 4800000, 33.00, 648000, 35.00, 15.00, 16.03, 3.47, 30.50, 0}, // ISS paid outside the DAS
+}
+
+// efetiva returns the effective Simples rate of a RBT12 in the bracket, in %
+func (f *faixaSimples) efetiva(rbt12 float64) float64 {
+	return (rbt12*f.Aliquota/100 - f.Deducao) / rbt12 * 100
+}
+
+// Annual revenue ceilings (LC 123/2006 arts. 3º, 13-A and 18-A)
+const (
+	LimiteMEI           = 81000.0
+	SublimiteSimplesNac = 3600000.0 // above it ISS and ICMS are paid outside the DAS
+	LimiteSimplesNac    = 4800000.0
+)
+
+// ISS rate bounds (LC 116/2003, arts. 8º and 8º-A)
+const (
+	issAliquotaMinima = 2.0
+	issAliquotaMaxima = 5.0
+)
+
+// Federal withholding rates on services and the minimum amount withheld
+const (
+	retencaoIR     = 1.5  // IRRF, RIR/2018 art. 714
+	retencaoPIS    = 0.65 // Lei 10.833/2003 art. 30
+	retencaoCOFINS = 3.0
+	retencaoCSLL   = 1.0
+	retencaoINSS   = 11.0 // cessão de mão de obra, Lei 8.212/1991 art. 31
+	retencaoMinima = 10.0 // below R$ 10,00 the tributo is not withheld
+)
+
+// Default rates of the provider's own taxes outside the Simples
+const (
+	presuncaoServicos = 32.0 // Lucro Presumido base for services
+	aliquotaIRPJ      = 15.0
+	aliquotaCSLL      = 9.0
+	pisCumulativo     = 0.65
+	cofinsCumulativo  = 3.0
+	pisNaoCumulativo  = 1.65
+	cofinsNaoCumul    = 7.6
+)
+
 // NewTaxCalculationService creates a new tax calculation service
 func NewTaxCalculationService() *TaxCalculationService {
 	return &TaxCalculationService{}
 }
 
-// Calculate calculates all applicable taxes based on fiscal configuration via Python Bridge
-func (s *TaxCalculationService) Calculate(valorServicos float64, valorDeducoes float64, config *domain.ConfiguracaoFiscal) *TaxCalculationResult {
-	// Call Python Bridge
-	resp, err := bridge.CallPython("calculate_taxes", map[string]interface{}{
-		"valor_servicos": valorServicos,
-		"valor_deducoes": valorDeducoes,
-		"config":         config,
-	})
+// Calculate calculates all applicable taxes of a service invoice. It fails instead of
+// returning zero taxes when the configuration does not allow a correct calculation.
+func (s *TaxCalculationService) Calculate(valorServicos float64, valorDeducoes float64, config *domain.ConfiguracaoFiscal) (*TaxCalculationResult, error) {
+	if config == nil {
+		return nil, errors.New("configuração fiscal não encontrada")
+	}
+	if valorServicos <= 0 {
+		return nil, errors.New("valor dos serviços deve ser maior que zero")
+	}
+	if valorDeducoes < 0 || valorDeducoes > valorServicos {
+		return nil, errors.New("valor das deduções deve estar entre zero e o valor dos serviços")
+	}
+
+	result := &TaxCalculationResult{
+		ValorServicos:    round2(valorServicos),
+		ValorDeducoes:    round2(valorDeducoes),
+		BaseCalculo:      round2(valorServicos - valorDeducoes),
+		RegimeTributario: regimeOf(config),
+	}
+
+	var err error
+	switch result.RegimeTributario {
+	case domain.RegimeMEI:
+		result.Observacoes = "MEI: tributos recolhidos em valor fixo mensal no DAS-MEI; sem destaque de ISS."
+	case domain.RegimeSimplesNac:
+		err = s.simplesNacional(result, config)
+	case domain.RegimeLucroPresumido, domain.RegimeLucroReal:
+		err = s.lucro(result, config)
+	case domain.RegimeImune, domain.RegimeIsento:
+		result.Observacoes = fmt.Sprintf("Prestador %s de ISS.", strings.ToLower(result.RegimeTributario))
+	case "":
+		err = errors.New("regime tributário não configurado")
+	default:
+		err = fmt.Errorf("regime tributário desconhecido: %s", result.RegimeTributario)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if config.ISSRetido && result.RegimeTributario != domain.RegimeMEI && result.ValorISS > 0 {
+		result.ISSRetido = true
+		result.ValorISSRetido = result.ValorISS
+	}
+	result.TotalRetido = round2(result.ValorISSRetido + result.ValorRetIR + result.ValorRetPIS +
+		result.ValorRetCOFINS + result.ValorRetCSLL + result.ValorRetINSS)
+	result.ValorLiquido = round2(result.ValorServicos - result.TotalRetido)
+	return result, nil
+}
+
+// CalculateAt calculates the taxes of a service performed in codMunicipioPrestacao. When it is not
+// the provider's municipality and the catalog has an ISS rule for the service there, that rate applies.
+func (s *TaxCalculationService) CalculateAt(valorServicos float64, valorDeducoes float64, config *domain.ConfiguracaoFiscal, codMunicipioPrestacao int) (*TaxCalculationResult, error) {
+	if config == nil || codMunicipioPrestacao == 0 || codMunicipioPrestacao == config.CodigoMunicipio {
+		return s.Calculate(valorServicos, valorDeducoes, config)
+	}
+	regra := Municipios().RegraISS(codMunicipioPrestacao, config.CodigoServico)
+	if regra == nil {
+		return s.Calculate(valorServicos, valorDeducoes, config)
+	}
+
+	local := *config
+	local.AliquotaISSPadrao = regra.Aliquota
+	result, err := s.Calculate(valorServicos, valorDeducoes, &local)
+	if err != nil {
+		return nil, err
+	}
+	if m := Municipios().Codigo(codMunicipioPrestacao); m != nil {
+		result.Observacoes += fmt.Sprintf(" | Serviço prestado em %s/%s: item %s, alíquota de ISS municipal %.2f%%", m.Nome, m.UF, regra.ItemLC116, regra.Aliquota)
+	}
+	return result, nil
+}
+
+// simplesNacional applies the Anexo III effective rate and its ISS share
+func (s *TaxCalculationService) simplesNacional(result *TaxCalculationResult, config *domain.ConfiguracaoFiscal) error {
+	rbt12 := config.ReceitaBruta12Meses
+	inicioAtividade := rbt12 <= 0
+	if inicioAtividade {
+		// Início de atividade: revenue of the month annualized (LC 123/2006 art. 18 §2º)
+		rbt12 = result.ValorServicos * 12
+	}
+
+	faixa, err := faixaAnexoIII(rbt12)
 	if err != nil {
-		fmt.Printf("⚠️ Error calculating taxes via bridge: %v. Falling back to simple calculation.\n", err)
-		return &TaxCalculationResult{
-			ValorServicos: valorServicos,
-			BaseCalculo:   valorServicos - valorDeducoes,
-			ValorLiquido:  valorServicos - valorDeducoes,
+		return err
+	}
+
+	efetiva := faixa.efetiva(rbt12)
+	issShare := efetiva * faixa.ISS / 100
+	// The ISS share is capped at 5%; the excess goes to the federal taxes of the DAS
+	if issShare > issAliquotaMaxima {
+		issShare = issAliquotaMaxima
+	}
+
+	result.FaixaSimplesNac = faixa.Faixa
+	result.RBT12 = round2(rbt12)
+	result.AliquotaNominal = faixa.Aliquota
+	result.AliquotaSimplesNac = round4(efetiva)
+	result.ValorSimplesNac = round2(result.ValorServicos * efetiva / 100)
+	result.TotalTributos = result.ValorSimplesNac
+
+	if faixa.ISS > 0 {
+		// No 2% floor here: the minimum of LC 116 art. 8º-A does not apply to the ISS share of the DAS
+		result.AliquotaISS = round4(issShare)
+		result.ValorISS = round2(result.BaseCalculo * issShare / 100)
+	} else {
+		// Last bracket: ISS is paid to the municipality at its own rate
+		if err := validarAliquotaISS(config.AliquotaISSPadrao); err != nil {
+			return err
 		}
+		result.AliquotaISS = config.AliquotaISSPadrao
+		result.ValorISS = round2(result.BaseCalculo * config.AliquotaISSPadrao / 100)
+		result.TotalTributos = round2(result.TotalTributos + result.ValorISS)
 	}
 
-	dataJSON, _ := json.Marshal(resp.Data)
-	var result TaxCalculationResult
-	if err := json.Unmarshal(dataJSON, &result); err != nil {
-		fmt.Printf("⚠️ Error unmarshaling tax result: %v\n", err)
+	result.Observacoes = fmt.Sprintf("Simples Nacional Anexo III %s - RBT12 R$ %.2f, alíquota efetiva %.4f%%, ISS %.4f%%",
+		getFaixaDescricao(faixa.Faixa), result.RBT12, result.AliquotaSimplesNac, result.AliquotaISS)
+	if inicioAtividade {
+		result.Observacoes += " (RBT12 proporcional de início de atividade)"
 	}
+	return nil
+}
+
+// lucro calculates Lucro Presumido/Real taxes and the federal withholdings of the tomador
+func (s *TaxCalculationService) lucro(result *TaxCalculationResult, config *domain.ConfiguracaoFiscal) error {
+	if err := validarAliquotaISS(config.AliquotaISSPadrao); err != nil {
+		return err
+	}
+	valor := result.ValorServicos
+	result.AliquotaISS = config.AliquotaISSPadrao
+	result.ValorISS = round2(result.BaseCalculo * config.AliquotaISSPadrao / 100)
+
+	if result.RegimeTributario == domain.RegimeLucroPresumido {
+		result.AliquotaPIS = orDefault(config.AliquotaPIS, pisCumulativo)
+		result.AliquotaCOFINS = orDefault(config.AliquotaCOFINS, cofinsCumulativo)
+		// IRPJ and CSLL on the presumed profit of services
+		result.AliquotaIRPJ = round4(presuncaoServicos * orDefault(config.AliquotaIRPJ, aliquotaIRPJ) / 100)
+		result.AliquotaCSLL = round4(presuncaoServicos * orDefault(config.AliquotaCSLL, aliquotaCSLL) / 100)
+		result.Observacoes = "Lucro Presumido - PIS/COFINS cumulativos, IRPJ/CSLL sobre presunção de 32%"
+	} else {
+		result.AliquotaPIS = orDefault(config.AliquotaPIS, pisNaoCumulativo)
+		result.AliquotaCOFINS = orDefault(config.AliquotaCOFINS, cofinsNaoCumul)
+		// IRPJ/CSLL depend on the accounting profit and are not estimated per invoice
+		result.Observacoes = "Lucro Real - PIS/COFINS não cumulativos; IRPJ/CSLL apurados sobre o lucro contábil"
+	}
+	result.ValorPIS = round2(valor * result.AliquotaPIS / 100)
+	result.ValorCOFINS = round2(valor * result.AliquotaCOFINS / 100)
+	result.ValorIRPJ = round2(valor * result.AliquotaIRPJ / 100)
+	result.ValorCSLL = round2(valor * result.AliquotaCSLL / 100)
+	result.TotalTributos = round2(result.ValorISS + result.ValorPIS + result.ValorCOFINS + result.ValorIRPJ + result.ValorCSLL)
 
-	return &result
+	if config.RetemIR {
+		result.ValorRetIR = retencao(valor, retencaoIR)
+	}
+	// PIS, COFINS and CSLL are withheld together (PCC): the minimum applies to their sum
+	pcc := 0.0
+	if config.RetemPIS {
+		pcc += valor * retencaoPIS / 100
+	}
+	if config.RetemCOFINS {
+		pcc += valor * retencaoCOFINS / 100
+	}
+	if config.RetemCSLL {
+		pcc += valor * retencaoCSLL / 100
+	}
+	if pcc >= retencaoMinima {
+		if config.RetemPIS {
+			result.ValorRetPIS = round2(valor * retencaoPIS / 100)
+		}
+		if config.RetemCOFINS {
+			result.ValorRetCOFINS = round2(valor * retencaoCOFINS / 100)
+		}
+		if config.RetemCSLL {
+			result.ValorRetCSLL = round2(valor * retencaoCSLL / 100)
+		}
+	}
+	if config.RetemINSS {
+		result.ValorRetINSS = retencao(valor, orDefault(config.AliquotaINSS, retencaoINSS))
+	}
+	return nil
 }
 
-// Helper functions for Simples Nacional rates (Anexo III - Serviços)
-func (s *TaxCalculationService) getAliquotaSimplesNacional(faixa string) float64 {
-	// Alíquotas Anexo III - Serviços (2024)
-	switch faixa {
-	case domain.SimplesNacFaixa1:
-		return 6.0 // Até R$ 180.000
-	case domain.SimplesNacFaixa2:
-		return 11.2 // De R$ 180.000,01 até R$ 360.000
-	case domain.SimplesNacFaixa3:
-		return 13.5 // De R$ 360.000,01 até R$ 720.000
-	case domain.SimplesNacFaixa4:
-		return 16.0 // De R$ 720.000,01 até R$ 1.800.000
-	case domain.SimplesNacFaixa5:
-		return 21.0 // De R$ 1.800.000,01 até R$ 3.600.000
-	case domain.SimplesNacFaixa6:
-		return 33.0 // De R$ 3.600.000,01 até R$ 4.800.000
-	default:
-		return 6.0 // Default to first faixa
+// regimeOf resolves the regime of a configuration, falling back to the MEI/Simples flags
+func regimeOf(config *domain.ConfiguracaoFiscal) string {
+	switch {
+	case config.RegimeTributario != "":
+		return config.RegimeTributario
+	case config.IsMEI:
+		return domain.RegimeMEI
+	case config.OptanteSimplesNac:
+		return domain.RegimeSimplesNac
 	}
+	return ""
 }
 
-func (s *TaxCalculationService) getISSAliquotaSimplesNacional(faixa string) float64 {
-	// ISS dentro do Simples (Anexo III)
-	switch faixa {
-	case domain.SimplesNacFaixa1:
-		return 2.0
-	case domain.SimplesNacFaixa2:
-		return 2.79
-	case domain.SimplesNacFaixa3:
-		return 3.50
-	case domain.SimplesNacFaixa4:
-		return 3.84
-	case domain.SimplesNacFaixa5:
-		return 4.23
-	case domain.SimplesNacFaixa6:
-		return 5.0
-	default:
-		return 2.0
+// faixaAnexoIII returns the Anexo III bracket of a RBT12
+func faixaAnexoIII(rbt12 float64) (*faixaSimples, error) {
+	for i := range anexoIII {
+		if rbt12 <= anexoIII[i].Limite {
+			return &anexoIII[i], nil
+		}
+	}
+	return nil, fmt.Errorf("receita bruta dos últimos 12 meses (R$ %.2f) excede o limite do Simples Nacional", rbt12)
+}
+
+func validarAliquotaISS(aliquota float64) error {
+	if aliquota < issAliquotaMinima || aliquota > issAliquotaMaxima {
+		return fmt.Errorf("alíquota de ISS deve estar entre %.0f%% e %.0f%% (configurada: %.2f%%)", issAliquotaMinima, issAliquotaMaxima, aliquota)
 	}
+	return nil
 }
 
-func (s *TaxCalculationService) getFaixaDescricao(faixa string) string {
+// retencao returns the amount withheld, or zero below the minimum
+func retencao(valor, aliquota float64) float64 {
+	v := round2(valor * aliquota / 100)
+	if v < retencaoMinima {
+		return 0
+	}
+	return v
+}
+
+func orDefault(v, def float64) float64 {
+	if v > 0 {
+		return v
+	}
+	return def
+}
+
+func round4(v float64) float64 {
+	return math.Round(v*10000) / 10000
+}
+
+func getFaixaDescricao(faixa string) string {
 	switch faixa {
 	case domain.SimplesNacFaixa1:
 		return "Faixa 1 (até R$ 180.000)"
@@ -310,27 +545,15 @@ func (s *TaxCalculationService) DetectRegimeFromCNPJ(cnpj string) string {
 
 // CalculateFaixaSimplesNacional determines the Simples Nacional bracket based on revenue
 func (s *TaxCalculationService) CalculateFaixaSimplesNacional(receitaBruta12Meses float64) string {
-	switch {
-	case receitaBruta12Meses <= 180000:
-		return domain.SimplesNacFaixa1
-	case receitaBruta12Meses <= 360000:
-		return domain.SimplesNacFaixa2
-	case receitaBruta12Meses <= 720000:
-		return domain.SimplesNacFaixa3
-	case receitaBruta12Meses <= 1800000:
-		return domain.SimplesNacFaixa4
-	case receitaBruta12Meses <= 3600000:
-		return domain.SimplesNacFaixa5
-	case receitaBruta12Meses <= 4800000:
-		return domain.SimplesNacFaixa6
-	default:
+	faixa, err := faixaAnexoIII(receitaBruta12Meses)
+	if err != nil {
 		// Above limit - must be Lucro Presumido or Real
 		return ""
 	}
+	return faixa.Faixa
 }
 
 // IsMEIEligible checks if CNPJ is eligible for MEI status
 func (s *TaxCalculationService) IsMEIEligible(receitaBrutaAnual float64, numFuncionarios int) bool {
-	// MEI limits (2024)
-	return receitaBrutaAnual <= 81000 && numFuncionarios <= 1
+	return receitaBrutaAnual <= LimiteMEI && numFuncionarios <= 1
 }
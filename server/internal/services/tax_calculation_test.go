@@ -0,0 +1,153 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"inovar/internal/domain"
+)
+
+func simplesConfig(rbt12 float64) *domain.ConfiguracaoFiscal {
+	return &domain.ConfiguracaoFiscal{
+		RegimeTributario:    domain.RegimeSimplesNac,
+		ReceitaBruta12Meses: rbt12,
+		AliquotaISSPadrao:   3,
+	}
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 0.00005
+}
+
+func TestSimplesNacionalAnexoIII(t *testing.T) {
+	tests := []struct {
+		name        string
+		rbt12       float64
+		faixa       string
+		efetiva     float64 // %
+		valorDAS    float64 // on R$ 1.000,00
+		aliquotaISS float64 // %
+		valorISS    float64
+	}{
+		{"faixa 1", 120000, domain.SimplesNacFaixa1, 6, 60, 2.01, 20.10},
+		{"faixa 1 upper bound", 180000, domain.SimplesNacFaixa1, 6, 60, 2.01, 20.10},
+		// The ISS share stays below 2%: the municipal minimum does not apply inside the DAS
+		{"faixa 2 lower bound", 180000.01, domain.SimplesNacFaixa2, 6, 60, 1.92, 19.20},
+		{"faixa 2", 200000, domain.SimplesNacFaixa2, 6.52, 65.20, 2.0864, 20.86},
+		{"faixa 2 upper bound", 360000, domain.SimplesNacFaixa2, 8.6, 86, 2.752, 27.52},
+		{"faixa 3 lower bound", 360000.01, domain.SimplesNacFaixa3, 8.6, 86, 2.795, 27.95},
+		{"faixa 3 upper bound", 720000, domain.SimplesNacFaixa3, 11.05, 110.50, 3.5913, 35.91},
+		{"faixa 4 upper bound", 1800000, domain.SimplesNacFaixa4, 14.02, 140.20, 4.5565, 45.57},
+		{"faixa 5", 2000000, domain.SimplesNacFaixa5, 14.718, 147.18, 4.9305, 49.31},
+		// 17.51% x 33.5% = 5.87%: the ISS share is capped at 5% and the rest goes to the federal taxes
+		{"faixa 5 upper bound", 3600000, domain.SimplesNacFaixa5, 17.51, 175.10, 5, 50},
+	}
+
+	s := NewTaxCalculationService()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := s.Calculate(1000, 0, simplesConfig(tt.rbt12))
+			if err != nil {
+				t.Fatalf("Calculate: %v", err)
+			}
+			if r.FaixaSimplesNac != tt.faixa {
+				t.Errorf("faixa = %s, want %s", r.FaixaSimplesNac, tt.faixa)
+			}
+			if !almostEqual(r.AliquotaSimplesNac, tt.efetiva) {
+				t.Errorf("alíquota efetiva = %v, want %v", r.AliquotaSimplesNac, tt.efetiva)
+			}
+			if !almostEqual(r.ValorSimplesNac, tt.valorDAS) {
+				t.Errorf("valor do DAS = %v, want %v", r.ValorSimplesNac, tt.valorDAS)
+			}
+			if !almostEqual(r.AliquotaISS, tt.aliquotaISS) {
+				t.Errorf("alíquota ISS = %v, want %v", r.AliquotaISS, tt.aliquotaISS)
+			}
+			if !almostEqual(r.ValorISS, tt.valorISS) {
+				t.Errorf("valor ISS = %v, want %v", r.ValorISS, tt.valorISS)
+			}
+			// The ISS share is part of the DAS, not a tax on top of it
+			if !almostEqual(r.TotalTributos, tt.valorDAS) {
+				t.Errorf("total de tributos = %v, want the DAS %v", r.TotalTributos, tt.valorDAS)
+			}
+		})
+	}
+}
+
+func TestSimplesNacionalFaixa6(t *testing.T) {
+	r, err := NewTaxCalculationService().Calculate(1000, 0, simplesConfig(4800000))
+	if err != nil {
+		t.Fatalf("Calculate: %v", err)
+	}
+	if r.FaixaSimplesNac != domain.SimplesNacFaixa6 || !almostEqual(r.AliquotaSimplesNac, 19.5) {
+		t.Errorf("faixa %s at %v%%, want faixa 6 at 19.5%%", r.FaixaSimplesNac, r.AliquotaSimplesNac)
+	}
+	// Above the sublimite ISS is paid to the municipality at its own rate, outside the DAS
+	if r.AliquotaISS != 3 || r.ValorISS != 30 {
+		t.Errorf("ISS = %v%% / %v, want 3%% / 30", r.AliquotaISS, r.ValorISS)
+	}
+	if r.TotalTributos != 225 {
+		t.Errorf("total de tributos = %v, want 225 (DAS 195 + ISS 30)", r.TotalTributos)
+	}
+}
+
+func TestSimplesNacionalAboveLimit(t *testing.T) {
+	if _, err := NewTaxCalculationService().Calculate(1000, 0, simplesConfig(4800000.01)); err == nil {
+		t.Error("RBT12 above R$ 4,8 milhões was accepted")
+	}
+}
+
+func TestSimplesNacionalInicioAtividade(t *testing.T) {
+	tests := []struct {
+		name  string
+		valor float64
+		rbt12 float64 // annualized revenue of the month
+		faixa string
+	}{
+		{"first bracket", 10000, 120000, domain.SimplesNacFaixa1},
+		{"annualized into faixa 2", 20000, 240000, domain.SimplesNacFaixa2},
+	}
+
+	s := NewTaxCalculationService()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := s.Calculate(tt.valor, 0, simplesConfig(0))
+			if err != nil {
+				t.Fatalf("Calculate: %v", err)
+			}
+			if r.RBT12 != tt.rbt12 || r.FaixaSimplesNac != tt.faixa {
+				t.Errorf("RBT12 %v in %s, want %v in %s", r.RBT12, r.FaixaSimplesNac, tt.rbt12, tt.faixa)
+			}
+			if !strings.Contains(r.Observacoes, "início de atividade") {
+				t.Errorf("observações %q do not mention início de atividade", r.Observacoes)
+			}
+		})
+	}
+}
+
+func TestSimplesNacionalISSSplit(t *testing.T) {
+	s := NewTaxCalculationService()
+
+	// Deductions lower the ISS base but not the revenue taxed by the DAS
+	r, err := s.Calculate(1000, 200, simplesConfig(200000))
+	if err != nil {
+		t.Fatalf("Calculate: %v", err)
+	}
+	if r.BaseCalculo != 800 || r.ValorSimplesNac != 65.20 {
+		t.Errorf("base %v, DAS %v; want 800, 65.20", r.BaseCalculo, r.ValorSimplesNac)
+	}
+	if !almostEqual(r.ValorISS, 16.69) {
+		t.Errorf("valor ISS = %v, want 16.69 (2.0864%% of 800)", r.ValorISS)
+	}
+
+	// A withheld ISS is taken from the amount received
+	cfg := simplesConfig(200000)
+	cfg.ISSRetido = true
+	r, err = s.Calculate(1000, 0, cfg)
+	if err != nil {
+		t.Fatalf("Calculate: %v", err)
+	}
+	if !r.ISSRetido || r.ValorISSRetido != 20.86 || r.ValorLiquido != 979.14 {
+		t.Errorf("retido %v %v, líquido %v; want true 20.86, 979.14", r.ISSRetido, r.ValorISSRetido, r.ValorLiquido)
+	}
+}
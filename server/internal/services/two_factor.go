@@ -0,0 +1,395 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+)
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpMaxFailures   = 5
+	totpLockout       = 15 * time.Minute
+	recoveryCodeCount = 10
+	trustedDeviceDays = 30
+)
+
+// ErrTOTPLocked is returned while the second factor is locked after repeated wrong codes
+var ErrTOTPLocked = errors.New("muitas tentativas inválidas; aguarde alguns minutos")
+
+// TOTPEnrollment is what the user scans into the authenticator app
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauthUri"`
+	QRCode string `json:"qrCode"`
+}
+
+// TwoFactorService handles TOTP enrollment and verification (RFC 6238), recovery codes and
+// trusted devices. Secrets are sealed with the TOTP master keys.
+type TwoFactorService struct {
+	db      *gorm.DB
+	keys    map[string][]byte
+	legacy  map[string][]byte // certificate keys, which sealed the secrets of earlier versions
+	current string
+	issuer  string
+	now     func() time.Time // replaced by tests
+}
+
+// NewTwoFactorService creates a new two-factor service
+func NewTwoFactorService(db *gorm.DB, cfg *config.Config) *TwoFactorService {
+	s := &TwoFactorService{db: db, keys: map[string][]byte{}, legacy: map[string][]byte{}, issuer: "INOVAR", now: time.Now}
+	for i, k := range cfg.TOTPMasterKeys {
+		if i == 0 {
+			s.current = k.ID
+		}
+		s.keys[k.ID] = k.Key
+	}
+	for _, k := range cfg.CertMasterKeys {
+		s.legacy[k.ID] = k.Key
+	}
+	return s
+}
+
+// Rotate re-seals with the current key the secrets sealed with an older key, so it can be retired
+func (s *TwoFactorService) Rotate() (int, error) {
+	var creds []domain.TOTPCredential
+	if err := s.db.Find(&creds).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, cred := range creds {
+		if strings.HasPrefix(cred.Secret, s.current+":") {
+			continue
+		}
+		secret, err := s.unseal(cred.UserID, cred.Secret)
+		if err != nil {
+			log.Printf("⚠️ Segredo TOTP do usuário %s não pôde ser recifrado: %v", cred.UserID, err)
+			continue
+		}
+		sealed, err := s.seal(cred.UserID, base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret))
+		if err != nil {
+			return count, err
+		}
+		// Unless the user enrolled again meanwhile
+		if err := s.db.Model(&domain.TOTPCredential{}).Where("user_id = ? AND secret = ?", cred.UserID, cred.Secret).
+			Update("secret", sealed).Error; err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Required reports whether the role of the user must use a second factor (setting totp_obrigatorio
+// of the user's company)
+func (s *TwoFactorService) Required(user *domain.User) bool {
+	for _, r := range strings.Split(getSetting(s.db, companyOf(user), "totp_obrigatorio"), ",") {
+		if strings.TrimSpace(r) == user.Role {
+			return true
+		}
+	}
+	return false
+}
+
+// Begin creates a new pending secret for the user, replacing any unconfirmed one
+func (s *TwoFactorService) Begin(user *domain.User) (*TOTPEnrollment, error) {
+	if user.TOTPEnabled {
+		return nil, errors.New("autenticação em dois fatores já está ativa")
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw)
+
+	sealed, err := s.seal(user.ID, secret)
+	if err != nil {
+		return nil, err
+	}
+	cred := domain.TOTPCredential{UserID: user.ID, Secret: sealed}
+	if err := s.db.Save(&cred).Error; err != nil {
+		return nil, err
+	}
+
+	uri := s.uri(user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    uri,
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	}, nil
+}
+
+// Confirm checks the first code of a pending secret, enables the second factor and returns the
+// recovery codes, shown only this once
+func (s *TwoFactorService) Confirm(user *domain.User, code string) ([]string, error) {
+	var cred domain.TOTPCredential
+	if err := s.db.First(&cred, "user_id = ?", user.ID).Error; err != nil {
+		return nil, errors.New("inicie a configuração do autenticador")
+	}
+	if cred.ConfirmedAt != nil {
+		return nil, errors.New("autenticação em dois fatores já está ativa")
+	}
+	if err := s.check(&cred, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := s.now()
+		if err := tx.Model(&cred).Update("confirmed_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&domain.User{}).Where("id = ?", user.ID).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = recoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.TOTPEnabled = true
+	return codes, nil
+}
+
+// Verify checks a sign-in code against the confirmed secret of the user
+func (s *TwoFactorService) Verify(userID, code string) error {
+	var cred domain.TOTPCredential
+	if err := s.db.First(&cred, "user_id = ? AND confirmed_at IS NOT NULL", userID).Error; err != nil {
+		return errors.New("autenticação em dois fatores não configurada")
+	}
+	return s.check(&cred, code)
+}
+
+// UseRecoveryCode consumes a recovery code of the user
+func (s *TwoFactorService) UseRecoveryCode(userID, code string) error {
+	code = normalizeRecoveryCode(code)
+	result := s.db.Model(&domain.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(code)).
+		Update("used_at", s.now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("código de recuperação inválido ou já utilizado")
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID string) ([]string, error) {
+	var codes []string
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = recoveryCodes(tx, userID)
+		return err
+	})
+	return codes, err
+}
+
+// RecoveryCodesLeft counts the unused recovery codes of the user
+func (s *TwoFactorService) RecoveryCodesLeft(userID string) int64 {
+	var count int64
+	s.db.Model(&domain.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count
+}
+
+// Disable removes the secret, recovery codes and trusted devices of the user
+func (s *TwoFactorService) Disable(userID string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&domain.TOTPCredential{}, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&domain.RecoveryCode{}, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&domain.TrustedDevice{}, "user_id = ?", userID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&domain.User{}).Where("id = ?", userID).Update("totp_enabled", false).Error
+	})
+}
+
+// TrustDevice remembers the browser of the user and returns the token it presents on the next sign-ins
+func (s *TwoFactorService) TrustDevice(user *domain.User, userAgent, ip string) (string, time.Time, error) {
+	token := randomHex(32)
+	expires := s.now().AddDate(0, 0, getSettingInt(s.db, companyOf(user), "totp_dispositivo_dias", trustedDeviceDays))
+	device := domain.TrustedDevice{
+		ID:         uuid.New().String(),
+		UserID:     user.ID,
+		TokenHash:  hashToken(token),
+		UserAgent:  truncate(userAgent, 500),
+		IPAddress:  ip,
+		ExpiresAt:  expires,
+		LastUsedAt: s.now(),
+	}
+	if err := s.db.Create(&device).Error; err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// TrustedDevice reports whether the token belongs to a remembered, unexpired browser of the user
+func (s *TwoFactorService) TrustedDevice(userID, token string) bool {
+	if token == "" {
+		return false
+	}
+	var device domain.TrustedDevice
+	if err := s.db.First(&device, "user_id = ? AND token_hash = ? AND expires_at > ?", userID, hashToken(token), s.now()).Error; err != nil {
+		return false
+	}
+	s.db.Model(&device).Update("last_used_at", s.now())
+	return true
+}
+
+// ForgetDevices drops every remembered browser of the user
+func (s *TwoFactorService) ForgetDevices(userID string) error {
+	return s.db.Delete(&domain.TrustedDevice{}, "user_id = ?", userID).Error
+}
+
+// check validates a code, refusing replays of an accepted step and locking after repeated failures
+func (s *TwoFactorService) check(cred *domain.TOTPCredential, code string) error {
+	now := s.now()
+	if cred.LockedUntil != nil && cred.LockedUntil.After(now) {
+		return ErrTOTPLocked
+	}
+
+	secret, err := s.unseal(cred.UserID, cred.Secret)
+	if err != nil {
+		return err
+	}
+
+	// One step of clock drift each way
+	step := now.Unix() / totpPeriod
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	for _, candidate := range []int64{step, step - 1, step + 1} {
+		if candidate > cred.LastStep && hmac.Equal([]byte(totpCode(secret, candidate)), []byte(code)) {
+			return s.db.Model(cred).Updates(map[string]interface{}{
+				"last_step":    candidate,
+				"failures":     0,
+				"locked_until": nil,
+			}).Error
+		}
+	}
+
+	updates := map[string]interface{}{"failures": cred.Failures + 1}
+	if cred.Failures+1 >= totpMaxFailures {
+		updates["failures"] = 0
+		updates["locked_until"] = now.Add(totpLockout)
+	}
+	s.db.Model(cred).Updates(updates)
+	return errors.New("código inválido")
+}
+
+func (s *TwoFactorService) uri(account, secret string) string {
+	label := url.PathEscape(s.issuer + ":" + account)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", s.issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// seal encrypts the secret under the current master key, as "keyId:base64"
+func (s *TwoFactorService) seal(userID, secret string) (string, error) {
+	data, err := seal(s.keys[s.current], []byte(secret), userID)
+	if err != nil {
+		return "", err
+	}
+	return s.current + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func (s *TwoFactorService) unseal(userID, sealed string) ([]byte, error) {
+	keyID, enc, _ := strings.Cut(sealed, ":")
+	key, ok := s.keys[keyID]
+	if !ok {
+		key, ok = s.legacy[keyID]
+	}
+	if !ok {
+		return nil, fmt.Errorf("chave mestra %q não configurada", keyID)
+	}
+	data, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := unseal(key, data, userID)
+	if err != nil {
+		return nil, errors.New("não foi possível decifrar o segredo do autenticador")
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(string(plain))
+}
+
+// totpCode computes the HOTP value of a time step (RFC 4226 dynamic truncation)
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// recoveryCodes replaces the recovery codes of the user within tx
+func recoveryCodes(tx *gorm.DB, userID string) ([]string, error) {
+	if err := tx.Delete(&domain.RecoveryCode{}, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]domain.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		raw := strings.ToUpper(randomHex(5))
+		codes[i] = raw[:5] + "-" + raw[5:]
+		rows[i] = domain.RecoveryCode{ID: uuid.New().String(), UserID: userID, CodeHash: hashToken(raw)}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// hashToken is the SHA-256 of a random token; they carry enough entropy not to need a slow hash
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"inovar/internal/domain"
+	"inovar/internal/infra/config"
+)
+
+// rfc6238Secret is the SHA-1 seed of the RFC 6238 test vectors
+var rfc6238Secret = []byte("12345678901234567890")
+
+func testKey(id string, b byte) config.MasterKey {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = b
+	}
+	return config.MasterKey{ID: id, Key: key}
+}
+
+// newTestTwoFactor creates the service with the TOTP keys, driven by a fake clock
+func newTestTwoFactor(t *testing.T, keys ...config.MasterKey) (*TwoFactorService, *fakeClock) {
+	t.Helper()
+	db := newTestDB(t, &domain.User{}, &domain.TOTPCredential{}, &domain.RecoveryCode{}, &domain.TrustedDevice{},
+		&domain.Setting{}, &domain.CompanySetting{})
+	s := NewTwoFactorService(db, &config.Config{TOTPMasterKeys: keys, CertMasterKeys: []config.MasterKey{testKey("cert", 9)}})
+	clock := &fakeClock{t: time.Unix(59, 0)}
+	s.now = clock.now
+	return s, clock
+}
+
+// enroll stores a confirmed credential holding the secret
+func enroll(t *testing.T, s *TwoFactorService, userID string, secret []byte) {
+	t.Helper()
+	sealed, err := s.seal(userID, base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := s.now()
+	if err := s.db.Create(&domain.TOTPCredential{UserID: userID, Secret: sealed, ConfirmedAt: &now}).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1; six digits are the last six of the eight in the RFC
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Secret, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTwoFactorVerify(t *testing.T) {
+	s, clock := newTestTwoFactor(t, testKey("k1", 1))
+	enroll(t, s, "user", rfc6238Secret)
+
+	if err := s.Verify("user", "287082"); err != nil {
+		t.Fatalf("valid code refused: %v", err)
+	}
+	if err := s.Verify("user", "287082"); err == nil {
+		t.Error("replayed code accepted")
+	}
+
+	// One step of drift each way
+	clock.t = time.Unix(1111111111+totpPeriod, 0)
+	if err := s.Verify("user", "050471"); err != nil {
+		t.Errorf("code of the previous step refused: %v", err)
+	}
+	clock.t = time.Unix(1234567890-totpPeriod, 0)
+	if err := s.Verify("user", "005924"); err != nil {
+		t.Errorf("code of the next step refused: %v", err)
+	}
+	clock.t = time.Unix(2000000000-2*totpPeriod, 0)
+	if err := s.Verify("user", "279037"); err == nil {
+		t.Error("code two steps ahead accepted")
+	}
+
+	// Repeated wrong codes lock the second factor, even for the right code
+	for i := 0; i < totpMaxFailures; i++ {
+		s.Verify("user", "000000")
+	}
+	clock.t = time.Unix(2000000000, 0)
+	if err := s.Verify("user", "279037"); err != ErrTOTPLocked {
+		t.Errorf("locked factor returned %v, want ErrTOTPLocked", err)
+	}
+	clock.advance(totpLockout)
+	if err := s.Verify("user", totpCode(rfc6238Secret, clock.t.Unix()/totpPeriod)); err != nil {
+		t.Errorf("code refused after the lockout: %v", err)
+	}
+}
+
+func TestTwoFactorRotate(t *testing.T) {
+	old, _ := newTestTwoFactor(t, testKey("k1", 1))
+	enroll(t, old, "user", rfc6238Secret)
+
+	// Secrets of earlier versions were sealed with the certificate keys
+	old.current, old.keys = "cert", old.legacy
+	enroll(t, old, "legacy", rfc6238Secret)
+
+	// k1 is retired to decrypting only, then rotated away
+	s := NewTwoFactorService(old.db, &config.Config{
+		TOTPMasterKeys: []config.MasterKey{testKey("k2", 2), testKey("k1", 1)},
+		CertMasterKeys: []config.MasterKey{testKey("cert", 9)},
+	})
+	s.now = old.now
+	if n, err := s.Rotate(); err != nil || n != 2 {
+		t.Fatalf("Rotate = %d, %v; want 2", n, err)
+	}
+	if n, _ := s.Rotate(); n != 0 {
+		t.Errorf("second Rotate re-sealed %d secret(s)", n)
+	}
+
+	s = NewTwoFactorService(old.db, &config.Config{TOTPMasterKeys: []config.MasterKey{testKey("k2", 2)}})
+	s.now = old.now
+	for _, user := range []string{"user", "legacy"} {
+		if err := s.Verify(user, "287082"); err != nil {
+			t.Errorf("%s locked out once the old keys were removed: %v", user, err)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	s, _ := newTestTwoFactor(t, testKey("k1", 1))
+	user := &domain.User{ID: "user", Name: "Técnico", Email: "tecnico@inovar.com", PasswordHash: "x", Role: domain.RoleTecnico}
+	s.db.Create(user)
+
+	enrollment, err := s.Begin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+	codes, err := s.Confirm(user, totpCode(secret, s.now().Unix()/totpPeriod))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || !user.TOTPEnabled {
+		t.Fatalf("%d recovery code(s), enabled %v", len(codes), user.TOTPEnabled)
+	}
+
+	var stored domain.RecoveryCode
+	s.db.First(&stored, "user_id = ?", user.ID)
+	for _, code := range codes {
+		if stored.CodeHash == code || stored.CodeHash == normalizeRecoveryCode(code) {
+			t.Fatal("recovery code stored in plain text")
+		}
+	}
+
+	// Typed with a space for the dash and in lower case
+	if err := s.UseRecoveryCode(user.ID, strings.ToLower(codes[0][:5]+" "+codes[0][6:])); err != nil {
+		t.Errorf("recovery code refused: %v", err)
+	}
+	if err := s.UseRecoveryCode(user.ID, codes[0]); err == nil {
+		t.Error("recovery code used twice")
+	}
+	if err := s.UseRecoveryCode("other", codes[1]); err == nil {
+		t.Error("recovery code of another user accepted")
+	}
+	if left := s.RecoveryCodesLeft(user.ID); left != recoveryCodeCount-1 {
+		t.Errorf("%d code(s) left, want %d", left, recoveryCodeCount-1)
+	}
+
+	// New codes replace the old ones
+	fresh, err := s.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UseRecoveryCode(user.ID, codes[1]); err == nil {
+		t.Error("replaced recovery code accepted")
+	}
+	if err := s.UseRecoveryCode(user.ID, fresh[0]); err != nil {
+		t.Errorf("new recovery code refused: %v", err)
+	}
+}
+
+func TestTrustedDevices(t *testing.T) {
+	s, clock := newTestTwoFactor(t, testKey("k1", 1))
+	company := "company-a"
+	user := &domain.User{ID: "user", CompanyID: &company}
+	s.db.Create(&domain.CompanySetting{CompanyID: company, Key: "totp_dispositivo_dias", Value: "7"})
+
+	token, expires, err := s.TrustDevice(user, "Firefox", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := clock.t.AddDate(0, 0, 7); !expires.Equal(want) {
+		t.Errorf("device trusted until %v, want the 7 days of the company (%v)", expires, want)
+	}
+	var device domain.TrustedDevice
+	s.db.First(&device)
+	if device.TokenHash == token {
+		t.Error("device token stored in plain text")
+	}
+
+	if !s.TrustedDevice(user.ID, token) {
+		t.Error("trusted device not recognized")
+	}
+	if s.TrustedDevice("other", token) || s.TrustedDevice(user.ID, "") || s.TrustedDevice(user.ID, token+"0") {
+		t.Error("device trusted for another user or token")
+	}
+	clock.advance(7 * 24 * time.Hour)
+	if s.TrustedDevice(user.ID, token) {
+		t.Error("expired device still trusted")
+	}
+
+	// Users of other companies keep the shared default
+	other, _, _ := s.TrustDevice(&domain.User{ID: "other"}, "Chrome", "10.0.0.2")
+	clock.advance((trustedDeviceDays - 1) * 24 * time.Hour)
+	if !s.TrustedDevice("other", other) {
+		t.Errorf("device lost before the default %d days", trustedDeviceDays)
+	}
+	if err := s.ForgetDevices("other"); err != nil {
+		t.Fatal(err)
+	}
+	if s.TrustedDevice("other", other) {
+		t.Error("forgotten device still trusted")
+	}
+}
@@ -0,0 +1,328 @@
+package services
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// XMLDSig algorithm identifiers used by the NFS-e Nacional
+const (
+	xmlDSigNS           = "http://www.w3.org/2000/09/xmldsig#"
+	xmlC14N             = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	xmlEnvelopedSig     = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	xmlDSigRSASHA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	xmlDigestSHA256     = "http://www.w3.org/2001/04/xmlenc#sha256"
+	xmlNamespacePrefix  = "xmlns"
+	xmlReservedNSPrefix = "xml"
+)
+
+// SignXML adds an enveloped XMLDSig signature (RSA-SHA256, inclusive C14N) for the element
+// whose Id attribute is refID. The Signature is appended as the last child of the root element.
+func SignXML(doc []byte, refID string, cert *A1Certificate) ([]byte, error) {
+	if cert == nil || cert.PrivateKey == nil {
+		return nil, errors.New("certificado sem chave privada")
+	}
+
+	canonical, err := CanonicalizeElement(doc, refID)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(canonical)
+
+	// SignedInfo is built already in canonical form (namespace declared on the apex, explicit end tags)
+	signedInfo := `<SignedInfo xmlns="` + xmlDSigNS + `">` +
+		`<CanonicalizationMethod Algorithm="` + xmlC14N + `"></CanonicalizationMethod>` +
+		`<SignatureMethod Algorithm="` + xmlDSigRSASHA256 + `"></SignatureMethod>` +
+		`<Reference URI="#` + escapeC14NAttr(refID) + `">` +
+		`<Transforms>` +
+		`<Transform Algorithm="` + xmlEnvelopedSig + `"></Transform>` +
+		`<Transform Algorithm="` + xmlC14N + `"></Transform>` +
+		`</Transforms>` +
+		`<DigestMethod Algorithm="` + xmlDigestSHA256 + `"></DigestMethod>` +
+		`<DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</DigestValue>` +
+		`</Reference>` +
+		`</SignedInfo>`
+
+	hashed := sha256.Sum256([]byte(signedInfo))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, cert.PrivateKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return nil, fmt.Errorf("erro ao assinar XML: %w", err)
+	}
+
+	sig := `<Signature xmlns="` + xmlDSigNS + `">` + signedInfo +
+		`<SignatureValue>` + base64.StdEncoding.EncodeToString(signature) + `</SignatureValue>` +
+		`<KeyInfo><X509Data><X509Certificate>` + base64.StdEncoding.EncodeToString(cert.Certificate.Raw) +
+		`</X509Certificate></X509Data></KeyInfo>` +
+		`</Signature>`
+
+	end := bytes.LastIndex(doc, []byte("</"))
+	if end < 0 {
+		return nil, errors.New("XML sem elemento raiz")
+	}
+	signed := make([]byte, 0, len(doc)+len(sig))
+	signed = append(signed, doc[:end]...)
+	signed = append(signed, sig...)
+	signed = append(signed, doc[end:]...)
+	return signed, nil
+}
+
+// VerifyXMLSignature checks the enveloped signature of a document and returns the signer certificate
+func VerifyXMLSignature(doc []byte) (*x509.Certificate, error) {
+	var parsed struct {
+		Signature struct {
+			SignedInfo struct {
+				Reference struct {
+					URI         string `xml:"URI,attr"`
+					DigestValue string `xml:"DigestValue"`
+				} `xml:"Reference"`
+			} `xml:"SignedInfo"`
+			SignatureValue  string `xml:"SignatureValue"`
+			X509Certificate string `xml:"KeyInfo>X509Data>X509Certificate"`
+		} `xml:"http://www.w3.org/2000/09/xmldsig# Signature"`
+	}
+	if err := xml.Unmarshal(doc, &parsed); err != nil {
+		return nil, fmt.Errorf("XML inválido: %w", err)
+	}
+	sig := parsed.Signature
+	if sig.SignatureValue == "" {
+		return nil, errors.New("assinatura não encontrada")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(compactBase64(sig.X509Certificate))
+	if err != nil {
+		return nil, fmt.Errorf("certificado da assinatura inválido: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("certificado da assinatura inválido: %w", err)
+	}
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("certificado da assinatura não é RSA")
+	}
+
+	// Reference digest
+	refID := strings.TrimPrefix(sig.SignedInfo.Reference.URI, "#")
+	canonical, err := CanonicalizeElement(doc, refID)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(canonical)
+	if base64.StdEncoding.EncodeToString(digest[:]) != compactBase64(sig.SignedInfo.Reference.DigestValue) {
+		return nil, errors.New("digest da referência não confere")
+	}
+
+	// SignedInfo signature
+	signedInfo, err := canonicalize(doc, func(name xml.Name, _ []xml.Attr, ns map[string]string) bool {
+		return name.Local == "SignedInfo" && ns[name.Space] == xmlDSigNS
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	value, err := base64.StdEncoding.DecodeString(compactBase64(sig.SignatureValue))
+	if err != nil {
+		return nil, fmt.Errorf("valor da assinatura inválido: %w", err)
+	}
+	hashed := sha256.Sum256(signedInfo)
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], value); err != nil {
+		return nil, errors.New("assinatura inválida")
+	}
+
+	return cert, nil
+}
+
+// CanonicalizeElement returns the inclusive C14N form (without comments) of the element whose
+// Id attribute equals id, after the enveloped-signature transform
+func CanonicalizeElement(doc []byte, id string) ([]byte, error) {
+	out, err := canonicalize(doc, func(_ xml.Name, attrs []xml.Attr, _ map[string]string) bool {
+		for _, a := range attrs {
+			if a.Name.Space == "" && a.Name.Local == "Id" && a.Value == id {
+				return true
+			}
+		}
+		return false
+	}, true)
+	if err != nil {
+		return nil, fmt.Errorf("elemento com Id %q: %w", id, err)
+	}
+	return out, nil
+}
+
+type c14nFrame struct {
+	ns       map[string]string // in-scope namespaces (prefix -> URI)
+	rendered map[string]string // namespaces already rendered on output ancestors
+}
+
+// canonicalize serializes the first element matched by match in canonical form.
+// When enveloped is set, dsig Signature elements inside it are dropped.
+func canonicalize(doc []byte, match func(xml.Name, []xml.Attr, map[string]string) bool, enveloped bool) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	var out bytes.Buffer
+
+	stack := []c14nFrame{{ns: map[string]string{xmlReservedNSPrefix: "http://www.w3.org/XML/1998/namespace"}, rendered: map[string]string{}}}
+	depth := 0 // depth inside the matched element; 0 means not inside yet
+	skip := 0  // depth inside a removed Signature element
+
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			parent := stack[len(stack)-1]
+			frame := c14nFrame{ns: copyNS(parent.ns), rendered: parent.rendered}
+			var attrs []xml.Attr
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == xmlNamespacePrefix:
+					frame.ns[""] = a.Value
+				case a.Name.Space == xmlNamespacePrefix:
+					frame.ns[a.Name.Local] = a.Value
+				default:
+					attrs = append(attrs, a)
+				}
+			}
+			stack = append(stack, frame)
+
+			if skip > 0 {
+				skip++
+				continue
+			}
+			if depth == 0 {
+				if !match(t.Name, t.Attr, frame.ns) {
+					continue
+				}
+			} else if enveloped && t.Name.Local == "Signature" && frame.ns[t.Name.Space] == xmlDSigNS {
+				skip = 1
+				continue
+			}
+			depth++
+
+			// Namespace declarations not yet rendered with the same value
+			var decls []string
+			for prefix, uri := range frame.ns {
+				if prefix == xmlReservedNSPrefix {
+					continue
+				}
+				if current, ok := parent.rendered[prefix]; ok && current == uri {
+					continue
+				}
+				if _, ok := parent.rendered[prefix]; !ok && prefix == "" && uri == "" {
+					continue
+				}
+				decls = append(decls, prefix)
+			}
+			sort.Strings(decls)
+			rendered := copyNS(parent.rendered)
+
+			out.WriteByte('<')
+			out.WriteString(qualified(t.Name))
+			for _, prefix := range decls {
+				uri := frame.ns[prefix]
+				rendered[prefix] = uri
+				if prefix == "" {
+					out.WriteString(` xmlns="`)
+				} else {
+					out.WriteString(` xmlns:` + prefix + `="`)
+				}
+				out.WriteString(escapeC14NAttr(uri))
+				out.WriteByte('"')
+			}
+			stack[len(stack)-1].rendered = rendered
+
+			sort.Slice(attrs, func(i, j int) bool {
+				ui, uj := frame.ns[attrs[i].Name.Space], frame.ns[attrs[j].Name.Space]
+				if attrs[i].Name.Space == "" {
+					ui = ""
+				}
+				if attrs[j].Name.Space == "" {
+					uj = ""
+				}
+				if ui != uj {
+					return ui < uj
+				}
+				return attrs[i].Name.Local < attrs[j].Name.Local
+			})
+			for _, a := range attrs {
+				out.WriteByte(' ')
+				out.WriteString(qualified(a.Name))
+				out.WriteString(`="`)
+				out.WriteString(escapeC14NAttr(a.Value))
+				out.WriteByte('"')
+			}
+			out.WriteByte('>')
+
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if depth == 0 {
+				continue
+			}
+			out.WriteString("</" + qualified(t.Name) + ">")
+			depth--
+			if depth == 0 {
+				return out.Bytes(), nil
+			}
+
+		case xml.CharData:
+			if depth > 0 && skip == 0 {
+				out.WriteString(escapeC14NText(string(t)))
+			}
+		}
+	}
+
+	return nil, errors.New("elemento não encontrado")
+}
+
+func copyNS(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func qualified(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+var c14nTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+var c14nAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+func escapeC14NText(s string) string { return c14nTextEscaper.Replace(s) }
+
+func escapeC14NAttr(s string) string { return c14nAttrEscaper.Replace(s) }
+
+// compactBase64 drops the line breaks some signers insert in base64 values
+func compactBase64(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\n', '\r', '\t':
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a self-signed A1 certificate for signing tests
+func newTestCertificate(t *testing.T, name string) *A1Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &A1Certificate{Certificate: cert, PrivateKey: key}
+}
+
+const testDPSXML = `<DPS xmlns="http://www.sped.fazenda.gov.br/nfse" versao="1.00">` +
+	`<infDPS Id="DPS1"><xDescServ>Manutenção &amp; limpeza</xDescServ><vServ>150.00</vServ></infDPS></DPS>`
+
+func TestSignXMLRoundTrip(t *testing.T) {
+	cert := newTestCertificate(t, "PRESTADOR TESTE")
+
+	signed, err := SignXML([]byte(testDPSXML), "DPS1", cert)
+	if err != nil {
+		t.Fatalf("SignXML: %v", err)
+	}
+	if !bytes.HasSuffix(signed, []byte("</Signature></DPS>")) {
+		t.Errorf("signature is not the last child of the root: %s", signed)
+	}
+
+	signer, err := VerifyXMLSignature(signed)
+	if err != nil {
+		t.Fatalf("VerifyXMLSignature: %v", err)
+	}
+	if !bytes.Equal(signer.Raw, cert.Certificate.Raw) {
+		t.Error("VerifyXMLSignature returned another certificate than the signer's")
+	}
+}
+
+func TestVerifyXMLSignatureRejectsTampering(t *testing.T) {
+	cert := newTestCertificate(t, "PRESTADOR TESTE")
+	signed, err := SignXML([]byte(testDPSXML), "DPS1", cert)
+	if err != nil {
+		t.Fatalf("SignXML: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		old  string
+		new  string
+	}{
+		{"signed content", "150.00", "999.00"},
+		{"signature value", "<SignatureValue>", "<SignatureValue>AAAA"},
+		{"reference", `URI="#DPS1"`, `URI="#DPS2"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := bytes.Replace(signed, []byte(tt.old), []byte(tt.new), 1)
+			if bytes.Equal(doc, signed) {
+				t.Fatalf("%q not found in the signed document", tt.old)
+			}
+			if _, err := VerifyXMLSignature(doc); err == nil {
+				t.Error("tampered document passed verification")
+			}
+		})
+	}
+}
+
+func TestVerifyXMLSignatureRejectsUnsigned(t *testing.T) {
+	if _, err := VerifyXMLSignature([]byte(testDPSXML)); err == nil {
+		t.Error("unsigned document passed verification")
+	}
+}
+
+func TestSignXMLUnknownID(t *testing.T) {
+	cert := newTestCertificate(t, "PRESTADOR TESTE")
+	if _, err := SignXML([]byte(testDPSXML), "DPS9", cert); err == nil {
+		t.Error("SignXML accepted an Id missing from the document")
+	}
+}
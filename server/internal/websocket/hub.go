@@ -3,6 +3,7 @@ package websocket
 import (
 	"encoding/json"
 	"log"
+	"time"
 
 	"github.com/gofiber/websocket/v2"
 )
@@ -12,16 +13,28 @@ type Hub struct {
 	// Registered clients.
 	clients map[*Client]bool
 
-	// Inbound messages from the clients.
-	broadcast chan []byte
+	// Outbound messages to deliver to the clients they are addressed to.
+	broadcast chan outbound
 
 	// Register requests from the clients.
 	register chan *Client
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Handles events sent by authenticated clients.
+	onMessage MessageHandler
+
+	// Tells whether a session is still open; connections of ended sessions are dropped.
+	sessionActive func(sessionID string) bool
 }
 
+// How often the sessions of the connections are checked
+const sessionCheckInterval = 30 * time.Second
+
+// MessageHandler processes an event sent by an authenticated client
+type MessageHandler func(userID, event string, data json.RawMessage)
+
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
 	hub *Hub
@@ -31,11 +44,34 @@ type Client struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// Authenticated user, their session and the company whose events it receives.
+	userID    string
+	sessionID string
+	companyID string
+
+	// Super admins receive the events of every company.
+	allCompanies bool
+}
+
+// outbound is a message addressed to the clients of a company or to a single user
+type outbound struct {
+	companyID string
+	userID    string
+	data      []byte
+}
+
+// accepts reports whether the message is addressed to the client
+func (c *Client) accepts(m outbound) bool {
+	if m.userID != "" {
+		return c.userID == m.userID
+	}
+	return c.allCompanies || (m.companyID != "" && c.companyID == m.companyID)
 }
 
 func NewHub() *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan outbound),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
@@ -43,8 +79,12 @@ func NewHub() *Hub {
 }
 
 func (h *Hub) Run() {
+	ticker := time.NewTicker(sessionCheckInterval)
+	defer ticker.Stop()
 	for {
 		select {
+		case <-ticker.C:
+			h.dropEndedSessions()
 		case client := <-h.register:
 			h.clients[client] = true
 		case client := <-h.unregister:
@@ -54,8 +94,11 @@ func (h *Hub) Run() {
 			}
 		case message := <-h.broadcast:
 			for client := range h.clients {
+				if !client.accepts(message) {
+					continue
+				}
 				select {
-				case client.send <- message:
+				case client.send <- message.data:
 				default:
 					close(client.send)
 					delete(h.clients, client)
@@ -65,12 +108,52 @@ func (h *Hub) Run() {
 	}
 }
 
+// dropEndedSessions closes the connections whose session was revoked or expired
+func (h *Hub) dropEndedSessions() {
+	if h.sessionActive == nil {
+		return
+	}
+	for client := range h.clients {
+		if client.sessionID != "" && !h.sessionActive(client.sessionID) {
+			close(client.send)
+			delete(h.clients, client)
+		}
+	}
+}
+
+// CheckSessions sets how the hub tells whether a session is still open. Call it before serving
+// connections.
+func (h *Hub) CheckSessions(active func(sessionID string) bool) {
+	h.sessionActive = active
+}
+
+// OnMessage sets the handler for client events. Call it before serving connections.
+func (h *Hub) OnMessage(fn MessageHandler) {
+	h.onMessage = fn
+}
+
+// ClientMessage is an event sent by a client
+type ClientMessage struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
 type BroadcastMessage struct {
 	Topic   string      `json:"topic"`
 	Payload interface{} `json:"payload"`
 }
 
-func (h *Hub) Broadcast(topic string, payload interface{}) {
+// Broadcast sends an event to the connected clients of a company
+func (h *Hub) Broadcast(companyID, topic string, payload interface{}) {
+	h.send(outbound{companyID: companyID}, topic, payload)
+}
+
+// SendToUser sends an event to the connections of a single user
+func (h *Hub) SendToUser(userID, topic string, payload interface{}) {
+	h.send(outbound{userID: userID}, topic, payload)
+}
+
+func (h *Hub) send(m outbound, topic string, payload interface{}) {
 	msg := BroadcastMessage{
 		Topic:   topic,
 		Payload: payload,
@@ -80,11 +163,18 @@ func (h *Hub) Broadcast(topic string, payload interface{}) {
 		log.Println("Error marshalling broadcast message:", err)
 		return
 	}
-	h.broadcast <- jsonMessage
+	m.data = jsonMessage
+	h.broadcast <- m
 }
 
+// HandleWebSocket serves an authenticated connection; the upgrade handler sets the userId,
+// sessionId, companyId and allCompanies locals from the token
 func (h *Hub) HandleWebSocket(c *websocket.Conn) {
 	client := &Client{hub: h, conn: c, send: make(chan []byte, 256)}
+	client.userID, _ = c.Locals("userId").(string)
+	client.sessionID, _ = c.Locals("sessionId").(string)
+	client.companyID, _ = c.Locals("companyId").(string)
+	client.allCompanies, _ = c.Locals("allCompanies").(bool)
 	client.hub.register <- client
 
 	// Start write pump in a goroutine
@@ -101,16 +191,22 @@ func (c *Client) readPump() {
 	}()
 
 	for {
-		_, _, err := c.conn.ReadMessage()
+		_, message, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("error: %v", err)
 			}
 			break
 		}
-		// We can handle incoming messages here if needed
-		// message = bytes.TrimSpace(bytes.Replace(message, []byte{'\n'}, []byte{' '}, -1))
-		// c.hub.broadcast <- message
+		if c.userID == "" || c.hub.onMessage == nil {
+			continue
+		}
+
+		var msg ClientMessage
+		if err := json.Unmarshal(message, &msg); err != nil || msg.Event == "" {
+			continue
+		}
+		c.hub.onMessage(c.userID, msg.Event, msg.Data)
 	}
 }
 
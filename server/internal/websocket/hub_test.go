@@ -0,0 +1,51 @@
+package websocket
+
+import "testing"
+
+func TestClientAccepts(t *testing.T) {
+	empresaA := &Client{userID: "u1", companyID: "a"}
+	empresaB := &Client{userID: "u2", companyID: "b"}
+	superAdmin := &Client{userID: "u3", allCompanies: true}
+
+	tests := []struct {
+		name    string
+		message outbound
+		want    map[*Client]bool
+	}{
+		{"company event", outbound{companyID: "a"}, map[*Client]bool{empresaA: true, empresaB: false, superAdmin: true}},
+		{"event without company", outbound{}, map[*Client]bool{empresaA: false, empresaB: false, superAdmin: true}},
+		{"user event", outbound{userID: "u2"}, map[*Client]bool{empresaA: false, empresaB: true, superAdmin: false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for client, want := range tt.want {
+				if got := client.accepts(tt.message); got != want {
+					t.Errorf("client %s accepts = %v, want %v", client.userID, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDropEndedSessions(t *testing.T) {
+	h := NewHub()
+	open := &Client{userID: "u1", sessionID: "s1", send: make(chan []byte, 1)}
+	ended := &Client{userID: "u2", sessionID: "s2", send: make(chan []byte, 1)}
+	legacy := &Client{userID: "u3", send: make(chan []byte, 1)}
+	for _, client := range []*Client{open, ended, legacy} {
+		h.clients[client] = true
+	}
+	h.CheckSessions(func(sessionID string) bool { return sessionID == "s1" })
+
+	h.dropEndedSessions()
+
+	if !h.clients[open] || !h.clients[legacy] {
+		t.Error("connection of an open session dropped")
+	}
+	if h.clients[ended] {
+		t.Error("connection of an ended session kept")
+	}
+	if _, ok := <-ended.send; ok {
+		t.Error("send channel of the ended session left open")
+	}
+}